@@ -0,0 +1,54 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// readAliasFile reads an --alias-file: a JSON object mapping source keys to
+// the keys they have in the target, e.g.
+//
+//	{"DATABASE_URL": "DB_URL", "SECRET_KEY": "APP_SECRET"}
+func readAliasFile(filename string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var aliases map[string]string
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("invalid alias file %s: %v", filename, err)
+	}
+	for from, to := range aliases {
+		if !variableKeyPattern.MatchString(to) {
+			return nil, fmt.Errorf("invalid alias file %s: %s maps to invalid key %q", filename, from, to)
+		}
+	}
+	return aliases, nil
+}
+
+// applyAliases renames source variables to their target keys, so that they
+// are compared with, updated in and kept from pruning in the target under
+// that name. An alias that would collide with another source variable of the
+// same scope is an error.
+func applyAliases(variables []EnvVar, aliases map[string]string, explain bool) ([]EnvVar, error) {
+	result := make([]EnvVar, len(variables))
+	seen := make(map[variableKey]string, len(variables))
+	for i, v := range variables {
+		original := keyOf(v)
+		if to, ok := aliases[v.Key]; ok {
+			v.Key = to
+			if explain {
+				log.Printf("explain: %s: aliased to %s", original, keyOf(v))
+			}
+		}
+		if other, ok := seen[keyOf(v)]; ok {
+			return nil, fmt.Errorf("%s and %s both map to %s", other, original, keyOf(v))
+		}
+		seen[keyOf(v)] = original.String()
+		result[i] = v
+	}
+	return result, nil
+}
@@ -0,0 +1,56 @@
+package envsync
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadAliasFile(t *testing.T) {
+	aliases, err := readAliasFile(writeFile(t, "aliases.json", `{"DATABASE_URL": "DB_URL"}`))
+	if err != nil || len(aliases) != 1 || aliases["DATABASE_URL"] != "DB_URL" {
+		t.Errorf("readAliasFile = %v, %v", aliases, err)
+	}
+	for content, want := range map[string]string{
+		`["DB_URL"]`:              "invalid alias file",
+		`{"DATABASE_URL": "a b"}`: `DATABASE_URL maps to invalid key "a b"`,
+	} {
+		if _, err := readAliasFile(writeFile(t, "aliases.json", content)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", content, err, want)
+		}
+	}
+}
+
+func TestApplyAliasesCollision(t *testing.T) {
+	aliases := map[string]string{"DATABASE_URL": "DB_URL"}
+	variables := []EnvVar{envVar("DB_URL", "1", "staging"), envVar("DATABASE_URL", "2", "production"), envVar("DATABASE_URL", "3", "staging")}
+	_, err := applyAliases(variables, aliases, false)
+	if err == nil || err.Error() != "DB_URL@staging and DATABASE_URL@staging both map to DB_URL@staging" {
+		t.Errorf("err = %v", err)
+	}
+	renamed, err := applyAliases(variables[1:], aliases, false)
+	if err != nil || renamed[0].Key != "DB_URL" || variables[1].Key != "DATABASE_URL" {
+		t.Errorf("applyAliases = %v, %v", renamed, err)
+	}
+}
+
+// A renamed variable is updated under its target key, not created next to
+// a pruned original.
+func TestAliasedVariableIsUpdated(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("DATABASE_URL", "postgres://new", ""), envVar("PORT", "80", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("DB_URL", "postgres://old", ""), envVar("PORT", "80", "")}
+	aliases := writeFile(t, "aliases.json", `{"DATABASE_URL": "DB_URL"}`)
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--alias-file", aliases, "--upsert", "--prune", "--yes")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	writes := f.writes()
+	if len(writes) != 1 || writes[0] != http.MethodPut+" projects/g%2Fdst/variables/DB_URL" {
+		t.Errorf("writes = %v, want one update of DB_URL", writes)
+	}
+	if got := f.vars("g/dst"); len(got) != 2 || got[0].Key != "DB_URL" || got[0].Value != "postgres://new" {
+		t.Errorf("target = %v", got)
+	}
+}
@@ -0,0 +1,109 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// auditReport groups the variables of a project by protection posture. Only
+// keys and scopes are listed, never values.
+type auditReport struct {
+	Project                string   `json:"project"`
+	Total                  int      `json:"total"`
+	UnprotectedAndUnmasked []string `json:"unprotected_and_unmasked"`
+	Unprotected            []string `json:"unprotected"`
+	Unmasked               []string `json:"unmasked"`
+
+	// Sizes is set with --audit-sizes.
+	Sizes *sizeHistogram `json:"sizes,omitempty"`
+}
+
+// sizeHistogram counts variables by value size in bytes.
+type sizeHistogram struct {
+	Under100B  int `json:"under_100b"`
+	Under1KB   int `json:"under_1kb"`
+	Under10KB  int `json:"under_10kb"`
+	Larger     int `json:"larger"`
+	TotalBytes int `json:"total_bytes"`
+
+	// FileVariables and FileBytes cover the file-type variables, which
+	// tend to hold most of the data.
+	FileVariables int `json:"file_variables"`
+	FileBytes     int `json:"file_bytes"`
+}
+
+func valueSizes(variables []EnvVar) *sizeHistogram {
+	h := &sizeHistogram{}
+	for _, v := range variables {
+		n := len(v.Value)
+		switch {
+		case n < 100:
+			h.Under100B++
+		case n < 1024:
+			h.Under1KB++
+		case n < 10*1024:
+			h.Under10KB++
+		default:
+			h.Larger++
+		}
+		h.TotalBytes += n
+		if v.VariableType == "file" {
+			h.FileVariables++
+			h.FileBytes += n
+		}
+	}
+	return h
+}
+
+func auditVariables(project string, variables []EnvVar) *auditReport {
+	report := &auditReport{
+		Project:                project,
+		Total:                  len(variables),
+		UnprotectedAndUnmasked: []string{},
+		Unprotected:            []string{},
+		Unmasked:               []string{},
+	}
+	for _, v := range variables {
+		ref := keyOf(v).String()
+		switch {
+		case !v.Protected && !v.Masked:
+			report.UnprotectedAndUnmasked = append(report.UnprotectedAndUnmasked, ref)
+		case !v.Protected:
+			report.Unprotected = append(report.Unprotected, ref)
+		case !v.Masked:
+			report.Unmasked = append(report.Unmasked, ref)
+		}
+	}
+	sort.Strings(report.UnprotectedAndUnmasked)
+	sort.Strings(report.Unprotected)
+	sort.Strings(report.Unmasked)
+	return report
+}
+
+func writeAuditReport(w io.Writer, format string, report *auditReport) error {
+	if format == logFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	fmt.Fprintf(w, "Audit of %s: %d variables\n", report.Project, report.Total)
+	writeAuditCategory(w, "Unprotected and unmasked", report.UnprotectedAndUnmasked)
+	writeAuditCategory(w, "Unprotected only", report.Unprotected)
+	writeAuditCategory(w, "Unmasked only", report.Unmasked)
+	if h := report.Sizes; h != nil {
+		fmt.Fprintf(w, "Value sizes: %d bytes in total\n", h.TotalBytes)
+		fmt.Fprintf(w, "  < 100 B: %d\n  < 1 KB: %d\n  < 10 KB: %d\n  >= 10 KB: %d\n", h.Under100B, h.Under1KB, h.Under10KB, h.Larger)
+		fmt.Fprintf(w, "  file variables: %d, %d bytes\n", h.FileVariables, h.FileBytes)
+	}
+	return nil
+}
+
+func writeAuditCategory(w io.Writer, title string, keys []string) {
+	fmt.Fprintf(w, "%s: %d\n", title, len(keys))
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %s\n", key)
+	}
+}
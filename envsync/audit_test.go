@@ -0,0 +1,155 @@
+package envsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// auditDataset has one variable of each protection level.
+func auditDataset() []EnvVar {
+	both := envVar("SAFE", "s", "production")
+	both.Protected, both.Masked = true, true
+	protected := envVar("PROTECTED", "p", "production")
+	protected.Protected = true
+	masked := envVar("MASKED", "m", "")
+	masked.Masked = true
+	return []EnvVar{both, protected, masked, envVar("OPEN", "o", ""), envVar("DEBUG", "1", "staging")}
+}
+
+func TestAuditVariables(t *testing.T) {
+	report := auditVariables("g/app", auditDataset())
+	if report.Total != 5 {
+		t.Errorf("total = %d, want 5", report.Total)
+	}
+	if want := []string{"DEBUG@staging", "OPEN@*"}; !reflect.DeepEqual(report.UnprotectedAndUnmasked, want) {
+		t.Errorf("unprotected and unmasked = %v, want %v", report.UnprotectedAndUnmasked, want)
+	}
+	if want := []string{"MASKED@*"}; !reflect.DeepEqual(report.Unprotected, want) {
+		t.Errorf("unprotected = %v, want %v", report.Unprotected, want)
+	}
+	if want := []string{"PROTECTED@production"}; !reflect.DeepEqual(report.Unmasked, want) {
+		t.Errorf("unmasked = %v, want %v", report.Unmasked, want)
+	}
+}
+
+func TestWriteAuditReport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeAuditReport(&buf, logFormatText, auditVariables("g/app", auditDataset())); err != nil {
+		t.Fatal(err)
+	}
+	want := `Audit of g/app: 5 variables
+Unprotected and unmasked: 2
+  DEBUG@staging
+  OPEN@*
+Unprotected only: 1
+  MASKED@*
+Unmasked only: 1
+  PROTECTED@production
+`
+	if buf.String() != want {
+		t.Errorf("report:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+// The JSON report lists keys only; no value reaches the output.
+func TestAuditJSONHasNoValues(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = auditDataset()
+	f.projects["g/app"][3].Value = "open-secret"
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/app", "--audit", "--log-format", "json")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	var report auditReport
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("%v in:\n%s", err, stdout)
+	}
+	if report.Project != "g/app" || len(report.UnprotectedAndUnmasked) != 2 {
+		t.Errorf("report = %+v", report)
+	}
+	if bytes.Contains([]byte(stdout), []byte("open-secret")) {
+		t.Errorf("report contains a value:\n%s", stdout)
+	}
+}
+
+// sizeDataset has values on both sides of every bucket boundary.
+func sizeDataset() []EnvVar {
+	file := envVar("CERT", strings.Repeat("c", 2000), "")
+	file.VariableType = "file"
+	bigFile := envVar("BUNDLE", strings.Repeat("b", 20000), "")
+	bigFile.VariableType = "file"
+	return []EnvVar{
+		envVar("EMPTY", "", ""),
+		envVar("SHORT", strings.Repeat("s", 99), ""),
+		envVar("AT_100", strings.Repeat("h", 100), ""),
+		envVar("AT_1023", strings.Repeat("k", 1023), ""),
+		envVar("AT_1024", strings.Repeat("k", 1024), ""),
+		file,
+		envVar("AT_10239", strings.Repeat("t", 10*1024-1), ""),
+		envVar("AT_10240", strings.Repeat("t", 10*1024), ""),
+		bigFile,
+	}
+}
+
+func TestValueSizes(t *testing.T) {
+	got := valueSizes(sizeDataset())
+	want := &sizeHistogram{
+		Under100B:     2,
+		Under1KB:      2,
+		Under10KB:     3,
+		Larger:        2,
+		TotalBytes:    99 + 100 + 1023 + 1024 + 2000 + 10239 + 10240 + 20000,
+		FileVariables: 2,
+		FileBytes:     22000,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("valueSizes = %+v, want %+v", got, want)
+	}
+}
+
+func TestAuditSizes(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = sizeDataset()
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/app", "--audit", "--audit-sizes")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	want := `Value sizes: 44725 bytes in total
+  < 100 B: 2
+  < 1 KB: 2
+  < 10 KB: 3
+  >= 10 KB: 2
+  file variables: 2, 22000 bytes
+`
+	if !strings.HasSuffix(stdout, want) {
+		t.Errorf("report:\n%s\nwant it to end with:\n%s", stdout, want)
+	}
+
+	stdout, stderr, code = runMain(t, "", f.args("--source", "g/app", "--audit", "--audit-sizes", "--log-format", "json")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	var report auditReport
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("%v in:\n%s", err, stdout)
+	}
+	if report.Sizes == nil || *report.Sizes != *valueSizes(sizeDataset()) {
+		t.Errorf("sizes = %+v", report.Sizes)
+	}
+}
+
+// Without --audit-sizes the report has no sizes.
+func TestAuditWithoutSizes(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = sizeDataset()
+
+	stdout, _, code := runMain(t, "", f.args("--source", "g/app", "--audit", "--log-format", "json")...)
+	if code != 0 || strings.Contains(stdout, "sizes") {
+		t.Errorf("exit code %d, report:\n%s", code, stdout)
+	}
+}
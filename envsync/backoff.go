@@ -0,0 +1,182 @@
+package envsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Strategies for --backoff.
+const (
+	backoffConstant          = "constant"
+	backoffLinear            = "linear"
+	backoffExponential       = "exponential"
+	backoffExponentialJitter = "exponential-jitter"
+)
+
+// Backoff computes how long to wait before a retry. NewBackoff returns one.
+type Backoff interface {
+	// delay returns the wait before retry number attempt, starting at 1.
+	delay(attempt int) time.Duration
+}
+
+type constantBackoff struct{ base time.Duration }
+
+func (b constantBackoff) delay(int) time.Duration { return b.base }
+
+type linearBackoff struct{ base, max time.Duration }
+
+func (b linearBackoff) delay(attempt int) time.Duration {
+	return min(b.base*time.Duration(attempt), b.max)
+}
+
+type exponentialBackoff struct{ base, max time.Duration }
+
+func (b exponentialBackoff) delay(attempt int) time.Duration {
+	d := b.base
+	for i := 1; i < attempt && d < b.max; i++ {
+		d *= 2
+	}
+	return min(d, b.max)
+}
+
+// jitterBackoff waits a random time up to the exponential delay ("full
+// jitter"), so jobs that failed together do not retry together.
+type jitterBackoff struct{ exponentialBackoff }
+
+func (b jitterBackoff) delay(attempt int) time.Duration {
+	return rand.N(b.exponentialBackoff.delay(attempt) + 1)
+}
+
+// NewBackoff returns the Backoff for a --backoff strategy: constant,
+// linear, exponential or exponential-jitter, waiting base first and never
+// longer than max.
+func NewBackoff(strategy string, base, max time.Duration) (Backoff, error) {
+	if base <= 0 || max < base {
+		return nil, fmt.Errorf("--backoff-base must be positive and at most --backoff-max")
+	}
+	switch strategy {
+	case backoffConstant:
+		return constantBackoff{base}, nil
+	case backoffLinear:
+		return linearBackoff{base, max}, nil
+	case backoffExponential:
+		return exponentialBackoff{base, max}, nil
+	case backoffExponentialJitter:
+		return jitterBackoff{exponentialBackoff{base, max}}, nil
+	}
+	return nil, fmt.Errorf("unknown --backoff %q (use constant, linear, exponential or exponential-jitter)", strategy)
+}
+
+// retryingTransport retries requests that failed transiently: network
+// errors, rate limiting (429) and server errors (5xx). timeout bounds each
+// attempt, so waiting between attempts does not use up the time of the next.
+type retryingTransport struct {
+	next    http.RoundTripper
+	retries int
+	backoff Backoff
+	timeout time.Duration
+}
+
+func (t *retryingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.attempt(req)
+		if attempt > t.retries || req.Context().Err() != nil || !transient(resp, err) {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		wait := t.backoff.delay(attempt)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			wait = max(wait, retryAfter(resp))
+			resp.Body.Close()
+		}
+		log.Printf("Retrying %s %s in %s (retry %d/%d): %s", req.Method, req.URL.EscapedPath(), wait, attempt, t.retries, reason)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// attempt sends req once within t.timeout. The timeout keeps running while
+// the response body is read.
+func (t *retryingTransport) attempt(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases an attempt's context once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func transient(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryAfter is the wait a 429 or 503 response asks for in seconds, or zero.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// WithRetries retries transient failures up to retries times, waiting as
+// backoff says. It wraps whatever transport the other options set up, so it
+// comes after them, except WithSimulatedFailures. The client's timeout then
+// applies to each attempt instead of to all of them together.
+func WithRetries(retries int, backoff Backoff) ClientOption {
+	return func(c *GitLabClient) {
+		next := c.httpClient.Transport
+		if next == nil {
+			next = c.transport()
+		}
+		c.httpClient.Transport = &retryingTransport{next: next, retries: retries, backoff: backoff, timeout: c.httpClient.Timeout}
+		c.httpClient.Timeout = 0
+	}
+}
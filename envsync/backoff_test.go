@@ -0,0 +1,179 @@
+package envsync
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func delays(b Backoff, n int) []time.Duration {
+	var result []time.Duration
+	for attempt := 1; attempt <= n; attempt++ {
+		result = append(result, b.delay(attempt))
+	}
+	return result
+}
+
+func TestBackoffDelays(t *testing.T) {
+	const s = time.Second
+	for _, test := range []struct {
+		strategy string
+		want     []time.Duration
+	}{
+		{backoffConstant, []time.Duration{s, s, s, s, s, s}},
+		{backoffLinear, []time.Duration{s, 2 * s, 3 * s, 4 * s, 5 * s, 5 * s}},
+		{backoffExponential, []time.Duration{s, 2 * s, 4 * s, 5 * s, 5 * s, 5 * s}},
+	} {
+		b, err := NewBackoff(test.strategy, s, 5*s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := delays(b, len(test.want)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s delays = %v, want %v", test.strategy, got, test.want)
+		}
+	}
+}
+
+// Large attempt numbers stay at the maximum instead of overflowing.
+func TestExponentialBackoffDoesNotOverflow(t *testing.T) {
+	b, err := NewBackoff(backoffExponential, time.Second, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.delay(200); got != time.Minute {
+		t.Errorf("delay(200) = %s, want %s", got, time.Minute)
+	}
+}
+
+// Jitter waits anywhere from zero up to the exponential delay of the
+// attempt, and not the same time every time.
+func TestJitterBackoffDelays(t *testing.T) {
+	b, err := NewBackoff(backoffExponentialJitter, 100*time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ceiling := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		for attempt, limit := range ceiling {
+			d := b.delay(attempt + 1)
+			if d < 0 || d > limit {
+				t.Fatalf("delay(%d) = %s, want within [0, %s]", attempt+1, d, limit)
+			}
+			seen[d] = true
+		}
+	}
+	if len(seen) < 10 {
+		t.Errorf("jitter produced only %d distinct delays in 500", len(seen))
+	}
+}
+
+func TestNewBackoffRejects(t *testing.T) {
+	for _, test := range []struct {
+		strategy  string
+		base, max time.Duration
+		want      string
+	}{
+		{"fibonacci", time.Second, time.Minute, `unknown --backoff "fibonacci"`},
+		{backoffLinear, 0, time.Minute, "--backoff-base must be positive"},
+		{backoffLinear, time.Minute, time.Second, "at most --backoff-max"},
+	} {
+		_, err := NewBackoff(test.strategy, test.base, test.max)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("NewBackoff(%q, %s, %s) = %v, want %q", test.strategy, test.base, test.max, err, test.want)
+		}
+	}
+}
+
+// Server errors are retried with the request body resent; the final
+// answer is the one after the last retry.
+func TestRetriesTransientErrors(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = nil
+	failures := 2
+	f.intercept = func(r fakeRequest) (int, string) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if r.Method == http.MethodPost && failures > 0 {
+			failures--
+			return http.StatusBadGateway, `{"message":"502 Bad Gateway"}`
+		}
+		return 0, ""
+	}
+	backoff, err := NewBackoff(backoffConstant, time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := captureLog(t)
+
+	if err := f.client(WithRetries(2, backoff)).CreateVariable("g/app", envVar("A", "1", ""), false); err != nil {
+		t.Fatal(err)
+	}
+	posts := f.received(http.MethodPost)
+	if len(posts) != 3 {
+		t.Fatalf("%d POSTs, want 3", len(posts))
+	}
+	for _, p := range posts {
+		if !strings.Contains(p.Body, `"key":"A"`) {
+			t.Errorf("retried request body = %q", p.Body)
+		}
+	}
+	if got := f.vars("g/app"); len(got) != 1 || got[0].Value != "1" {
+		t.Errorf("target = %v", got)
+	}
+	if !strings.Contains(logs.String(), "Retrying POST /api/v4/projects/g%2Fapp/variables in 1ms (retry 2/2): 502 Bad Gateway") {
+		t.Errorf("log = %s", logs)
+	}
+}
+
+func TestRetriesGiveUp(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = nil
+	f.intercept = func(fakeRequest) (int, string) {
+		return http.StatusServiceUnavailable, `{"message":"503"}`
+	}
+	backoff, err := NewBackoff(backoffConstant, time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.client(WithRetries(2, backoff)).GetVariables("g/app")
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("err = %v, want the last 503", err)
+	}
+	reads := 0
+	for _, r := range f.received() {
+		if strings.HasSuffix(r.Path, "/variables") {
+			reads++
+		}
+	}
+	if reads != 3 {
+		t.Errorf("%d variable reads, want 1 and 2 retries", reads)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":     0,
+		"3":    3 * time.Second,
+		"-1":   0,
+		"soon": 0,
+	} {
+		resp := &http.Response{Header: http.Header{}}
+		if value != "" {
+			resp.Header.Set("Retry-After", value)
+		}
+		if got := retryAfter(resp); got != want {
+			t.Errorf("retryAfter(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestBackoffFlagValidation(t *testing.T) {
+	_, stderr, code := runMain(t, "", newFakeGitLab(t).args("--source", "g/a", "--target", "g/b", "--retries", "2", "--backoff", "fibonacci")...)
+	if code == 0 || !strings.Contains(stderr, `unknown --backoff "fibonacci"`) {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+}
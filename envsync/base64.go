@@ -0,0 +1,67 @@
+package envsync
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// decodeBase64Values decodes the values of variables whose key matches keys.
+// Both standard and URL-safe encodings are accepted, with or without padding.
+func decodeBase64Values(variables []EnvVar, keys *regexp.Regexp, explain bool) ([]EnvVar, error) {
+	result := make([]EnvVar, len(variables))
+	count := 0
+	for i, v := range variables {
+		if keys.MatchString(v.Key) {
+			count++
+			decoded, err := decodeBase64(v.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: value is not valid base64: %v", keyOf(v), err)
+			}
+			if !utf8.Valid(decoded) {
+				log.Printf("Warning: decoded value of %s is binary, which GitLab may reject", keyOf(v))
+			}
+			if explain {
+				log.Printf("explain: %s: base64-decoded (%d -> %d bytes)", keyOf(v), len(v.Value), len(decoded))
+			}
+			v.Value = string(decoded)
+		}
+		result[i] = v
+	}
+	log.Printf("Base64-decoded %d values", count)
+	return result, nil
+}
+
+func decodeBase64(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := enc.DecodeString(value); err == nil {
+			return decoded, nil
+		}
+	}
+	_, err := base64.StdEncoding.DecodeString(value)
+	return nil, err
+}
+
+// encodeBase64Values encodes the values of variables whose key matches keys
+// with standard, padded base64.
+func encodeBase64Values(variables []EnvVar, keys *regexp.Regexp, explain bool) []EnvVar {
+	result := make([]EnvVar, len(variables))
+	count := 0
+	for i, v := range variables {
+		if keys.MatchString(v.Key) {
+			count++
+			encoded := base64.StdEncoding.EncodeToString([]byte(v.Value))
+			if explain {
+				log.Printf("explain: %s: base64-encoded (%d -> %d bytes)", keyOf(v), len(v.Value), len(encoded))
+			}
+			v.Value = encoded
+		}
+		result[i] = v
+	}
+	log.Printf("Base64-encoded %d values", count)
+	return result
+}
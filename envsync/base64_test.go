@@ -0,0 +1,82 @@
+package envsync
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBase64(t *testing.T) {
+	for encoded, want := range map[string]string{
+		"aGk/Pz4+":   "hi??>>",
+		"aGk_Pz4-":   "hi??>>",
+		"aGk/Pz4+\n": "hi??>>",
+		"aGk":        "hi",
+	} {
+		decoded, err := decodeBase64(encoded)
+		if err != nil {
+			t.Errorf("decodeBase64(%q): %v", encoded, err)
+		} else if string(decoded) != want {
+			t.Errorf("decodeBase64(%q) = %q, want %q", encoded, decoded, want)
+		}
+	}
+	if _, err := decodeBase64("not base64!"); err == nil {
+		t.Error("invalid base64 was accepted")
+	}
+}
+
+func TestBase64RoundTrip(t *testing.T) {
+	keys := regexp.MustCompile(`^(?:.*_B64)$`)
+	variables := []EnvVar{envVar("CERT_B64", "line 1\nline 2", ""), envVar("PLAIN", "aGk=", "")}
+
+	encoded := encodeBase64Values(variables, keys, false)
+	if encoded[0].Value != "bGluZSAxCmxpbmUgMg==" || encoded[1].Value != "aGk=" {
+		t.Fatalf("encoded = %v", encoded)
+	}
+	decoded, err := decodeBase64Values(encoded, keys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded[0].Value != variables[0].Value || decoded[1].Value != "aGk=" {
+		t.Errorf("decoded = %v, want %v", decoded, variables)
+	}
+	if variables[0].Value != "line 1\nline 2" {
+		t.Error("encodeBase64Values changed its input")
+	}
+}
+
+func TestDecodeBase64ValuesInvalid(t *testing.T) {
+	keys := regexp.MustCompile(`^(?:CERT)$`)
+	_, err := decodeBase64Values([]EnvVar{envVar("CERT", "%%%", "production")}, keys, false)
+	if err == nil || !strings.HasPrefix(err.Error(), "CERT@production: value is not valid base64") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// --explain logs lengths, never values, and an invalid value stops the run
+// before anything is written.
+func TestDecodeBase64Command(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("TOKEN", "c2VjcmV0LXZhbHVl", "")}
+	f.projects["g/dst"] = nil
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--decode-base64", "TOKEN", "--explain")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if got := f.vars("g/dst"); len(got) != 1 || got[0].Value != "secret-value" {
+		t.Errorf("target = %v, want the decoded value", got)
+	}
+	if !strings.Contains(stderr, "explain: TOKEN@*: base64-decoded (16 -> 12 bytes)") || strings.Contains(stderr, "secret-value") {
+		t.Errorf("stderr:\n%s", stderr)
+	}
+
+	f.projects["g/src"] = append(f.projects["g/src"], envVar("BROKEN", "not base64!", ""))
+	_, stderr, code = runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--decode-base64", "TOKEN,BROKEN")...)
+	if code != exitFailure || !strings.Contains(stderr, "BROKEN@*: value is not valid base64") {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if writes := f.writes(); len(writes) != 1 {
+		t.Errorf("writes = %v, want only the first run's create", writes)
+	}
+}
@@ -0,0 +1,91 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// checkpoint records which variables a run has already synced to which
+// target, so an interrupted run can be resumed with --resume.
+type checkpoint struct {
+	filename string
+	mode     os.FileMode
+
+	Source  string              `json:"source"`
+	Targets map[string][]string `json:"targets"`
+
+	done map[string]map[variableKey]bool
+}
+
+// loadCheckpoint returns the checkpoint for a run from source. With resume
+// set the existing file is read, otherwise the run starts from scratch and
+// overwrites it.
+func loadCheckpoint(filename, source string, resume bool, mode os.FileMode) (*checkpoint, error) {
+	c := &checkpoint{
+		filename: filename,
+		mode:     mode,
+		Source:   source,
+		Targets:  map[string][]string{},
+		done:     map[string]map[variableKey]bool{},
+	}
+	if !resume {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var saved checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %v", filename, err)
+	}
+	if saved.Source != source {
+		return nil, fmt.Errorf("checkpoint file %s was written for source %s, not %s", filename, saved.Source, source)
+	}
+	for target, refs := range saved.Targets {
+		for _, ref := range refs {
+			k, err := parseVariableKey(ref)
+			if err != nil {
+				return nil, fmt.Errorf("invalid checkpoint file %s: %v", filename, err)
+			}
+			c.add(target, k)
+		}
+	}
+	return c, nil
+}
+
+func (c *checkpoint) add(target string, k variableKey) {
+	if c.done[target] == nil {
+		c.done[target] = map[variableKey]bool{}
+	}
+	if !c.done[target][k] {
+		c.done[target][k] = true
+		c.Targets[target] = append(c.Targets[target], k.String())
+	}
+}
+
+// pending drops the variables already completed for target.
+func (c *checkpoint) pending(target string, variables []EnvVar) []EnvVar {
+	var result []EnvVar
+	for _, v := range variables {
+		if !c.done[target][keyOf(v)] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// complete marks a variable as synced and saves the checkpoint, replacing
+// the file atomically so an interruption never leaves it half-written.
+func (c *checkpoint) complete(target string, v EnvVar) error {
+	c.add(target, keyOf(v))
+	sort.Strings(c.Targets[target])
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.filename, data, c.mode)
+}
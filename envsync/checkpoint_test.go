@@ -0,0 +1,73 @@
+package envsync
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "checkpoint.json")
+	c, err := loadCheckpoint(filename, "g/src", false, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []EnvVar{envVar("B", "1", ""), envVar("A", "1", "production")} {
+		if err := c.complete("g/dst", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resumed, err := loadCheckpoint(filename, "g/src", true, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"A@production", "B@*"}; !reflect.DeepEqual(resumed.Targets["g/dst"], want) {
+		t.Errorf("targets = %v, want %v", resumed.Targets, want)
+	}
+	pending := resumed.pending("g/dst", []EnvVar{envVar("A", "1", "production"), envVar("A", "1", ""), envVar("B", "2", "")})
+	if len(pending) != 1 || keyOf(pending[0]).String() != "A@*" {
+		t.Errorf("pending = %v, want A@* only", pending)
+	}
+	if got := resumed.pending("g/other", pending); len(got) != 1 {
+		t.Errorf("another target has nothing completed, got %v", got)
+	}
+
+	if _, err := loadCheckpoint(filename, "g/elsewhere", true, 0o644); err == nil || !strings.Contains(err.Error(), "written for source g/src") {
+		t.Errorf("other source: err = %v", err)
+	}
+}
+
+// A resumed run only writes the variables the interrupted one did not
+// finish.
+func TestResumeSkipsCompleted(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", ""), envVar("C", "3", ""), envVar("D", "4", "")}
+	f.projects["g/dst"] = nil
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost && strings.Contains(r.Body, `"key":"C"`) {
+			return http.StatusInternalServerError, `{"message":"500 Internal Server Error"}`
+		}
+		return 0, ""
+	}
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	if _, _, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--checkpoint", checkpoint)...); code != exitFailure {
+		t.Fatalf("first run: exit code %d, want %d", code, exitFailure)
+	}
+	first := len(f.received(http.MethodPost))
+
+	f.intercept = nil
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--checkpoint", checkpoint, "--resume")...); code != 0 {
+		t.Fatalf("resumed run: exit code %d; stderr:\n%s", code, stderr)
+	}
+	posts := f.received(http.MethodPost)[first:]
+	if len(posts) != 1 || !strings.Contains(posts[0].Body, `"key":"C"`) {
+		t.Errorf("resumed run created %v, want only C", posts)
+	}
+	if got := len(f.vars("g/dst")); got != 4 {
+		t.Errorf("%d variables in the target, want 4", got)
+	}
+}
@@ -0,0 +1,92 @@
+package envsync
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+func valueChecksum(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseVariableKey parses the KEY@scope form produced by variableKey.String.
+// Variable keys cannot contain "@", so the first one separates the scope.
+func parseVariableKey(s string) (variableKey, error) {
+	key, scope, ok := strings.Cut(s, "@")
+	if !ok || key == "" {
+		return variableKey{}, fmt.Errorf("invalid variable reference %q, expected KEY@scope", s)
+	}
+	return variableKey{Key: key, Scope: normalizeScope(scope)}, nil
+}
+
+// writeChecksumFile writes one "<sha256>  KEY@scope" line per variable,
+// sorted, in the style of sha256sum.
+func writeChecksumFile(filename string, variables []EnvVar, mode os.FileMode) error {
+	lines := make([]string, 0, len(variables))
+	for _, v := range variables {
+		lines = append(lines, fmt.Sprintf("%s  %s", valueChecksum(v.Value), keyOf(v)))
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i][66:] < lines[j][66:]
+	})
+
+	return writeOutputFile(filename, []byte(strings.Join(lines, "\n")+"\n"), mode)
+}
+
+func readChecksumFile(filename string) (map[variableKey]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	checksums := map[variableKey]string{}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		sum, ref, ok := strings.Cut(line, "  ")
+		if !ok || len(sum) != 64 {
+			return nil, fmt.Errorf("%s:%d: expected \"<sha256>  KEY@scope\"", filename, lineNo)
+		}
+		key, err := parseVariableKey(ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+		}
+		checksums[key] = sum
+	}
+	return checksums, scanner.Err()
+}
+
+// verifyChecksums compares the target's variables against a checksum file and
+// describes every variable that is missing or whose value changed. Variables
+// not listed in the file are ignored.
+func verifyChecksums(expected map[variableKey]string, target []EnvVar) []string {
+	actual := map[variableKey]string{}
+	for _, v := range target {
+		actual[keyOf(v)] = valueChecksum(v.Value)
+	}
+
+	var problems []string
+	for key, sum := range expected {
+		got, ok := actual[key]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: missing from target", key))
+		case got != sum:
+			problems = append(problems, fmt.Sprintf("%s: value checksum mismatch", key))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
@@ -0,0 +1,69 @@
+package envsync
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChecksumFileRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "sums.txt")
+	variables := []EnvVar{envVar("B", "2", "production"), envVar("A", "1", "")}
+	if err := writeChecksumFile(filename, variables, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256sum output of "1" and "2", sorted by variable.
+	want := "6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b  A@*\n" +
+		"d4735e3a265e16eee03f59718b9b5d03019c07d8b6c51f90da3a666eec13ab35  B@production\n"
+	if string(data) != want {
+		t.Errorf("checksum file:\n%s\nwant:\n%s", data, want)
+	}
+
+	sums, err := readChecksumFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := verifyChecksums(sums, variables); len(problems) != 0 {
+		t.Errorf("problems = %v, want none", problems)
+	}
+	changed := []EnvVar{envVar("A", "changed", ""), envVar("C", "3", "")}
+	wantProblems := []string{"A@*: value checksum mismatch", "B@production: missing from target"}
+	if problems := verifyChecksums(sums, changed); !reflect.DeepEqual(problems, wantProblems) {
+		t.Errorf("problems = %v, want %v", problems, wantProblems)
+	}
+}
+
+func TestReadChecksumFileRejectsBadLines(t *testing.T) {
+	for _, line := range []string{"abc  A@*", strings.Repeat("0", 64) + "  A", strings.Repeat("0", 64) + " A@*"} {
+		if _, err := readChecksumFile(writeFile(t, "sums.txt", line+"\n")); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
+
+// A live run writes the checksums that --verify-checksum later checks.
+func TestChecksumOutputVerifies(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")}
+	f.projects["g/dst"] = nil
+	sums := filepath.Join(t.TempDir(), "sums.txt")
+
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--checksum-output", sums)...); code != 0 {
+		t.Fatalf("sync: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if _, stderr, code := runMain(t, "", f.args("--target", "g/dst", "--verify-checksum", sums)...); code != 0 {
+		t.Fatalf("verify: exit code %d; stderr:\n%s", code, stderr)
+	}
+
+	f.projects["g/dst"][0].Value = "edited"
+	stdout, _, code := runMain(t, "", f.args("--target", "g/dst", "--verify-checksum", sums)...)
+	if code != exitMismatch || stdout != "A@*: value checksum mismatch\n" {
+		t.Errorf("after an edit: exit code %d, stdout %q", code, stdout)
+	}
+}
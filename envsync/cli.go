@@ -0,0 +1,993 @@
+package envsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+)
+
+type EnvVar struct {
+	VariableType     string `json:"variable_type"`
+	Key              string `json:"key"`
+	Value            string `json:"value"`
+	Protected        bool   `json:"protected"`
+	Masked           bool   `json:"masked"`
+	EnvironmentScope string `json:"environment_scope"`
+	Hidden           bool   `json:"hidden,omitempty"`
+	Raw              bool   `json:"raw"`
+	Description      string `json:"description,omitempty"`
+
+	// unspecified marks attributes the source did not provide, e.g. protection
+	// flags for variables imported from a .env file.
+	unspecified attribute
+
+	// origin is the source or --overlay a merged variable came from.
+	origin string
+}
+
+// defaultVariableType is what GitLab assumes for a variable without a type.
+const defaultVariableType = "env_var"
+
+// normalizeVariableType maps the empty type to defaultVariableType, the
+// way normalizeScope does for scopes.
+func normalizeVariableType(variableType string) string {
+	if variableType == "" {
+		return defaultVariableType
+	}
+	return variableType
+}
+
+type GitLabClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	fields     fieldMapping
+
+	// base is the transport at the bottom of httpClient's wrappers; see
+	// transport.
+	base *http.Transport
+
+	// strict rejects unknown fields in variable responses.
+	strict bool
+
+	// projects caches the numeric IDs of project paths.
+	projects *projectIDs
+
+	// deprecations collects the API's deprecation notices.
+	deprecations *deprecations
+
+	// runID, if set, is sent with every request; see WithRunID.
+	runID string
+
+	// ctx bounds every request made through the client; nil means no limit
+	// beyond the HTTP timeout.
+	ctx context.Context
+}
+
+func NewGitLabClient(baseURL, token string, opts ...ClientOption) *GitLabClient {
+	c := &GitLabClient{
+		baseURL:      baseURL,
+		token:        token,
+		projects:     &projectIDs{segments: map[string]string{}},
+		deprecations: &deprecations{seen: map[string]bool{}},
+		httpClient: &http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithContext returns a shallow copy of the client whose requests are bound
+// to ctx.
+func (c *GitLabClient) WithContext(ctx context.Context) *GitLabClient {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+// pathSegment encodes a project or group path, or a variable key, for an
+// :id segment of the API URL the way GitLab documents it: fully URL-encoded,
+// so / becomes %2F. Everything but letters, digits and -_.~ is escaped;
+// url.PathEscape leaves characters such as + and : alone, which some proxies
+// in front of GitLab decode or mangle.
+func pathSegment(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func (c *GitLabClient) makeRequest(method, path string, body io.Reader) (*http.Request, error) {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	url := fmt.Sprintf("%s/api/v4/%s", c.baseURL, path)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("PRIVATE-TOKEN", c.token)
+	req.Header.Set("Content-Type", "application/json")
+	if c.runID != "" {
+		req.Header.Set(runIDHeader, c.runID)
+	}
+	return req, nil
+}
+
+// getAllPages GETs path page by page, following GitLab's X-Next-Page header,
+// and hands each page's body to decode. Non-200 responses are returned as
+// *APIError described by op.
+func (c *GitLabClient) getAllPages(path, op string, decode func(*json.Decoder) error) error {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	page := "1"
+	for page != "" {
+		req, err := c.makeRequest("GET", fmt.Sprintf("%s%sper_page=%d&page=%s", path, separator, pageSize, page), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := c.do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			apiErr := newAPIError(op, resp)
+			resp.Body.Close()
+			return apiErr
+		}
+
+		err = decode(json.NewDecoder(resp.Body))
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		page = resp.Header.Get("X-Next-Page")
+	}
+
+	return nil
+}
+
+func (c *GitLabClient) GetVariables(projectPath string) ([]EnvVar, error) {
+	encodedPath := c.projectSegment(projectPath)
+
+	var variables []EnvVar
+	err := c.getAllPages(fmt.Sprintf("projects/%s/variables", encodedPath), "failed to get variables", func(dec *json.Decoder) error {
+		return c.fields.decodeVariables(dec, &variables, c.strict)
+	})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("project not found: %s", projectPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return variables, nil
+}
+
+// GetVariable fetches one variable by key and environment scope.
+func (c *GitLabClient) GetVariable(projectPath, key, scope string) (*EnvVar, error) {
+	path := fmt.Sprintf("projects/%s/variables/%s?filter[environment_scope]=%s",
+		c.projectSegment(projectPath), pathSegment(key), url.QueryEscape(normalizeScope(scope)))
+	req, err := c.makeRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(fmt.Sprintf("failed to get variable %s", key), resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var variable EnvVar
+	if err := c.fields.decodeVariable(data, &variable, c.strict); err != nil {
+		return nil, err
+	}
+	return &variable, nil
+}
+
+func (c *GitLabClient) CreateVariable(projectPath string, variable EnvVar, dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+	return c.createVariable("projects", projectPath, variable)
+}
+
+// createVariable POSTs a variable to a project or group. kind is "projects"
+// or "groups"; the full path is escaped as a single segment, so nested
+// namespaces like parent/child/grandchild[/project] resolve correctly.
+func (c *GitLabClient) createVariable(kind, fullPath string, variable EnvVar) error {
+	encodedPath := pathSegment(fullPath)
+	if kind == "projects" {
+		encodedPath = c.projectSegment(fullPath)
+	}
+	data, err := c.fields.marshal(variable)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.makeRequest("POST", fmt.Sprintf("%s/%s/variables", kind, encodedPath), strings.NewReader(string(data)))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return newAPIError(fmt.Sprintf("failed to create variable %s", variable.Key), resp)
+	}
+
+	return nil
+}
+
+// UpdateVariable replaces a variable's value and attributes. The type is
+// always sent, since some instances reject updates without one.
+func (c *GitLabClient) UpdateVariable(projectPath string, variable EnvVar) error {
+	variable.VariableType = normalizeVariableType(variable.VariableType)
+	return c.updateVariable(projectPath, variable, variable)
+}
+
+// UpdateVariableAttributes updates everything but the value. It is used when
+// only attributes changed, so secrets are not re-sent needlessly.
+func (c *GitLabClient) UpdateVariableAttributes(projectPath string, variable EnvVar) error {
+	payload := struct {
+		VariableType     string `json:"variable_type"`
+		Protected        bool   `json:"protected"`
+		Masked           bool   `json:"masked"`
+		EnvironmentScope string `json:"environment_scope"`
+		Raw              bool   `json:"raw"`
+		Description      string `json:"description,omitempty"`
+	}{
+		VariableType:     normalizeVariableType(variable.VariableType),
+		Protected:        variable.Protected,
+		Masked:           variable.Masked,
+		EnvironmentScope: variable.EnvironmentScope,
+		Raw:              variable.Raw,
+		Description:      variable.Description,
+	}
+	return c.updateVariable(projectPath, variable, payload)
+}
+
+func (c *GitLabClient) updateVariable(projectPath string, variable EnvVar, payload interface{}) error {
+	encodedPath := c.projectSegment(projectPath)
+	data, err := c.fields.marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("projects/%s/variables/%s?filter[environment_scope]=%s",
+		encodedPath, pathSegment(variable.Key), url.QueryEscape(normalizeScope(variable.EnvironmentScope)))
+	req, err := c.makeRequest("PUT", path, strings.NewReader(string(data)))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(fmt.Sprintf("failed to update variable %s", variable.Key), resp)
+	}
+
+	return nil
+}
+
+func (c *GitLabClient) DeleteVariable(projectPath string, variable EnvVar) error {
+	encodedPath := c.projectSegment(projectPath)
+	path := fmt.Sprintf("projects/%s/variables/%s?filter[environment_scope]=%s",
+		encodedPath, pathSegment(variable.Key), url.QueryEscape(normalizeScope(variable.EnvironmentScope)))
+
+	req, err := c.makeRequest("DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return newAPIError(fmt.Sprintf("failed to delete variable %s", variable.Key), resp)
+	}
+
+	return nil
+}
+
+// dryRunOutput is the file written by --dry-run and read back by --apply.
+type dryRunOutput struct {
+	Timestamp     string        `json:"timestamp"`
+	SourceProject string        `json:"source_project"`
+	TargetProject string        `json:"target_project"`
+	Variables     []EnvVar      `json:"variables"`
+	Prune         []variableRef `json:"prune,omitempty"`
+
+	// TargetStateHash is the fingerprint of the target when the plan was
+	// written. It is only recorded when the target was read.
+	TargetStateHash string `json:"target_state_hash,omitempty"`
+
+	// EstimatedAPICalls approximates the requests applying the plan makes.
+	EstimatedAPICalls *apiCallEstimate `json:"estimated_api_calls,omitempty"`
+
+	// Provenance maps KEY@scope to the source each variable came from when
+	// --overlay was used.
+	Provenance map[string]string `json:"provenance,omitempty"`
+}
+
+func writeDryRunOutput(filename string, format string, showValues bool, sourceProject string, targetProject string, variables []EnvVar, prune []EnvVar, targetHash string, estimate *apiCallEstimate, recipient *age.X25519Recipient, mode os.FileMode) error {
+	output := dryRunOutput{
+		Timestamp:         time.Now().Format(time.RFC3339),
+		SourceProject:     sourceProject,
+		TargetProject:     targetProject,
+		Variables:         variables,
+		TargetStateHash:   targetHash,
+		EstimatedAPICalls: estimate,
+		Provenance:        provenance(variables),
+	}
+	for _, v := range prune {
+		output.Prune = append(output.Prune, refOf(v))
+	}
+
+	var buf bytes.Buffer
+	if err := writePlan(&buf, format, &output, showValues); err != nil {
+		return err
+	}
+	data, err := sealFor(recipient, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return writeOutputFile(filename, data, mode)
+}
+
+func readDryRunOutput(filename string, identity *age.X25519Identity, strict bool) (*dryRunOutput, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = openSealed(identity, data); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	var output dryRunOutput
+	// A bare array is a variable list as returned by the API or
+	// `glab variable list -o json`.
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := unmarshalJSON(trimmed, &output.Variables, strict); err != nil {
+			return nil, fmt.Errorf("invalid variable list %s: %v", filename, err)
+		}
+		return &output, nil
+	}
+	if err := unmarshalJSON(data, &output, strict); err != nil {
+		return nil, fmt.Errorf("invalid plan file %s: %v", filename, err)
+	}
+
+	return &output, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Main runs the gitlab-env-sync command line tool with the flags in
+// os.Args. It exits the process with one of the documented exit codes
+// unless the run succeeds.
+func Main() {
+	cfg := &config{}
+	cfg.registerFlags(flag.CommandLine)
+	flag.Parse()
+
+	if cfg.RunID == "" {
+		var err error
+		if cfg.RunID, err = newRunID(); err != nil {
+			log.Fatalf("Error generating run ID: %v", err)
+		}
+	}
+	if err := setupLogging(cfg.LogFormat, os.Stderr, cfg.RunID); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if cfg.GenerateKey != "" {
+		publicKey, err := generateKeyFile(cfg.GenerateKey)
+		if err != nil {
+			log.Fatalf("Error generating key: %v", err)
+		}
+		log.Printf("Wrote private key to %s; pass it to --decrypt-key and keep it secret", cfg.GenerateKey)
+		fmt.Println(publicKey)
+		return
+	}
+	if cfg.EncryptOutput != (cfg.EncryptRecipient.key != nil) {
+		log.Fatalf("--encrypt-output and --encrypt-recipient must be used together")
+	}
+	if cfg.QuietDryRun {
+		// Counts are only meaningful against the target, so plan the way
+		// an --upsert run would.
+		cfg.DryRun = true
+		cfg.Upsert = true
+	}
+	if cfg.UpdateOnly {
+		cfg.Upsert = true
+	}
+	if cfg.ValidatePlan != "" {
+		os.Exit(runValidatePlan(cfg.ValidatePlan, cfg.DecryptKey.key, cfg.StrictSchema, resolveMaskingRules(nil, cfg.GitLabVersion)))
+	}
+	if (cfg.Audit || cfg.Fingerprint || cfg.List || cfg.Snapshot != "") && cfg.SourceProject == "" && cfg.ImportFile == "" {
+		cfg.SourceProject = cfg.TargetProject
+	}
+	if cfg.PrintConfig {
+		if err := printConfig(os.Stdout, flag.CommandLine); err != nil {
+			log.Fatalf("Error printing configuration: %v", err)
+		}
+		return
+	}
+
+	missingSource := cfg.SourceProject == "" && cfg.ImportFile == "" && cfg.ApplyFile == "" && cfg.VerifyChecksum == "" && !cfg.DetectDrift
+	missingTarget := cfg.TargetProject == "" && cfg.TargetGroup == "" && cfg.ApplyFile == "" && cfg.CompareFile == "" && cfg.ExportFile == "" && !cfg.Audit && !cfg.Fingerprint && !cfg.List && cfg.Snapshot == ""
+	if cfg.GitLabURL == "" || cfg.Token == "" || missingSource || missingTarget {
+		flag.Usage()
+		fmt.Println("\nExample usage:")
+		fmt.Println("  ./gitlab-env-sync \\")
+		fmt.Println("    --gitlab-url https://gitlab.com \\")
+		fmt.Println("    --token your-token \\")
+		fmt.Println("    --source group/project-a \\")
+		fmt.Println("    --target group/project-b")
+		os.Exit(exitFailure)
+	}
+	if cfg.TargetProject != "" && cfg.TargetGroup != "" {
+		log.Fatalf("--target and --target-group cannot be combined")
+	}
+	if cfg.DetectDrift && cfg.StateFile == "" {
+		log.Fatalf("--detect-drift requires --state-file")
+	}
+	if cfg.ChangedSinceSync && cfg.StateFile == "" {
+		log.Fatalf("--changed-since-sync requires --state-file")
+	}
+	if cfg.Resume && cfg.Checkpoint == "" {
+		log.Fatalf("--resume requires --checkpoint")
+	}
+	if cfg.ConsolidateReviewScopes && cfg.ExpandReviewScopes != "" {
+		log.Fatalf("--consolidate-review-scopes and --expand-review-scopes cannot be combined")
+	}
+	if cfg.SnapshotValues != snapshotHash && cfg.SnapshotValues != snapshotRedact {
+		log.Fatalf("Error: unknown --snapshot-values %q (use hash or redact)", cfg.SnapshotValues)
+	}
+	if !validEOL(cfg.NormalizeEOL) {
+		log.Fatalf("Error: unknown --normalize-eol %q (use lf or crlf)", cfg.NormalizeEOL)
+	}
+	if !validPlanFormat(cfg.DryRunFormat) {
+		log.Fatalf("Error: unknown --dry-run-format %q (use json, yaml, table or env)", cfg.DryRunFormat)
+	}
+	if cfg.ExportFile != "" && cfg.ExportFormat == exportFormatSOPS && !cfg.ShowValues {
+		log.Fatalf("--export-format sops-yaml writes real values, pass --show-values to confirm")
+	}
+	if cfg.SOPS && cfg.ExportFile != "" && cfg.ExportFormat != exportFormatSOPS {
+		log.Fatalf("--sops needs --export-format sops-yaml")
+	}
+	if cfg.SOPS && cfg.ExportFile == exportStdout {
+		log.Fatalf("--sops picks its keys by file name, so it cannot export to stdout")
+	}
+	if cfg.SOPS && cfg.EncryptRecipient.key != nil {
+		log.Fatalf("--sops and --encrypt-output cannot be combined")
+	}
+	if cfg.ExportFile == exportStdout && cfg.EncryptRecipient.key != nil {
+		log.Fatalf("--encrypt-output writes binary data, so it cannot export to stdout")
+	}
+	if cfg.ExpectCount >= 0 && (cfg.ExpectCountMin >= 0 || cfg.ExpectCountMax >= 0) {
+		log.Fatalf("--expect-count cannot be combined with --expect-count-min or --expect-count-max")
+	}
+	if cfg.ExpectCountMax >= 0 && cfg.ExpectCountMin > cfg.ExpectCountMax {
+		log.Fatalf("--expect-count-min must not be greater than --expect-count-max")
+	}
+	switch cfg.ScopeCollision {
+	case collisionFirst, collisionLast, collisionError:
+	default:
+		log.Fatalf("Error: unknown --scope-collision %q (use first, last or error)", cfg.ScopeCollision)
+	}
+	if cfg.Effective && !cfg.List {
+		log.Fatalf("--effective only applies to --list")
+	}
+	if cfg.Effective && (cfg.ImportFile != "" || cfg.ApplyFile != "") {
+		log.Fatalf("--list --effective needs a source project, not a file")
+	}
+	if cfg.ThreadsPerTarget < 1 {
+		log.Fatalf("Error: --threads-per-target must be at least 1")
+	}
+	if cfg.ParallelProjects < 1 {
+		log.Fatalf("Error: --parallel-projects must be at least 1")
+	}
+	if cfg.ParallelProjects > 1 && cfg.Resolve == resolveInteractive {
+		log.Fatalf("--resolve interactive cannot be combined with --parallel-projects")
+	}
+	if cfg.RateLimit < 0 {
+		log.Fatalf("Error: --rate-limit must not be negative")
+	}
+	if cfg.PruneBatchSize < 0 {
+		log.Fatalf("Error: --prune-batch-size must not be negative")
+	}
+	switch cfg.OnMaskFailure {
+	case maskFailureFail, maskFailureSkip, maskFailureUnmask:
+	default:
+		log.Fatalf("Error: unknown --on-mask-failure %q (use fail, skip or unmask)", cfg.OnMaskFailure)
+	}
+
+	cfg.GitLabURL = strings.TrimRight(cfg.GitLabURL, "/")
+	cfg.SourceGitLabURL = strings.TrimRight(cfg.SourceGitLabURL, "/")
+
+	clientOpts := []ClientOption{WithRunID(cfg.RunID)}
+	if cfg.StrictSchema {
+		clientOpts = append(clientOpts, WithStrictSchema())
+	}
+	if cfg.Pool != (ConnectionPool{}) {
+		clientOpts = append(clientOpts, WithConnectionPool(cfg.Pool))
+	}
+	if cfg.SOCKS5 != "" {
+		proxyURL, err := socks5ProxyURL(cfg.SOCKS5)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		clientOpts = append(clientOpts, WithProxy(proxyURL))
+	}
+	if limiter := newRateLimiter(cfg.RateLimit); limiter != nil {
+		clientOpts = append(clientOpts, WithRateLimit(limiter))
+	}
+
+	backoff, err := NewBackoff(cfg.Backoff, cfg.BackoffBase, cfg.BackoffMax)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if cfg.Retries < 0 {
+		log.Fatalf("Error: --retries must not be negative")
+	}
+	if cfg.Retries > 0 {
+		clientOpts = append(clientOpts, WithRetries(cfg.Retries, backoff))
+	}
+	if cfg.SimulateFailures != 0 {
+		if err := checkSimulationTarget(cfg.SimulateFailures, cfg.GitLabURL); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		log.Printf("Simulating failures of %g of all writes", cfg.SimulateFailures)
+		clientOpts = append(clientOpts, WithSimulatedFailures(cfg.SimulateFailures))
+	}
+
+	client := NewGitLabClient(cfg.GitLabURL, cfg.Token, clientOpts...)
+	if cfg.FieldMapFile != "" {
+		fields, err := readFieldMapping(cfg.FieldMapFile)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		client.fields = fields
+	}
+	// The source client is built with the same options, so requests and
+	// path escaping are the same on both instances.
+	sourceClient := client
+	if cfg.SourceGitLabURL != "" || cfg.SourceToken != "" {
+		baseURL, token := cfg.GitLabURL, cfg.Token
+		if cfg.SourceGitLabURL != "" {
+			baseURL = cfg.SourceGitLabURL
+		}
+		if cfg.SourceToken != "" {
+			token = cfg.SourceToken
+		}
+		sourceClient = NewGitLabClient(baseURL, token, clientOpts...)
+		sourceClient.fields = client.fields
+		log.Printf("Reading source variables from %s", baseURL)
+	}
+	// The deprecation notices are logged once, however the run ends from
+	// here on, and only then does it exit with code.
+	var code int
+	defer func() {
+		warnDeprecations(client, sourceClient)
+		if code != 0 {
+			os.Exit(code)
+		}
+	}()
+
+	if cfg.VerifyChecksum != "" {
+		os.Exit(runVerifyChecksum(client, cfg))
+	}
+
+	var state *syncState
+	if cfg.StateFile != "" {
+		var err error
+		if state, err = readStateFile(cfg.StateFile); err != nil {
+			log.Fatalf("Error reading state file: %v", err)
+		}
+	}
+	if cfg.DetectDrift {
+		os.Exit(runDetectDrift(client, cfg, state, resolveTargets(client, cfg)))
+	}
+
+	if cfg.PreSyncCmd != "" {
+		if err := runSyncHook(cfg.PreSyncCmd, preSyncEnv(cfg), nil); err != nil {
+			log.Fatalf("--pre-sync-cmd failed, aborting before fetching anything: %v", err)
+		}
+	}
+	sourceVars := loadSourceVariables(sourceClient, cfg)
+	if len(cfg.Overlays.sources) > 0 {
+		sourceVars = withOrigin(sourceVars, cfg.SourceProject)
+		for _, source := range cfg.Overlays.sources {
+			overlay, err := readOverlay(sourceClient, source, cfg.CSVColumns)
+			if isAuthError(err) {
+				exitAuth(err)
+			}
+			if err != nil {
+				log.Fatalf("Error reading overlay %s: %v", source, err)
+			}
+			var overridden int
+			sourceVars, overridden = mergeOverlay(sourceVars, withOrigin(overlay, source), cfg.Explain)
+			log.Printf("Merged %d variables from overlay %s (%d overrides)", len(overlay), source, overridden)
+		}
+	}
+	if cfg.SourceScopes != "" {
+		sourceVars = keepScopes(sourceVars, splitList(cfg.SourceScopes), cfg.Explain)
+		log.Printf("Kept %d source variables in scopes %s", len(sourceVars), cfg.SourceScopes)
+	}
+
+	if cfg.MatrixFile != "" {
+		matrix, err := readMatrixFile(cfg.MatrixFile)
+		if err != nil {
+			log.Fatalf("Error reading matrix file: %v", err)
+		}
+		sourceVars, err = applyMatrix(sourceVars, matrix)
+		if err != nil {
+			log.Fatalf("Error applying matrix file %s: %v", cfg.MatrixFile, err)
+		}
+		log.Printf("Applied %d matrix overrides from %s", len(matrix), cfg.MatrixFile)
+	}
+	if keys, err := compileNameList(cfg.DecodeBase64); err != nil {
+		log.Fatalf("Error: invalid --decode-base64: %v", err)
+	} else if keys != nil {
+		if sourceVars, err = decodeBase64Values(sourceVars, keys, cfg.Explain); err != nil {
+			log.Fatalf("Error decoding base64: %v", err)
+		}
+	}
+	if keys, err := compileNameList(cfg.EncodeBase64); err != nil {
+		log.Fatalf("Error: invalid --encode-base64: %v", err)
+	} else if keys != nil {
+		sourceVars = encodeBase64Values(sourceVars, keys, cfg.Explain)
+	}
+	if cfg.NormalizeEOL != "" {
+		sourceVars = normalizeEOL(sourceVars, cfg.NormalizeEOL, cfg.Explain)
+	}
+
+	if cfg.Where != "" {
+		where, err := parseWhere(cfg.Where)
+		if err != nil {
+			log.Fatalf("Error: invalid --where expression: %v", err)
+		}
+		sourceVars = filterVariables(sourceVars, where, "--where", cfg.Explain)
+	}
+	if cfg.ValueMatch != "" {
+		pattern, err := regexp.Compile(cfg.ValueMatch)
+		if err != nil {
+			log.Fatalf("Error: invalid --value-match: %v", err)
+		}
+		sourceVars = filterVariables(sourceVars, func(v EnvVar) bool {
+			return pattern.MatchString(v.Value)
+		}, "value does not match --value-match", cfg.Explain)
+		log.Printf("Kept %d source variables matching --value-match", len(sourceVars))
+	}
+	if cfg.ConsolidateReviewScopes {
+		sourceVars = consolidateReviewScopes(sourceVars)
+	}
+	if cfg.ExpandReviewScopes != "" {
+		sourceVars = expandReviewScopes(sourceVars, splitList(cfg.ExpandReviewScopes))
+	}
+	if len(cfg.ScopeRenames.renames) > 0 {
+		if sourceVars, err = renameScopes(sourceVars, cfg.ScopeRenames.renames, cfg.ScopeCollision, cfg.Explain); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	if cfg.AliasFile != "" {
+		aliases, err := readAliasFile(cfg.AliasFile)
+		if err != nil {
+			log.Fatalf("Error reading alias file: %v", err)
+		}
+		if sourceVars, err = applyAliases(sourceVars, aliases, cfg.Explain); err != nil {
+			log.Fatalf("Error applying alias file %s: %v", cfg.AliasFile, err)
+		}
+	}
+	if cfg.ValuePrefix != "" || cfg.ValueSuffix != "" {
+		keys, err := compileNameList(cfg.ValueAffixKeys)
+		if err != nil {
+			log.Fatalf("Error: invalid --value-affix-keys: %v", err)
+		}
+		sourceVars = affixValues(sourceVars, cfg.ValuePrefix, cfg.ValueSuffix, keys, cfg.DryRun || cfg.Explain)
+	}
+	if cfg.MarkManaged {
+		sourceVars = markManaged(sourceVars)
+	}
+	if cfg.SortKeys || cfg.ContinueFrom != "" {
+		sourceVars = sortedByKey(sourceVars)
+	}
+	if err := checkExpectedCount(len(sourceVars), cfg.ExpectCount, cfg.ExpectCountMin, cfg.ExpectCountMax); err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(exitMismatch)
+	}
+
+	if cfg.Audit {
+		report := auditVariables(cfg.SourceProject, sourceVars)
+		if cfg.AuditSizes {
+			report.Sizes = valueSizes(sourceVars)
+		}
+		if err := writeAuditReport(os.Stdout, cfg.LogFormat, report); err != nil {
+			log.Fatalf("Error writing audit report: %v", err)
+		}
+		return
+	}
+
+	if cfg.Fingerprint {
+		fmt.Println(fingerprint(sourceVars))
+		return
+	}
+
+	if cfg.Snapshot != "" {
+		if err := writeSnapshot(cfg.Snapshot, cfg.SourceProject, sourceVars, cfg.SnapshotValues, cfg.FileMode.modeFor(false)); err != nil {
+			log.Fatalf("Error writing snapshot: %v", err)
+		}
+		log.Printf("Wrote snapshot of %d variables to %s", len(sourceVars), cfg.Snapshot)
+		return
+	}
+
+	if cfg.List {
+		listed := projectVariables(sourceVars)
+		if cfg.Effective {
+			listed = readEffectiveVariables(sourceClient, cfg.SourceProject, sourceVars)
+		}
+		if err := writeVariableList(os.Stdout, cfg.LogFormat, listed); err != nil {
+			log.Fatalf("Error writing variable list: %v", err)
+		}
+		return
+	}
+
+	if cfg.ExportFile != "" {
+		exportVars := sourceVars
+		if cfg.StripScopes {
+			exportVars = stripScopes(exportVars)
+		}
+		if cfg.ExportFormat == exportFormatCI && !cfg.ShowValues {
+			exportVars = redactSecrets(exportVars)
+		}
+		var err error
+		if cfg.ExportFormat == exportFormatSOPS {
+			exportVars = withoutHidden(exportVars)
+		}
+		if cfg.SOPS {
+			err = writeSOPSExport(cfg.ExportFile, exportVars, cfg.FileMode.modeFor(true))
+		} else {
+			err = writeExportFile(cfg.ExportFile, cfg.ExportFormat, exportVars, cfg.EncryptRecipient.key, cfg.FileMode.modeFor(true))
+		}
+		if err != nil {
+			log.Fatalf("Error writing export: %v", err)
+		}
+		if cfg.ExportFile == exportStdout {
+			log.Printf("Exported %d variables to stdout", len(exportVars))
+		} else {
+			log.Printf("Exported %d variables to %s", len(exportVars), cfg.ExportFile)
+		}
+		return
+	}
+
+	if cfg.CompareFile != "" {
+		baseline, valuesOnly, err := readBaseline(cfg.CompareFile, cfg.DecryptKey.key, cfg.StrictSchema)
+		if err != nil {
+			log.Fatalf("Error reading baseline: %v", err)
+		}
+		if valuesOnly {
+			log.Printf("Baseline %s is a .env file; only keys and values are compared", cfg.CompareFile)
+		}
+		writeDiff(os.Stdout, diffVariables(baseline, sourceVars, valuesOnly), colorEnabled(os.Stdout, cfg.NoColor))
+		return
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	resolve, err := newResolver(cfg.Resolve, stdin, os.Stderr)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	opts := transferOptions{
+		Upsert:          cfg.Upsert,
+		UpdateOnly:      cfg.UpdateOnly,
+		Replace:         cfg.Replace,
+		Safe:            cfg.SafeMode,
+		AllowOverwrite:  cfg.AllowOverwrite,
+		MergeAttributes: cfg.MergeAttributes,
+		Resolve:         resolve,
+		OnMaskFailure:   cfg.OnMaskFailure,
+		VariableTimeout: cfg.VariableTimeout,
+		Threads:         cfg.ThreadsPerTarget,
+		StopOnFailure:   cfg.Transactional,
+	}
+	if opts.CompareFields, err = parseCompareFields(cfg.CompareFields); err != nil {
+		log.Fatalf("Error: invalid --compare-fields: %v", err)
+	}
+	if opts.Protect, err = compileNameList(cfg.ProtectKeys); err != nil {
+		log.Fatalf("Error: invalid --protect-keys: %v", err)
+	}
+
+	targets := resolveTargets(client, cfg)
+	if cfg.FindOrphans {
+		if err := runFindOrphans(os.Stdout, client, sourceVars, targets); err != nil {
+			os.Exit(exitCodeFor(err))
+		}
+		return
+	}
+	if state != nil {
+		state.observeSource(cfg.SourceProject, sourceVars)
+	}
+	run := &targetRun{client: client, cfg: cfg, opts: opts, stdin: stdin, multi: len(targets) > 1, state: state}
+	if cfg.TargetGroup != "" && !cfg.DryRun {
+		confirmTargets(stdin, targets, cfg)
+	}
+	if cfg.Transactional && !cfg.DryRun {
+		run.masking = resolveMaskingRules(client, cfg.GitLabVersion)
+	}
+	if run.highRisk, err = compileNameList(cfg.HighRiskScopes); err != nil {
+		log.Fatalf("Error: invalid --high-risk-scopes: %v", err)
+	}
+	if cfg.OutputJSONL {
+		run.stream = newJSONLWriter(os.Stdout)
+	}
+	if cfg.RetryFailures != "" {
+		records, err := readFailuresFile(cfg.RetryFailures)
+		if err != nil {
+			log.Fatalf("Error reading failures file: %v", err)
+		}
+		var skipped int
+		run.retry, skipped = newRetrySet(records, cfg.RetryAll)
+		if skipped > 0 {
+			log.Printf("Not retrying %d permanent failures (use --retry-all to include them)", skipped)
+		}
+	}
+	if cfg.Journal != "" && !cfg.DryRun {
+		var err error
+		run.journal, err = openJournal(cfg.Journal, cfg.SourceProject, cfg.FileMode.modeFor(false))
+		if err != nil {
+			log.Fatalf("Error opening journal: %v", err)
+		}
+	}
+	if cfg.Checkpoint != "" && !cfg.DryRun {
+		var err error
+		run.checkpoint, err = loadCheckpoint(cfg.Checkpoint, cfg.SourceProject, cfg.Resume, cfg.FileMode.modeFor(false))
+		if err != nil {
+			log.Fatalf("Error reading checkpoint file: %v", err)
+		}
+	}
+
+	var summary *runSummary
+	var syncErr error
+	if run.multi {
+		var summaries []*runSummary
+		summary = newRunSummary(cfg.SourceProject, cfg.TargetGroup, cfg.DryRun)
+		summaries, syncErr = run.syncAll(sourceVars, targets, cfg.ParallelProjects)
+		for _, target := range summaries {
+			summary.add(target)
+		}
+		logTargetSummaries(summary)
+	} else {
+		summary, syncErr = run.sync(sourceVars, targets[0])
+	}
+
+	summary.finish()
+	if cfg.CompactSummary {
+		fmt.Fprintln(os.Stderr, summary.compact())
+	}
+	if cfg.SummaryTable && isTerminal(os.Stderr) {
+		if err := writeSummaryTable(os.Stderr, summary, run.failures); err != nil {
+			log.Printf("Warning: failed to write summary table: %v", err)
+		}
+	}
+
+	if cfg.MetricsFile != "" {
+		if err := writeMetricsFile(cfg.MetricsFile, summary, cfg.FileMode.modeFor(false)); err != nil {
+			log.Printf("Warning: failed to write metrics file: %v", err)
+		}
+	}
+
+	if state != nil && !cfg.DryRun {
+		if err := writeStateFile(cfg.StateFile, state, cfg.FileMode.modeFor(false)); err != nil {
+			log.Printf("Warning: failed to write state file: %v", err)
+		}
+	}
+
+	if cfg.FailuresFile != "" && !cfg.DryRun {
+		if err := writeFailuresFile(cfg.FailuresFile, run.failures, cfg.FileMode.modeFor(false)); err != nil {
+			log.Printf("Warning: failed to write failures file: %v", err)
+		} else if len(run.failures) > 0 {
+			log.Printf("Recorded %d failures in %s", len(run.failures), cfg.FailuresFile)
+		}
+	}
+
+	if cfg.WebhookURL != "" {
+		if err := postSummary(cfg.WebhookURL, cfg.WebhookHeaders.header, summary); err != nil {
+			log.Printf("Warning: failed to post summary to webhook: %v", err)
+		}
+	}
+
+	if cfg.PostSyncCmd != "" {
+		data, err := json.Marshal(summary)
+		if err == nil {
+			err = runSyncHook(cfg.PostSyncCmd, postSyncEnv(cfg, summary), bytes.NewReader(data))
+		}
+		if err != nil {
+			log.Printf("Warning: --post-sync-cmd failed: %v", err)
+		}
+	}
+
+	if cfg.OnCompleteCmd != "" {
+		if err := runOnComplete(cfg.OnCompleteCmd, summary); err != nil {
+			log.Printf("Warning: --on-complete-cmd failed: %v", err)
+			if cfg.OnCompleteAffectsExit {
+				code = exitFailure
+			}
+		}
+	}
+
+	switch {
+	case syncErr != nil:
+		code = exitCodeFor(syncErr)
+	case summary.Failed > 0 || summary.RollbackFailed > 0:
+		code = exitFailure
+	case code == 0 && cfg.QuietDryRun && summary.Created+summary.Updated+summary.Pruned > 0:
+		code = exitMismatch
+	}
+	// os.Exit skips deferred calls, so the journal is closed here.
+	if run.journal != nil {
+		if err := run.journal.Close(); err != nil {
+			log.Printf("Warning: failed to close journal %s: %v", cfg.Journal, err)
+		}
+	}
+}
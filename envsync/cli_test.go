@@ -0,0 +1,179 @@
+package envsync
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetVariablesUnknownProject(t *testing.T) {
+	f := newFakeGitLab(t)
+	if _, err := f.client().GetVariables("g/missing"); err == nil || err.Error() != "project not found: g/missing" {
+		t.Errorf("err = %v, want project not found", err)
+	}
+}
+
+func TestGetVariablesFollowsPages(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.maxPerPage = 2
+	for i := 0; i < 5; i++ {
+		f.projects["g/app"] = append(f.projects["g/app"], envVar(fmt.Sprintf("K%d", i), "v", "*"))
+	}
+
+	variables, err := f.client().GetVariables("g/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variables) != 5 || variables[4].Key != "K4" {
+		t.Errorf("GetVariables returned %v, want K0 to K4", variables)
+	}
+	if n := len(f.received("GET")); n != 4 {
+		t.Errorf("%d requests, want one project lookup and three pages", n)
+	}
+}
+
+// glabVariables is the shape of `glab variable list -o json` and of the API.
+const glabVariables = `
+[
+  {"key": "API_URL", "value": "https://example.com", "variable_type": "env_var", "protected": false, "masked": false, "hidden": false, "raw": false, "environment_scope": "*", "description": null},
+  {"key": "CONFIG", "value": "a: 1", "variable_type": "file", "protected": true, "masked": false, "hidden": false, "raw": true, "environment_scope": "production", "description": "App config"}
+]
+`
+
+func TestReadDryRunOutputShapes(t *testing.T) {
+	bare := writeFile(t, "variables.json", glabVariables)
+	wrapped := writeFile(t, "plan.json", `{"source_project": "g/src", "target_project": "g/dst", "variables": `+glabVariables+`}`)
+
+	for _, filename := range []string{bare, wrapped} {
+		plan, err := readDryRunOutput(filename, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Variables) != 2 {
+			t.Fatalf("%s: variables = %v", filename, plan.Variables)
+		}
+		config := plan.Variables[1]
+		if config.Key != "CONFIG" || config.VariableType != "file" || !config.Protected || !config.Raw || config.EnvironmentScope != "production" || config.Description != "App config" {
+			t.Errorf("%s: CONFIG = %+v", filename, config)
+		}
+	}
+	if plan, _ := readDryRunOutput(wrapped, nil, false); plan.TargetProject != "g/dst" {
+		t.Errorf("wrapped plan lost its target: %+v", plan)
+	}
+	if _, err := readDryRunOutput(writeFile(t, "broken.json", `[{"key": 1}]`), nil, false); err == nil || !strings.HasPrefix(err.Error(), "invalid variable list") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// A raw variable list works for both --import and --apply.
+func TestImportBareVariableList(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/imported"] = nil
+	f.projects["g/applied"] = nil
+	list := writeFile(t, "variables.json", glabVariables)
+
+	for _, args := range [][]string{{"--import", list, "--target", "g/imported"}, {"--apply", list, "--target", "g/applied"}} {
+		if _, stderr, code := runMain(t, "", f.args(args...)...); code != 0 {
+			t.Fatalf("%v: exit code %d; stderr:\n%s", args, code, stderr)
+		}
+		target := args[3]
+		if got := f.vars(target); len(got) != 2 || got[1].EnvironmentScope != "production" || got[1].VariableType != "file" {
+			t.Errorf("%s = %v", target, got)
+		}
+	}
+}
+
+// With --source-gitlab-url, source reads go to the replica with the source
+// token and everything else to the primary.
+func TestSourceReplica(t *testing.T) {
+	replica := newFakeGitLab(t)
+	replica.projects["grp/sub/app"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "staging")}
+	primary := newFakeGitLab(t)
+	primary.projects["grp/sub/app"] = []EnvVar{envVar("A", "old", "")}
+	primary.projects["grp/sub/app-copy"] = []EnvVar{envVar("A", "old", "")}
+
+	_, stderr, code := runMain(t, "", "--gitlab-url", primary.server.URL, "--token", "primary-token",
+		"--source-gitlab-url", replica.server.URL, "--source-token", "replica-token",
+		"--source", "grp/sub/app", "--target", "grp/sub/app-copy", "--upsert")
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+
+	for _, r := range replica.received() {
+		if r.Method != http.MethodGet || strings.TrimSuffix(r.Path, "/variables") != "projects/grp%2Fsub%2Fapp" {
+			t.Errorf("replica got %s", r)
+		}
+		if token := r.Header.Get("PRIVATE-TOKEN"); token != "replica-token" {
+			t.Errorf("replica got token %q", token)
+		}
+	}
+	if len(replica.received()) == 0 {
+		t.Error("the source was not read from the replica")
+	}
+	for _, r := range primary.received() {
+		if !strings.HasPrefix(r.Path, "projects/grp%2Fsub%2Fapp-copy") {
+			t.Errorf("primary got %s", r)
+		}
+		if token := r.Header.Get("PRIVATE-TOKEN"); token != "primary-token" {
+			t.Errorf("primary got token %q", token)
+		}
+	}
+	if got := primary.vars("grp/sub/app-copy"); len(got) != 2 || got[0].Value != "1" {
+		t.Errorf("target = %v", got)
+	}
+	if got := primary.vars("grp/sub/app"); got[0].Value != "old" {
+		t.Errorf("the primary's copy of the source was changed: %v", got)
+	}
+}
+
+// Paths with dots, plus signs and other special characters reach the
+// project and group they name, fully URL-encoded in a single :id segment.
+func TestSpecialCharacterPaths(t *testing.T) {
+	for path, segment := range map[string]string{
+		"group/my.project":      "group%2Fmy.project",
+		"group/project+fork":    "group%2Fproject%2Bfork",
+		"my.group/sub/app_1~x":  "my.group%2Fsub%2Fapp_1~x",
+		"group/a b@c:d":         "group%2Fa%20b%40c%3Ad",
+		"group/100%/x#y?z=1&w;": "group%2F100%25%2Fx%23y%3Fz%3D1%26w%3B",
+	} {
+		t.Run(segment, func(t *testing.T) {
+			f := newFakeGitLab(t)
+			f.projects[path] = []EnvVar{envVar("A", "1", "")}
+			f.groups[path] = []EnvVar{envVar("G", "1", "")}
+			client := f.client()
+
+			if vars, err := client.GetVariables(path); err != nil || len(vars) != 1 {
+				t.Fatalf("GetVariables = %v, %v", vars, err)
+			}
+			if err := client.UpdateVariable(path, envVar("A", "2", "")); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.CreateVariable(path, envVar("B", "3", ""), false); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.DeleteVariable(path, envVar("B", "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if vars, err := client.GetGroupVariables(path); err != nil || len(vars) != 1 {
+				t.Fatalf("GetGroupVariables = %v, %v", vars, err)
+			}
+
+			want := []string{
+				"GET projects/" + segment,
+				"GET projects/" + segment + "/variables",
+				"PUT projects/" + segment + "/variables/A",
+				"POST projects/" + segment + "/variables",
+				"DELETE projects/" + segment + "/variables/B",
+				"GET groups/" + segment + "/variables",
+			}
+			var got []string
+			for _, r := range f.received() {
+				got = append(got, r.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("requests =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
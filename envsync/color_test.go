@@ -0,0 +1,93 @@
+package envsync
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestColorEnabled(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	file, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	// /dev/null is a character device, so it stands in for a terminal.
+	tty, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer tty.Close()
+
+	for _, tc := range []struct {
+		name    string
+		f       *os.File
+		noColor string
+		term    string
+		flag    bool
+		want    bool
+	}{
+		{"terminal", tty, "", "xterm", false, true},
+		{"pipe", w, "", "xterm", false, false},
+		{"file", file, "", "xterm", false, false},
+		{"NO_COLOR", tty, "1", "xterm", false, false},
+		{"TERM=dumb", tty, "", "dumb", false, false},
+		{"--no-color", tty, "", "xterm", true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("NO_COLOR", tc.noColor)
+			t.Setenv("TERM", tc.term)
+			if got := colorEnabled(tc.f, tc.flag); got != tc.want {
+				t.Errorf("colorEnabled = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestColorizedOutput(t *testing.T) {
+	entries := diffVariables([]EnvVar{envVar("OLD", "1", "")}, []EnvVar{envVar("NEW", "1", "")}, false)
+	prune := []EnvVar{envVar("STALE", "1", "")}
+
+	var plain, colored bytes.Buffer
+	writeDiff(&plain, entries, false)
+	writePruneList(&plain, prune, false)
+	writeDiff(&colored, entries, true)
+	writePruneList(&colored, prune, true)
+
+	if strings.Contains(plain.String(), "\x1b[") {
+		t.Errorf("uncolored output has escape codes: %q", plain.String())
+	}
+	if !strings.Contains(colored.String(), colorRed+"  - STALE@*"+colorReset) {
+		t.Errorf("colored output = %q, want the prune list in red", colored.String())
+	}
+}
+
+// Piped output with NO_COLOR set, as runMain runs, is never colored.
+func TestPipedOutputHasNoColor(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("STALE", "1", "")}
+	baseline := writeFile(t, "baseline.env", "A=0\nC=3\n")
+
+	stdout, stderr, _ := runMain(t, "", f.args("--source", "g/src", "--compare", baseline)...)
+	pruneOut, pruneErr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--prune", "--dry-run")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, pruneErr)
+	}
+	for _, out := range []string{stdout, stderr, pruneOut, pruneErr} {
+		if strings.Contains(out, "\x1b[") {
+			t.Errorf("output has escape codes: %q", out)
+		}
+	}
+	if !strings.Contains(pruneErr, "  - STALE@*") {
+		t.Errorf("stderr does not list the prune:\n%s", pruneErr)
+	}
+}
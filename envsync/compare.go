@@ -0,0 +1,121 @@
+package envsync
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// Kinds of difference reported by diffVariables.
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// diffEntry is one difference between a baseline and the source.
+type diffEntry struct {
+	Key    variableKey
+	Change string
+	Fields []string
+}
+
+func (d diffEntry) String() string {
+	switch d.Change {
+	case diffAdded:
+		return "+ " + d.Key.String()
+	case diffRemoved:
+		return "- " + d.Key.String()
+	default:
+		return fmt.Sprintf("~ %s (%s changed)", d.Key, strings.Join(d.Fields, ", "))
+	}
+}
+
+// readBaseline loads a baseline for --compare. JSON files are read as plans
+// written by --dry-run; anything else is parsed as a .env file. The returned
+// flag is true when the baseline only carries keys and values.
+func readBaseline(filename string, identity *age.X25519Identity, strict bool) ([]EnvVar, bool, error) {
+	if strings.HasSuffix(filename, ".json") {
+		plan, err := readDryRunOutput(filename, identity, strict)
+		if err != nil {
+			return nil, false, err
+		}
+		return plan.Variables, false, nil
+	}
+
+	variables, err := readDotEnv(filename)
+	return variables, true, err
+}
+
+// diffVariables compares the source against a baseline. With valuesOnly the
+// baseline has no scopes or attributes, so variables are matched by key alone
+// and only values are compared.
+func diffVariables(baseline, source []EnvVar, valuesOnly bool) []diffEntry {
+	identity := keyOf
+	if valuesOnly {
+		identity = func(v EnvVar) variableKey {
+			return variableKey{Key: v.Key, Scope: defaultScope}
+		}
+	}
+
+	base := map[variableKey]EnvVar{}
+	for _, v := range baseline {
+		base[identity(v)] = v
+	}
+
+	var entries []diffEntry
+	seen := map[variableKey]bool{}
+	for _, v := range source {
+		id := identity(v)
+		seen[id] = true
+		old, ok := base[id]
+		if !ok {
+			entries = append(entries, diffEntry{Key: keyOf(v), Change: diffAdded})
+			continue
+		}
+
+		fields := changedFields(v, old)
+		if valuesOnly {
+			fields = nil
+			if v.Value != old.Value {
+				fields = []string{fieldValue}
+			}
+		}
+		if len(fields) > 0 {
+			entries = append(entries, diffEntry{Key: keyOf(v), Change: diffChanged, Fields: fields})
+		}
+	}
+	for id, v := range base {
+		if !seen[id] {
+			entries = append(entries, diffEntry{Key: keyOf(v), Change: diffRemoved})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Key.Key != entries[j].Key.Key {
+			return entries[i].Key.Key < entries[j].Key.Key
+		}
+		return entries[i].Key.Scope < entries[j].Key.Scope
+	})
+	return entries
+}
+
+// writeDiff prints a diff report. Values are never included.
+func writeDiff(w io.Writer, entries []diffEntry, color bool) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No differences")
+		return
+	}
+	for _, e := range entries {
+		fmt.Fprintln(w, colorize(e.String(), diffColors[e.Change], color))
+	}
+}
+
+var diffColors = map[string]string{
+	diffAdded:   colorGreen,
+	diffRemoved: colorRed,
+	diffChanged: colorYellow,
+}
@@ -0,0 +1,71 @@
+package envsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func diffStrings(entries []diffEntry) string {
+	var lines []string
+	for _, e := range entries {
+		lines = append(lines, e.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDotEnvBaselineComparesValuesOnly(t *testing.T) {
+	baseline, valuesOnly, err := readBaseline(writeFile(t, "base.env", "SAME=1\nCHANGED=old\nGONE=x\n"), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valuesOnly {
+		t.Fatal("a .env baseline should compare values only")
+	}
+	protected := EnvVar{Key: "SAME", Value: "1", VariableType: "file", EnvironmentScope: "production", Protected: true}
+	source := []EnvVar{protected, envVar("CHANGED", "new", ""), envVar("NEW", "1", "")}
+
+	want := "~ CHANGED@* (value changed)\n- GONE@*\n+ NEW@*"
+	if got := diffStrings(diffVariables(baseline, source, valuesOnly)); got != want {
+		t.Errorf("diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDiffVariablesComparesAttributes(t *testing.T) {
+	masked := envVar("A", "1", "production")
+	masked.Masked = true
+	baseline := []EnvVar{envVar("A", "1", "production"), envVar("A", "1", "")}
+	source := []EnvVar{masked, envVar("A", "1", "")}
+
+	want := "~ A@production (masked changed)"
+	if got := diffStrings(diffVariables(baseline, source, false)); got != want {
+		t.Errorf("diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCompareWithDotEnvBaseline(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")}
+	baseline := writeFile(t, "base.env", "A=1\nB=old\n")
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/src", "--compare", baseline)...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if stdout != "~ B@* (value changed)\n" {
+		t.Errorf("stdout = %q", stdout)
+	}
+	if !strings.Contains(stderr, "only keys and values are compared") {
+		t.Errorf("stderr lacks the values-only note:\n%s", stderr)
+	}
+}
@@ -0,0 +1,301 @@
+package envsync
+
+import (
+	"flag"
+	"time"
+)
+
+// config holds the settings of a run as resolved from the command line.
+type config struct {
+	GitLabURL string
+	Token     string
+
+	// SourceGitLabURL and SourceToken, if set, are used to read the source
+	// project instead of GitLabURL and Token.
+	SourceGitLabURL string
+	SourceToken     string
+
+	SourceProject string
+	SourceScopes  string
+	Overlays      overlayFlag
+	TargetProject string
+	TargetGroup   string
+	TargetExclude string
+	FieldMapFile  string
+	StrictSchema  bool
+	SOCKS5        string
+	Pool          ConnectionPool
+
+	Retries     int
+	Backoff     string
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+
+	SimulateFailures float64
+
+	DryRun       bool
+	QuietDryRun  bool
+	SplitPlan    string
+	OutputFile   string
+	DryRunFormat string
+	FileMode     fileModeFlag
+
+	EncryptOutput    bool
+	EncryptRecipient recipientFlag
+	DecryptKey       identityFlag
+	GenerateKey      string
+	ApplyFile        string
+	Force            bool
+	ValidatePlan     string
+	GitLabVersion    string
+	Tokenize         bool
+	SecretsFile      string
+	ImportFile       string
+	ImportScope      string
+	CSVColumns       string
+	CompareFile      string
+
+	ExportFile   string
+	ExportFormat string
+	ShowValues   bool
+	SOPS         bool
+	StripScopes  bool
+
+	FailuresFile  string
+	RetryFailures string
+	RetryAll      bool
+
+	Checkpoint string
+	Resume     bool
+	Journal    string
+
+	ChecksumOutput string
+	RollbackScript string
+	VerifyChecksum string
+
+	StateFile        string
+	DetectDrift      bool
+	FailOnDrift      bool
+	ChangedSinceSync bool
+
+	Upsert            bool
+	UpdateOnly        bool
+	Replace           bool
+	MergeAttributes   bool
+	CompareFields     string
+	Resolve           string
+	OnMaskFailure     string
+	VariableTimeout   time.Duration
+	ThreadsPerTarget  int
+	ParallelProjects  int
+	RateLimit         float64
+	VerifyBeforeWrite bool
+	SortKeys          bool
+	ContinueFrom      string
+	ExpectCount       int
+	ExpectCountMin    int
+	ExpectCountMax    int
+	Prune             bool
+	PruneBatchSize    int
+	Transactional     bool
+	AssumeYes         bool
+	HighRiskScopes    string
+	ConfirmProduction bool
+	SafeMode          bool
+	AllowOverwrite    bool
+	AllowDelete       bool
+
+	CheckInheritance bool
+	CheckFork        bool
+	MarkManaged      bool
+	ProtectKeys      string
+	FindOrphans      bool
+	CheckReferences  bool
+	RawUnresolved    bool
+	Where            string
+	ValueMatch       string
+	MatrixFile       string
+	DecodeBase64     string
+	EncodeBase64     string
+	ValuePrefix      string
+	ValueSuffix      string
+	ValueAffixKeys   string
+	NormalizeEOL     string
+
+	ConsolidateReviewScopes bool
+	ExpandReviewScopes      string
+	ScopeRenames            scopeRenameFlag
+	ScopeCollision          string
+	AliasFile               string
+
+	Explain     bool
+	PrintConfig bool
+	OutputJSONL bool
+	LogFormat   string
+	RunID       string
+	NoColor     bool
+	Audit       bool
+	AuditSizes  bool
+	Fingerprint bool
+	List        bool
+	Effective   bool
+
+	Snapshot       string
+	SnapshotValues string
+
+	MetricsFile    string
+	CompactSummary bool
+	SummaryTable   bool
+
+	WebhookURL     string
+	WebhookHeaders headerFlag
+
+	OnCompleteCmd         string
+	PreSyncCmd            string
+	PostSyncCmd           string
+	OnCompleteAffectsExit bool
+
+	// planLock is set by --apply when the plan recorded the target's state.
+	planLock *stateLock
+}
+
+func (c *config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.GitLabURL, "gitlab-url", "", "GitLab instance URL (e.g., https://gitlab.com)")
+	fs.StringVar(&c.Token, "token", "", "GitLab access token")
+	fs.StringVar(&c.SourceGitLabURL, "source-gitlab-url", "", "Read the source project from this GitLab URL, e.g. a read replica (default --gitlab-url)")
+	fs.StringVar(&c.SourceToken, "source-token", "", "Access token for reading the source project (default --token)")
+	fs.StringVar(&c.SourceProject, "source", "", "Source project path (e.g., group/project)")
+	fs.StringVar(&c.SourceScopes, "source-scopes", "", "Only read source variables in these comma-separated environment scopes, e.g. staging,*")
+	fs.Var(&c.Overlays, "overlay", "Merge the variables of this project or .env/.csv file over the source; repeatable, later overlays win")
+	fs.StringVar(&c.TargetProject, "target", "", "Target project path (e.g., group/project)")
+	fs.StringVar(&c.TargetGroup, "target-group", "", "Sync to every project in this group and its subgroups")
+	fs.StringVar(&c.TargetExclude, "target-exclude", "", "Comma-separated project paths or regular expressions to leave out of --target-group")
+
+	fs.StringVar(&c.SOCKS5, "socks5", "", "Reach GitLab through a SOCKS5 proxy (host:port or socks5:// URL), e.g. an SSH -D tunnel")
+	fs.IntVar(&c.Pool.MaxIdleConns, "max-idle-conns", 0, "Idle connections kept open across all hosts (default: 100)")
+	fs.IntVar(&c.Pool.MaxIdleConnsPerHost, "max-idle-conns-per-host", 0, "Idle connections kept open to the GitLab host (default: 2)")
+	fs.IntVar(&c.Pool.MaxConnsPerHost, "max-conns-per-host", 0, "Limit on connections to the GitLab host (default: no limit)")
+	fs.IntVar(&c.Retries, "retries", 0, "Retry requests that fail with a network error, 429 or 5xx up to this many times")
+	fs.StringVar(&c.Backoff, "backoff", backoffExponential, "Wait between --retries: constant, linear, exponential or exponential-jitter")
+	fs.DurationVar(&c.BackoffBase, "backoff-base", time.Second, "First wait between --retries")
+	fs.DurationVar(&c.BackoffMax, "backoff-max", 30*time.Second, "Longest wait between --retries")
+	fs.Float64Var(&c.SimulateFailures, "simulate-failures", 0, "Testing aid: fail this share (0-1) of writes with a synthetic 503; only allowed against a local server")
+	fs.StringVar(&c.FieldMapFile, "field-map", "", "JSON file renaming variable fields for GitLab-compatible APIs, e.g. {\"environment_scope\": \"scope\"}")
+	fs.BoolVar(&c.StrictSchema, "strict-schema", false, "Fail on unknown fields in API responses and plan files instead of ignoring them")
+
+	fs.BoolVar(&c.DryRun, "dry-run", false, "Perform a dry run and write output to file")
+	fs.StringVar(&c.OutputFile, "output", "env-sync-dry-run.json", "Output file for dry run (default: env-sync-dry-run.json)")
+	fs.BoolVar(&c.QuietDryRun, "quiet-dry-run", false, "Compare with the target and print only the would-create/update/delete counts; exit 2 if changes are pending")
+	fs.StringVar(&c.SplitPlan, "split-plan", "", "Write the dry run as PREFIXcreates.json, PREFIXupdates.json and PREFIXdeletes.json instead of --output")
+	fs.StringVar(&c.DryRunFormat, "dry-run-format", planFormatJSON, "Dry-run output format: json, yaml, table or env (only json can be applied)")
+	fs.Var(&c.FileMode, "file-mode", "Octal permissions for written files (default: 0600 for files with values, 0644 otherwise)")
+	fs.BoolVar(&c.EncryptOutput, "encrypt-output", false, "Encrypt plans, secrets files and exports for --encrypt-recipient")
+	fs.Var(&c.EncryptRecipient, "encrypt-recipient", "age public key (age1...) to encrypt output files for")
+	fs.Var(&c.DecryptKey, "decrypt-key", "age private key file to read encrypted plans, secrets files and baselines")
+	fs.StringVar(&c.GenerateKey, "generate-key", "", "Write a new private key to this file, print its public key and exit")
+	fs.StringVar(&c.ApplyFile, "apply", "", "Apply a plan file previously written by --dry-run")
+	fs.StringVar(&c.ValidatePlan, "validate-plan", "", "Validate a plan file offline and exit")
+	fs.StringVar(&c.GitLabVersion, "gitlab-version", "", "Validate masked values against the rules of this GitLab release (MAJOR.MINOR) instead of the target's detected version")
+	fs.BoolVar(&c.Force, "force", false, "Apply a plan even if the target changed since it was written")
+	fs.BoolVar(&c.Tokenize, "tokenize-values", false, "In dry run, replace values with reference tokens and store the real values in --secrets-file")
+	fs.StringVar(&c.SecretsFile, "secrets-file", "", "Token-to-value map for --tokenize-values and --apply (default: <plan>.secrets.json)")
+	fs.StringVar(&c.ImportFile, "import", "", "Read source variables from a .env, .csv, .json or sops-yaml .yaml file instead of a source project")
+	fs.StringVar(&c.ImportScope, "import-scope", "", "Environment scope to assign to all imported variables (default: *)")
+	fs.StringVar(&c.CSVColumns, "csv-columns", "", "Map CSV columns to variable fields for a .csv --import, e.g. key=NAME,value=SECRET")
+	fs.StringVar(&c.ExportFile, "export", "", "Write the source variables to this file, or to stdout for -, and exit")
+	fs.StringVar(&c.ExportFormat, "export-format", exportFormatDotEnv, "Format for --export: dotenv, json, gitlab-ci or sops-yaml")
+	fs.BoolVar(&c.SOPS, "sops", false, "Encrypt a sops-yaml --export, or decrypt a .yaml --import, with the sops binary")
+	fs.BoolVar(&c.ShowValues, "show-values", false, "Include masked, hidden and protected values in a gitlab-ci export and in yaml, table and env plans; required for sops-yaml")
+	fs.BoolVar(&c.StripScopes, "strip-scopes", false, "With --export, drop environment scopes from the exported variables")
+	fs.StringVar(&c.CompareFile, "compare", "", "Diff the source against a baseline (.env or dry-run JSON file) and exit")
+
+	fs.StringVar(&c.FailuresFile, "failures-file", "", "After a live run, record failed variables and their failure category in this file")
+	fs.StringVar(&c.RetryFailures, "retry-failures", "", "Only sync the retryable failures recorded in this --failures-file")
+	fs.StringVar(&c.Checkpoint, "checkpoint", "", "Record synced variables in this file after each success")
+	fs.StringVar(&c.Journal, "journal", "", "Append every change made to targets to this JSONL file")
+	fs.BoolVar(&c.Resume, "resume", false, "Skip variables already recorded in the --checkpoint file")
+	fs.BoolVar(&c.RetryAll, "retry-all", false, "With --retry-failures, also retry permanent failures such as validation errors")
+
+	fs.StringVar(&c.RollbackScript, "rollback-script", "", "After a live run, write a shell script that undoes its changes to this file (mode 0600, it contains previous values)")
+	fs.StringVar(&c.ChecksumOutput, "checksum-output", "", "After a live run, write a SHA-256 checksum of every transferred value to this file")
+	fs.StringVar(&c.VerifyChecksum, "verify-checksum", "", "Re-fetch the target and verify it against a --checksum-output file, then exit")
+	fs.StringVar(&c.StateFile, "state-file", "", "Record what live runs write to each target in this file")
+	fs.BoolVar(&c.DetectDrift, "detect-drift", false, "Report target variables changed outside env-sync since the --state-file was written, and exit")
+	fs.BoolVar(&c.FailOnDrift, "fail-on-drift", false, "With --detect-drift, exit with code 2 when drift is found")
+	fs.BoolVar(&c.ChangedSinceSync, "changed-since-sync", false, "Only sync variables that changed in the source since they were last synced to the target, per --state-file")
+
+	fs.BoolVar(&c.Upsert, "upsert", false, "Update variables that already exist in the target instead of failing")
+	fs.BoolVar(&c.Replace, "replace", false, "Delete and recreate every source variable that exists in the target instead of updating it")
+	fs.BoolVar(&c.UpdateOnly, "update-only", false, "Only update variables that already exist in the target, never create new ones (implies --upsert)")
+	fs.BoolVar(&c.MergeAttributes, "merge-attributes", false, "With --upsert, keep the target's attributes for fields the source does not specify")
+	fs.StringVar(&c.CompareFields, "compare-fields", "", "With --upsert, only compare and update these comma-separated fields, e.g. value (default: all)")
+	fs.StringVar(&c.Resolve, "resolve", resolveSource, "With --upsert, how to resolve value conflicts: source, target or interactive")
+	fs.StringVar(&c.OnMaskFailure, "on-mask-failure", maskFailureFail, "When GitLab rejects a masked value: fail, skip, or unmask (create it unmasked)")
+	fs.IntVar(&c.ThreadsPerTarget, "threads-per-target", 1, "Write this many variables of a target at the same time")
+	fs.IntVar(&c.ParallelProjects, "parallel-projects", 1, "Sync this many targets of a --target-group run at the same time")
+	fs.Float64Var(&c.RateLimit, "rate-limit", 0, "Send at most this many API requests per second, shared by all targets and threads (default: no limit)")
+	fs.DurationVar(&c.VariableTimeout, "variable-timeout", 0, "Give up on a single variable after this long and move on, e.g. 30s (default: no limit)")
+	fs.BoolVar(&c.VerifyBeforeWrite, "verify-before-write", false, "Re-read each target variable before writing it and fail it if it changed since the target was read")
+	fs.BoolVar(&c.SortKeys, "sort-keys", false, "Transfer variables in key order instead of the source's order")
+	fs.IntVar(&c.ExpectCount, "expect-count", -1, "Fail before any write unless exactly this many source variables remain after filtering")
+	fs.IntVar(&c.ExpectCountMin, "expect-count-min", -1, "Fail before any write if fewer source variables remain after filtering")
+	fs.IntVar(&c.ExpectCountMax, "expect-count-max", -1, "Fail before any write if more source variables remain after filtering")
+	fs.StringVar(&c.ContinueFrom, "continue-from", "", "Skip the variables whose key sorts before this one, to resume a --sort-keys run by hand (implies --sort-keys)")
+	fs.BoolVar(&c.Prune, "prune", false, "Delete target variables whose key and scope are not in the source")
+	fs.IntVar(&c.PruneBatchSize, "prune-batch-size", 0, "Delete pruned variables in batches of this size, confirming each batch unless --yes is set")
+	fs.BoolVar(&c.Transactional, "transactional", false, "Roll back every change made to a target if any of its writes fail (best effort)")
+	fs.BoolVar(&c.AssumeYes, "yes", false, "Do not ask for confirmation before destructive changes")
+	fs.StringVar(&c.HighRiskScopes, "high-risk-scopes", "", "Comma-separated scopes or regular expressions, e.g. production, whose changes need an extra confirmation even with --yes")
+	fs.BoolVar(&c.ConfirmProduction, "confirm-production", false, "Confirm changes in --high-risk-scopes without prompting")
+	fs.BoolVar(&c.SafeMode, "safe-mode", false, "Never change or delete existing target variables unless --allow-overwrite or --allow-delete is also given")
+	fs.BoolVar(&c.AllowOverwrite, "allow-overwrite", false, "In --safe-mode, allow --upsert to change existing variables")
+	fs.BoolVar(&c.AllowDelete, "allow-delete", false, "In --safe-mode, allow --prune to delete variables")
+
+	fs.StringVar(&c.DecodeBase64, "decode-base64", "", "Base64-decode the values of these comma-separated keys or regular expressions")
+	fs.StringVar(&c.EncodeBase64, "encode-base64", "", "Base64-encode the values of these comma-separated keys or regular expressions")
+	fs.StringVar(&c.AliasFile, "alias-file", "", "JSON file mapping source keys to the keys they have in the target")
+	fs.StringVar(&c.ValuePrefix, "value-prefix", "", "Prepend this text to every value, or to those of --value-affix-keys")
+	fs.StringVar(&c.ValueSuffix, "value-suffix", "", "Append this text to every value, or to those of --value-affix-keys, e.g. -prod")
+	fs.StringVar(&c.ValueAffixKeys, "value-affix-keys", "", "Limit --value-prefix and --value-suffix to these comma-separated keys or regular expressions")
+	fs.StringVar(&c.NormalizeEOL, "normalize-eol", "", "Convert line endings of values to lf or crlf before transfer (default: unchanged)")
+	fs.StringVar(&c.MatrixFile, "matrix", "", "JSON file mapping KEY@scope to per-environment values that override the source")
+	fs.StringVar(&c.Where, "where", "", "Only sync variables matching this expression, e.g. 'masked == true && scope != \"*\"'")
+	fs.StringVar(&c.ValueMatch, "value-match", "", "Only sync variables whose value matches this regular expression (values are never logged)")
+	fs.BoolVar(&c.CheckReferences, "check-references", false, "Warn about expanded variables whose $ references will not exist in the target")
+	fs.BoolVar(&c.RawUnresolved, "raw-unresolved", false, "With --check-references, mark variables with unresolved references as raw")
+	fs.StringVar(&c.ProtectKeys, "protect-keys", "", "Comma-separated keys or regular expressions that are never created, updated or pruned in the target")
+	fs.BoolVar(&c.MarkManaged, "mark-managed", false, "Mark synced variables as managed by env-sync in their description")
+	fs.BoolVar(&c.FindOrphans, "find-orphans", false, "List managed target variables that are no longer in the source and exit")
+	fs.BoolVar(&c.CheckInheritance, "group-variables-inheritance", false, "Warn when a synced variable shadows one the target inherits from its groups")
+	fs.BoolVar(&c.CheckFork, "check-fork", false, "Warn about synced variables that the target's fork parent already defines")
+
+	fs.BoolVar(&c.ConsolidateReviewScopes, "consolidate-review-scopes", false, "Collapse review/<branch> scoped source variables into a single review/* variant")
+	fs.StringVar(&c.ExpandReviewScopes, "expand-review-scopes", "", "Comma-separated review environment names to expand review/* source variables into")
+	fs.StringVar(&c.ScopeCollision, "scope-collision", collisionFirst, "When --scope-rename puts two variants of a key into one scope: first, last or error")
+	fs.Var(&c.ScopeRenames, "scope-rename", "Rewrite scopes matching a regex as PATTERN=REPLACEMENT, e.g. '^review/(.*)$=staging' (repeatable)")
+
+	fs.BoolVar(&c.Explain, "explain", false, "Log the decision and its reason for every source variable")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "Print the effective value and origin of every setting, with the token redacted, and exit")
+	fs.BoolVar(&c.OutputJSONL, "output-jsonl", false, "Stream one JSON object per processed variable to stdout")
+	fs.StringVar(&c.LogFormat, "log-format", logFormatText, "Log and report format: text or json")
+	fs.StringVar(&c.RunID, "run-id", "", "ID sent as X-Env-Sync-Run-Id with every API request and shown in the logs (default: random)")
+	fs.BoolVar(&c.NoColor, "no-color", false, "Never color terminal output (also disabled by NO_COLOR or when not a terminal)")
+	fs.BoolVar(&c.Audit, "audit", false, "Report unprotected and unmasked variables of the source (or target, if no source is given) and exit")
+	fs.BoolVar(&c.AuditSizes, "audit-sizes", false, "With --audit, also report the distribution of value sizes and the total bytes stored")
+	fs.BoolVar(&c.Fingerprint, "fingerprint", false, "Print a hash of the source's variable set (or the target's, without --source) and exit")
+	fs.BoolVar(&c.List, "list", false, "List the keys and scopes of the source (or the target, without --source) and exit")
+	fs.BoolVar(&c.Effective, "effective", false, "With --list, add the variables inherited from groups and the instance and mark which ones are shadowed")
+	fs.StringVar(&c.Snapshot, "snapshot", "", "Write a sorted, value-free .env-style snapshot of the source (or the target, without --source) for git, and exit")
+	fs.StringVar(&c.SnapshotValues, "snapshot-values", snapshotRedact, "Values in --snapshot: redact, or hash for unsalted SHA-256 hashes that show changes but can be guessed for weak values")
+
+	fs.StringVar(&c.MetricsFile, "metrics-file", "", "Write Prometheus text-format metrics of the run to this file")
+	fs.BoolVar(&c.SummaryTable, "summary-table", false, "Print a table of the run's counts and failed variables to stderr at the end of the run, if it is a terminal")
+	fs.BoolVar(&c.CompactSummary, "compact-summary", false, "Print a single key=value summary line to stderr at the end of the run")
+
+	fs.StringVar(&c.WebhookURL, "summary-webhook", "", "POST the JSON run summary to this URL when the run completes")
+	fs.Var(&c.WebhookHeaders, "summary-webhook-header", "Extra header for the summary webhook as \"Name: value\" (repeatable)")
+	fs.StringVar(&c.PreSyncCmd, "pre-sync-cmd", "", "Run this shell command before fetching anything; a non-zero exit aborts the run")
+	fs.StringVar(&c.PostSyncCmd, "post-sync-cmd", "", "Run this shell command after the sync with its counts in ENV_SYNC_* variables and the JSON summary on stdin")
+	fs.StringVar(&c.OnCompleteCmd, "on-complete-cmd", "", "Run this shell command after the run with the JSON summary on stdin")
+	fs.BoolVar(&c.OnCompleteAffectsExit, "on-complete-affects-exit", false, "Exit with code 1 if the --on-complete-cmd command fails")
+}
@@ -0,0 +1,136 @@
+package envsync
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// csvFields are the EnvVar fields a CSV column can be mapped to.
+var csvFields = []string{"key", "value", "environment_scope", "variable_type", "protected", "masked", "raw"}
+
+// csvColumns maps EnvVar field names to CSV column headers.
+type csvColumns map[string]string
+
+// parseCSVColumns parses a --csv-columns mapping such as
+// "key=NAME,value=SECRET,environment_scope=ENV".
+func parseCSVColumns(spec string) (csvColumns, error) {
+	known := map[string]bool{}
+	for _, f := range csvFields {
+		known[f] = true
+	}
+
+	columns := csvColumns{}
+	for _, pair := range splitList(spec) {
+		field, column, ok := strings.Cut(pair, "=")
+		field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, expected field=column", pair)
+		}
+		if !known[field] {
+			return nil, fmt.Errorf("unknown field %q in column mapping (use %s)", field, strings.Join(csvFields, ", "))
+		}
+		columns[field] = column
+	}
+	return columns, nil
+}
+
+// readCSV imports variables from a CSV file with a header row. Fields not in
+// columns are read from a column of the same name if there is one. key and
+// value are required; attributes without a column or with an empty cell are
+// marked unspecified, like those of a .env import.
+func readCSV(filename string, columns csvColumns) ([]EnvVar, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s: missing header row", filename)
+	}
+
+	header := map[string]int{}
+	for i, name := range rows[0] {
+		header[strings.TrimSpace(name)] = i
+	}
+	index := map[string]int{}
+	for _, field := range csvFields {
+		column, mapped := columns[field]
+		if !mapped {
+			column = field
+		}
+		i, ok := header[column]
+		if !ok {
+			if mapped {
+				return nil, fmt.Errorf("%s: no column %q for %s", filename, column, field)
+			}
+			continue
+		}
+		index[field] = i
+	}
+	for _, field := range []string{"key", "value"} {
+		if _, ok := index[field]; !ok {
+			return nil, fmt.Errorf("%s: no %s column (map one with --csv-columns %s=COLUMN)", filename, field, field)
+		}
+	}
+
+	var variables []EnvVar
+	for n, row := range rows[1:] {
+		line := n + 2
+		cell := func(field string) string {
+			if i, ok := index[field]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		v := EnvVar{
+			VariableType:     "env_var",
+			Key:              cell("key"),
+			EnvironmentScope: normalizeScope(cell("environment_scope")),
+		}
+		if i := index["value"]; i < len(row) {
+			v.Value = row[i]
+		}
+		if v.Key == "" {
+			return nil, fmt.Errorf("%s:%d: empty key", filename, line)
+		}
+
+		if t := cell("variable_type"); t != "" {
+			v.VariableType = t
+		} else {
+			v.unspecified |= attrVariableType
+		}
+		for _, b := range []struct {
+			field string
+			attr  attribute
+			dst   *bool
+		}{
+			{"protected", attrProtected, &v.Protected},
+			{"masked", attrMasked, &v.Masked},
+			{"raw", attrRaw, &v.Raw},
+		} {
+			value := cell(b.field)
+			if value == "" {
+				v.unspecified |= b.attr
+				continue
+			}
+			parsed, err := strconv.ParseBool(strings.ToLower(value))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid %s value %q", filename, line, b.field, value)
+			}
+			*b.dst = parsed
+		}
+		variables = append(variables, v)
+	}
+	return variables, nil
+}
@@ -0,0 +1,80 @@
+package envsync
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCSVWithColumnMapping(t *testing.T) {
+	filename := writeFile(t, "export.csv", "NAME,SECRET,ENV,protected,kind\n"+
+		"DB_HOST,db.prod,production,true,\n"+
+		"CERT,\"-----BEGIN\nline, with comma\",,False,file\n"+
+		"  SPACED  , keeps spaces ,staging,,\n")
+	columns, err := parseCSVColumns("key=NAME, value=SECRET, environment_scope=ENV, variable_type=kind")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readCSV(filename, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvVar{
+		{Key: "DB_HOST", Value: "db.prod", VariableType: "env_var", EnvironmentScope: "production", Protected: true, unspecified: attrVariableType | attrMasked | attrRaw},
+		{Key: "CERT", Value: "-----BEGIN\nline, with comma", VariableType: "file", EnvironmentScope: "*", unspecified: attrMasked | attrRaw},
+		{Key: "SPACED", Value: " keeps spaces ", VariableType: "env_var", EnvironmentScope: "staging", unspecified: attrAll},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readCSV =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestReadCSVDefaultColumns(t *testing.T) {
+	got, err := readCSV(writeFile(t, "vars.csv", "key,value,masked\nA,1,true\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Key != "A" || !got[0].Masked || got[0].unspecified.has(attrMasked) {
+		t.Errorf("readCSV = %+v", got)
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	for _, test := range []struct {
+		content, columns, want string
+	}{
+		{"", "", "missing header row"},
+		{"NAME,value\nA,1\n", "", "no key column"},
+		{"key,value\nA,1\n", "environment_scope=ENV", `no column "ENV" for environment_scope`},
+		{"key,value\n,1\n", "", "vars.csv:2: empty key"},
+		{"key,value,protected\nA,1,maybe\n", "", `invalid protected value "maybe"`},
+	} {
+		columns, err := parseCSVColumns(test.columns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = readCSV(writeFile(t, "vars.csv", test.content), columns)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: err = %v, want %q", test.content, err, test.want)
+		}
+	}
+	for _, spec := range []string{"key", "colour=C", "key="} {
+		if _, err := parseCSVColumns(spec); err == nil {
+			t.Errorf("parseCSVColumns(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = nil
+	filename := writeFile(t, "jenkins.csv", "Name,Secret\nAPI_KEY,abc\n")
+
+	if _, stderr, code := runMain(t, "", f.args("--import", filename, "--csv-columns", "key=Name,value=Secret", "--target", "g/dst")...); code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if got := f.vars("g/dst"); len(got) != 1 || got[0].Key != "API_KEY" || got[0].Value != "abc" {
+		t.Errorf("target = %v", got)
+	}
+}
@@ -0,0 +1,122 @@
+package envsync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// deprecations collects the deprecation notices of API responses, once
+// each. It is shared by the copies WithContext makes.
+type deprecations struct {
+	mu       sync.Mutex
+	seen     map[string]bool
+	messages []string
+}
+
+func (d *deprecations) add(message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.seen[message] {
+		d.seen[message] = true
+		d.messages = append(d.messages, message)
+	}
+}
+
+// take returns the notices collected so far and forgets them.
+func (d *deprecations) take() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	messages := d.messages
+	d.messages = nil
+	return messages
+}
+
+// do sends req and records any deprecation notice of the response.
+func (c *GitLabClient) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err == nil && c.deprecations != nil {
+		c.noteDeprecations(req, resp)
+	}
+	return resp, err
+}
+
+// noteDeprecations looks for the Deprecation and Sunset headers (RFC 9745,
+// RFC 8594), a Warning header, and a "warning" field in JSON object bodies.
+// The body is left readable for the caller.
+func (c *GitLabClient) noteDeprecations(req *http.Request, resp *http.Response) {
+	endpoint := req.Method + " " + endpointPattern(req.URL.EscapedPath())
+	if deprecation := resp.Header.Get("Deprecation"); deprecation != "" {
+		message := fmt.Sprintf("%s is deprecated (Deprecation: %s)", endpoint, deprecation)
+		if sunset := resp.Header.Get("Sunset"); sunset != "" {
+			message += fmt.Sprintf(", removal planned for %s", sunset)
+		}
+		c.deprecations.add(message)
+	}
+	for _, warning := range resp.Header.Values("Warning") {
+		c.deprecations.add(fmt.Sprintf("%s: %s", endpoint, warning))
+	}
+
+	body := bufio.NewReader(resp.Body)
+	resp.Body = readCloser{body, resp.Body}
+	if first, err := body.Peek(1); err != nil || first[0] != '{' {
+		return
+	}
+	data, err := io.ReadAll(body)
+	resp.Body = readCloser{io.MultiReader(bytes.NewReader(data), errReader{err}), resp.Body}
+	var envelope struct {
+		Warning string `json:"warning"`
+	}
+	if json.Unmarshal(data, &envelope) == nil && envelope.Warning != "" {
+		c.deprecations.add(fmt.Sprintf("%s: %s", endpoint, envelope.Warning))
+	}
+}
+
+// endpointPattern replaces project, group and variable names in an API path
+// with placeholders, so one notice covers every call of an endpoint.
+func endpointPattern(path string) string {
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		switch parts[i-1] {
+		case "projects", "groups":
+			parts[i] = ":id"
+		case "variables":
+			parts[i] = ":key"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// errReader returns err, or io.EOF if there was none, once the data read
+// before it is used up.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, io.EOF
+}
+
+// warnDeprecations logs the deprecation notices the clients collected.
+func warnDeprecations(clients ...*GitLabClient) {
+	for _, c := range clients {
+		if c.deprecations == nil {
+			continue
+		}
+		for _, message := range c.deprecations.take() {
+			log.Printf("Warning: GitLab API deprecation: %s", message)
+		}
+	}
+}
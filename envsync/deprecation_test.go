@@ -0,0 +1,130 @@
+package envsync
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// withHeaders makes the fake add header to every response.
+func withHeaders(f *fakeGitLab, header http.Header) {
+	f.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		f.serve(w, r)
+	})
+}
+
+func TestEndpointPattern(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/v4/projects/g%2Fapp/variables/API_KEY": "/api/v4/projects/:id/variables/:key",
+		"/api/v4/groups/7/variables":                 "/api/v4/groups/:id/variables",
+		"/api/v4/admin/ci/variables":                 "/api/v4/admin/ci/variables",
+	} {
+		if got := endpointPattern(path); got != want {
+			t.Errorf("endpointPattern(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// A notice repeated by every response of an endpoint is collected once,
+// and take empties the list.
+func TestDeprecationHeaders(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")}
+	withHeaders(f, http.Header{
+		"Deprecation": {"@1767225600"},
+		"Sunset":      {"Wed, 01 Jul 2026 00:00:00 GMT"},
+		"Warning":     {`299 - "per_page above 50 is deprecated"`},
+	})
+	client := f.client()
+
+	for _, key := range []string{"A", "B"} {
+		if _, err := client.GetVariable("g/app", key, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"GET /api/v4/projects/:id is deprecated (Deprecation: @1767225600), removal planned for Wed, 01 Jul 2026 00:00:00 GMT",
+		`GET /api/v4/projects/:id: 299 - "per_page above 50 is deprecated"`,
+		"GET /api/v4/projects/:id/variables/:key is deprecated (Deprecation: @1767225600), removal planned for Wed, 01 Jul 2026 00:00:00 GMT",
+		`GET /api/v4/projects/:id/variables/:key: 299 - "per_page above 50 is deprecated"`,
+	}
+	got := client.deprecations.take()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("notices =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if again := client.deprecations.take(); len(again) != 0 {
+		t.Errorf("take returned %v again", again)
+	}
+}
+
+// A warning field in a JSON object body is collected and the body is still
+// decoded.
+func TestDeprecationWarningField(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = nil
+	f.intercept = func(r fakeRequest) (int, string) {
+		if strings.HasSuffix(r.Path, "/variables/A") {
+			return http.StatusOK, `{"key":"A","value":"1","warning":"the raw attribute will default to true"}`
+		}
+		return 0, ""
+	}
+	client := f.client()
+
+	v, err := client.GetVariable("g/app", "A", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Key != "A" || v.Value != "1" {
+		t.Errorf("variable = %+v", v)
+	}
+	got := client.deprecations.take()
+	if len(got) != 1 || got[0] != "GET /api/v4/projects/:id/variables/:key: the raw attribute will default to true" {
+		t.Errorf("notices = %v", got)
+	}
+}
+
+// The notices of a run are logged once, at its end.
+func TestDeprecationsReportedAtEnd(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", ""), envVar("C", "3", "")}
+	f.projects["g/dst"] = []EnvVar{}
+	withHeaders(f, http.Header{"Deprecation": {"true"}})
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	notice := "Warning: GitLab API deprecation: POST /api/v4/projects/:id/variables is deprecated (Deprecation: true)"
+	if n := strings.Count(stderr, notice); n != 1 {
+		t.Fatalf("notice logged %d times, want once:\n%s", n, stderr)
+	}
+	if strings.Index(stderr, notice) < strings.Index(stderr, "Transfer completed") {
+		t.Errorf("notice logged before the end of the run:\n%s", stderr)
+	}
+}
+
+// A run that fails still logs its notices, once, before it exits.
+func TestDeprecationsReportedOnFailure(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")}
+	f.projects["g/dst"] = []EnvVar{}
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost && strings.Contains(r.Body, `"key":"B"`) {
+			return http.StatusForbidden, `{"message":"403 Forbidden"}`
+		}
+		return 0, ""
+	}
+	withHeaders(f, http.Header{"Deprecation": {"true"}})
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst")...)
+	if code != exitFailure {
+		t.Fatalf("exit code %d, want %d; stderr:\n%s", code, exitFailure, stderr)
+	}
+	notice := "Warning: GitLab API deprecation: POST /api/v4/projects/:id/variables is deprecated (Deprecation: true)"
+	if n := strings.Count(stderr, notice); n != 1 {
+		t.Errorf("notice logged %d times, want once:\n%s", n, stderr)
+	}
+}
@@ -0,0 +1,9 @@
+// Package envsync copies GitLab CI/CD variables between projects. It holds
+// the gitlab-env-sync command line tool, which Main runs, and the pieces it
+// is built from for use in other programs: GitLabClient talks to the
+// variables API, EnvVar is one variable and Sync applies a list of variables
+// to a project with SyncOptions.
+//
+//	client := envsync.NewGitLabClient("https://gitlab.com", token)
+//	err := envsync.Sync(client, "group/app", variables, envsync.SyncOptions{Upsert: true})
+package envsync
@@ -0,0 +1,141 @@
+package envsync
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readDotEnv parses a .env file into variables. A plain .env file carries only
+// keys and values, so every other attribute is marked unspecified and
+// variables land in the wildcard scope. A metadata comment as written by the
+// dotenv exporter sets the scope and attributes of the variable below it.
+func readDotEnv(filename string) ([]EnvVar, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var variables []EnvVar
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	meta := dotEnvMeta()
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "# @scope=") {
+			if meta, err = parseDotEnvMetadata(strings.TrimPrefix(line, "# ")); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", filename, lineNo)
+		}
+
+		value, err := parseDotEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+		}
+
+		v := meta
+		v.Key = key
+		v.Value = value
+		variables = append(variables, v)
+		meta = dotEnvMeta()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return variables, nil
+}
+
+// dotEnvMeta is a variable without a metadata comment.
+func dotEnvMeta() EnvVar {
+	return EnvVar{VariableType: "env_var", EnvironmentScope: defaultScope, unspecified: attrAll}
+}
+
+// parseDotEnvMetadata reads a metadata comment, "# @scope=..." without its
+// "# ". A comment with @type describes the variable completely, so flags it
+// leaves out are false. Without @type, the flags it names are set and the
+// others are left unspecified.
+func parseDotEnvMetadata(comment string) (EnvVar, error) {
+	v := dotEnvMeta()
+	for rest := strings.TrimSpace(comment); rest != ""; rest = strings.TrimSpace(rest) {
+		if !strings.HasPrefix(rest, "@") {
+			return EnvVar{}, fmt.Errorf("expected @tag in metadata comment, got %q", rest)
+		}
+		tag, value := rest[1:], ""
+		if i := strings.IndexAny(tag, "= "); i >= 0 && tag[i] == '=' {
+			tag, rest = tag[:i], tag[i+1:]
+			if strings.HasPrefix(rest, `"`) {
+				quoted, err := strconv.QuotedPrefix(rest)
+				if err != nil {
+					return EnvVar{}, fmt.Errorf("invalid quoted @%s: %v", tag, err)
+				}
+				value, _ = strconv.Unquote(quoted)
+				rest = rest[len(quoted):]
+			} else {
+				value, rest, _ = strings.Cut(rest, " ")
+			}
+		} else {
+			tag, rest, _ = strings.Cut(tag, " ")
+		}
+
+		switch tag {
+		case "scope":
+			v.EnvironmentScope = normalizeScope(value)
+		case "type":
+			if value != "env_var" && value != "file" {
+				return EnvVar{}, fmt.Errorf("invalid @type %q (use env_var or file)", value)
+			}
+			v.VariableType = value
+			v.unspecified = 0
+		case "protected":
+			v.Protected = true
+			v.unspecified &^= attrProtected
+		case "masked":
+			v.Masked = true
+			v.unspecified &^= attrMasked
+		case "raw":
+			v.Raw = true
+			v.unspecified &^= attrRaw
+		case "description":
+			v.Description = value
+		default:
+			return EnvVar{}, fmt.Errorf("unknown tag @%s in metadata comment", tag)
+		}
+	}
+	return v, nil
+}
+
+func parseDotEnvValue(value string) (string, error) {
+	if len(value) >= 2 {
+		switch value[0] {
+		case '"':
+			if value[len(value)-1] == '"' {
+				unquoted, err := strconv.Unquote(value)
+				if err != nil {
+					return "", fmt.Errorf("invalid quoted value: %v", err)
+				}
+				return unquoted, nil
+			}
+		case '\'':
+			if value[len(value)-1] == '\'' {
+				return value[1 : len(value)-1], nil
+			}
+		}
+	}
+	return value, nil
+}
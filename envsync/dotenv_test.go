@@ -0,0 +1,132 @@
+package envsync
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteDotEnvMetadata(t *testing.T) {
+	masked := envVar("TOKEN", "s3cr3t-value", "production")
+	masked.Protected, masked.Masked = true, true
+	file := envVar("CERT", "line 1\nline 2", "")
+	file.VariableType, file.Raw, file.Description = "file", true, `say "hi"`
+
+	var buf bytes.Buffer
+	if err := writeDotEnv(&buf, []EnvVar{envVar("PLAIN", "1", ""), masked, file}); err != nil {
+		t.Fatal(err)
+	}
+	want := `# @scope=* @type=env_var
+PLAIN=1
+# @scope=production @type=env_var @protected @masked
+TOKEN=s3cr3t-value
+# @scope=* @type=file @raw @description="say \"hi\""
+CERT="line 1\nline 2"
+`
+	if buf.String() != want {
+		t.Errorf("export:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestParseDotEnvMetadata(t *testing.T) {
+	v, err := parseDotEnvMetadata(`@scope=review/* @type=file @masked @description="a b" @raw`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.EnvironmentScope != "review/*" || v.VariableType != "file" || !v.Masked || !v.Raw || v.Protected || v.Description != "a b" || v.unspecified != 0 {
+		t.Errorf("metadata = %+v", v)
+	}
+
+	// Without @type, the flags written are set and the others unspecified.
+	v, err = parseDotEnvMetadata("@scope=production @protected @masked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.EnvironmentScope != "production" || !v.Protected || !v.Masked || v.Raw || v.unspecified != attrVariableType|attrRaw {
+		t.Errorf("metadata without @type = %+v", v)
+	}
+	v, err = parseDotEnvMetadata("@scope=staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.EnvironmentScope != "staging" || v.unspecified != attrAll {
+		t.Errorf("scope-only metadata = %+v", v)
+	}
+
+	for comment, want := range map[string]string{
+		"@scope=* @type=secret":     `invalid @type "secret"`,
+		"@scope=* @color=red":       "unknown tag @color",
+		"@scope=* oops":             `expected @tag in metadata comment, got "oops"`,
+		`@scope=* @description="x`:  "invalid quoted @description",
+		"@scope=* @type=env_var @x": "unknown tag @x",
+	} {
+		if _, err := parseDotEnvMetadata(comment); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseDotEnvMetadata(%q) = %v, want %q", comment, err, want)
+		}
+	}
+}
+
+// A metadata comment applies to the next variable only; plain lines, other
+// comments and export prefixes are read as before.
+func TestReadDotEnvMetadataScope(t *testing.T) {
+	filename := writeFile(t, "app.env", `# a normal comment
+# @scope=production @type=env_var @protected
+export API_URL=https://prod
+
+LOG_LEVEL='debug'
+# @scope=bad @type=nope
+`)
+	_, err := readDotEnv(filename)
+	if err == nil || !strings.Contains(err.Error(), "app.env:6: invalid @type") {
+		t.Errorf("err = %v, want the bad comment's line", err)
+	}
+
+	variables, err := readDotEnv(writeFile(t, "ok.env", "# @scope=production @type=env_var @protected\nexport API_URL=https://prod\nLOG_LEVEL='debug'\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variables) != 2 {
+		t.Fatalf("variables = %+v", variables)
+	}
+	if v := variables[0]; v.EnvironmentScope != "production" || !v.Protected || v.unspecified != 0 {
+		t.Errorf("API_URL = %+v", v)
+	}
+	if v := variables[1]; v.EnvironmentScope != "*" || v.Value != "debug" || v.unspecified != attrAll {
+		t.Errorf("LOG_LEVEL = %+v, want plain", v)
+	}
+}
+
+// Exporting a project to .env and importing the file into another gives
+// the second project the same variables, attributes included.
+func TestDotEnvExportImportBetweenProjects(t *testing.T) {
+	f := newFakeGitLab(t)
+	masked := envVar("TOKEN", "s3cr3t-value", "production")
+	masked.Protected, masked.Masked = true, true
+	file := envVar("CERT", "-----BEGIN-----\nabc\n", "")
+	file.VariableType, file.Description = "file", "TLS certificate"
+	raw := envVar("TEMPLATE", "$HOME/x", "staging")
+	raw.Raw = true
+	f.projects["g/src"] = []EnvVar{envVar("PLAIN", "1", ""), masked, file, raw}
+	f.projects["g/dst"] = []EnvVar{}
+	export := filepath.Join(t.TempDir(), "vars.env")
+
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--export", export)...); code != 0 {
+		t.Fatalf("export: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if _, stderr, code := runMain(t, "", f.args("--import", export, "--target", "g/dst")...); code != 0 {
+		t.Fatalf("import: exit code %d; stderr:\n%s", code, stderr)
+	}
+
+	got := f.vars("g/dst")
+	want := f.vars("g/src")
+	if len(got) != len(want) {
+		t.Fatalf("target = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		want[i].EnvironmentScope = normalizeScope(want[i].EnvironmentScope)
+		if got[i] != want[i] {
+			t.Errorf("variable %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
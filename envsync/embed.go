@@ -0,0 +1,78 @@
+package envsync
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ProgressFunc receives the outcome of each variable handled by Sync. key is
+// in KEY@scope form, action is one of created, updated, unchanged, skipped or
+// failed, and err is set only for failed.
+type ProgressFunc func(key string, action string, err error)
+
+// SyncOptions configures Sync.
+type SyncOptions struct {
+	// Upsert compares with the target's variables and updates existing ones
+	// instead of failing to create them.
+	Upsert bool
+
+	// MergeAttributes keeps the target's attributes where a variable leaves
+	// them unspecified. It only applies with Upsert.
+	MergeAttributes bool
+
+	// OnMaskFailure is "fail" (the default), "skip" or "unmask".
+	OnMaskFailure string
+
+	// Threads is the number of variables written at the same time; zero
+	// or one writes them one after another.
+	Threads int
+
+	// OnProgress, if set, is called once per variable. Calls never overlap,
+	// so the callback needs no locking of its own.
+	OnProgress ProgressFunc
+}
+
+// Sync copies variables to targetProject the way the command line does
+// without a plan file: it reads the target if needed, decides per variable
+// and applies the decisions. Per-variable failures are reported through
+// OnProgress as they happen and returned at the end as a *MultiError, which
+// errors.As can search for an *APIError. Failures that stop the run, such
+// as a rejected token, are returned on their own.
+func Sync(client *GitLabClient, targetProject string, variables []EnvVar, opts SyncOptions) error {
+	transfer := transferOptions{
+		Upsert:          opts.Upsert,
+		MergeAttributes: opts.MergeAttributes,
+		OnMaskFailure:   opts.OnMaskFailure,
+		Threads:         opts.Threads,
+	}
+	if transfer.OnMaskFailure == "" {
+		transfer.OnMaskFailure = maskFailureFail
+	}
+
+	existing := map[variableKey]EnvVar{}
+	if opts.Upsert {
+		targetVars, err := client.GetVariables(targetProject)
+		if err != nil {
+			return fmt.Errorf("reading target %s: %w", targetProject, err)
+		}
+		for _, v := range targetVars {
+			existing[keyOf(v)] = v
+		}
+	}
+
+	decisions, err := planTransfer(variables, existing, transfer)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	report := func(v EnvVar, result outcome, err error) {
+		if opts.OnProgress == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		opts.OnProgress(keyOf(v).String(), string(result), err)
+	}
+	return transferVariables(client, targetProject, decisions, transfer, report)
+}
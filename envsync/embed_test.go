@@ -0,0 +1,106 @@
+package envsync
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSyncReportsEveryVariable(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{envVar("SAME", "1", ""), envVar("OLD", "1", "")}
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost && strings.Contains(r.Body, `"key":"BAD"`) {
+			return http.StatusBadRequest, `{"message":{"value":["is invalid"]}}`
+		}
+		return 0, ""
+	}
+	variables := []EnvVar{
+		envVar("NEW", "1", ""),
+		envVar("SAME", "1", ""),
+		envVar("OLD", "2", ""),
+		envVar("BAD", "1", ""),
+	}
+	hidden := envVar("HIDDEN", "", "")
+	hidden.Hidden = true
+	variables = append(variables, hidden)
+
+	var inCallback atomic.Bool
+	actions := map[string]string{}
+	err := Sync(f.client(), "g/dst", variables, SyncOptions{
+		Upsert:  true,
+		Threads: 4,
+		OnProgress: func(key, action string, err error) {
+			if !inCallback.CompareAndSwap(false, true) {
+				t.Error("OnProgress calls overlap")
+			}
+			defer inCallback.Store(false)
+			if _, ok := actions[key]; ok {
+				t.Errorf("%s reported twice", key)
+			}
+			if (action == "failed") != (err != nil) {
+				t.Errorf("%s: action %s with error %v", key, action, err)
+			}
+			actions[key] = action
+		},
+	})
+
+	want := map[string]string{
+		"NEW@*":    "created",
+		"SAME@*":   "unchanged",
+		"OLD@*":    "updated",
+		"BAD@*":    "failed",
+		"HIDDEN@*": "skipped",
+	}
+	if len(actions) != len(want) {
+		t.Errorf("callbacks = %v, want %v", actions, want)
+	}
+	for key, action := range want {
+		if actions[key] != action {
+			t.Errorf("%s: action %q, want %q", key, actions[key], action)
+		}
+	}
+
+	var multi *MultiError
+	if !errors.As(err, &multi) || len(multi.Errors) != 1 || multi.Get("BAD@*") == nil {
+		t.Fatalf("err = %v, want a MultiError for BAD@*", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("errors.As found %v, want the 400 APIError", apiErr)
+	}
+}
+
+func TestSyncWithoutUpsertDoesNotReadTarget(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = nil
+	var calls int
+	err := Sync(f.client(), "g/dst", []EnvVar{envVar("A", "1", "")}, SyncOptions{
+		OnProgress: func(string, string, error) { calls++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("OnProgress called %d times, want 1", calls)
+	}
+	for _, r := range f.received(http.MethodGet) {
+		if strings.HasSuffix(r.Path, "/variables") {
+			t.Errorf("target variables were read: %s", r)
+		}
+	}
+}
+
+func TestSyncStopsOnRejectedToken(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.intercept = func(fakeRequest) (int, string) {
+		return http.StatusUnauthorized, `{"message":"401 Unauthorized"}`
+	}
+	err := Sync(f.client(), "g/dst", []EnvVar{envVar("A", "1", "")}, SyncOptions{Upsert: true})
+	var multi *MultiError
+	if err == nil || errors.As(err, &multi) || !isAuthError(err) {
+		t.Errorf("err = %v, want the authentication failure on its own", err)
+	}
+}
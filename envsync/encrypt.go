@@ -0,0 +1,131 @@
+package envsync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"filippo.io/age"
+)
+
+// Output files are encrypted with age (https://age-encryption.org) for a
+// recipient's X25519 public key, so they can also be read with the age
+// command line tool and keys made by age-keygen work here. Only the holder
+// of the recipient's private key can read the file.
+
+// ageMagic starts every file in age's binary format.
+const ageMagic = "age-encryption.org/v1\n"
+
+// recipientFlag is an --encrypt-recipient public key.
+type recipientFlag struct {
+	key *age.X25519Recipient
+}
+
+func (f *recipientFlag) String() string {
+	if f == nil || f.key == nil {
+		return ""
+	}
+	return f.key.String()
+}
+
+func (f *recipientFlag) Set(value string) error {
+	key, err := age.ParseX25519Recipient(value)
+	if err != nil {
+		return fmt.Errorf("invalid key, expected age1...: %v", err)
+	}
+	f.key = key
+	return nil
+}
+
+// identityFlag is a --decrypt-key private key file.
+type identityFlag struct {
+	filename string
+	key      *age.X25519Identity
+}
+
+func (f *identityFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.filename
+}
+
+func (f *identityFlag) Set(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	for _, identity := range identities {
+		if key, ok := identity.(*age.X25519Identity); ok {
+			f.key, f.filename = key, filename
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: no X25519 key (AGE-SECRET-KEY-1...) found", filename)
+}
+
+// generateKeyFile writes a new private key to filename, in the format of
+// age-keygen, and returns the matching public key for --encrypt-recipient.
+func generateKeyFile(filename string) (string, error) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", err
+	}
+	data := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), key.Recipient(), key)
+	if err := writeOutputFile(filename, []byte(data), secretFileMode); err != nil {
+		return "", err
+	}
+	return key.Recipient().String(), nil
+}
+
+// sealFor encrypts data for recipient. A nil recipient returns data as is.
+func sealFor(recipient *age.X25519Recipient, data []byte) ([]byte, error) {
+	if recipient == nil {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isSealed reports whether data is an age file.
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageMagic))
+}
+
+// openSealed decrypts data written by sealFor. Data that is not encrypted is
+// returned as is.
+func openSealed(identity *age.X25519Identity, data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+	if identity == nil {
+		return nil, errors.New("file is encrypted, pass --decrypt-key")
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), identity)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %v", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %v", err)
+	}
+	return plaintext, nil
+}
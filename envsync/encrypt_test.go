@@ -0,0 +1,139 @@
+package envsync
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// testKeys generates a key file and returns its flags.
+func testKeys(t *testing.T) (*recipientFlag, *identityFlag, string) {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "key.txt")
+	public, err := generateKeyFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recipient recipientFlag
+	if err := recipient.Set(public); err != nil {
+		t.Fatal(err)
+	}
+	var identity identityFlag
+	if err := identity.Set(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	return &recipient, &identity, keyFile
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	recipient, identity, keyFile := testKeys(t)
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	plaintext := []byte(`{"variables":[{"key":"A","value":"s3cr3t"}]}`)
+	sealed, err := sealFor(recipient.key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSealed(sealed) || bytes.Contains(sealed, []byte("s3cr3t")) {
+		t.Fatalf("sealed data is not age-encrypted:\n%q", sealed)
+	}
+	opened, err := openSealed(identity.key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("opened = %q, want %q", opened, plaintext)
+	}
+
+	// Plain files pass through, with or without a key.
+	if data, err := openSealed(nil, plaintext); err != nil || !bytes.Equal(data, plaintext) {
+		t.Errorf("openSealed(plain) = %q, %v", data, err)
+	}
+	if data, err := sealFor(nil, plaintext); err != nil || !bytes.Equal(data, plaintext) {
+		t.Errorf("sealFor(nil) = %q, %v", data, err)
+	}
+}
+
+func TestOpenSealedErrors(t *testing.T) {
+	recipient, _, _ := testKeys(t)
+	sealed, err := sealFor(recipient.key, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openSealed(nil, sealed); err == nil || !strings.Contains(err.Error(), "pass --decrypt-key") {
+		t.Errorf("without a key: err = %v", err)
+	}
+	_, other, _ := testKeys(t)
+	if _, err := openSealed(other.key, sealed); err == nil || !strings.Contains(err.Error(), "decryption failed") {
+		t.Errorf("with the wrong key: err = %v", err)
+	}
+}
+
+// Files sealed here open with the age library itself, and so with the age
+// command line tool.
+func TestSealedFilesAreAgeFiles(t *testing.T) {
+	recipient, identity, _ := testKeys(t)
+	sealed, err := sealFor(recipient.key, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := age.Decrypt(bytes.NewReader(sealed), identity.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil || buf.String() != "hello" {
+		t.Errorf("age.Decrypt = %q, %v", buf.String(), err)
+	}
+}
+
+func TestKeyFlagErrors(t *testing.T) {
+	var recipient recipientFlag
+	if err := recipient.Set("ssh-ed25519 AAAA"); err == nil {
+		t.Error("recipientFlag accepted a non-age key")
+	}
+	var identity identityFlag
+	if err := identity.Set(writeFile(t, "key.txt", "# no key here\n")); err == nil {
+		t.Error("identityFlag accepted a file without a key")
+	}
+}
+
+// An encrypted plan holds no plaintext and applies with --decrypt-key.
+func TestEncryptedPlanApplies(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("API_KEY", "s3cr3t-value", "")}
+	f.projects["g/dst"] = nil
+	recipient, _, keyFile := testKeys(t)
+	plan := filepath.Join(t.TempDir(), "plan.json")
+
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--dry-run", "--output", plan, "--encrypt-output", "--encrypt-recipient", recipient.String())...); code != 0 {
+		t.Fatalf("dry run: exit code %d; stderr:\n%s", code, stderr)
+	}
+	data, err := os.ReadFile(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), ageMagic) || strings.Contains(string(data), "s3cr3t-value") {
+		t.Fatalf("plan is not encrypted:\n%s", data)
+	}
+
+	if _, _, code := runMain(t, "", f.args("--apply", plan, "--yes")...); code == 0 {
+		t.Error("applying without --decrypt-key succeeded")
+	}
+	if _, stderr, code := runMain(t, "", f.args("--apply", plan, "--yes", "--decrypt-key", keyFile)...); code != 0 {
+		t.Fatalf("apply: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if got := f.vars("g/dst"); len(got) != 1 || got[0].Value != "s3cr3t-value" {
+		t.Errorf("target = %v", got)
+	}
+}
@@ -0,0 +1,47 @@
+package envsync
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Line endings accepted by --normalize-eol.
+const (
+	eolLF   = "lf"
+	eolCRLF = "crlf"
+)
+
+func validEOL(eol string) bool {
+	return eol == "" || eol == eolLF || eol == eolCRLF
+}
+
+// normalizeEOL rewrites the line endings of multi-line values to eol. Lone
+// carriage returns are left alone.
+func normalizeEOL(variables []EnvVar, eol string, explain bool) []EnvVar {
+	result := make([]EnvVar, len(variables))
+	changed := 0
+	for i, v := range variables {
+		value := strings.ReplaceAll(v.Value, "\r\n", "\n")
+		if eol == eolCRLF {
+			value = strings.ReplaceAll(value, "\n", "\r\n")
+		}
+		if value != v.Value {
+			changed++
+			if explain {
+				log.Printf("explain: %s: line endings normalized to %s (was %s)", keyOf(v), eol, describeEOL(v.Value))
+			}
+		}
+		v.Value = value
+		result[i] = v
+	}
+	log.Printf("Normalized line endings of %d values to %s", changed, eol)
+	return result
+}
+
+// describeEOL counts the line endings of value, e.g. "3 CRLF, 0 LF".
+func describeEOL(value string) string {
+	crlf := strings.Count(value, "\r\n")
+	lf := strings.Count(value, "\n") - crlf
+	return fmt.Sprintf("%d CRLF, %d LF", crlf, lf)
+}
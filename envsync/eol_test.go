@@ -0,0 +1,62 @@
+package envsync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEOL(t *testing.T) {
+	for _, test := range []struct {
+		value, eol, want string
+	}{
+		{"a\r\nb\r\n", eolLF, "a\nb\n"},
+		{"a\nb\r\nc", eolLF, "a\nb\nc"},
+		{"a\nb\n", eolCRLF, "a\r\nb\r\n"},
+		{"a\r\nb\nc", eolCRLF, "a\r\nb\r\nc"},
+		{"a\rb", eolLF, "a\rb"},
+		{"a\rb", eolCRLF, "a\rb"},
+		{"single line", eolCRLF, "single line"},
+	} {
+		got := normalizeEOL([]EnvVar{envVar("CERT", test.value, "")}, test.eol, false)
+		if got[0].Value != test.want {
+			t.Errorf("normalizeEOL(%q, %s) = %q, want %q", test.value, test.eol, got[0].Value, test.want)
+		}
+	}
+}
+
+func TestNormalizeEOLExplain(t *testing.T) {
+	logs := captureLog(t)
+	normalizeEOL([]EnvVar{envVar("CERT", "a\r\nb\nc\r\n", ""), envVar("PLAIN", "x", "")}, eolLF, true)
+	for _, want := range []string{
+		"explain: CERT@*: line endings normalized to lf (was 2 CRLF, 1 LF)",
+		"Normalized line endings of 1 values to lf",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log does not contain %q:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs.String(), "PLAIN") {
+		t.Errorf("an unchanged value was logged:\n%s", logs)
+	}
+}
+
+// A CRLF source value matches its LF target once normalized, so there is no
+// spurious update.
+func TestNormalizeEOLCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("CERT", "-----BEGIN-----\r\nabc\r\n-----END-----\r\n", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("CERT", "-----BEGIN-----\nabc\n-----END-----\n", "")}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--normalize-eol", "lf")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v, want none", writes)
+	}
+
+	_, stderr, code = runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--normalize-eol", "cr")...)
+	if code != exitFailure || !strings.Contains(stderr, `unknown --normalize-eol "cr"`) {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+}
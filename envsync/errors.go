@@ -0,0 +1,223 @@
+package envsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Process exit codes.
+const (
+	exitFailure     = 1
+	exitMismatch    = 2
+	exitAuthFailure = 3
+	exitInvalid     = 4
+)
+
+// APIError is a non-success response from the GitLab API.
+type APIError struct {
+	Op         string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	if e.isValidation() {
+		if fields := e.fieldErrors(); len(fields) > 0 {
+			return fmt.Sprintf("%s: validation failed (status code %d): %s", e.Op, e.StatusCode, formatFieldErrors(fields))
+		}
+	}
+	return fmt.Sprintf("%s: status code %d, response: %s", e.Op, e.StatusCode, e.Body)
+}
+
+// isValidation reports whether GitLab rejected the request as unprocessable,
+// i.e. the payload broke one of its constraints.
+func (e *APIError) isValidation() bool {
+	return e.StatusCode == http.StatusUnprocessableEntity
+}
+
+// VariableError is the failure of a single variable.
+type VariableError struct {
+	// Key is the variable in KEY@scope form.
+	Key string
+	Err error
+}
+
+func (e *VariableError) Error() string { return e.Key + ": " + e.Err.Error() }
+
+func (e *VariableError) Unwrap() error { return e.Err }
+
+// MultiError collects the per-variable failures of a transfer. errors.As
+// and errors.Is look into every failure, so a caller can extract, say, the
+// first *APIError; Get returns the error of one variable.
+type MultiError struct {
+	Errors []*VariableError
+}
+
+func (e *MultiError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		parts[i] = err.Error()
+	}
+	return fmt.Sprintf("%d variable(s) failed: %s", len(e.Errors), strings.Join(parts, "; "))
+}
+
+func (e *MultiError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Get returns the error of the variable with key in KEY@scope form, or nil
+// if it did not fail.
+func (e *MultiError) Get(key string) error {
+	for _, err := range e.Errors {
+		if err.Key == key {
+			return err.Err
+		}
+	}
+	return nil
+}
+
+// add records the failure of v.
+func (e *MultiError) add(v EnvVar, err error) {
+	e.Errors = append(e.Errors, &VariableError{Key: keyOf(v).String(), Err: err})
+}
+
+// errOrNil returns e, or nil if nothing failed, so that a caller's err !=
+// nil check works.
+func (e *MultiError) errOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// newAPIError drains resp's body into an APIError describing op.
+func newAPIError(op string, resp *http.Response) *APIError {
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	return &APIError{Op: op, StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+}
+
+// fieldErrors parses GitLab's validation envelope,
+// {"message": {"field": ["problem", ...]}}. It returns nil for other bodies.
+func (e *APIError) fieldErrors() map[string][]string {
+	var envelope struct {
+		Message map[string][]string `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(e.Body), &envelope); err != nil {
+		return nil
+	}
+	return envelope.Message
+}
+
+// formatFieldErrors renders field errors as "key: is invalid; value: ...",
+// sorted by field.
+func formatFieldErrors(fields map[string][]string) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ": " + strings.Join(fields[name], ", ")
+	}
+	return strings.Join(parts, "; ")
+}
+
+// isMaskError reports whether err is GitLab rejecting a value that does not
+// meet the masking requirements.
+func isMaskError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || (apiErr.StatusCode != http.StatusBadRequest && !apiErr.isValidation()) {
+		return false
+	}
+	fields := apiErr.fieldErrors()
+	_, onValue := fields["value"]
+	_, onMasked := fields["masked"]
+	return onValue || onMasked
+}
+
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// errChangedSincePlan marks a variable whose target state no longer matches
+// the plan when --verify-before-write re-reads it.
+var errChangedSincePlan = errors.New("target changed since planning")
+
+// errVariableTimeout marks a variable whose requests exceeded
+// --variable-timeout.
+var errVariableTimeout = errors.New("timed out")
+
+// errNotRecreated marks a --replace whose delete succeeded but whose create
+// failed, so the variable is missing from the target.
+var errNotRecreated = errors.New("deleted to replace it, but not recreated")
+
+func isTimeout(err error) bool {
+	return errors.Is(err, errVariableTimeout)
+}
+
+// isAuthError reports whether err is a 401 from the API. Once the token is
+// rejected every following request will fail the same way.
+func isAuthError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized
+}
+
+// exitAuth stops the run after the API rejected the token.
+func exitAuth(err error) {
+	log.Printf("Authentication failed - token may be revoked or expired: %v", err)
+	os.Exit(exitAuthFailure)
+}
+
+// exitError is an error that ends the run with a particular exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// exitCodeFor logs the error a target's sync returned and gives the exit
+// code it calls for: exitAuthFailure if the token was rejected, else the
+// code of the first exitError, else exitFailure. err may join the errors of
+// several targets.
+func exitCodeFor(err error) int {
+	for _, e := range unjoin(err) {
+		if isAuthError(e) {
+			log.Printf("Authentication failed - token may be revoked or expired: %v", e)
+		} else {
+			log.Printf("Error: %v", e)
+		}
+	}
+	var exitErr *exitError
+	switch {
+	case isAuthError(err):
+		return exitAuthFailure
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	default:
+		return exitFailure
+	}
+}
+
+// unjoin splits an errors.Join result into its errors.
+func unjoin(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
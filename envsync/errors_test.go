@@ -0,0 +1,208 @@
+package envsync
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsAuthError(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&APIError{StatusCode: http.StatusUnauthorized}, true},
+		{fmt.Errorf("creating A: %w", &APIError{StatusCode: http.StatusUnauthorized}), true},
+		{&APIError{StatusCode: http.StatusForbidden}, false},
+		{fmt.Errorf("plain"), false},
+	} {
+		if got := isAuthError(test.err); got != test.want {
+			t.Errorf("isAuthError(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+// revokeAfter makes the fake answer 401 to every request after the first n
+// writes, as GitLab does once a token is revoked.
+func revokeAfter(f *fakeGitLab, n int) {
+	var mu sync.Mutex
+	writes := 0
+	f.intercept = func(r fakeRequest) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if writes >= n {
+			return http.StatusUnauthorized, `{"message":"401 Unauthorized"}`
+		}
+		if r.Method != http.MethodGet {
+			writes++
+		}
+		return 0, ""
+	}
+}
+
+// A token revoked partway stops the run at once with the auth exit code:
+// the 401 is neither retried nor counted against --abort-threshold.
+func TestRevokedTokenStopsRun(t *testing.T) {
+	f := newFakeGitLab(t)
+	for i := 0; i < 10; i++ {
+		f.projects["g/src"] = append(f.projects["g/src"], envVar(fmt.Sprintf("VAR_%d", i), "v", ""))
+	}
+	f.projects["g/dst"] = nil
+	revokeAfter(f, 3)
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--retries", "3", "--backoff-base", "1ms")...)
+	if code != exitAuthFailure {
+		t.Fatalf("exit code %d, want %d; stderr:\n%s", code, exitAuthFailure, stderr)
+	}
+	if !strings.Contains(stderr, "Authentication failed - token may be revoked or expired") {
+		t.Errorf("stderr lacks the auth message:\n%s", stderr)
+	}
+	if posts := f.received(http.MethodPost); len(posts) != 4 {
+		t.Errorf("%d create requests, want 3 before the revocation and 1 rejected", len(posts))
+	}
+	if got := len(f.vars("g/dst")); got != 3 {
+		t.Errorf("%d variables created, want 3", got)
+	}
+}
+
+func TestIsMaskError(t *testing.T) {
+	for _, test := range []struct {
+		err  *APIError
+		want bool
+	}{
+		{&APIError{StatusCode: http.StatusBadRequest, Body: `{"message":{"value":["is invalid"]}}`}, true},
+		{&APIError{StatusCode: http.StatusUnprocessableEntity, Body: `{"message":{"masked":["cannot be true"]}}`}, true},
+		{&APIError{StatusCode: http.StatusBadRequest, Body: `{"message":{"key":["(A) has already been taken"]}}`}, false},
+		{&APIError{StatusCode: http.StatusInternalServerError, Body: `{"message":{"value":["is invalid"]}}`}, false},
+	} {
+		if got := isMaskError(test.err); got != test.want {
+			t.Errorf("isMaskError(%d %s) = %v, want %v", test.err.StatusCode, test.err.Body, got, test.want)
+		}
+	}
+}
+
+func TestAPIErrorValidationMessage(t *testing.T) {
+	for _, test := range []struct {
+		err  *APIError
+		want string
+	}{
+		{
+			&APIError{Op: "failed to create variable", StatusCode: http.StatusUnprocessableEntity, Body: `{"message":{"value":["is invalid","is too short"],"key":["is reserved"]}}`},
+			"failed to create variable: validation failed (status code 422): key: is reserved; value: is invalid, is too short",
+		},
+		{
+			&APIError{Op: "failed to create variable", StatusCode: http.StatusUnprocessableEntity, Body: `{"message":"422 Unprocessable Entity"}`},
+			`failed to create variable: status code 422, response: {"message":"422 Unprocessable Entity"}`,
+		},
+		{
+			&APIError{Op: "failed to create variable", StatusCode: http.StatusBadRequest, Body: `{"message":{"key":["is reserved"]}}`},
+			`failed to create variable: status code 400, response: {"message":{"key":["is reserved"]}}`,
+		},
+	} {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("Error() = %q, want %q", got, test.want)
+		}
+	}
+}
+
+// A 422 carries GitLab's field errors to the caller and is not retried.
+func TestCreateVariableValidationError(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = nil
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost {
+			return http.StatusUnprocessableEntity, `{"message":{"environment_scope":["is invalid"]}}`
+		}
+		return 0, ""
+	}
+	backoff, err := NewBackoff(backoffConstant, time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.client(WithRetries(3, backoff)).CreateVariable("g/app", envVar("A", "1", "bad scope"), false)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.isValidation() {
+		t.Fatalf("err = %v, want a validation APIError", err)
+	}
+	if !strings.Contains(err.Error(), "validation failed (status code 422): environment_scope: is invalid") {
+		t.Errorf("err = %v", err)
+	}
+	if categorizeFailure(err) != failureValidation {
+		t.Errorf("category = %s, want %s", categorizeFailure(err), failureValidation)
+	}
+	if posts := f.received(http.MethodPost); len(posts) != 1 {
+		t.Errorf("%d POSTs, want the 422 not retried", len(posts))
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	var empty MultiError
+	if empty.errOrNil() != nil {
+		t.Error("errOrNil of no failures is not nil")
+	}
+
+	timeout := errors.New("timed out")
+	var m MultiError
+	m.add(envVar("A", "", ""), &APIError{Op: "failed to create variable", StatusCode: http.StatusBadRequest, Body: "bad"})
+	m.add(envVar("B", "", "production"), timeout)
+	err := m.errOrNil()
+
+	if want := "2 variable(s) failed: A@*: failed to create variable: status code 400, response: bad; B@production: timed out"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err, want)
+	}
+	if m.Get("B@production") != timeout || m.Get("B@*") != nil {
+		t.Errorf("Get returned the wrong errors")
+	}
+	if !errors.Is(err, timeout) {
+		t.Error("errors.Is does not look into the failures")
+	}
+	var varErr *VariableError
+	if !errors.As(err, &varErr) || varErr.Key != "A@*" {
+		t.Errorf("errors.As found %v, want the first VariableError", varErr)
+	}
+}
+
+// A caller can pick one failed variable out of a transfer and inspect its
+// APIError.
+func TestTransferReturnsEachFailure(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = nil
+	f.intercept = func(r fakeRequest) (int, string) {
+		switch {
+		case strings.Contains(r.Body, `"key":"BAD"`):
+			return http.StatusBadRequest, `{"message":{"key":["is reserved"]}}`
+		case strings.Contains(r.Body, `"key":"INVALID"`):
+			return http.StatusUnprocessableEntity, `{"message":{"environment_scope":["is invalid"]}}`
+		}
+		return 0, ""
+	}
+	decisions := []decision{
+		{envVar("BAD", "1", ""), actionCreate, ""},
+		{envVar("OK", "2", ""), actionCreate, ""},
+		{envVar("INVALID", "3", ""), actionCreate, ""},
+	}
+
+	err := transferVariables(f.client(), "g/dst", decisions, transferOptions{}, func(EnvVar, outcome, error) {})
+	var failures *MultiError
+	if !errors.As(err, &failures) || len(failures.Errors) != 2 {
+		t.Fatalf("err = %v, want two failures", err)
+	}
+	var apiErr *APIError
+	if !errors.As(failures.Get("INVALID@*"), &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity || !apiErr.isValidation() {
+		t.Errorf("INVALID@* failed with %v, want the 422", failures.Get("INVALID@*"))
+	}
+	if !errors.As(failures.Get("BAD@*"), &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("BAD@* failed with %v, want the 400", failures.Get("BAD@*"))
+	}
+	if failures.Get("OK@*") != nil {
+		t.Errorf("OK@* reported as failed")
+	}
+	if got := f.vars("g/dst"); len(got) != 1 || got[0].Key != "OK" {
+		t.Errorf("target = %v", got)
+	}
+}
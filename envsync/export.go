@@ -0,0 +1,128 @@
+package envsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// Formats accepted by --export-format.
+const (
+	exportFormatDotEnv = "dotenv"
+	exportFormatJSON   = "json"
+	exportFormatCI     = "gitlab-ci"
+)
+
+// exportStdout as the --export file name writes the export to stdout.
+const exportStdout = "-"
+
+// writeExportFile writes variables to filename in the given format, or to
+// stdout for exportStdout.
+func writeExportFile(filename, format string, variables []EnvVar, recipient *age.X25519Recipient, mode os.FileMode) error {
+	var buf bytes.Buffer
+	if err := writeExport(&buf, format, variables); err != nil {
+		return err
+	}
+	data, err := sealFor(recipient, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if filename == exportStdout {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return writeOutputFile(filename, data, mode)
+}
+
+func writeExport(w io.Writer, format string, variables []EnvVar) error {
+	switch format {
+	case exportFormatDotEnv:
+		return writeDotEnv(w, variables)
+	case exportFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(variables)
+	case exportFormatCI:
+		return writeGitLabCI(w, variables)
+	case exportFormatSOPS:
+		return writeSOPSYAML(w, variables)
+	default:
+		return fmt.Errorf("unknown export format %q (use dotenv, json, gitlab-ci or sops-yaml)", format)
+	}
+}
+
+// writeDotEnv writes variables as KEY=value lines, each preceded by a
+// metadata comment such as "# @scope=production @type=env_var @protected"
+// that readDotEnv turns back into the variable's attributes.
+func writeDotEnv(w io.Writer, variables []EnvVar) error {
+	for _, v := range variables {
+		if _, err := fmt.Fprintf(w, "# %s\n", dotEnvMetadata(v)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", v.Key, quoteDotEnvValue(v.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dotEnvMetadata lists a variable's scope, type and set flags as @tags. The
+// description, if any, comes last.
+func dotEnvMetadata(v EnvVar) string {
+	tags := []string{"@scope=" + normalizeScope(v.EnvironmentScope), "@type=" + normalizeVariableType(v.VariableType)}
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{{"protected", v.Protected}, {"masked", v.Masked}, {"raw", v.Raw}} {
+		if flag.set {
+			tags = append(tags, "@"+flag.name)
+		}
+	}
+	if v.Description != "" {
+		tags = append(tags, "@description="+strconv.Quote(v.Description))
+	}
+	return strings.Join(tags, " ")
+}
+
+// quoteDotEnvValue double-quotes values that would not survive being written
+// bare, using the Go escapes readDotEnv understands.
+func quoteDotEnvValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\r\n\"'#\\$`") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// stripScopes drops environment scopes, moving every variable to the
+// wildcard scope. Keys that existed in several scopes keep their first value.
+func stripScopes(variables []EnvVar) []EnvVar {
+	result := make([]EnvVar, 0, len(variables))
+	seen := map[string]bool{}
+	for _, v := range variables {
+		if seen[v.Key] {
+			log.Printf("Warning: dropping %s, %s is already exported without a scope", keyOf(v), v.Key)
+			continue
+		}
+		seen[v.Key] = true
+		v.EnvironmentScope = defaultScope
+		result = append(result, v)
+	}
+	return result
+}
+
+// withScope returns variables moved into the given scope.
+func withScope(variables []EnvVar, scope string) []EnvVar {
+	result := make([]EnvVar, len(variables))
+	for i, v := range variables {
+		v.EnvironmentScope = scope
+		result[i] = v
+	}
+	return result
+}
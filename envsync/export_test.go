@@ -0,0 +1,130 @@
+package envsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripScopes(t *testing.T) {
+	logs := captureLog(t)
+	variables := []EnvVar{envVar("A", "prod", "production"), envVar("B", "1", ""), envVar("A", "staging", "staging")}
+	got := stripScopes(variables)
+	want := []EnvVar{envVar("A", "prod", "*"), envVar("B", "1", "*")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stripScopes = %v, want %v", got, want)
+	}
+	if !strings.Contains(logs.String(), "dropping A@staging") {
+		t.Errorf("log = %q, want the dropped variable named", logs)
+	}
+}
+
+// The dotenv export keeps every attribute, so importing it gives back the
+// exported variables.
+func TestDotEnvExportRoundTrip(t *testing.T) {
+	variables := []EnvVar{
+		{Key: "A", Value: "plain", VariableType: "env_var", EnvironmentScope: "*"},
+		{Key: "B", Value: "with space $HOME \"quoted\"\nline", VariableType: "file", EnvironmentScope: "production", Protected: true, Masked: true, Raw: true},
+		{Key: "C", Value: "", VariableType: "env_var", EnvironmentScope: "review/*", Description: "empty on purpose"},
+	}
+	var buf bytes.Buffer
+	if err := writeDotEnv(&buf, variables); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readDotEnv(writeFile(t, "export.env", buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, variables) {
+		t.Errorf("round trip:\n%s\ngot  %+v\nwant %+v", buf.String(), read, variables)
+	}
+}
+
+func TestImportScope(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = nil
+	env := writeFile(t, "app.env", "A=1\nB=2\n")
+
+	if _, stderr, code := runMain(t, "", f.args("--import", env, "--import-scope", "staging", "--target", "g/dst")...); code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	for _, v := range f.vars("g/dst") {
+		if v.EnvironmentScope != "staging" {
+			t.Errorf("%s imported into scope %q, want staging", v.Key, v.EnvironmentScope)
+		}
+	}
+}
+
+func TestExportStripScopes(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "production"), envVar("B", "2", "")}
+	export := filepath.Join(t.TempDir(), "out.env")
+
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--export", export, "--strip-scopes")...); code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	read, err := readDotEnv(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range read {
+		if v.EnvironmentScope != "*" {
+			t.Errorf("%s exported with scope %q", v.Key, v.EnvironmentScope)
+		}
+	}
+}
+
+// --export - writes nothing to stdout but the export, so it can be piped
+// into another tool.
+func TestExportToStdout(t *testing.T) {
+	f := newFakeGitLab(t)
+	source := []EnvVar{envVar("A", "1", "*"), envVar("B", "two words", "production")}
+	f.projects["g/src"] = source
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/src", "--export", "-")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	read, err := readDotEnv(writeFile(t, "piped.env", stdout))
+	if err != nil {
+		t.Fatalf("stdout is not a clean .env file: %v\n%s", err, stdout)
+	}
+	if !reflect.DeepEqual(read, source) {
+		t.Errorf("piped export:\n%s\ngot  %+v\nwant %+v", stdout, read, source)
+	}
+	if !strings.Contains(stderr, "Exported 2 variables to stdout") {
+		t.Errorf("stderr = %q, want the export logged there", stderr)
+	}
+
+	stdout, stderr, code = runMain(t, "", f.args("--source", "g/src", "--export", "-", "--export-format", "json")...)
+	if code != 0 {
+		t.Fatalf("json: exit code %d; stderr:\n%s", code, stderr)
+	}
+	var decoded []EnvVar
+	if err := json.Unmarshal([]byte(stdout), &decoded); err != nil {
+		t.Fatalf("stdout is not a clean JSON document: %v\n%s", err, stdout)
+	}
+	if len(decoded) != len(source) {
+		t.Errorf("piped JSON export has %d variables, want %d", len(decoded), len(source))
+	}
+}
+
+func TestExportToStdoutRejectsEncryption(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "*")}
+	recipient, _, _ := testKeys(t)
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/src", "--export", "-", "--encrypt-output", "--encrypt-recipient", recipient.String())...)
+	if code == 0 || !strings.Contains(stderr, "cannot export to stdout") {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if stdout != "" {
+		t.Errorf("stdout = %q, want nothing written", stdout)
+	}
+	if got := f.received(); len(got) != 0 {
+		t.Errorf("requests %v, want none before the flag check", got)
+	}
+}
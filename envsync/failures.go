@@ -0,0 +1,114 @@
+package envsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Failure categories. Retryable failures are transient (rate limiting,
+// server errors, network problems); permanent ones are rejections a retry
+// will not fix, and validation failures are the permanent ones where GitLab
+// named the constraint that failed (422).
+const (
+	failureRetryable  = "retryable"
+	failurePermanent  = "permanent"
+	failureValidation = "validation"
+)
+
+// failureRecord is a failed variable as stored by --failures-file. Values are
+// not stored; a retry reads them from the source again.
+type failureRecord struct {
+	Target           string `json:"target"`
+	Key              string `json:"key"`
+	EnvironmentScope string `json:"environment_scope"`
+	Category         string `json:"category"`
+	StatusCode       int    `json:"status_code,omitempty"`
+	Error            string `json:"error"`
+}
+
+func categorizeFailure(err error) string {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return failureRetryable
+	}
+	if apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500 {
+		return failureRetryable
+	}
+	if apiErr.isValidation() {
+		return failureValidation
+	}
+	return failurePermanent
+}
+
+func newFailureRecord(targetProject string, v EnvVar, err error) failureRecord {
+	record := failureRecord{
+		Target:           targetProject,
+		Key:              v.Key,
+		EnvironmentScope: normalizeScope(v.EnvironmentScope),
+		Category:         categorizeFailure(err),
+		Error:            err.Error(),
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		record.StatusCode = apiErr.StatusCode
+	}
+	return record
+}
+
+func writeFailuresFile(filename string, records []failureRecord, mode os.FileMode) error {
+	if records == nil {
+		records = []failureRecord{}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutputFile(filename, data, mode)
+}
+
+func readFailuresFile(filename string) ([]failureRecord, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []failureRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("invalid failures file %s: %v", filename, err)
+	}
+	return records, nil
+}
+
+// retrySet selects the source variables to retry per target.
+type retrySet map[string]map[variableKey]bool
+
+// newRetrySet keeps the retryable records, or all of them with all set.
+func newRetrySet(records []failureRecord, all bool) (retrySet, int) {
+	set := retrySet{}
+	skipped := 0
+	for _, r := range records {
+		if !all && r.Category != failureRetryable {
+			skipped++
+			continue
+		}
+		if set[r.Target] == nil {
+			set[r.Target] = map[variableKey]bool{}
+		}
+		set[r.Target][variableKey{Key: r.Key, Scope: normalizeScope(r.EnvironmentScope)}] = true
+	}
+	return set, skipped
+}
+
+func (s retrySet) filter(targetProject string, variables []EnvVar) []EnvVar {
+	keys := s[targetProject]
+	var result []EnvVar
+	for _, v := range variables {
+		if keys[keyOf(v)] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
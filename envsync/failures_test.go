@@ -0,0 +1,96 @@
+package envsync
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCategorizeFailure(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{&APIError{StatusCode: http.StatusTooManyRequests}, failureRetryable},
+		{&APIError{StatusCode: http.StatusBadGateway}, failureRetryable},
+		{errors.New("connection reset by peer"), failureRetryable},
+		{&APIError{StatusCode: http.StatusUnprocessableEntity}, failureValidation},
+		{&APIError{StatusCode: http.StatusBadRequest}, failurePermanent},
+		{&APIError{StatusCode: http.StatusForbidden}, failurePermanent},
+	} {
+		if got := categorizeFailure(test.err); got != test.want {
+			t.Errorf("categorizeFailure(%v) = %s, want %s", test.err, got, test.want)
+		}
+	}
+}
+
+func TestNewRetrySet(t *testing.T) {
+	records := []failureRecord{
+		{Target: "g/dst", Key: "RATE", EnvironmentScope: "*", Category: failureRetryable},
+		{Target: "g/dst", Key: "BAD", EnvironmentScope: "production", Category: failureValidation},
+		{Target: "g/dst", Key: "DENIED", EnvironmentScope: "*", Category: failurePermanent},
+	}
+	source := []EnvVar{envVar("RATE", "1", ""), envVar("BAD", "2", "production"), envVar("DENIED", "3", ""), envVar("OK", "4", "")}
+
+	set, skipped := newRetrySet(records, false)
+	if got := set.filter("g/dst", source); skipped != 2 || len(got) != 1 || got[0].Key != "RATE" {
+		t.Errorf("retryable only: %v, %d skipped", got, skipped)
+	}
+	set, skipped = newRetrySet(records, true)
+	if got := set.filter("g/dst", source); skipped != 0 || len(got) != 3 {
+		t.Errorf("--retry-all: %v, %d skipped", got, skipped)
+	}
+	if got := set.filter("g/other", source); len(got) != 0 {
+		t.Errorf("other target: %v, want nothing", got)
+	}
+}
+
+// A run records its failures by category; the retry only resends the
+// retryable one.
+func TestRetryFailuresSkipsPermanent(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("RATE", "1", ""), envVar("BAD", "2", ""), envVar("OK", "3", "")}
+	f.projects["g/dst"] = nil
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method != http.MethodPost {
+			return 0, ""
+		}
+		switch {
+		case strings.Contains(r.Body, `"key":"RATE"`):
+			return http.StatusServiceUnavailable, `{"message":"try later"}`
+		case strings.Contains(r.Body, `"key":"BAD"`):
+			return http.StatusUnprocessableEntity, `{"message":{"value":["is invalid"]}}`
+		}
+		return 0, ""
+	}
+	failures := filepath.Join(t.TempDir(), "failures.json")
+
+	if _, _, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--failures-file", failures)...); code != exitFailure {
+		t.Fatalf("first run: exit code %d, want %d", code, exitFailure)
+	}
+	records, err := readFailuresFile(failures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	categories := map[string]string{}
+	for _, r := range records {
+		categories[r.Key] = r.Category
+	}
+	if len(categories) != 2 || categories["RATE"] != failureRetryable || categories["BAD"] != failureValidation {
+		t.Fatalf("recorded failures = %v", categories)
+	}
+
+	f.intercept = nil
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--retry-failures", failures)...); code != 0 {
+		t.Fatalf("retry: exit code %d; stderr:\n%s", code, stderr)
+	}
+	keys := map[string]bool{}
+	for _, v := range f.vars("g/dst") {
+		keys[v.Key] = true
+	}
+	if len(keys) != 2 || !keys["OK"] || !keys["RATE"] {
+		t.Errorf("target after the retry = %v, want OK and RATE only", keys)
+	}
+}
@@ -0,0 +1,119 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// standardFields are the JSON field names of EnvVar as used by GitLab.
+var standardFields = []string{
+	"variable_type", "key", "value", "protected", "masked", "environment_scope", "hidden", "raw", "description",
+}
+
+// fieldMapping renames EnvVar JSON fields for GitLab-compatible APIs that use
+// different names. It maps standard names to API names; fields not listed
+// keep their standard name, and a nil mapping changes nothing.
+type fieldMapping map[string]string
+
+func readFieldMapping(filename string) (fieldMapping, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var mapping fieldMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("invalid field mapping %s: %v", filename, err)
+	}
+
+	known := map[string]bool{}
+	for _, f := range standardFields {
+		known[f] = true
+	}
+	for standard, api := range mapping {
+		if !known[standard] {
+			return nil, fmt.Errorf("invalid field mapping %s: unknown field %q", filename, standard)
+		}
+		if api == "" {
+			return nil, fmt.Errorf("invalid field mapping %s: empty name for %q", filename, standard)
+		}
+	}
+	return mapping, nil
+}
+
+// marshal encodes v with its fields renamed to the API's names.
+func (m fieldMapping) marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(m) == 0 {
+		return data, err
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	return json.Marshal(renameFields(object, m))
+}
+
+// decodeVariables decodes a JSON array of variables whose fields use the
+// API's names. With strict set, unknown fields are an error.
+func (m fieldMapping) decodeVariables(dec *json.Decoder, out *[]EnvVar, strict bool) error {
+	if len(m) == 0 {
+		return decodeVariableList(dec, out, strict)
+	}
+
+	var objects []map[string]json.RawMessage
+	if err := dec.Decode(&objects); err != nil {
+		return err
+	}
+
+	reverse := m.reverse()
+	for _, object := range objects {
+		data, err := json.Marshal(renameFields(object, reverse))
+		if err != nil {
+			return err
+		}
+		var v EnvVar
+		if err := unmarshalJSON(data, &v, strict); err != nil {
+			return err
+		}
+		*out = append(*out, v)
+	}
+	return nil
+}
+
+// decodeVariable decodes a single variable whose fields use the API's names.
+func (m fieldMapping) decodeVariable(data []byte, out *EnvVar, strict bool) error {
+	if len(m) > 0 {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+		var err error
+		if data, err = json.Marshal(renameFields(object, m.reverse())); err != nil {
+			return err
+		}
+	}
+	return unmarshalJSON(data, out, strict)
+}
+
+// reverse maps the API's field names back to the standard ones.
+func (m fieldMapping) reverse() map[string]string {
+	reverse := make(map[string]string, len(m))
+	for standard, api := range m {
+		reverse[api] = standard
+	}
+	return reverse
+}
+
+func renameFields(object map[string]json.RawMessage, names map[string]string) map[string]json.RawMessage {
+	renamed := make(map[string]json.RawMessage, len(object))
+	for name, value := range object {
+		if to, ok := names[name]; ok {
+			name = to
+		}
+		renamed[name] = value
+	}
+	return renamed
+}
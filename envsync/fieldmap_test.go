@@ -0,0 +1,74 @@
+package envsync
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadFieldMapping(t *testing.T) {
+	mapping, err := readFieldMapping(writeFile(t, "map.json", `{"environment_scope": "scope", "key": "name"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping["environment_scope"] != "scope" || mapping["key"] != "name" {
+		t.Errorf("mapping = %v", mapping)
+	}
+	for _, content := range []string{`{"colour": "x"}`, `{"key": ""}`, `[]`} {
+		if _, err := readFieldMapping(writeFile(t, "map.json", content)); err == nil {
+			t.Errorf("%s: no error", content)
+		}
+	}
+}
+
+// A mapped client reads and writes the API's field names.
+func TestFieldMappingAgainstAPI(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = nil
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodGet && r.Path == "projects/g%2Fsrc/variables" {
+			return http.StatusOK, `[{"name": "A", "value": "1", "scope": "production", "protected": true}]`
+		}
+		return 0, ""
+	}
+	client := f.client()
+	client.fields = fieldMapping{"key": "name", "environment_scope": "scope"}
+
+	variables, err := client.GetVariables("g/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variables) != 1 || variables[0].Key != "A" || variables[0].EnvironmentScope != "production" || !variables[0].Protected {
+		t.Fatalf("variables = %+v", variables)
+	}
+
+	if err := client.CreateVariable("g/dst", variables[0], false); err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(f.received(http.MethodPost)[0].Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["name"] != "A" || body["scope"] != "production" {
+		t.Errorf("create body = %v, want the mapped names", body)
+	}
+	for _, standard := range []string{"key", "environment_scope"} {
+		if _, ok := body[standard]; ok {
+			t.Errorf("create body has the standard field %q", standard)
+		}
+	}
+}
+
+func TestFieldMappingStrictRejectsUnknown(t *testing.T) {
+	m := fieldMapping{"key": "name"}
+	var out []EnvVar
+	err := m.decodeVariables(json.NewDecoder(strings.NewReader(`[{"name": "A", "colour": "red"}]`)), &out, true)
+	if err == nil {
+		t.Error("strict decode of an unknown field succeeded")
+	}
+	out = nil
+	if err := m.decodeVariables(json.NewDecoder(strings.NewReader(`[{"name": "A", "colour": "red"}]`)), &out, false); err != nil || out[0].Key != "A" {
+		t.Errorf("lenient decode = %v, %v", out, err)
+	}
+}
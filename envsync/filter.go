@@ -0,0 +1,68 @@
+package envsync
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// filterVariables keeps the variables for which keep returns true. With
+// explain set, every dropped variable is logged with reason.
+func filterVariables(variables []EnvVar, keep func(EnvVar) bool, reason string, explain bool) []EnvVar {
+	result := make([]EnvVar, 0, len(variables))
+	for _, v := range variables {
+		if keep(v) {
+			result = append(result, v)
+			continue
+		}
+		if explain {
+			log.Printf("explain: %s: filtered out (%s)", keyOf(v), reason)
+		}
+	}
+	return result
+}
+
+// compileNameList turns a comma-separated list of names or regular
+// expressions into one pattern that must match a whole name. An empty list
+// returns nil.
+func compileNameList(list string) (*regexp.Regexp, error) {
+	patterns := splitList(list)
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, err
+		}
+	}
+	return regexp.Compile("^(?:" + strings.Join(patterns, "|") + ")$")
+}
+
+// sortedByKey returns a copy of variables sorted by key and scope.
+func sortedByKey(variables []EnvVar) []EnvVar {
+	sorted := append([]EnvVar(nil), variables...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Key != sorted[j].Key {
+			return sorted[i].Key < sorted[j].Key
+		}
+		return normalizeScope(sorted[i].EnvironmentScope) < normalizeScope(sorted[j].EnvironmentScope)
+	})
+	return sorted
+}
+
+// checkExpectedCount fails if n is not the count --expect-count asks for, or
+// outside --expect-count-min and --expect-count-max. Negative bounds are
+// unset.
+func checkExpectedCount(n, exact, minimum, maximum int) error {
+	switch {
+	case exact >= 0 && n != exact:
+		return fmt.Errorf("expected %d source variables after filtering, found %d", exact, n)
+	case minimum >= 0 && n < minimum:
+		return fmt.Errorf("expected at least %d source variables after filtering, found %d", minimum, n)
+	case maximum >= 0 && n > maximum:
+		return fmt.Errorf("expected at most %d source variables after filtering, found %d", maximum, n)
+	}
+	return nil
+}
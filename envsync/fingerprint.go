@@ -0,0 +1,28 @@
+package envsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// fingerprint is a SHA-256 over a normalized variable set: keys, scopes,
+// attributes and values. It does not depend on the order of vars, and an
+// empty scope or type counts as GitLab's default (keyOf normalizes the
+// scope), so two projects with identical variables have the same
+// fingerprint.
+func fingerprint(vars []EnvVar) string {
+	lines := make([]string, 0, len(vars))
+	for _, v := range vars {
+		lines = append(lines, fmt.Sprintf("%s\x00%s\x00%t\x00%t\x00%t\x00%t\x00%q\x00%s",
+			keyOf(v), normalizeVariableType(v.VariableType), v.Protected, v.Masked, v.Hidden, v.Raw, v.Description, valueChecksum(v.Value)))
+	}
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, line := range lines {
+		fmt.Fprintln(h, line)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,72 @@
+package envsync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFingerprintIgnoresOrder(t *testing.T) {
+	a := []EnvVar{envVar("A", "1", ""), envVar("B", "2", "production"), envVar("B", "3", "staging")}
+	b := []EnvVar{envVar("B", "3", "staging"), envVar("A", "1", "*"), envVar("B", "2", "production")}
+	if fingerprint(a) != fingerprint(b) {
+		t.Error("reordered variable sets have different fingerprints")
+	}
+	untyped := []EnvVar{{Key: "A", Value: "1"}, {Key: "B", Value: "2", EnvironmentScope: "production"}, {Key: "B", Value: "3", EnvironmentScope: "staging"}}
+	if fingerprint(untyped) != fingerprint(a) {
+		t.Error("an empty type or scope is not fingerprinted as env_var and *")
+	}
+	if fingerprint(nil) != fingerprint([]EnvVar{}) {
+		t.Error("empty variable sets have different fingerprints")
+	}
+}
+
+func TestFingerprintCoversEveryField(t *testing.T) {
+	base := envVar("A", "1", "")
+	want := fingerprint([]EnvVar{base})
+	for name, change := range map[string]func(*EnvVar){
+		"key":         func(v *EnvVar) { v.Key = "B" },
+		"value":       func(v *EnvVar) { v.Value = "2" },
+		"scope":       func(v *EnvVar) { v.EnvironmentScope = "production" },
+		"type":        func(v *EnvVar) { v.VariableType = "file" },
+		"protected":   func(v *EnvVar) { v.Protected = true },
+		"masked":      func(v *EnvVar) { v.Masked = true },
+		"raw":         func(v *EnvVar) { v.Raw = true },
+		"description": func(v *EnvVar) { v.Description = "x" },
+	} {
+		v := base
+		change(&v)
+		if fingerprint([]EnvVar{v}) == want {
+			t.Errorf("changing the %s keeps the fingerprint", name)
+		}
+	}
+}
+
+// The fingerprint of two projects with the same variables listed in a
+// different order is the same.
+func TestFingerprintCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/a"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")}
+	f.projects["g/b"] = []EnvVar{envVar("B", "2", ""), envVar("A", "1", "")}
+	f.projects["g/c"] = []EnvVar{envVar("A", "1", ""), envVar("B", "changed", "")}
+
+	output := map[string]string{}
+	for _, args := range [][]string{{"--source", "g/a"}, {"--target", "g/b"}, {"--source", "g/c"}} {
+		stdout, stderr, code := runMain(t, "", f.args(append(args, "--fingerprint")...)...)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d; stderr:\n%s", args, code, stderr)
+		}
+		output[args[1]] = strings.TrimSpace(stdout)
+	}
+	if len(output["g/a"]) != 64 {
+		t.Errorf("fingerprint = %q, want a hex SHA-256", output["g/a"])
+	}
+	if output["g/a"] != output["g/b"] {
+		t.Errorf("g/a %s and g/b %s differ", output["g/a"], output["g/b"])
+	}
+	if output["g/a"] == output["g/c"] {
+		t.Error("g/a and g/c have the same fingerprint")
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v", writes)
+	}
+}
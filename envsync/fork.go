@@ -0,0 +1,84 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// GetProject fetches a single project by its full path.
+func (c *GitLabClient) GetProject(projectPath string) (*Project, error) {
+	req, err := c.makeRequest("GET", "projects/"+pathSegment(projectPath), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("project not found: %s", projectPath)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError("failed to get project", resp)
+	}
+
+	var project Project
+	if err := json.NewDecoder(resp.Body).Decode(&project); err != nil {
+		return nil, err
+	}
+	c.rememberProject(projectPath, project.ID)
+	return &project, nil
+}
+
+// warnForkDuplicates logs every synced variable that the target's fork
+// parent already defines for the same scope. GitLab does not copy variables
+// into forks, but pipelines for merge requests from a fork that run in the
+// parent project use the parent's variables, so such duplicates are often
+// redundant. It returns the number of requests it made, and an error only
+// if the token was rejected.
+func warnForkDuplicates(client *GitLabClient, targetProject string, variables []EnvVar) (int, error) {
+	project, err := client.GetProject(targetProject)
+	if isAuthError(err) {
+		return 1, err
+	}
+	if err != nil {
+		log.Printf("Warning: cannot read project %s, skipping fork check: %v", targetProject, err)
+		return 1, nil
+	}
+	if project.ForkedFromProject == nil {
+		return 1, nil
+	}
+
+	upstream := project.ForkedFromProject.PathWithNamespace
+	client.rememberProject(upstream, project.ForkedFromProject.ID)
+	upstreamVars, err := client.GetVariables(upstream)
+	if isAuthError(err) {
+		return 2, err
+	}
+	if err != nil {
+		log.Printf("Warning: cannot read variables of upstream project %s, skipping fork check: %v", upstream, err)
+		return 2, nil
+	}
+
+	defined := make(map[variableKey]EnvVar, len(upstreamVars))
+	for _, v := range upstreamVars {
+		defined[keyOf(v)] = v
+	}
+	for _, v := range variables {
+		parent, ok := defined[keyOf(v)]
+		if !ok {
+			continue
+		}
+		note := "values differ"
+		if parent.Value == v.Value {
+			note = "values are identical"
+		}
+		log.Printf("Warning: %s is also defined in %s, the upstream of fork %s (%s)", keyOf(v), upstream, targetProject, note)
+	}
+	return 1 + pages(len(upstreamVars)), nil
+}
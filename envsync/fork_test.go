@@ -0,0 +1,94 @@
+package envsync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetProjectForkParent(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.info["me/app"] = Project{ID: 7, PathWithNamespace: "me/app", ForkedFromProject: &Project{ID: 3, PathWithNamespace: "team/app"}}
+
+	project, err := f.client().GetProject("me/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.ID != 7 || project.ForkedFromProject == nil || project.ForkedFromProject.PathWithNamespace != "team/app" {
+		t.Errorf("project = %+v", project)
+	}
+	if _, err := f.client().GetProject("me/missing"); err == nil || err.Error() != "project not found: me/missing" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestWarnForkDuplicates(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.info["me/app"] = Project{ID: 7, PathWithNamespace: "me/app", ForkedFromProject: &Project{ID: 3, PathWithNamespace: "team/app"}}
+	f.info["team/app"] = Project{ID: 3, PathWithNamespace: "team/app"}
+	f.projects["me/app"] = nil
+	f.projects["team/app"] = []EnvVar{envVar("SAME", "1", ""), envVar("DIFFERENT", "upstream", ""), envVar("SCOPED", "1", "production")}
+	logs := captureLog(t)
+
+	reads, err := warnForkDuplicates(f.client(), "me/app", []EnvVar{
+		envVar("SAME", "1", ""),
+		envVar("DIFFERENT", "fork", ""),
+		envVar("SCOPED", "1", "staging"),
+		envVar("OWN", "1", ""),
+	})
+	// The upstream's ID comes with the fork, so it needs no lookup.
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests := f.received(); reads != 2 || len(requests) != 2 {
+		t.Errorf("reported %d reads, made %v", reads, requests)
+	}
+	got := logs.String()
+	for _, want := range []string{
+		"Warning: SAME@* is also defined in team/app, the upstream of fork me/app (values are identical)",
+		"Warning: DIFFERENT@* is also defined in team/app, the upstream of fork me/app (values differ)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "Warning:") != 2 {
+		t.Errorf("log has other warnings:\n%s", got)
+	}
+}
+
+// A project that is not a fork is not checked further.
+func TestWarnForkDuplicatesNotAFork(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.info["team/app"] = Project{ID: 3, PathWithNamespace: "team/app"}
+	logs := captureLog(t)
+
+	if _, err := warnForkDuplicates(f.client(), "team/app", []EnvVar{envVar("A", "1", "")}); err != nil {
+		t.Fatal(err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("log:\n%s", logs)
+	}
+	if requests := f.received(); len(requests) != 1 {
+		t.Errorf("requests = %v, want only the project lookup", requests)
+	}
+}
+
+func TestCheckForkCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.info["me/app"] = Project{ID: 7, PathWithNamespace: "me/app", ForkedFromProject: &Project{ID: 3, PathWithNamespace: "team/app"}}
+	f.info["team/app"] = Project{ID: 3, PathWithNamespace: "team/app"}
+	f.projects["g/src"] = []EnvVar{envVar("API_URL", "x", "")}
+	f.projects["me/app"] = nil
+	f.projects["team/app"] = []EnvVar{envVar("API_URL", "x", "")}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "me/app", "--check-fork")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Warning: API_URL@* is also defined in team/app, the upstream of fork me/app") {
+		t.Errorf("stderr:\n%s", stderr)
+	}
+	if got := f.vars("me/app"); len(got) != 1 {
+		t.Errorf("target = %v, want the variable synced anyway", got)
+	}
+}
@@ -0,0 +1,381 @@
+package envsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mainArgsEnv carries the arguments of a runMain child process.
+const mainArgsEnv = "ENVSYNC_TEST_MAIN_ARGS"
+
+func TestMain(m *testing.M) {
+	if args := os.Getenv(mainArgsEnv); args != "" {
+		var argv []string
+		if err := json.Unmarshal([]byte(args), &argv); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(100)
+		}
+		os.Args = append([]string{"gitlab-env-sync"}, argv...)
+		Main()
+		os.Exit(0)
+	}
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// runMain runs the command line in a child process with stdin as its input
+// and returns its output and exit code.
+func runMain(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	data, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), mainArgsEnv+"="+string(data), "NO_COLOR=1")
+	cmd.Dir = t.TempDir()
+	cmd.Stdin = strings.NewReader(stdin)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return out.String(), errOut.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), 0
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/name, or rewrites the file with
+// -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	filename := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(filename, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (rerun with -update to accept):\n%s", filename, got)
+	}
+}
+
+// captureLog collects the log output of the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+	return &buf
+}
+
+// testConfig returns the configuration the command line would build from
+// args, before main's own checks and derived settings.
+func testConfig(t *testing.T, args ...string) *config {
+	t.Helper()
+	cfg := &config{}
+	fs := flag.NewFlagSet("gitlab-env-sync", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg.registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// fakeRequest is a request received by fakeGitLab.
+type fakeRequest struct {
+	Method string
+	// Path is the escaped path below /api/v4/, e.g. projects/g%2Fapp/variables.
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+func (r fakeRequest) String() string {
+	return r.Method + " " + r.Path
+}
+
+// fakeGitLab is an in-memory GitLab API serving the endpoints the client
+// uses. Projects and groups are keyed by full path; a project listed in
+// info is also reachable by its numeric ID.
+type fakeGitLab struct {
+	mu sync.Mutex
+
+	projects      map[string][]EnvVar
+	groups        map[string][]EnvVar
+	instance      []EnvVar
+	info          map[string]Project
+	groupProjects map[string][]Project
+	version       string
+
+	// maxPerPage caps the page size, to exercise pagination.
+	maxPerPage int
+
+	// intercept, if set, sees every request first. It returns the status
+	// and body to answer with, or zero to let the fake handle it.
+	intercept func(r fakeRequest) (int, string)
+
+	requests []fakeRequest
+	server   *httptest.Server
+}
+
+func newFakeGitLab(t *testing.T) *fakeGitLab {
+	f := &fakeGitLab{
+		projects:      map[string][]EnvVar{},
+		groups:        map[string][]EnvVar{},
+		info:          map[string]Project{},
+		groupProjects: map[string][]Project{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// client returns a client for the fake.
+func (f *fakeGitLab) client(opts ...ClientOption) *GitLabClient {
+	return NewGitLabClient(f.server.URL, "test-token", opts...)
+}
+
+// args returns a command line against the fake followed by extra.
+func (f *fakeGitLab) args(extra ...string) []string {
+	return append([]string{"--gitlab-url", f.server.URL, "--token", "test-token"}, extra...)
+}
+
+// vars returns the current variables of a project.
+func (f *fakeGitLab) vars(project string) []EnvVar {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]EnvVar(nil), f.projects[project]...)
+}
+
+// received returns the requests served so far, optionally only those with
+// one of the given methods.
+func (f *fakeGitLab) received(methods ...string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []fakeRequest
+	for _, r := range f.requests {
+		if len(methods) == 0 || containsField(methods, r.Method) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// writes returns the requests that change something, as "METHOD path".
+func (f *fakeGitLab) writes() []string {
+	var result []string
+	for _, r := range f.received(http.MethodPost, http.MethodPut, http.MethodDelete) {
+		result = append(result, r.String())
+	}
+	return result
+}
+
+func (f *fakeGitLab) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req := fakeRequest{
+		Method: r.Method,
+		Path:   strings.TrimPrefix(r.URL.EscapedPath(), "/api/v4/"),
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   string(body),
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	intercept := f.intercept
+	f.mu.Unlock()
+
+	if intercept != nil {
+		if status, response := intercept(req); status != 0 {
+			writeFakeResponse(w, status, response, nil)
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status, response, header := f.handle(req)
+	writeFakeResponse(w, status, response, header)
+}
+
+func writeFakeResponse(w http.ResponseWriter, status int, response interface{}, header http.Header) {
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	switch body := response.(type) {
+	case nil:
+	case string:
+		io.WriteString(w, body)
+	default:
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func notFound(what string) (int, interface{}, http.Header) {
+	return http.StatusNotFound, map[string]string{"message": "404 " + what + " Not Found"}, nil
+}
+
+func (f *fakeGitLab) handle(r fakeRequest) (int, interface{}, http.Header) {
+	segments := strings.Split(r.Path, "/")
+	for i, s := range segments {
+		segments[i], _ = url.PathUnescape(s)
+	}
+
+	switch {
+	case len(segments) == 1 && segments[0] == "version":
+		if f.version == "" {
+			return notFound("")
+		}
+		return http.StatusOK, map[string]string{"version": f.version}, nil
+	case len(segments) == 3 && segments[0] == "admin" && segments[2] == "variables":
+		return f.page(r, f.instance)
+	case len(segments) == 2 && segments[0] == "projects":
+		project, ok := f.info[f.projectPath(segments[1])]
+		if !ok {
+			return notFound("Project")
+		}
+		return http.StatusOK, project, nil
+	case len(segments) == 3 && segments[0] == "groups" && segments[2] == "projects":
+		projects, ok := f.groupProjects[segments[1]]
+		if !ok {
+			return notFound("Group")
+		}
+		return f.page(r, projects)
+	case len(segments) >= 3 && segments[2] == "variables":
+		store := f.projects
+		path := f.projectPath(segments[1])
+		if segments[0] == "groups" {
+			store, path = f.groups, segments[1]
+		}
+		if _, ok := store[path]; !ok {
+			if segments[0] == "groups" {
+				return notFound("Group")
+			}
+			return notFound("Project")
+		}
+		if len(segments) == 3 {
+			return f.variables(r, store, path)
+		}
+		return f.variable(r, store, path, segments[3])
+	}
+	return notFound("")
+}
+
+// projectPath maps a numeric project ID to its path.
+func (f *fakeGitLab) projectPath(id string) string {
+	for path, p := range f.info {
+		if strconv.Itoa(p.ID) == id {
+			return path
+		}
+	}
+	return id
+}
+
+// page answers a list request with the page it asks for.
+func (f *fakeGitLab) page(r fakeRequest, items interface{}) (int, interface{}, http.Header) {
+	data, _ := json.Marshal(items)
+	var all []json.RawMessage
+	json.Unmarshal(data, &all)
+
+	perPage, _ := strconv.Atoi(r.Query.Get("per_page"))
+	if perPage <= 0 {
+		perPage = 20
+	}
+	if f.maxPerPage > 0 {
+		perPage = min(perPage, f.maxPerPage)
+	}
+	page, _ := strconv.Atoi(r.Query.Get("page"))
+	page = max(page, 1)
+	start := min((page-1)*perPage, len(all))
+	end := min(start+perPage, len(all))
+	header := http.Header{}
+	if end < len(all) {
+		header.Set("X-Next-Page", strconv.Itoa(page+1))
+	}
+	return http.StatusOK, append([]json.RawMessage{}, all[start:end]...), header
+}
+
+func (f *fakeGitLab) variables(r fakeRequest, store map[string][]EnvVar, path string) (int, interface{}, http.Header) {
+	switch r.Method {
+	case http.MethodGet:
+		return f.page(r, store[path])
+	case http.MethodPost:
+		var v EnvVar
+		if err := json.Unmarshal([]byte(r.Body), &v); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}, nil
+		}
+		v.EnvironmentScope = normalizeScope(v.EnvironmentScope)
+		v.VariableType = normalizeVariableType(v.VariableType)
+		for _, existing := range store[path] {
+			if keyOf(existing) == keyOf(v) {
+				return http.StatusBadRequest, map[string]interface{}{"message": map[string][]string{"key": {"(" + v.Key + ") has already been taken"}}}, nil
+			}
+		}
+		store[path] = append(store[path], v)
+		return http.StatusCreated, v, nil
+	}
+	return http.StatusMethodNotAllowed, nil, nil
+}
+
+func (f *fakeGitLab) variable(r fakeRequest, store map[string][]EnvVar, path, key string) (int, interface{}, http.Header) {
+	scope := r.Query.Get("filter[environment_scope]")
+	index := -1
+	for i, v := range store[path] {
+		if v.Key == key && (scope == "" || normalizeScope(v.EnvironmentScope) == scope) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return notFound("Variable")
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return http.StatusOK, store[path][index], nil
+	case http.MethodPut:
+		// Only the fields sent are changed, as in GitLab.
+		v := store[path][index]
+		if err := json.Unmarshal([]byte(r.Body), &v); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}, nil
+		}
+		store[path][index] = v
+		return http.StatusOK, v, nil
+	case http.MethodDelete:
+		store[path] = append(store[path][:index:index], store[path][index+1:]...)
+		return http.StatusNoContent, nil, nil
+	}
+	return http.StatusMethodNotAllowed, nil, nil
+}
+
+// envVar returns an unprotected, unmasked env_var variable.
+func envVar(key, value, scope string) EnvVar {
+	return EnvVar{VariableType: defaultVariableType, Key: key, Value: value, EnvironmentScope: scope}
+}
@@ -0,0 +1,77 @@
+package envsync
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// redactedPlaceholder replaces secret values in a gitlab-ci export.
+const redactedPlaceholder = "[redacted]"
+
+// isSecret reports whether a variable's value should be kept out of
+// documentation: masked, hidden and protected variables.
+func isSecret(v EnvVar) bool {
+	return v.Masked || v.Hidden || v.Protected
+}
+
+// redactSecrets replaces the values of secret variables with a placeholder.
+func redactSecrets(variables []EnvVar) []EnvVar {
+	result := make([]EnvVar, len(variables))
+	for i, v := range variables {
+		if isSecret(v) {
+			v.Value = redactedPlaceholder
+		}
+		result[i] = v
+	}
+	return result
+}
+
+// writeGitLabCI writes variables as a .gitlab-ci.yml variables: block. CI
+// file variables have no environment scopes, so each key appears once with
+// its * variant (or its first one), and a comment lists the scopes it is
+// set for in GitLab. Raw variables get expand: false.
+func writeGitLabCI(w io.Writer, variables []EnvVar) error {
+	var order []string
+	byKey := map[string][]EnvVar{}
+	for _, v := range variables {
+		if _, ok := byKey[v.Key]; !ok {
+			order = append(order, v.Key)
+		}
+		byKey[v.Key] = append(byKey[v.Key], v)
+	}
+
+	if len(order) == 0 {
+		_, err := fmt.Fprintln(w, "variables: {}")
+		return err
+	}
+	fmt.Fprintln(w, "variables:")
+	for _, key := range order {
+		variants := byKey[key]
+		chosen := variants[0]
+		scopes := make([]string, len(variants))
+		for i, v := range variants {
+			scopes[i] = normalizeScope(v.EnvironmentScope)
+			if scopes[i] == defaultScope {
+				chosen = v
+			}
+		}
+		if len(variants) > 1 || normalizeScope(chosen.EnvironmentScope) != defaultScope {
+			fmt.Fprintf(w, "  # %s is scoped to %s in GitLab; showing %s\n", key, strings.Join(scopes, ", "), normalizeScope(chosen.EnvironmentScope))
+		}
+
+		if chosen.Description == "" && !chosen.Raw {
+			fmt.Fprintf(w, "  %s: %s\n", key, yamlString(chosen.Value))
+			continue
+		}
+		fmt.Fprintf(w, "  %s:\n", key)
+		fmt.Fprintf(w, "    value: %s\n", yamlString(chosen.Value))
+		if chosen.Description != "" {
+			fmt.Fprintf(w, "    description: %s\n", yamlString(chosen.Description))
+		}
+		if chosen.Raw {
+			fmt.Fprintln(w, "    expand: false")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package envsync
+
+import (
+	"strings"
+	"testing"
+)
+
+func gitLabCIDataset() []EnvVar {
+	masked := envVar("API_TOKEN", "s3cr3t-token", "")
+	masked.Masked = true
+	protected := envVar("DEPLOY_KEY", "deploy", "production")
+	protected.Protected = true
+	raw := envVar("TEMPLATE", "$HOME/x", "")
+	raw.Raw = true
+	raw.Description = "Not expanded: keeps $HOME"
+	return []EnvVar{
+		envVar("APP_ENV", "development", ""),
+		envVar("API_URL", "https://staging", "staging"),
+		envVar("API_URL", "https://example.com", ""),
+		masked,
+		protected,
+		raw,
+		envVar("GREETING", "hello: world", ""),
+	}
+}
+
+func TestGitLabCIExport(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = gitLabCIDataset()
+
+	for _, tc := range []struct {
+		golden string
+		args   []string
+	}{
+		{"gitlab-ci.yml", nil},
+		{"gitlab-ci-values.yml", []string{"--show-values"}},
+	} {
+		args := append([]string{"--source", "g/src", "--export", "-", "--export-format", "gitlab-ci"}, tc.args...)
+		stdout, stderr, code := runMain(t, "", f.args(args...)...)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d; stderr:\n%s", tc.args, code, stderr)
+		}
+		checkGolden(t, tc.golden, []byte(stdout))
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	redacted := redactSecrets(gitLabCIDataset())
+	for _, v := range redacted {
+		if isSecret(v) != (v.Value == redactedPlaceholder) {
+			t.Errorf("%s: value %q", keyOf(v), v.Value)
+		}
+	}
+	if gitLabCIDataset()[3].Value == redactedPlaceholder {
+		t.Error("redactSecrets changed its input")
+	}
+}
+
+func TestGitLabCIExportEmpty(t *testing.T) {
+	var b strings.Builder
+	if err := writeGitLabCI(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "variables: {}\n" {
+		t.Errorf("empty export = %q", b.String())
+	}
+}
@@ -0,0 +1,66 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Project is the subset of GitLab's project resource the tool relies on.
+type Project struct {
+	ID                int    `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	Archived          bool   `json:"archived"`
+
+	// ForkedFromProject is the upstream of a fork, nil otherwise.
+	ForkedFromProject *Project `json:"forked_from_project,omitempty"`
+}
+
+// GetGroupProjects lists every project in a group, including those of all
+// nested subgroups. groupPath is the full path, e.g. parent/child/grandchild.
+func (c *GitLabClient) GetGroupProjects(groupPath string) ([]Project, error) {
+	encodedPath := pathSegment(groupPath)
+
+	var projects []Project
+	path := fmt.Sprintf("groups/%s/projects?include_subgroups=true", encodedPath)
+	err := c.getAllPages(path, "failed to list group projects", func(dec *json.Decoder) error {
+		var page []Project
+		if err := dec.Decode(&page); err != nil {
+			return err
+		}
+		projects = append(projects, page...)
+		return nil
+	})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("group not found: %s", groupPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return projects, nil
+}
+
+// GetGroupVariables lists the CI/CD variables defined on a group. Variables
+// inherited from parent groups are not included.
+func (c *GitLabClient) GetGroupVariables(groupPath string) ([]EnvVar, error) {
+	encodedPath := pathSegment(groupPath)
+
+	var variables []EnvVar
+	err := c.getAllPages(fmt.Sprintf("groups/%s/variables", encodedPath), "failed to get group variables", func(dec *json.Decoder) error {
+		return c.fields.decodeVariables(dec, &variables, c.strict)
+	})
+	if isNotFound(err) {
+		return nil, fmt.Errorf("group not found: %s", groupPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return variables, nil
+}
+
+// CreateGroupVariable creates a CI/CD variable on a group, which any nested
+// group or project inherits.
+func (c *GitLabClient) CreateGroupVariable(groupPath string, variable EnvVar) error {
+	return c.createVariable("groups", groupPath, variable)
+}
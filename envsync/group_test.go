@@ -0,0 +1,240 @@
+package envsync
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetGroupProjectsFollowsPages(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.maxPerPage = 2
+	var want []string
+	for i := 1; i <= 5; i++ {
+		path := fmt.Sprintf("grp/sub/app%d", i)
+		f.groupProjects["grp"] = append(f.groupProjects["grp"], Project{ID: i, PathWithNamespace: path})
+		want = append(want, path)
+	}
+
+	projects, err := f.client().GetGroupProjects("grp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range projects {
+		got = append(got, p.PathWithNamespace)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("projects = %v, want %v", got, want)
+	}
+	requests := f.received()
+	if len(requests) != 3 {
+		t.Errorf("requests = %v, want three pages", requests)
+	}
+	for _, r := range requests {
+		if r.Query.Get("include_subgroups") != "true" {
+			t.Errorf("%v does not include subgroups", r)
+		}
+	}
+}
+
+func TestGetGroupProjectsUnknownGroup(t *testing.T) {
+	_, err := newFakeGitLab(t).client().GetGroupProjects("nope")
+	if err == nil || err.Error() != "group not found: nope" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestResolveTargetsFromGroup(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.groupProjects["grp"] = []Project{
+		{ID: 1, PathWithNamespace: "grp/src"},
+		{ID: 2, PathWithNamespace: "grp/app"},
+		{ID: 3, PathWithNamespace: "grp/old", Archived: true},
+		{ID: 4, PathWithNamespace: "grp/sandbox-1"},
+		{ID: 5, PathWithNamespace: "grp/sub/api"},
+	}
+	cfg := testConfig(t, "--source", "grp/src", "--target-group", "grp", "--target-exclude", "grp/sandbox-.*")
+	logs := captureLog(t)
+
+	got := resolveTargets(f.client(), cfg)
+	if want := []string{"grp/app", "grp/sub/api"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("targets = %v, want %v", got, want)
+	}
+	if !strings.Contains(logs.String(), "Resolved 2 target projects from group grp (1 archived, 1 excluded)") {
+		t.Errorf("log = %q", logs)
+	}
+}
+
+// Archived and excluded projects of the group are neither written nor read,
+// and --explain reports why.
+func TestTargetGroupSkipsArchivedAndExcluded(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["grp/src"] = []EnvVar{envVar("A", "1", "")}
+	for _, p := range []Project{
+		{ID: 1, PathWithNamespace: "grp/src"},
+		{ID: 2, PathWithNamespace: "grp/app"},
+		{ID: 3, PathWithNamespace: "grp/legacy", Archived: true},
+		{ID: 4, PathWithNamespace: "grp/templates/base"},
+	} {
+		f.groupProjects["grp"] = append(f.groupProjects["grp"], p)
+		f.info[p.PathWithNamespace] = p
+		if f.projects[p.PathWithNamespace] == nil {
+			f.projects[p.PathWithNamespace] = []EnvVar{}
+		}
+	}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "grp/src", "--target-group", "grp", "--target-exclude", "grp/templates/.*", "--explain", "--yes")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"explain: target grp/app: included",
+		"explain: target grp/legacy: skipped (archived)",
+		"explain: target grp/templates/base: skipped (--target-exclude)",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr lacks %q:\n%s", want, stderr)
+		}
+	}
+	if writes := f.writes(); len(writes) != 1 || f.vars("grp/app")[0].Key != "A" {
+		t.Errorf("writes = %v, want A created in grp/app only", writes)
+	}
+}
+
+func TestPathSegment(t *testing.T) {
+	for in, want := range map[string]string{
+		"parent/child/grandchild": "parent%2Fchild%2Fgrandchild",
+		"my-group/app_1.x~":       "my-group%2Fapp_1.x~",
+		"a+b:c d":                 "a%2Bb%3Ac%20d",
+	} {
+		if got := pathSegment(in); got != want {
+			t.Errorf("pathSegment(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// A three-level subgroup path is sent as one encoded :id segment.
+func TestNestedGroupPaths(t *testing.T) {
+	const group = "parent/child/grandchild"
+	const encoded = "groups/parent%2Fchild%2Fgrandchild"
+	f := newFakeGitLab(t)
+	f.groups[group] = []EnvVar{envVar("A", "1", "")}
+	f.groupProjects[group] = []Project{{ID: 1, PathWithNamespace: group + "/app"}}
+	client := f.client()
+
+	if projects, err := client.GetGroupProjects(group); err != nil || len(projects) != 1 {
+		t.Fatalf("GetGroupProjects = %v, %v", projects, err)
+	}
+	if variables, err := client.GetGroupVariables(group); err != nil || len(variables) != 1 {
+		t.Fatalf("GetGroupVariables = %v, %v", variables, err)
+	}
+	if err := client.CreateGroupVariable(group, envVar("B", "2", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GET " + encoded + "/projects",
+		"GET " + encoded + "/variables",
+		"POST " + encoded + "/variables",
+	}
+	requests := f.received()
+	if len(requests) != len(want) {
+		t.Fatalf("requests = %v, want %v", requests, want)
+	}
+	for i, r := range requests {
+		if r.String() != want[i] {
+			t.Errorf("request %d = %s, want %s", i, r, want[i])
+		}
+	}
+	if requests[0].Query.Get("include_subgroups") != "true" {
+		t.Errorf("%v does not include subgroups", requests[0])
+	}
+	if len(f.groups[group]) != 2 {
+		t.Errorf("group variables = %v, want B created", f.groups[group])
+	}
+}
+
+func TestGroupVariablesUnknownGroup(t *testing.T) {
+	f := newFakeGitLab(t)
+	if _, err := f.client().GetGroupVariables("parent/missing"); err == nil || err.Error() != "group not found: parent/missing" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// groupFake serves a source project and a group of two empty targets.
+func groupFake(t *testing.T) *fakeGitLab {
+	f := newFakeGitLab(t)
+	f.projects["grp/src"] = []EnvVar{envVar("A", "1", "")}
+	for _, p := range []Project{
+		{ID: 1, PathWithNamespace: "grp/src"},
+		{ID: 2, PathWithNamespace: "grp/app"},
+		{ID: 3, PathWithNamespace: "grp/sub/api"},
+	} {
+		f.groupProjects["grp"] = append(f.groupProjects["grp"], p)
+		f.info[p.PathWithNamespace] = p
+		if f.projects[p.PathWithNamespace] == nil {
+			f.projects[p.PathWithNamespace] = []EnvVar{}
+		}
+	}
+	return f
+}
+
+// Without a terminal a group sync lists its targets and stops before
+// writing unless --yes is given.
+func TestTargetGroupNeedsConfirmation(t *testing.T) {
+	f := groupFake(t)
+
+	_, stderr, code := runMain(t, "", f.args("--source", "grp/src", "--target-group", "grp")...)
+	if code == 0 {
+		t.Fatalf("group sync without --yes succeeded; stderr:\n%s", stderr)
+	}
+	for _, want := range []string{
+		"This run writes to 2 target project(s):",
+		"  grp/app",
+		"  grp/sub/api",
+		"needs confirmation; pass --yes",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr lacks %q:\n%s", want, stderr)
+		}
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v, want none", writes)
+	}
+}
+
+// With --yes the full target list is still logged, before the first
+// target is synced.
+func TestTargetGroupListsTargetsBeforeWriting(t *testing.T) {
+	f := groupFake(t)
+
+	_, stderr, code := runMain(t, "", f.args("--source", "grp/src", "--target-group", "grp", "--yes")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	list := strings.Index(stderr, "This run writes to 2 target project(s):\n")
+	if list < 0 {
+		t.Fatalf("stderr lacks the target list:\n%s", stderr)
+	}
+	if first := strings.Index(stderr, "Starting transfer"); first < list {
+		t.Errorf("a variable was written before the target list was shown:\n%s", stderr)
+	}
+	if writes := f.writes(); len(writes) != 2 {
+		t.Errorf("writes = %v, want A created in both targets", writes)
+	}
+}
+
+// A dry run writes nothing and is not asked.
+func TestTargetGroupDryRunIsNotConfirmed(t *testing.T) {
+	f := groupFake(t)
+
+	_, stderr, code := runMain(t, "", f.args("--source", "grp/src", "--target-group", "grp", "--dry-run")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if strings.Contains(stderr, "needs confirmation") {
+		t.Errorf("dry run asked for confirmation:\n%s", stderr)
+	}
+}
@@ -0,0 +1,98 @@
+package envsync
+
+import (
+	"log"
+	"strings"
+)
+
+// ancestorGroups returns the groups a project inherits variables from,
+// nearest first: group/sub/app yields group/sub, then group.
+func ancestorGroups(projectPath string) []string {
+	var groups []string
+	for i := strings.LastIndex(projectPath, "/"); i > 0; i = strings.LastIndex(projectPath, "/") {
+		projectPath = projectPath[:i]
+		groups = append(groups, projectPath)
+	}
+	return groups
+}
+
+// scopesOverlap reports whether variables in the two scopes can apply to the
+// same environment.
+func scopesOverlap(a, b string) bool {
+	a, b = normalizeScope(a), normalizeScope(b)
+	return a == b || a == defaultScope || b == defaultScope
+}
+
+// inheritedConflict is a synced variable that collides with a variable the
+// target inherits from one of its groups.
+type inheritedConflict struct {
+	Variable  EnvVar
+	Inherited EnvVar
+	Group     string
+}
+
+// findInheritedConflicts matches the variables being synced against the
+// variables of the target's ancestor groups.
+func findInheritedConflicts(variables []EnvVar, groupVars map[string][]EnvVar, groups []string) []inheritedConflict {
+	var conflicts []inheritedConflict
+	for _, v := range variables {
+		for _, group := range groups {
+			for _, inherited := range groupVars[group] {
+				if inherited.Key == v.Key && scopesOverlap(inherited.EnvironmentScope, v.EnvironmentScope) {
+					conflicts = append(conflicts, inheritedConflict{Variable: v, Inherited: inherited, Group: group})
+				}
+			}
+		}
+	}
+	return conflicts
+}
+
+// stillInherited lists where the inherited variable of a conflict keeps
+// applying instead of the synced one. Project variables take precedence over
+// group variables whatever their scopes, so an inherited variable never
+// overrides a synced one where both apply; it stays in effect only where the
+// synced variable does not apply: outside its narrower scope, or on
+// unprotected refs if only the synced variable is protected.
+func (c inheritedConflict) stillInherited() []string {
+	var where []string
+	if normalizeScope(c.Variable.EnvironmentScope) != defaultScope && normalizeScope(c.Inherited.EnvironmentScope) == defaultScope {
+		where = append(where, "in environments other than "+c.Variable.EnvironmentScope)
+	}
+	if c.Variable.Protected && !c.Inherited.Protected {
+		where = append(where, "on unprotected branches and tags")
+	}
+	return where
+}
+
+// warnInheritedConflicts logs every synced variable that would shadow a
+// variable inherited from the target's groups, and where the inherited one
+// would still apply instead. Project variables take precedence in GitLab, so
+// where both apply the inherited value stops being effective. It returns an
+// error only if the token was rejected.
+func warnInheritedConflicts(client *GitLabClient, targetProject string, variables []EnvVar) error {
+	groups := ancestorGroups(targetProject)
+	groupVars := map[string][]EnvVar{}
+	for _, group := range groups {
+		vars, err := client.GetGroupVariables(group)
+		if isAuthError(err) {
+			return err
+		}
+		if err != nil {
+			log.Printf("Warning: cannot read variables of group %s, skipping inheritance check for it: %v", group, err)
+			continue
+		}
+		groupVars[group] = vars
+	}
+
+	for _, c := range findInheritedConflicts(variables, groupVars, groups) {
+		note := "values differ"
+		if c.Variable.Value == c.Inherited.Value {
+			note = "values are identical, the project variable is redundant"
+		}
+		log.Printf("Warning: %s will shadow %s inherited from group %s (%s)", keyOf(c.Variable), keyOf(c.Inherited), c.Group, note)
+		for _, where := range c.stillInherited() {
+			log.Printf("Warning: %s inherited from group %s is still used instead of %s %s", keyOf(c.Inherited), c.Group, keyOf(c.Variable), where)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package envsync
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAncestorGroups(t *testing.T) {
+	if got, want := ancestorGroups("org/team/sub/app"), []string{"org/team/sub", "org/team", "org"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ancestorGroups = %v, want %v", got, want)
+	}
+	if got := ancestorGroups("app"); len(got) != 0 {
+		t.Errorf("ancestorGroups(app) = %v, want none", got)
+	}
+}
+
+func TestScopesOverlap(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want bool
+	}{
+		{"production", "production", true},
+		{"", "production", true},
+		{"staging", "*", true},
+		{"staging", "production", false},
+	} {
+		if got := scopesOverlap(test.a, test.b); got != test.want {
+			t.Errorf("scopesOverlap(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+// A synced key that collides with a variable of the target's group is
+// reported, in any overlapping scope; an unrelated scope is not.
+func TestWarnInheritedConflicts(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.groups["org"] = []EnvVar{envVar("REGISTRY", "shared", "")}
+	f.groups["org/team"] = []EnvVar{envVar("API_URL", "https://team", "production"), envVar("DEBUG", "0", "staging")}
+	logs := captureLog(t)
+
+	synced := []EnvVar{envVar("API_URL", "https://app", ""), envVar("REGISTRY", "shared", "production"), envVar("DEBUG", "1", "development")}
+	if err := warnInheritedConflicts(f.client(), "org/team/app", synced); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"API_URL@* will shadow API_URL@production inherited from group org/team (values differ)",
+		"REGISTRY@production will shadow REGISTRY@* inherited from group org (values are identical",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs.String(), "DEBUG") {
+		t.Errorf("DEBUG in non-overlapping scopes was reported:\n%s", logs)
+	}
+}
+
+// Where the synced variable does not apply, the inherited one stays in
+// effect: outside a narrower scope and, for a protected project variable, on
+// unprotected refs. It never wins where both apply.
+func TestWarnStillInherited(t *testing.T) {
+	f := newFakeGitLab(t)
+	token := envVar("TOKEN", "group", "")
+	f.groups["org"] = []EnvVar{envVar("REGISTRY", "shared", ""), token, envVar("API_URL", "https://org", "production")}
+	logs := captureLog(t)
+
+	protected := envVar("TOKEN", "project", "")
+	protected.Protected = true
+	synced := []EnvVar{envVar("REGISTRY", "mine", "production"), protected, envVar("API_URL", "https://app", "")}
+	if err := warnInheritedConflicts(f.client(), "org/app", synced); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"REGISTRY@* inherited from group org is still used instead of REGISTRY@production in environments other than production",
+		"TOKEN@* inherited from group org is still used instead of TOKEN@* on unprotected branches and tags",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, logs)
+		}
+	}
+	// A narrower group scope does not override the project's * variable.
+	if strings.Contains(logs.String(), "API_URL@production inherited from group org is still used") {
+		t.Errorf("API_URL reported as still inherited:\n%s", logs)
+	}
+	if !strings.Contains(logs.String(), "API_URL@* will shadow API_URL@production inherited from group org") {
+		t.Errorf("API_URL not reported as shadowing:\n%s", logs)
+	}
+}
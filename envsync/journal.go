@@ -0,0 +1,63 @@
+package envsync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// journalEntry is one line of the --journal file: a change env-sync made to
+// a target. Values are never recorded.
+type journalEntry struct {
+	Timestamp string  `json:"timestamp"`
+	Source    string  `json:"source"`
+	Target    string  `json:"target"`
+	Key       string  `json:"key"`
+	Scope     string  `json:"scope"`
+	Action    outcome `json:"action"`
+}
+
+// journal appends applied changes to a JSONL file that accumulates across
+// runs.
+type journal struct {
+	f      *os.File
+	enc    *json.Encoder
+	source string
+}
+
+func openJournal(filename, source string, mode os.FileMode) (*journal, error) {
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{f: f, enc: json.NewEncoder(f), source: source}, nil
+}
+
+// record appends v if result changed the target.
+func (j *journal) record(targetProject string, v EnvVar, result outcome) error {
+	switch result {
+	case outcomeCreated, outcomeUpdated, outcomeDeleted:
+	default:
+		return nil
+	}
+	// Each entry is written with a single unbuffered write, so an
+	// interrupted run never leaves a partial line.
+	return j.enc.Encode(journalEntry{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Source:    j.source,
+		Target:    targetProject,
+		Key:       v.Key,
+		Scope:     normalizeScope(v.EnvironmentScope),
+		Action:    result,
+	})
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
@@ -0,0 +1,84 @@
+package envsync
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readJournal(t *testing.T, filename string) []journalEntry {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []journalEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestJournalRecordsChangesOnly(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "logs", "journal.jsonl")
+	j, err := openJournal(filename, "g/src", 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range []outcome{outcomeCreated, outcomeUnchanged, outcomeSkipped, outcomeFailed, outcomeUpdated, outcomeDeleted} {
+		if err := j.record("g/dst", envVar("A", "secret", "production"), result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readJournal(t, filename)
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, string(e.Action))
+		if e.Source != "g/src" || e.Target != "g/dst" || e.Key != "A" || e.Scope != "production" || e.Timestamp == "" {
+			t.Errorf("entry = %+v", e)
+		}
+	}
+	if got := strings.Join(actions, ","); got != "created,updated,deleted" {
+		t.Errorf("actions = %s, want created,updated,deleted", got)
+	}
+	data, _ := os.ReadFile(filename)
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("journal contains a value:\n%s", data)
+	}
+}
+
+func TestJournalAccumulatesAcrossRuns(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")}
+	f.projects["g/dst"] = nil
+	filename := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--journal", filename)...); code != 0 {
+		t.Fatalf("first run: exit code %d; stderr:\n%s", code, stderr)
+	}
+	f.projects["g/src"][0].Value = "changed"
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--journal", filename, "--upsert")...); code != 0 {
+		t.Fatalf("second run: exit code %d; stderr:\n%s", code, stderr)
+	}
+
+	var got []string
+	for _, e := range readJournal(t, filename) {
+		got = append(got, e.Key+" "+string(e.Action))
+	}
+	if want := "A created,B created,A updated"; strings.Join(got, ",") != want {
+		t.Errorf("journal = %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package envsync
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonlEvent is one line of --output-jsonl output.
+type jsonlEvent struct {
+	Target string  `json:"target"`
+	Key    string  `json:"key"`
+	Scope  string  `json:"scope"`
+	Action outcome `json:"action"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// jsonlWriter streams one JSON object per processed variable.
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	return &jsonlWriter{enc: json.NewEncoder(w)}
+}
+
+// forTarget returns a reportFunc that streams events for targetProject.
+func (w *jsonlWriter) forTarget(targetProject string) reportFunc {
+	return func(v EnvVar, result outcome, err error) {
+		w.write(targetProject, v, result, err)
+	}
+}
+
+func (w *jsonlWriter) write(targetProject string, v EnvVar, result outcome, err error) {
+	event := jsonlEvent{
+		Target: targetProject,
+		Key:    v.Key,
+		Scope:  normalizeScope(v.EnvironmentScope),
+		Action: result,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	// Encode writes the line in a single call, so consumers never see a
+	// partial object.
+	_ = w.enc.Encode(event)
+}
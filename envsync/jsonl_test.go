@@ -0,0 +1,58 @@
+package envsync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJSONLWriter(t *testing.T) {
+	var buf bytes.Buffer
+	report := newJSONLWriter(&buf).forTarget("g/dst")
+	report(envVar("A", "1", ""), outcomeCreated, nil)
+	report(envVar("B", "2", "production"), outcomeFailed, errors.New("boom"))
+
+	want := `{"target":"g/dst","key":"A","scope":"*","action":"created"}
+{"target":"g/dst","key":"B","scope":"production","action":"failed","error":"boom"}
+`
+	if buf.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+// Every processed variable appears on stdout as one JSON object per line,
+// and nothing else is written there.
+func TestOutputJSONLStreamsStdout(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", ""), envVar("C", "3", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("B", "2", ""), envVar("C", "old", "")}
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--output-jsonl")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	actions := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		var event jsonlEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		if event.Target != "g/dst" {
+			t.Errorf("event %+v has the wrong target", event)
+		}
+		actions[event.Key] = string(event.Action)
+	}
+	want := map[string]string{"A": "created", "B": "unchanged", "C": "updated"}
+	if len(actions) != len(want) {
+		t.Fatalf("actions = %v, want %v", actions, want)
+	}
+	for key, action := range want {
+		if actions[key] != action {
+			t.Errorf("%s: action %q, want %q", key, actions[key], action)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"text/tabwriter"
+)
+
+// Levels a variable can be defined at, in order of precedence.
+const (
+	levelProject  = "project"
+	levelGroup    = "group"
+	levelInstance = "instance"
+)
+
+// listedVariable is one row of --list. Values are never listed.
+type listedVariable struct {
+	Key              string `json:"key"`
+	EnvironmentScope string `json:"environment_scope"`
+	Level            string `json:"level"`
+
+	// Group is the group the variable is defined on for level group.
+	Group string `json:"group,omitempty"`
+
+	// ShadowedBy names the definition that overrides this one for every
+	// environment it applies to; the variable is not effective.
+	ShadowedBy string `json:"shadowed_by,omitempty"`
+}
+
+func (l listedVariable) origin() string {
+	if l.Level == levelGroup {
+		return "group " + l.Group
+	}
+	return l.Level
+}
+
+// GetInstanceVariables lists the instance-level CI/CD variables. Reading them
+// needs an administrator token.
+func (c *GitLabClient) GetInstanceVariables() ([]EnvVar, error) {
+	var variables []EnvVar
+	err := c.getAllPages("admin/ci/variables", "failed to get instance variables", func(dec *json.Decoder) error {
+		return c.fields.decodeVariables(dec, &variables, c.strict)
+	})
+	return variables, err
+}
+
+// effectiveVariables lists the project's variables followed by those it
+// inherits, nearest group first and instance variables last. A definition is
+// shadowed when one with higher precedence has the same key and a scope
+// covering its own: the same scope or *. A group's production variable is
+// still effective beside a project's staging one of the same key.
+func effectiveVariables(project []EnvVar, groups []string, groupVars map[string][]EnvVar, instance []EnvVar) []listedVariable {
+	var listed []listedVariable
+	add := func(vars []EnvVar, level, group string) {
+		start := len(listed)
+		for _, v := range vars {
+			l := listedVariable{Key: v.Key, EnvironmentScope: normalizeScope(v.EnvironmentScope), Level: level, Group: group}
+			for _, higher := range listed[:start] {
+				if higher.Key == l.Key && higher.ShadowedBy == "" && (higher.EnvironmentScope == l.EnvironmentScope || higher.EnvironmentScope == defaultScope) {
+					l.ShadowedBy = fmt.Sprintf("%s@%s (%s)", higher.Key, higher.EnvironmentScope, higher.origin())
+					break
+				}
+			}
+			listed = append(listed, l)
+		}
+	}
+	add(project, levelProject, "")
+	for _, group := range groups {
+		add(groupVars[group], levelGroup, group)
+	}
+	add(instance, levelInstance, "")
+
+	sort.SliceStable(listed, func(i, j int) bool {
+		if listed[i].Key != listed[j].Key {
+			return listed[i].Key < listed[j].Key
+		}
+		return listed[i].EnvironmentScope < listed[j].EnvironmentScope
+	})
+	return listed
+}
+
+// readEffectiveVariables reads what projectPath inherits and combines it with
+// its own variables. Groups or instance variables that cannot be read are
+// left out with a warning.
+func readEffectiveVariables(client *GitLabClient, projectPath string, project []EnvVar) []listedVariable {
+	groups := ancestorGroups(projectPath)
+	groupVars := map[string][]EnvVar{}
+	for _, group := range groups {
+		vars, err := client.GetGroupVariables(group)
+		if isAuthError(err) {
+			exitAuth(err)
+		}
+		if err != nil {
+			log.Printf("Warning: cannot read variables of group %s, leaving them out: %v", group, err)
+			continue
+		}
+		groupVars[group] = vars
+	}
+	instance, err := client.GetInstanceVariables()
+	if err != nil {
+		log.Printf("Warning: cannot read instance variables (an administrator token is needed), leaving them out: %v", err)
+		instance = nil
+	}
+	return effectiveVariables(project, groups, groupVars, instance)
+}
+
+// projectVariables lists a project's own variables without inheritance.
+func projectVariables(vars []EnvVar) []listedVariable {
+	return effectiveVariables(vars, nil, nil, nil)
+}
+
+func writeVariableList(w io.Writer, format string, listed []listedVariable) error {
+	if format == logFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(listed)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSCOPE\tDEFINED ON\tNOTE")
+	for _, l := range listed {
+		note := ""
+		if l.ShadowedBy != "" {
+			note = "shadowed by " + l.ShadowedBy
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", l.Key, l.EnvironmentScope, l.origin(), note)
+	}
+	return tw.Flush()
+}
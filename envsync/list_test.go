@@ -0,0 +1,106 @@
+package envsync
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A project variable shadows a group one of the same key in the same scope
+// or when its own scope is *; a group's production variable stays effective
+// beside a project's staging one, and the nearest group wins over its parent.
+func TestEffectiveVariables(t *testing.T) {
+	project := []EnvVar{
+		envVar("API_URL", "p", "staging"),
+		envVar("LOG_LEVEL", "p", ""),
+	}
+	groups := []string{"org/team", "org"}
+	groupVars := map[string][]EnvVar{
+		"org/team": {
+			envVar("API_URL", "g", "production"),
+			envVar("API_URL", "g", "staging"),
+			envVar("LOG_LEVEL", "g", "production"),
+			envVar("REGISTRY", "team", ""),
+		},
+		"org": {
+			envVar("REGISTRY", "org", ""),
+			envVar("REGION", "org", "production"),
+		},
+	}
+	instance := []EnvVar{envVar("REGION", "instance", ""), envVar("REGISTRY", "instance", "staging")}
+
+	got := effectiveVariables(project, groups, groupVars, instance)
+	want := []listedVariable{
+		{Key: "API_URL", EnvironmentScope: "production", Level: levelGroup, Group: "org/team"},
+		{Key: "API_URL", EnvironmentScope: "staging", Level: levelProject},
+		{Key: "API_URL", EnvironmentScope: "staging", Level: levelGroup, Group: "org/team", ShadowedBy: "API_URL@staging (project)"},
+		{Key: "LOG_LEVEL", EnvironmentScope: "*", Level: levelProject},
+		{Key: "LOG_LEVEL", EnvironmentScope: "production", Level: levelGroup, Group: "org/team", ShadowedBy: "LOG_LEVEL@* (project)"},
+		{Key: "REGION", EnvironmentScope: "*", Level: levelInstance},
+		{Key: "REGION", EnvironmentScope: "production", Level: levelGroup, Group: "org"},
+		{Key: "REGISTRY", EnvironmentScope: "*", Level: levelGroup, Group: "org/team"},
+		{Key: "REGISTRY", EnvironmentScope: "*", Level: levelGroup, Group: "org", ShadowedBy: "REGISTRY@* (group org/team)"},
+		{Key: "REGISTRY", EnvironmentScope: "staging", Level: levelInstance, ShadowedBy: "REGISTRY@* (group org/team)"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("effectiveVariables =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestListEffective(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["org/team/app"] = []EnvVar{envVar("API_URL", "secret-project", "")}
+	f.groups["org/team"] = []EnvVar{envVar("API_URL", "secret-group", "production"), envVar("REGISTRY", "secret-group", "")}
+	f.groups["org"] = []EnvVar{}
+	f.instance = []EnvVar{envVar("REGION", "secret-instance", "")}
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "org/team/app", "--list", "--effective", "--log-format", "json")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if strings.Contains(stdout, "secret") {
+		t.Errorf("the list shows values:\n%s", stdout)
+	}
+	var got []listedVariable
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatalf("%v:\n%s", err, stdout)
+	}
+	want := []listedVariable{
+		{Key: "API_URL", EnvironmentScope: "*", Level: levelProject},
+		{Key: "API_URL", EnvironmentScope: "production", Level: levelGroup, Group: "org/team", ShadowedBy: "API_URL@* (project)"},
+		{Key: "REGION", EnvironmentScope: "*", Level: levelInstance},
+		{Key: "REGISTRY", EnvironmentScope: "*", Level: levelGroup, Group: "org/team"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("list = %v, want %v", got, want)
+	}
+}
+
+// Without an administrator token the instance variables are left out with a
+// warning and the rest is still listed.
+func TestListEffectiveWithoutInstanceAccess(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["org/app"] = []EnvVar{envVar("API_URL", "x", "")}
+	f.groups["org"] = []EnvVar{envVar("REGISTRY", "x", "")}
+	f.intercept = func(r fakeRequest) (int, string) {
+		if strings.HasPrefix(r.Path, "admin/") {
+			return http.StatusForbidden, `{"message":"403 Forbidden"}`
+		}
+		return 0, ""
+	}
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "org/app", "--list", "--effective")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "cannot read instance variables") {
+		t.Errorf("stderr lacks the instance warning:\n%s", stderr)
+	}
+	for _, want := range []string{"KEY", "API_URL", "project", "REGISTRY", "group org"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("list lacks %q:\n%s", want, stdout)
+		}
+	}
+}
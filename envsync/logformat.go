@@ -0,0 +1,61 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Values accepted by --log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// jsonLogWriter turns each log line into a JSON object.
+type jsonLogWriter struct {
+	out   io.Writer
+	runID string
+}
+
+func (w jsonLogWriter) Write(p []byte) (int, error) {
+	entry := struct {
+		Time    string `json:"time"`
+		RunID   string `json:"run_id,omitempty"`
+		Message string `json:"msg"`
+	}{
+		Time:    time.Now().Format(time.RFC3339),
+		RunID:   w.runID,
+		Message: strings.TrimRight(string(p), "\n"),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// setupLogging configures the standard logger for the chosen format. Text
+// lines carry runID after the timestamp, JSON entries in a run_id field.
+func setupLogging(format string, out io.Writer, runID string) error {
+	switch format {
+	case logFormatText:
+		if runID != "" {
+			log.SetPrefix("[" + runID + "] ")
+			log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+		}
+		return nil
+	case logFormatJSON:
+		log.SetFlags(0)
+		log.SetOutput(jsonLogWriter{out: out, runID: runID})
+		return nil
+	default:
+		return fmt.Errorf("unknown --log-format %q (use text or json)", format)
+	}
+}
@@ -0,0 +1,70 @@
+package envsync
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// managedMarker is appended to the description of variables written with
+// --mark-managed, so later runs can tell them apart from variables created
+// by hand.
+const managedMarker = "(managed by env-sync)"
+
+func isManaged(v EnvVar) bool {
+	return strings.Contains(v.Description, managedMarker)
+}
+
+// markManaged adds the managed marker to every variable's description.
+func markManaged(variables []EnvVar) []EnvVar {
+	result := make([]EnvVar, len(variables))
+	for i, v := range variables {
+		if !isManaged(v) {
+			v.Description = strings.TrimSpace(v.Description + " " + managedMarker)
+		}
+		result[i] = v
+	}
+	return result
+}
+
+// findOrphans returns the managed target variables that are no longer in
+// the source.
+func findOrphans(sourceVars, targetVars []EnvVar) []EnvVar {
+	inSource := map[variableKey]bool{}
+	for _, v := range sourceVars {
+		inSource[keyOf(v)] = true
+	}
+	var orphans []EnvVar
+	for _, v := range targetVars {
+		if isManaged(v) && !inSource[keyOf(v)] {
+			orphans = append(orphans, v)
+		}
+	}
+	return orphans
+}
+
+// runFindOrphans lists the orphaned managed variables of every target
+// without changing anything.
+func runFindOrphans(w io.Writer, client *GitLabClient, sourceVars []EnvVar, targets []string) error {
+	total := 0
+	for _, target := range targets {
+		log.Printf("Fetching variables from target project: %s", target)
+		targetVars, err := client.GetVariables(target)
+		if err != nil {
+			return fmt.Errorf("getting variables from target project: %w", err)
+		}
+
+		orphans := findOrphans(sourceVars, targetVars)
+		for _, o := range orphans {
+			if len(targets) > 1 {
+				fmt.Fprintf(w, "%s: %s\n", target, keyOf(o))
+			} else {
+				fmt.Fprintln(w, keyOf(o))
+			}
+		}
+		total += len(orphans)
+	}
+	log.Printf("Found %d managed variables that are no longer in the source (use --prune to delete them)", total)
+	return nil
+}
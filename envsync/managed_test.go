@@ -0,0 +1,55 @@
+package envsync
+
+import (
+	"testing"
+)
+
+func managedVar(key, value, scope string) EnvVar {
+	v := envVar(key, value, scope)
+	v.Description = "Synced " + managedMarker
+	return v
+}
+
+func TestMarkManaged(t *testing.T) {
+	described := envVar("A", "1", "")
+	described.Description = "Database host"
+	got := markManaged([]EnvVar{described, envVar("B", "1", ""), managedVar("C", "1", "")})
+
+	for i, want := range []string{"Database host " + managedMarker, managedMarker, "Synced " + managedMarker} {
+		if got[i].Description != want {
+			t.Errorf("%s: description %q, want %q", got[i].Key, got[i].Description, want)
+		}
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	source := []EnvVar{envVar("IN_SOURCE", "1", ""), envVar("SCOPED", "1", "staging")}
+	target := []EnvVar{
+		managedVar("IN_SOURCE", "1", ""),
+		managedVar("ORPHAN", "1", ""),
+		managedVar("SCOPED", "1", "production"),
+		envVar("BY_HAND", "1", ""),
+	}
+	orphans := findOrphans(source, target)
+	if len(orphans) != 2 || keyOf(orphans[0]).String() != "ORPHAN@*" || keyOf(orphans[1]).String() != "SCOPED@production" {
+		t.Errorf("orphans = %v, want ORPHAN@* and SCOPED@production", orphans)
+	}
+}
+
+// --find-orphans only reads the target.
+func TestFindOrphansIsReadOnly(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = []EnvVar{managedVar("A", "1", ""), managedVar("GONE", "1", ""), envVar("MINE", "1", "")}
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--find-orphans")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if stdout != "GONE@*\n" {
+		t.Errorf("stdout = %q, want GONE@*", stdout)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v", writes)
+	}
+}
@@ -0,0 +1,79 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// readMatrixFile reads a --matrix file: a JSON object mapping KEY@scope to
+// the value that variable should get, e.g.
+//
+//	{"DB_HOST@staging": "db.staging", "DB_HOST@production": "db.prod"}
+func readMatrixFile(filename string) (map[variableKey]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid matrix file %s: %v", filename, err)
+	}
+
+	matrix := make(map[variableKey]string, len(raw))
+	for ref, value := range raw {
+		key, err := parseVariableKey(ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matrix file %s: %v", filename, err)
+		}
+		matrix[key] = value
+	}
+	return matrix, nil
+}
+
+// applyMatrix overrides source values with the matrix entries. An entry for
+// an existing key and scope replaces that variable's value; any other entry
+// becomes a new scoped variant with the attributes of the key's default-scope
+// variable, or of its first variant if there is none. Every matrix key must
+// exist in the source.
+func applyMatrix(variables []EnvVar, matrix map[variableKey]string) ([]EnvVar, error) {
+	templates := map[string]EnvVar{}
+	index := map[variableKey]int{}
+	for i, v := range variables {
+		index[keyOf(v)] = i
+		if t, ok := templates[v.Key]; !ok || (normalizeScope(t.EnvironmentScope) != defaultScope && normalizeScope(v.EnvironmentScope) == defaultScope) {
+			templates[v.Key] = v
+		}
+	}
+
+	entries := make([]variableKey, 0, len(matrix))
+	var missing []string
+	for k := range matrix {
+		entries = append(entries, k)
+		if _, ok := templates[k.Key]; !ok && !slices.Contains(missing, k.Key) {
+			missing = append(missing, k.Key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("matrix keys not in source: %s", strings.Join(missing, ", "))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].String() < entries[j].String() })
+
+	result := append([]EnvVar(nil), variables...)
+	for _, k := range entries {
+		if i, ok := index[k]; ok {
+			result[i].Value = matrix[k]
+			continue
+		}
+		v := templates[k.Key]
+		v.EnvironmentScope = k.Scope
+		v.Value = matrix[k]
+		result = append(result, v)
+	}
+	return result, nil
+}
@@ -0,0 +1,57 @@
+package envsync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyMatrix(t *testing.T) {
+	host := envVar("DB_HOST", "localhost", "")
+	host.Protected = true
+	variables := []EnvVar{host, envVar("DB_HOST", "old-staging", "staging"), envVar("DEBUG", "1", "")}
+	matrix, err := readMatrixFile(writeFile(t, "matrix.json", `{
+		"DB_HOST@staging": "db.staging",
+		"DB_HOST@production": "db.prod",
+		"DEBUG@review/*": "2"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := applyMatrix(variables, matrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod := host
+	prod.EnvironmentScope, prod.Value = "production", "db.prod"
+	want := []EnvVar{
+		host,
+		envVar("DB_HOST", "db.staging", "staging"),
+		envVar("DEBUG", "1", ""),
+		prod,
+		envVar("DEBUG", "2", "review/*"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("applyMatrix =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestApplyMatrixRequiresSourceKeys(t *testing.T) {
+	matrix := map[variableKey]string{
+		{Key: "NOPE", Scope: "staging"}:    "1",
+		{Key: "NOPE", Scope: "production"}: "2",
+		{Key: "A", Scope: "staging"}:       "3",
+	}
+	_, err := applyMatrix([]EnvVar{envVar("A", "1", "")}, matrix)
+	if err == nil || err.Error() != "matrix keys not in source: NOPE" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestReadMatrixFileErrors(t *testing.T) {
+	for _, content := range []string{`{"@staging": "x"}`, `["A@*"]`, `{"A@*": 1}`} {
+		if _, err := readMatrixFile(writeFile(t, "matrix.json", content)); err == nil {
+			t.Errorf("%s: no error", content)
+		}
+	}
+}
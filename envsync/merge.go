@@ -0,0 +1,127 @@
+package envsync
+
+import (
+	"fmt"
+	"strings"
+)
+
+// attribute is a bit set of EnvVar fields other than key and value.
+type attribute uint8
+
+const (
+	attrVariableType attribute = 1 << iota
+	attrProtected
+	attrMasked
+	attrRaw
+
+	attrAll = attrVariableType | attrProtected | attrMasked | attrRaw
+)
+
+func (a attribute) has(attr attribute) bool {
+	return a&attr != 0
+}
+
+// mergeUnspecified fills the attributes the source left unspecified from the
+// target's current variable, so an update never changes what the source did
+// not ask to change.
+func mergeUnspecified(source, current EnvVar) EnvVar {
+	merged := source
+	if source.unspecified.has(attrVariableType) {
+		merged.VariableType = current.VariableType
+	}
+	if source.unspecified.has(attrProtected) {
+		merged.Protected = current.Protected
+	}
+	if source.unspecified.has(attrMasked) {
+		merged.Masked = current.Masked
+	}
+	if source.unspecified.has(attrRaw) {
+		merged.Raw = current.Raw
+	}
+	merged.unspecified = 0
+	return merged
+}
+
+// Field names reported by changedFields, matching the API's JSON names.
+const (
+	fieldValue        = "value"
+	fieldVariableType = "variable_type"
+	fieldProtected    = "protected"
+	fieldMasked       = "masked"
+	fieldRaw          = "raw"
+	fieldDescription  = "description"
+)
+
+// changedFields lists the fields that would change if v were applied over
+// current. Both are expected to share the same key and scope.
+func changedFields(v, current EnvVar) []string {
+	var fields []string
+	if v.Value != current.Value {
+		fields = append(fields, fieldValue)
+	}
+	if normalizeVariableType(v.VariableType) != normalizeVariableType(current.VariableType) {
+		fields = append(fields, fieldVariableType)
+	}
+	if v.Protected != current.Protected {
+		fields = append(fields, fieldProtected)
+	}
+	if v.Masked != current.Masked {
+		fields = append(fields, fieldMasked)
+	}
+	if v.Raw != current.Raw {
+		fields = append(fields, fieldRaw)
+	}
+	// A source without a description leaves the target's alone.
+	if v.Description != "" && v.Description != current.Description {
+		fields = append(fields, fieldDescription)
+	}
+	return fields
+}
+
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// comparableFields are the fields --compare-fields accepts.
+var comparableFields = []string{fieldValue, fieldVariableType, fieldProtected, fieldMasked, fieldRaw, fieldDescription}
+
+// parseCompareFields parses a comma-separated --compare-fields list. An empty
+// list compares every field.
+func parseCompareFields(list string) ([]string, error) {
+	fields := splitList(list)
+	for _, f := range fields {
+		if !containsField(comparableFields, f) {
+			return nil, fmt.Errorf("unknown field %q (use %s)", f, strings.Join(comparableFields, ", "))
+		}
+	}
+	return fields, nil
+}
+
+// keepUncompared copies the fields outside compare from current, so they
+// neither trigger an update nor change when one is sent.
+func keepUncompared(v, current EnvVar, compare []string) EnvVar {
+	if !containsField(compare, fieldValue) {
+		v.Value = current.Value
+	}
+	if !containsField(compare, fieldVariableType) {
+		v.VariableType = current.VariableType
+	}
+	if !containsField(compare, fieldProtected) {
+		v.Protected = current.Protected
+	}
+	if !containsField(compare, fieldMasked) {
+		v.Masked = current.Masked
+	}
+	if !containsField(compare, fieldRaw) {
+		v.Raw = current.Raw
+	}
+	if !containsField(compare, fieldDescription) {
+		v.Description = current.Description
+	}
+	return v
+}
@@ -0,0 +1,120 @@
+package envsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeUnspecified(t *testing.T) {
+	current := EnvVar{Key: "A", Value: "old", VariableType: "file", Protected: true, Masked: true, Raw: true}
+	source := EnvVar{Key: "A", Value: "new", VariableType: "env_var", unspecified: attrProtected | attrRaw}
+
+	merged := mergeUnspecified(source, current)
+	want := EnvVar{Key: "A", Value: "new", VariableType: "env_var", Protected: true, Masked: false, Raw: true}
+	if merged != want {
+		t.Errorf("mergeUnspecified = %+v, want %+v", merged, want)
+	}
+	if all := mergeUnspecified(EnvVar{Key: "A", Value: "new", unspecified: attrAll}, current); all.VariableType != "file" || !all.Protected || !all.Masked || !all.Raw {
+		t.Errorf("with every attribute unspecified, got %+v, want the target's attributes", all)
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	current := EnvVar{Key: "A", Value: "1", VariableType: "", Protected: true, Description: "kept"}
+	for _, test := range []struct {
+		v    EnvVar
+		want string
+	}{
+		{EnvVar{Key: "A", Value: "1", VariableType: "env_var", Protected: true}, ""},
+		{EnvVar{Key: "A", Value: "2", VariableType: "env_var", Protected: true}, "value"},
+		{EnvVar{Key: "A", Value: "1", VariableType: "file", Masked: true}, "variable_type,protected,masked"},
+		{EnvVar{Key: "A", Value: "1", Protected: true, Raw: true, Description: "new"}, "raw,description"},
+	} {
+		if got := strings.Join(changedFields(test.v, current), ","); got != test.want {
+			t.Errorf("changedFields(%+v) = %q, want %q", test.v, got, test.want)
+		}
+	}
+}
+
+// A .env import only carries values, so --merge-attributes keeps the
+// target's protection and masking on update.
+func TestMergeAttributesKeepsTargetProtection(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{{Key: "TOKEN", Value: "old", VariableType: "env_var", EnvironmentScope: "*", Protected: true, Masked: true}}
+	env := filepath.Join(t.TempDir(), "app.env")
+	if err := os.WriteFile(env, []byte("TOKEN=new-secret-value\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		flags     []string
+		protected bool
+	}{
+		{[]string{"--merge-attributes"}, true},
+		{nil, false},
+	} {
+		f.projects["g/dst"][0].Protected, f.projects["g/dst"][0].Masked = true, true
+		args := f.args(append([]string{"--import", env, "--target", "g/dst", "--upsert"}, test.flags...)...)
+		if _, stderr, code := runMain(t, "", args...); code != 0 {
+			t.Fatalf("%v: exit code %d; stderr:\n%s", test.flags, code, stderr)
+		}
+		got := f.vars("g/dst")[0]
+		if got.Value != "new-secret-value" || got.Protected != test.protected || got.Masked != test.protected {
+			t.Errorf("%v: target = %+v, want protected and masked %v", test.flags, got, test.protected)
+		}
+	}
+}
+
+func TestParseCompareFields(t *testing.T) {
+	fields, err := parseCompareFields("value, masked")
+	if err != nil || len(fields) != 2 || fields[0] != fieldValue || fields[1] != fieldMasked {
+		t.Errorf("parseCompareFields = %v, %v", fields, err)
+	}
+	if fields, err := parseCompareFields(""); fields != nil || err != nil {
+		t.Errorf("empty list = %v, %v, want nil", fields, err)
+	}
+	if _, err := parseCompareFields("value,scope"); err == nil || !strings.HasPrefix(err.Error(), `unknown field "scope"`) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// An attribute outside --compare-fields neither triggers an update nor
+// changes when the value does.
+func TestCompareFieldsIgnoresAttributes(t *testing.T) {
+	protected := envVar("ATTR_ONLY", "1", "")
+	protected.Protected = true
+	both := envVar("BOTH", "old", "")
+	both.Protected = true
+	existing := existingVars(protected, both)
+
+	source := []EnvVar{envVar("ATTR_ONLY", "1", ""), envVar("BOTH", "new", "")}
+	opts := transferOptions{Upsert: true, CompareFields: []string{fieldValue}, OnMaskFailure: maskFailureFail}
+	decisions, err := planTransfer(source, existing, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decisions[0].Action != actionUnchanged || decisions[1].Action != actionUpdate {
+		t.Fatalf("decisions = %v, want unchanged and update", decisions)
+	}
+	if !decisions[1].Variable.Protected {
+		t.Error("the update would unprotect BOTH")
+	}
+
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = source
+	f.projects["g/dst"] = []EnvVar{protected, both}
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--compare-fields", "value")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if writes := f.writes(); len(writes) != 1 || writes[0] != "PUT projects/g%2Fdst/variables/BOTH" {
+		t.Errorf("writes = %v, want only the value update of BOTH", writes)
+	}
+	for _, v := range f.vars("g/dst") {
+		if !v.Protected {
+			t.Errorf("%s lost its protection", v.Key)
+		}
+	}
+}
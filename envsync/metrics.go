@@ -0,0 +1,67 @@
+package envsync
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// metric is one Prometheus metric family of a run.
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value func(*runSummary) float64
+
+	// perRun metrics describe the whole run rather than a single target.
+	perRun bool
+}
+
+var runMetrics = []metric{
+	{"env_sync_variables_created_total", "counter", "Variables created in the target.", func(s *runSummary) float64 { return float64(s.Created) }, false},
+	{"env_sync_variables_updated_total", "counter", "Variables updated in the target.", func(s *runSummary) float64 { return float64(s.Updated) }, false},
+	{"env_sync_variables_unchanged_total", "counter", "Variables already identical in the target.", func(s *runSummary) float64 { return float64(s.Unchanged) }, false},
+	{"env_sync_variables_skipped_total", "counter", "Variables skipped.", func(s *runSummary) float64 { return float64(s.Skipped) }, false},
+	{"env_sync_variables_pruned_total", "counter", "Variables deleted from the target by --prune.", func(s *runSummary) float64 { return float64(s.Pruned) }, false},
+	{"env_sync_failures_total", "counter", "Variables that failed to sync.", func(s *runSummary) float64 { return float64(s.Failed) }, false},
+	{"env_sync_duration_seconds", "gauge", "Duration of the run.", func(s *runSummary) float64 { return s.Duration }, true},
+	{"env_sync_last_run_timestamp_seconds", "gauge", "Unix time the run started.", func(s *runSummary) float64 { return float64(s.started.Unix()) }, true},
+}
+
+// formatMetrics renders the summary in the Prometheus text exposition format,
+// with one series per target.
+func formatMetrics(summary *runSummary) []byte {
+	targets := summary.Targets
+	if len(targets) == 0 {
+		targets = []*runSummary{summary}
+	}
+
+	var buf bytes.Buffer
+	for _, m := range runMetrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", m.name, m.kind)
+		for _, t := range targets {
+			value := m.value(t)
+			if m.perRun {
+				value = m.value(summary)
+			}
+			fmt.Fprintf(&buf, "%s{source=%s,target=%s} %s\n", m.name,
+				quoteLabel(summary.SourceProject), quoteLabel(t.TargetProject),
+				strconv.FormatFloat(value, 'f', -1, 64))
+		}
+	}
+	return buf.Bytes()
+}
+
+func quoteLabel(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// writeMetricsFile replaces filename atomically so a textfile collector
+// never reads a partial file.
+func writeMetricsFile(filename string, summary *runSummary, mode os.FileMode) error {
+	return writeFileAtomic(filename, formatMetrics(summary), mode)
+}
@@ -0,0 +1,46 @@
+package envsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func metricsSummary() *runSummary {
+	started := time.Unix(1700000000, 0)
+	summary := &runSummary{SourceProject: "g/src", Duration: 1.5, started: started}
+	for _, target := range []*runSummary{
+		{TargetProject: "g/app", Created: 2, Updated: 1, Unchanged: 4, Pruned: 1},
+		{TargetProject: `g/"quoted"`, Skipped: 3, Failed: 1},
+	} {
+		summary.add(target)
+	}
+	return summary
+}
+
+func TestFormatMetrics(t *testing.T) {
+	checkGolden(t, "metrics.prom", formatMetrics(metricsSummary()))
+}
+
+func TestFormatMetricsSingleTarget(t *testing.T) {
+	summary := &runSummary{SourceProject: "g/src", TargetProject: "g/app", Created: 3, Duration: 0.25, started: time.Unix(1700000000, 0)}
+	checkGolden(t, "metrics-single.prom", formatMetrics(summary))
+}
+
+func TestWriteMetricsFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "env_sync.prom")
+	if err := writeMetricsFile(filename, metricsSummary(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(formatMetrics(metricsSummary())) {
+		t.Errorf("metrics file:\n%s", data)
+	}
+}
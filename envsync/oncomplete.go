@@ -0,0 +1,85 @@
+package envsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// runOnComplete runs command through the shell with the JSON run summary on
+// stdin. Its output goes to stderr, so it cannot corrupt what env-sync
+// writes to stdout, such as --output-jsonl.
+func runOnComplete(command string, summary *runSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// runSyncHook runs a --pre-sync-cmd or --post-sync-cmd command through the
+// shell with the run's context in ENV_SYNC_* environment variables. Its
+// output goes to stderr, so stdout only carries what env-sync writes there.
+func runSyncHook(command string, env []string, stdin io.Reader) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// preSyncEnv describes a run that has not fetched anything yet. The target
+// is a group for --target-group runs and empty if a plan file will name it.
+func preSyncEnv(cfg *config) []string {
+	source := cfg.SourceProject
+	switch {
+	case cfg.ApplyFile != "":
+		source = cfg.ApplyFile
+	case cfg.ImportFile != "":
+		source = cfg.ImportFile
+	}
+	target := cfg.TargetProject
+	if cfg.TargetGroup != "" {
+		target = cfg.TargetGroup
+	}
+	return hookEnv(cfg.RunID, source, target, cfg.DryRun)
+}
+
+// postSyncEnv describes a finished run by its summary.
+func postSyncEnv(cfg *config, summary *runSummary) []string {
+	env := hookEnv(cfg.RunID, summary.SourceProject, summary.TargetProject, summary.DryRun)
+	for _, count := range []struct {
+		name string
+		n    int
+	}{
+		{"TOTAL", summary.Total},
+		{"CREATED", summary.Created},
+		{"UPDATED", summary.Updated},
+		{"UNCHANGED", summary.Unchanged},
+		{"SKIPPED", summary.Skipped},
+		{"PRUNED", summary.Pruned},
+		{"FAILED", summary.Failed},
+	} {
+		env = append(env, fmt.Sprintf("ENV_SYNC_%s=%d", count.name, count.n))
+	}
+	return env
+}
+
+func hookEnv(runID, source, target string, dryRun bool) []string {
+	return []string{
+		"ENV_SYNC_RUN_ID=" + runID,
+		"ENV_SYNC_SOURCE=" + source,
+		"ENV_SYNC_TARGET=" + target,
+		"ENV_SYNC_DRY_RUN=" + strconv.FormatBool(dryRun),
+	}
+}
@@ -0,0 +1,152 @@
+package envsync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The --on-complete-cmd command gets the JSON summary on stdin.
+func TestOnCompleteCmd(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("B", "2", "")}
+	received := filepath.Join(t.TempDir(), "summary.json")
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--on-complete-cmd", "cat > '"+received+"'; echo notified")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	// The command's output must not mix with env-sync's stdout.
+	if stdout != "" || !strings.Contains(stderr, "notified") {
+		t.Errorf("stdout = %q; stderr:\n%s", stdout, stderr)
+	}
+	data, err := os.ReadFile(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary runSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("%v:\n%s", err, data)
+	}
+	if summary.SourceProject != "g/src" || summary.TargetProject != "g/dst" || summary.Created != 1 || summary.Unchanged != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestOnCompleteAffectsExit(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = nil
+	args := f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--on-complete-cmd", "echo notify failed >&2; exit 3")
+
+	_, stderr, code := runMain(t, "", args...)
+	if code != 0 {
+		t.Errorf("exit code %d, want 0 without --on-complete-affects-exit", code)
+	}
+	if !strings.Contains(stderr, "notify failed") {
+		t.Errorf("the command's stderr was lost:\n%s", stderr)
+	}
+	if _, _, code := runMain(t, "", append(args, "--on-complete-affects-exit")...); code != exitFailure {
+		t.Errorf("exit code %d, want %d with --on-complete-affects-exit", code, exitFailure)
+	}
+}
+
+// The pre-sync command runs before the first request, the post-sync
+// command after the sync with the counts and the summary, and both before
+// --on-complete-cmd.
+func TestSyncHooks(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("B", "2", "")}
+	dir := t.TempDir()
+	pre, post, order := filepath.Join(dir, "pre.env"), filepath.Join(dir, "post.env"), filepath.Join(dir, "order")
+	var mu sync.Mutex
+	preRanFirst := true
+	f.intercept = func(r fakeRequest) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := os.Stat(pre); err != nil {
+			preRanFirst = false
+		}
+		return 0, ""
+	}
+
+	args := f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--run-id", "run-7",
+		"--pre-sync-cmd", "env | grep ^ENV_SYNC_ > '"+pre+"'; echo pre >> '"+order+"'",
+		"--post-sync-cmd", "{ env | grep ^ENV_SYNC_; cat; } > '"+post+"'; echo post >> '"+order+"'",
+		"--on-complete-cmd", "echo complete >> '"+order+"'")
+	stdout, stderr, code := runMain(t, "", args...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if stdout != "" {
+		t.Errorf("stdout = %q, want hook output on stderr", stdout)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !preRanFirst {
+		t.Error("a request was sent before --pre-sync-cmd ran")
+	}
+	if got := readTestFile(t, order); got != "pre\npost\ncomplete\n" {
+		t.Errorf("hooks ran in the order %q", got)
+	}
+	preEnv := readTestFile(t, pre)
+	for _, want := range []string{"ENV_SYNC_RUN_ID=run-7\n", "ENV_SYNC_SOURCE=g/src\n", "ENV_SYNC_TARGET=g/dst\n", "ENV_SYNC_DRY_RUN=false\n"} {
+		if !strings.Contains(preEnv, want) {
+			t.Errorf("pre-sync environment has no %q:\n%s", want, preEnv)
+		}
+	}
+	if strings.Contains(preEnv, "ENV_SYNC_CREATED") {
+		t.Errorf("pre-sync environment has counts:\n%s", preEnv)
+	}
+	postEnv := readTestFile(t, post)
+	for _, want := range []string{"ENV_SYNC_TOTAL=2\n", "ENV_SYNC_CREATED=1\n", "ENV_SYNC_UNCHANGED=1\n", "ENV_SYNC_FAILED=0\n"} {
+		if !strings.Contains(postEnv, want) {
+			t.Errorf("post-sync environment has no %q:\n%s", want, postEnv)
+		}
+	}
+	if !strings.Contains(postEnv, `"created":1`) {
+		t.Errorf("post-sync command got no JSON summary on stdin:\n%s", postEnv)
+	}
+}
+
+// A failing pre-sync command stops the run before anything is fetched; a
+// failing post-sync command is only a warning.
+func TestSyncHookFailures(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = nil
+	post := filepath.Join(t.TempDir(), "post")
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--pre-sync-cmd", "exit 2", "--post-sync-cmd", "touch '"+post+"'")...)
+	if code != 1 || !strings.Contains(stderr, "--pre-sync-cmd failed") {
+		t.Errorf("exit code %d, want 1; stderr:\n%s", code, stderr)
+	}
+	if got := f.received(); len(got) != 0 {
+		t.Errorf("requests after a failed pre-sync command: %v", got)
+	}
+	if _, err := os.Stat(post); err == nil {
+		t.Error("--post-sync-cmd ran after the pre-sync command failed")
+	}
+
+	_, stderr, code = runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--post-sync-cmd", "exit 2")...)
+	if code != 0 || !strings.Contains(stderr, "--post-sync-cmd failed") {
+		t.Errorf("exit code %d, want 0 with a warning; stderr:\n%s", code, stderr)
+	}
+	if len(f.vars("g/dst")) != 1 {
+		t.Errorf("target = %v, want A transferred", f.vars("g/dst"))
+	}
+}
+
+func readTestFile(t *testing.T, filename string) string {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,77 @@
+package envsync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Default permissions for files the tool writes.
+const (
+	secretFileMode   os.FileMode = 0600
+	redactedFileMode os.FileMode = 0644
+)
+
+// fileModeFlag is an octal permission flag such as 0640.
+type fileModeFlag struct {
+	mode os.FileMode
+	set  bool
+}
+
+func (f *fileModeFlag) String() string {
+	if f == nil || !f.set {
+		return ""
+	}
+	return fmt.Sprintf("%#o", f.mode)
+}
+
+func (f *fileModeFlag) Set(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("invalid file mode %q, expected octal permissions like 0600", value)
+	}
+	f.mode = os.FileMode(mode)
+	f.set = true
+	return nil
+}
+
+// modeFor returns the permissions for an output file: the --file-mode
+// override if given, otherwise 0600 for files with plaintext values and 0644
+// for the rest.
+func (f *fileModeFlag) modeFor(secret bool) os.FileMode {
+	switch {
+	case f.set:
+		return f.mode
+	case secret:
+		return secretFileMode
+	default:
+		return redactedFileMode
+	}
+}
+
+// writeOutputFile writes data to filename with exactly the given mode,
+// creating parent directories as needed.
+func writeOutputFile(filename string, data []byte, mode os.FileMode) error {
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(filename, data, mode); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file, and the umask may have
+	// narrowed a new one.
+	return os.Chmod(filename, mode)
+}
+
+// writeFileAtomic replaces filename with data via a temporary file, so
+// readers never see a partially written file.
+func writeFileAtomic(filename string, data []byte, mode os.FileMode) error {
+	tmp := filename + ".tmp"
+	if err := writeOutputFile(tmp, data, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
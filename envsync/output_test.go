@@ -0,0 +1,72 @@
+package envsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileModeFlag(t *testing.T) {
+	var f fileModeFlag
+	if f.modeFor(true) != 0o600 || f.modeFor(false) != 0o644 {
+		t.Errorf("default modes = %v, %v, want 0600 and 0644", f.modeFor(true), f.modeFor(false))
+	}
+	if err := f.Set("0640"); err != nil {
+		t.Fatal(err)
+	}
+	if f.modeFor(true) != 0o640 || f.modeFor(false) != 0o640 || f.String() != "0640" {
+		t.Errorf("after --file-mode 0640: %v, %v, %q", f.modeFor(true), f.modeFor(false), f.String())
+	}
+	for _, value := range []string{"rw-------", "0999", "1777"} {
+		if err := f.Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded", value)
+		}
+	}
+}
+
+func TestWriteOutputFileMode(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "nested", "dir", "out.json")
+	if err := writeOutputFile(filename, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// An existing file gets the new mode too.
+	if err := writeOutputFile(filename, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestDryRunFileModes(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = nil
+	dir := t.TempDir()
+
+	for _, test := range []struct {
+		flags []string
+		want  os.FileMode
+	}{
+		{nil, 0o600},
+		{[]string{"--file-mode", "0640"}, 0o640},
+	} {
+		plan := filepath.Join(dir, "plans", "plan.json")
+		os.Remove(plan)
+		args := f.args(append([]string{"--source", "g/src", "--target", "g/dst", "--dry-run", "--output", plan}, test.flags...)...)
+		if _, stderr, code := runMain(t, "", args...); code != 0 {
+			t.Fatalf("%v: exit code %d; stderr:\n%s", test.flags, code, stderr)
+		}
+		info, err := os.Stat(plan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != test.want {
+			t.Errorf("%v: plan mode = %v, want %v", test.flags, info.Mode().Perm(), test.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package envsync
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// overlayFlag collects repeated --overlay flags, in order.
+type overlayFlag struct {
+	sources []string
+}
+
+func (f *overlayFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.sources, ",")
+}
+
+func (f *overlayFlag) Set(value string) error {
+	if value == "" {
+		return fmt.Errorf("empty overlay")
+	}
+	f.sources = append(f.sources, value)
+	return nil
+}
+
+// readOverlay reads an overlay: a .env or .csv file if one exists at source,
+// the variables of the project with that path otherwise.
+func readOverlay(client *GitLabClient, source string, csvColumnsSpec string) ([]EnvVar, error) {
+	if _, err := os.Stat(source); err == nil {
+		log.Printf("Reading overlay file: %s", source)
+		if strings.EqualFold(filepath.Ext(source), ".csv") {
+			columns, err := parseCSVColumns(csvColumnsSpec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --csv-columns: %v", err)
+			}
+			return readCSV(source, columns)
+		}
+		return readDotEnv(source)
+	}
+
+	log.Printf("Fetching variables from overlay project: %s", source)
+	return client.GetVariables(source)
+}
+
+// withOrigin records origin as the source of every variable.
+func withOrigin(variables []EnvVar, origin string) []EnvVar {
+	result := make([]EnvVar, len(variables))
+	for i, v := range variables {
+		v.origin = origin
+		result[i] = v
+	}
+	return result
+}
+
+// mergeOverlay lays overlay over base: a variable with the same key and
+// scope replaces the base one in place, any other is appended.
+func mergeOverlay(base, overlay []EnvVar, explain bool) ([]EnvVar, int) {
+	result := append([]EnvVar(nil), base...)
+	index := make(map[variableKey]int, len(base))
+	for i, v := range result {
+		index[keyOf(v)] = i
+	}
+
+	overridden := 0
+	for _, v := range overlay {
+		if i, ok := index[keyOf(v)]; ok {
+			if explain {
+				log.Printf("explain: %s: %s overrides %s", keyOf(v), v.origin, result[i].origin)
+			}
+			result[i] = v
+			overridden++
+			continue
+		}
+		index[keyOf(v)] = len(result)
+		result = append(result, v)
+	}
+	return result, overridden
+}
+
+// provenance maps each variable's KEY@scope to the source it came from. It
+// returns nil when the variables were not merged from overlays.
+func provenance(variables []EnvVar) map[string]string {
+	var origins map[string]string
+	for _, v := range variables {
+		if v.origin == "" {
+			continue
+		}
+		if origins == nil {
+			origins = make(map[string]string, len(variables))
+		}
+		origins[keyOf(v).String()] = v.origin
+	}
+	return origins
+}
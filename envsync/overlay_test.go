@@ -0,0 +1,97 @@
+package envsync
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeOverlayProvenance(t *testing.T) {
+	base := withOrigin([]EnvVar{envVar("A", "base", ""), envVar("B", "base", ""), envVar("C", "base", "")}, "g/base")
+	team := withOrigin([]EnvVar{envVar("B", "team", ""), envVar("C", "team", ""), envVar("C", "team", "production")}, "g/team")
+	local := withOrigin([]EnvVar{envVar("C", "local", ""), envVar("D", "local", "")}, "local.env")
+
+	merged, overridden := mergeOverlay(base, team, false)
+	if overridden != 2 {
+		t.Errorf("team overrode %d variables, want 2", overridden)
+	}
+	merged, overridden = mergeOverlay(merged, local, false)
+	if overridden != 1 {
+		t.Errorf("local overrode %d variables, want 1", overridden)
+	}
+
+	var values []string
+	for _, v := range merged {
+		values = append(values, keyOf(v).String()+"="+v.Value)
+	}
+	wantValues := []string{"A@*=base", "B@*=team", "C@*=local", "C@production=team", "D@*=local"}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("merged = %v, want %v", values, wantValues)
+	}
+	want := map[string]string{
+		"A@*":          "g/base",
+		"B@*":          "g/team",
+		"C@*":          "local.env",
+		"C@production": "g/team",
+		"D@*":          "local.env",
+	}
+	if got := provenance(merged); !reflect.DeepEqual(got, want) {
+		t.Errorf("provenance = %v, want %v", got, want)
+	}
+	if got := provenance([]EnvVar{envVar("A", "1", "")}); got != nil {
+		t.Errorf("provenance without overlays = %v, want nil", got)
+	}
+}
+
+// The dry-run plan and the run summary name the source of each winning
+// variable of a project and two overlays.
+func TestOverlayProvenanceCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/base"] = []EnvVar{envVar("A", "base", ""), envVar("B", "base", ""), envVar("C", "base", "")}
+	f.projects["g/team"] = []EnvVar{envVar("B", "team", ""), envVar("C", "team", "")}
+	f.projects["g/dst"] = nil
+	local := writeFile(t, "local.env", "C=local\n")
+	want := map[string]string{"A@*": "g/base", "B@*": "g/team", "C@*": local}
+	args := f.args("--source", "g/base", "--overlay", "g/team", "--overlay", local, "--target", "g/dst")
+
+	plan := filepath.Join(t.TempDir(), "plan.json")
+	if _, stderr, code := runMain(t, "", append(args, "--dry-run", "--output", plan)...); code != 0 {
+		t.Fatalf("dry run: exit code %d; stderr:\n%s", code, stderr)
+	}
+	data, err := os.ReadFile(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output dryRunOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(output.Provenance, want) {
+		t.Errorf("plan provenance = %v, want %v", output.Provenance, want)
+	}
+
+	summaries := make(chan runSummary, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var s runSummary
+		json.Unmarshal(body, &s)
+		summaries <- s
+	}))
+	defer hook.Close()
+	if _, stderr, code := runMain(t, "", append(args, "--summary-webhook", hook.URL)...); code != 0 {
+		t.Fatalf("live run: exit code %d; stderr:\n%s", code, stderr)
+	}
+	select {
+	case s := <-summaries:
+		if !reflect.DeepEqual(s.Provenance, want) {
+			t.Errorf("summary provenance = %v, want %v", s.Provenance, want)
+		}
+	default:
+		t.Error("no summary was posted")
+	}
+}
@@ -0,0 +1,135 @@
+package envsync
+
+import (
+	"fmt"
+	"io"
+)
+
+// planCounts tallies what a live run would do.
+type planCounts struct {
+	Create    int
+	Update    int
+	Delete    int
+	Unchanged int
+	Skip      int
+
+	// Replace counts the updates made by --replace, a delete and a create
+	// each; they are included in Update.
+	Replace int
+}
+
+func countPlan(decisions []decision, prune []EnvVar) planCounts {
+	var c planCounts
+	for _, d := range decisions {
+		switch d.Action {
+		case actionCreate:
+			c.Create++
+		case actionUpdate, actionUpdateAttributes:
+			c.Update++
+		case actionReplace:
+			c.Update++
+			c.Replace++
+		case actionUnchanged:
+			c.Unchanged++
+		case actionSkip:
+			c.Skip++
+		}
+	}
+	c.Delete = len(prune)
+	return c
+}
+
+// writeCounts prints the counts of a --quiet-dry-run as one line, prefixed
+// with the target in multi-target runs.
+func writeCounts(w io.Writer, target string, c planCounts) {
+	if target != "" {
+		fmt.Fprintf(w, "%s: ", target)
+	}
+	fmt.Fprintf(w, "create=%d update=%d delete=%d unchanged=%d skip=%d\n", c.Create, c.Update, c.Delete, c.Unchanged, c.Skip)
+}
+
+// pageSize is the per_page used for every list request.
+const pageSize = 100
+
+// apiCallEstimate approximates the requests a live run of a plan would make.
+// Retries, such as --on-mask-failure unmask, are not included.
+type apiCallEstimate struct {
+	Reads   int `json:"reads"`
+	Creates int `json:"creates"`
+	Updates int `json:"updates"`
+	Deletes int `json:"deletes"`
+	Total   int `json:"total"`
+}
+
+// runReads are the reads of a live run that do not depend on what it
+// writes.
+type runReads struct {
+	// Target is the number of list pages of the target's variables, 0 when
+	// they are not read.
+	Target int
+	// Checks are the reads of --group-variables-inheritance and --check-fork.
+	Checks int
+	// LookupID says whether the target's project ID is still to be looked up
+	// by its first request; a --check-fork run before it already has it.
+	LookupID bool
+}
+
+// estimateAPICalls counts one request per write, one read per create and
+// update checked by --verify-before-write, and the reads in r. The ID lookup
+// is only counted if some request needs it.
+func estimateAPICalls(c planCounts, r runReads, verify bool) *apiCallEstimate {
+	e := &apiCallEstimate{Creates: c.Create + c.Replace, Updates: c.Update - c.Replace, Deletes: c.Delete + c.Replace}
+	e.Reads = r.Target + r.Checks
+	if verify {
+		e.Reads += c.Create + c.Update
+	}
+	if r.LookupID && (r.Target > 0 || c.Create+c.Update+c.Delete > 0) {
+		e.Reads++
+	}
+	e.Total = e.Reads + e.Creates + e.Updates + e.Deletes
+	return e
+}
+
+// pages is the number of list requests needed for n items; an empty list
+// still takes one.
+func pages(n int) int {
+	if n == 0 {
+		return 1
+	}
+	return (n + pageSize - 1) / pageSize
+}
+
+func (e *apiCallEstimate) String() string {
+	return fmt.Sprintf("%d (%d reads, %d creates, %d updates, %d deletes)", e.Total, e.Reads, e.Creates, e.Updates, e.Deletes)
+}
+
+// planPart is one file of a --split-plan dry run.
+type planPart struct {
+	name      string
+	decisions []decision
+	prune     []EnvVar
+}
+
+// splitPlan divides a plan by action into creates, updates and deletes.
+// Unchanged and skipped variables are in none of them.
+func splitPlan(decisions []decision, prune []EnvVar) []planPart {
+	creates := planPart{name: "creates"}
+	updates := planPart{name: "updates"}
+	for _, d := range decisions {
+		switch d.Action {
+		case actionCreate:
+			creates.decisions = append(creates.decisions, d)
+		case actionUpdate, actionUpdateAttributes, actionReplace:
+			updates.decisions = append(updates.decisions, d)
+		}
+	}
+	return []planPart{creates, updates, {name: "deletes", prune: prune}}
+}
+
+func variablesOf(decisions []decision) []EnvVar {
+	variables := make([]EnvVar, len(decisions))
+	for i, d := range decisions {
+		variables[i] = d.Variable
+	}
+	return variables
+}
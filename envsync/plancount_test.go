@@ -0,0 +1,240 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCountPlan(t *testing.T) {
+	decisions := []decision{
+		{envVar("A", "", ""), actionCreate, ""},
+		{envVar("B", "", ""), actionUpdate, ""},
+		{envVar("C", "", ""), actionUpdateAttributes, ""},
+		{envVar("D", "", ""), actionReplace, ""},
+		{envVar("E", "", ""), actionUnchanged, ""},
+		{envVar("F", "", ""), actionSkip, ""},
+	}
+	got := countPlan(decisions, []EnvVar{envVar("G", "", "")})
+	want := planCounts{Create: 1, Update: 3, Delete: 1, Unchanged: 1, Skip: 1, Replace: 1}
+	if got != want {
+		t.Errorf("countPlan = %+v, want %+v", got, want)
+	}
+}
+
+// --quiet-dry-run prints one line of counts, writes nothing and exits 2
+// while changes are pending.
+func TestQuietDryRun(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("NEW", "1", ""), envVar("CHANGED", "new", ""), envVar("SAME", "1", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("CHANGED", "old", ""), envVar("SAME", "1", ""), envVar("STALE", "1", "")}
+	plan := filepath.Join(t.TempDir(), "plan.json")
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--quiet-dry-run", "--prune", "--output", plan)...)
+	if code != exitMismatch {
+		t.Errorf("exit code %d, want %d; stderr:\n%s", code, exitMismatch, stderr)
+	}
+	if want := "create=1 update=1 delete=1 unchanged=1 skip=0\n"; stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+	if _, err := os.Stat(plan); !os.IsNotExist(err) {
+		t.Errorf("a plan file was written: %v", err)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v", writes)
+	}
+
+	f.projects["g/dst"] = f.vars("g/src")
+	stdout, stderr, code = runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--quiet-dry-run")...)
+	if code != 0 || stdout != "create=0 update=0 delete=0 unchanged=3 skip=0\n" {
+		t.Errorf("in sync: exit code %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+}
+
+func TestQuietDryRunPerProject(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["grp/app1"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["grp/app2"] = nil
+	for _, p := range []Project{{ID: 1, PathWithNamespace: "grp/app1"}, {ID: 2, PathWithNamespace: "grp/app2"}} {
+		f.groupProjects["grp"] = append(f.groupProjects["grp"], p)
+		f.info[p.PathWithNamespace] = p
+	}
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target-group", "grp", "--quiet-dry-run", "--yes")...)
+	if code != exitMismatch {
+		t.Errorf("exit code %d, want %d; stderr:\n%s", code, exitMismatch, stderr)
+	}
+	want := "grp/app1: create=0 update=0 delete=0 unchanged=1 skip=0\ngrp/app2: create=1 update=0 delete=0 unchanged=0 skip=0\n"
+	if stdout != want {
+		t.Errorf("stdout =\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestEstimateAPICalls(t *testing.T) {
+	c := planCounts{Create: 2, Update: 3, Replace: 1, Delete: 1}
+	got := estimateAPICalls(c, runReads{Target: pages(101), Checks: 2, LookupID: true}, false)
+	want := apiCallEstimate{Reads: 5, Creates: 3, Updates: 2, Deletes: 2, Total: 12}
+	if *got != want {
+		t.Errorf("estimate = %+v, want %+v", *got, want)
+	}
+	if got := estimateAPICalls(c, runReads{Target: pages(101), Checks: 2, LookupID: true}, true); got.Reads != 10 {
+		t.Errorf("estimate with verifies = %+v, want 10 reads", *got)
+	}
+	if got := estimateAPICalls(planCounts{Create: 1}, runReads{LookupID: true}, false); got.Total != 2 || got.Reads != 1 {
+		t.Errorf("estimate without reading the target = %+v", *got)
+	}
+	if got := estimateAPICalls(planCounts{Create: 1}, runReads{Checks: 1}, false); got.Reads != 1 {
+		t.Errorf("estimate with the ID known = %+v", *got)
+	}
+	if got := estimateAPICalls(planCounts{Unchanged: 1}, runReads{LookupID: true}, false); got.Total != 0 {
+		t.Errorf("estimate without requests = %+v", *got)
+	}
+}
+
+// The estimate in a dry-run plan matches the requests the live run makes.
+func TestEstimateMatchesLiveRun(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		args  []string
+		reads int
+	}{
+		// Two list pages and the ID lookup.
+		{"compare", nil, 3},
+		// One more read before each of the 10 creates and 10 updates.
+		{"verify", []string{"--verify-before-write"}, 23},
+		// The project and one page of its upstream's variables.
+		{"fork", []string{"--check-fork"}, 5},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeGitLab(t)
+			var source, target []EnvVar
+			for i := 0; i < 150; i++ {
+				key := fmt.Sprintf("VAR_%03d", i)
+				source = append(source, envVar(key, "1", ""))
+				switch {
+				case i < 10:
+					target = append(target, envVar(key, "old", ""))
+				case i < 140:
+					target = append(target, envVar(key, "1", ""))
+				}
+			}
+			target = append(target, envVar("STALE", "1", ""))
+			f.projects["g/src"] = source
+			f.projects["g/dst"] = target
+			f.projects["g/up"] = []EnvVar{envVar("VAR_000", "1", "")}
+			f.info["g/dst"] = Project{ID: 5, PathWithNamespace: "g/dst", ForkedFromProject: &Project{ID: 6, PathWithNamespace: "g/up"}}
+			f.info["g/up"] = Project{ID: 6, PathWithNamespace: "g/up"}
+			args := append([]string{"--source", "g/src", "--target", "g/dst", "--upsert", "--prune", "--yes"}, tt.args...)
+
+			plan := filepath.Join(t.TempDir(), "plan.json")
+			if _, stderr, code := runMain(t, "", f.args(append(args, "--dry-run", "--output", plan)...)...); code != 0 {
+				t.Fatalf("dry run: exit code %d; stderr:\n%s", code, stderr)
+			}
+			data, err := os.ReadFile(plan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var output dryRunOutput
+			if err := json.Unmarshal(data, &output); err != nil {
+				t.Fatal(err)
+			}
+			estimate := output.EstimatedAPICalls
+			if estimate == nil {
+				t.Fatalf("plan has no estimate:\n%s", data)
+			}
+
+			f.mu.Lock()
+			f.requests = nil
+			f.mu.Unlock()
+			if _, stderr, code := runMain(t, "", f.args(args...)...); code != 0 {
+				t.Fatalf("live run: exit code %d; stderr:\n%s", code, stderr)
+			}
+			actual := apiCallEstimate{
+				Creates: len(f.received(http.MethodPost)),
+				Updates: len(f.received(http.MethodPut)),
+				Deletes: len(f.received(http.MethodDelete)),
+			}
+			// Reading the source is not part of applying the plan.
+			for _, r := range f.received(http.MethodGet) {
+				if !strings.HasPrefix(r.Path, "projects/g%2Fsrc") {
+					actual.Reads++
+				}
+			}
+			actual.Total = actual.Reads + actual.Creates + actual.Updates + actual.Deletes
+			want := apiCallEstimate{Reads: tt.reads, Creates: 10, Updates: 10, Deletes: 1}
+			want.Total = want.Reads + 21
+			if *estimate != actual || actual != want {
+				t.Errorf("estimate %+v, live run made %+v, want %+v", *estimate, actual, want)
+			}
+		})
+	}
+}
+
+func TestSplitPlan(t *testing.T) {
+	decisions := []decision{
+		{envVar("A", "", ""), actionCreate, ""},
+		{envVar("B", "", ""), actionUpdate, ""},
+		{envVar("C", "", ""), actionUpdateAttributes, ""},
+		{envVar("D", "", ""), actionReplace, ""},
+		{envVar("E", "", ""), actionUnchanged, ""},
+		{envVar("F", "", ""), actionSkip, ""},
+	}
+	parts := splitPlan(decisions, []EnvVar{envVar("G", "", "")})
+	got := map[string]string{}
+	for _, part := range parts {
+		var keys []string
+		for _, v := range append(variablesOf(part.decisions), part.prune...) {
+			keys = append(keys, v.Key)
+		}
+		got[part.name] = strings.Join(keys, ",")
+	}
+	want := map[string]string{"creates": "A", "updates": "B,C,D", "deletes": "G"}
+	if len(parts) != 3 || !reflect.DeepEqual(got, want) {
+		t.Errorf("parts = %v, want %v", got, want)
+	}
+}
+
+// Each --split-plan file holds only its action's variables.
+func TestSplitPlanCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("NEW", "1", ""), envVar("CHANGED", "new", ""), envVar("SAME", "1", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("CHANGED", "old", ""), envVar("SAME", "1", ""), envVar("STALE", "1", "staging")}
+	prefix := filepath.Join(t.TempDir(), "plan-")
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--prune", "--dry-run", "--split-plan", prefix)...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	for name, want := range map[string]string{"creates": "NEW@*", "updates": "CHANGED@*", "deletes": "STALE@staging"} {
+		data, err := os.ReadFile(prefix + name + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output dryRunOutput
+		if err := json.Unmarshal(data, &output); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, v := range output.Variables {
+			got = append(got, keyOf(v).String())
+		}
+		for _, ref := range output.Prune {
+			got = append(got, ref.Key+"@"+ref.EnvironmentScope)
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%s: %v, want %s", name, got, want)
+		}
+		if output.EstimatedAPICalls == nil || output.EstimatedAPICalls.Total != 3 {
+			t.Errorf("%s: estimate %+v, want the list, the ID lookup and one write", name, output.EstimatedAPICalls)
+		}
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v", writes)
+	}
+}
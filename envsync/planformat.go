@@ -0,0 +1,147 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Formats accepted by --dry-run-format. Only json plans can be passed to
+// --apply; the others are for review.
+const (
+	planFormatJSON  = "json"
+	planFormatYAML  = "yaml"
+	planFormatTable = "table"
+	planFormatEnv   = "env"
+)
+
+func validPlanFormat(format string) bool {
+	switch format {
+	case planFormatJSON, planFormatYAML, planFormatTable, planFormatEnv:
+		return true
+	}
+	return false
+}
+
+// planExtension is the file extension of a plan in format.
+func planExtension(format string) string {
+	switch format {
+	case planFormatYAML:
+		return ".yaml"
+	case planFormatTable:
+		return ".txt"
+	case planFormatEnv:
+		return ".env"
+	}
+	return ".json"
+}
+
+// writePlan renders a dry-run plan. Values are written as they appear in the
+// plan, so tokenized plans stay tokenized in every format. The review formats
+// redact secret values the way the gitlab-ci export does, unless showValues
+// is set; json keeps them, since --apply reads them back.
+func writePlan(w io.Writer, format string, plan *dryRunOutput, showValues bool) error {
+	if format != planFormatJSON && !showValues {
+		redacted := *plan
+		redacted.Variables = redactSecrets(plan.Variables)
+		plan = &redacted
+	}
+	switch format {
+	case planFormatJSON:
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case planFormatYAML:
+		return writePlanYAML(w, plan)
+	case planFormatTable:
+		return writePlanTable(w, plan)
+	case planFormatEnv:
+		return writePlanEnv(w, plan)
+	default:
+		return fmt.Errorf("unknown dry-run format %q (use json, yaml, table or env)", format)
+	}
+}
+
+func writePlanYAML(w io.Writer, plan *dryRunOutput) error {
+	fmt.Fprintf(w, "timestamp: %s\n", yamlString(plan.Timestamp))
+	fmt.Fprintf(w, "source_project: %s\n", yamlString(plan.SourceProject))
+	fmt.Fprintf(w, "target_project: %s\n", yamlString(plan.TargetProject))
+	if len(plan.Variables) == 0 {
+		fmt.Fprintln(w, "variables: []")
+	} else {
+		fmt.Fprintln(w, "variables:")
+		writeVariablesYAML(w, "  ", plan.Variables)
+	}
+	if len(plan.Prune) > 0 {
+		fmt.Fprintln(w, "prune:")
+		for _, ref := range plan.Prune {
+			fmt.Fprintf(w, "  - key: %s\n", yamlString(ref.Key))
+			fmt.Fprintf(w, "    environment_scope: %s\n", yamlString(ref.EnvironmentScope))
+		}
+	}
+	if plan.TargetStateHash != "" {
+		fmt.Fprintf(w, "target_state_hash: %s\n", yamlString(plan.TargetStateHash))
+	}
+	if e := plan.EstimatedAPICalls; e != nil {
+		fmt.Fprintln(w, "estimated_api_calls:")
+		fmt.Fprintf(w, "  reads: %d\n  creates: %d\n  updates: %d\n  deletes: %d\n  total: %d\n", e.Reads, e.Creates, e.Updates, e.Deletes, e.Total)
+	}
+	if len(plan.Provenance) > 0 {
+		fmt.Fprintln(w, "provenance:")
+		refs := make([]string, 0, len(plan.Provenance))
+		for ref := range plan.Provenance {
+			refs = append(refs, ref)
+		}
+		sort.Strings(refs)
+		for _, ref := range refs {
+			fmt.Fprintf(w, "  %s: %s\n", yamlString(ref), yamlString(plan.Provenance[ref]))
+		}
+	}
+	return nil
+}
+
+// maxTableValue is the longest value shown in full in the table format.
+const maxTableValue = 40
+
+func writePlanTable(w io.Writer, plan *dryRunOutput) error {
+	fmt.Fprintf(w, "Plan: %s -> %s (%s)\n\n", plan.SourceProject, plan.TargetProject, plan.Timestamp)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSCOPE\tTYPE\tPROTECTED\tMASKED\tVALUE")
+	for _, v := range plan.Variables {
+		value := []rune(fmt.Sprintf("%q", v.Value))
+		if len(value) > maxTableValue {
+			value = append(value[:maxTableValue-3], []rune("...")...)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%t\t%s\n", v.Key, normalizeScope(v.EnvironmentScope), v.VariableType, v.Protected, v.Masked, string(value))
+	}
+	for _, ref := range plan.Prune {
+		fmt.Fprintf(tw, "%s\t%s\t(prune)\t\t\t\n", ref.Key, ref.EnvironmentScope)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if plan.EstimatedAPICalls != nil {
+		fmt.Fprintf(w, "\nEstimated API calls: %s\n", plan.EstimatedAPICalls)
+	}
+	return nil
+}
+
+func writePlanEnv(w io.Writer, plan *dryRunOutput) error {
+	fmt.Fprintf(w, "# Plan: %s -> %s (%s)\n", plan.SourceProject, plan.TargetProject, plan.Timestamp)
+	if err := writeDotEnv(w, plan.Variables); err != nil {
+		return err
+	}
+	for _, ref := range plan.Prune {
+		fmt.Fprintf(w, "# prune: %s@%s\n", ref.Key, ref.EnvironmentScope)
+	}
+	if plan.EstimatedAPICalls != nil {
+		fmt.Fprintf(w, "# estimated API calls: %s\n", plan.EstimatedAPICalls)
+	}
+	return nil
+}
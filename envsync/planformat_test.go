@@ -0,0 +1,79 @@
+package envsync
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// goldenPlan exercises every section of a plan.
+func goldenPlan() *dryRunOutput {
+	secret := EnvVar{Key: "TOKEN", Value: "s3cr3t", VariableType: "env_var", EnvironmentScope: "production", Protected: true, Masked: true}
+	cert := EnvVar{Key: "CERT", Value: "-----BEGIN CERTIFICATE-----\nMIIB...\n-----END CERTIFICATE-----", VariableType: "file", EnvironmentScope: "*", Raw: true}
+	return &dryRunOutput{
+		Timestamp:         "2026-01-02T03:04:05Z",
+		SourceProject:     "g/src",
+		TargetProject:     "g/dst",
+		Variables:         []EnvVar{secret, cert, {Key: "EMPTY", VariableType: "env_var", EnvironmentScope: "*", Description: "left blank"}},
+		Prune:             []variableRef{{Key: "OLD", EnvironmentScope: "staging"}},
+		TargetStateHash:   "abc123",
+		EstimatedAPICalls: &apiCallEstimate{Reads: 1, Creates: 2, Updates: 1, Deletes: 1, Total: 5},
+		Provenance:        map[string]string{"TOKEN@production": "g/src", "CERT@*": "overlay.env"},
+	}
+}
+
+func TestWritePlanGolden(t *testing.T) {
+	for _, format := range []string{planFormatJSON, planFormatYAML, planFormatTable, planFormatEnv} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writePlan(&buf, format, goldenPlan(), false); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, "plan"+planExtension(format), buf.Bytes())
+		})
+	}
+}
+
+func TestWritePlanUnknownFormat(t *testing.T) {
+	if err := writePlan(&bytes.Buffer{}, "xml", goldenPlan(), false); err == nil || validPlanFormat("xml") {
+		t.Error("xml was accepted")
+	}
+}
+
+// The env format is a .env file the import reads back; with --show-values
+// it holds every value.
+func TestPlanEnvFormatImports(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writePlan(&buf, planFormatEnv, goldenPlan(), true); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readDotEnv(writeFile(t, "plan.env", buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range goldenPlan().Variables {
+		if read[i] != v {
+			t.Errorf("variable %d = %+v, want %+v", i, read[i], v)
+		}
+	}
+}
+
+// The review formats leave secret values out, like the gitlab-ci export;
+// the json plan keeps them for --apply.
+func TestWritePlanRedactsSecrets(t *testing.T) {
+	for _, format := range []string{planFormatJSON, planFormatYAML, planFormatTable, planFormatEnv} {
+		for _, showValues := range []bool{false, true} {
+			var buf bytes.Buffer
+			if err := writePlan(&buf, format, goldenPlan(), showValues); err != nil {
+				t.Fatal(err)
+			}
+			shown := strings.Contains(buf.String(), "s3cr3t")
+			if want := showValues || format == planFormatJSON; shown != want {
+				t.Errorf("%s plan with showValues %t: masked value shown = %t, want %t:\n%s", format, showValues, shown, want, buf.String())
+			}
+			if !strings.Contains(buf.String(), "BEGIN CERTIFICATE") {
+				t.Errorf("%s plan with showValues %t lost a plain value:\n%s", format, showValues, buf.String())
+			}
+		}
+	}
+}
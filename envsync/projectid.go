@@ -0,0 +1,51 @@
+package envsync
+
+import (
+	"strconv"
+	"sync"
+)
+
+// projectIDs caches the URL segment used for each project path: its numeric
+// ID once resolved, or the encoded path if it could not be. It is shared by
+// the copies WithContext makes.
+type projectIDs struct {
+	mu       sync.Mutex
+	segments map[string]string
+}
+
+// projectSegment returns the :id segment for projectPath, resolving the
+// path to the project's numeric ID with one GET /projects/:path the first
+// time. If that fails, e.g. on a GitLab-compatible API without the
+// endpoint, the encoded path is used from then on.
+func (c *GitLabClient) projectSegment(projectPath string) string {
+	if c.projects == nil {
+		return pathSegment(projectPath)
+	}
+	c.projects.mu.Lock()
+	segment, ok := c.projects.segments[projectPath]
+	c.projects.mu.Unlock()
+	if ok {
+		return segment
+	}
+
+	segment = pathSegment(projectPath)
+	if project, err := c.GetProject(projectPath); err == nil && project.ID > 0 {
+		return c.rememberProject(projectPath, project.ID)
+	}
+	c.projects.mu.Lock()
+	c.projects.segments[projectPath] = segment
+	c.projects.mu.Unlock()
+	return segment
+}
+
+// rememberProject records the ID of a project read from the API, so no
+// lookup is needed for it later, and returns its segment.
+func (c *GitLabClient) rememberProject(projectPath string, id int) string {
+	segment := strconv.Itoa(id)
+	if c.projects != nil && id > 0 {
+		c.projects.mu.Lock()
+		c.projects.segments[projectPath] = segment
+		c.projects.mu.Unlock()
+	}
+	return segment
+}
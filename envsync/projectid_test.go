@@ -0,0 +1,91 @@
+package envsync
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// lookups counts the GET /projects/:path requests served.
+func lookups(f *fakeGitLab) map[string]int {
+	counts := map[string]int{}
+	for _, r := range f.received() {
+		if strings.HasPrefix(r.Path, "projects/") && strings.Count(r.Path, "/") == 1 {
+			counts[r.Path]++
+		}
+	}
+	return counts
+}
+
+// Each project path is looked up once; every later request of the client,
+// and of its WithContext copies, addresses the project by ID.
+func TestProjectIDResolvedOnce(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.info["g/src"] = Project{ID: 11, PathWithNamespace: "g/src"}
+	f.info["g/dst"] = Project{ID: 22, PathWithNamespace: "g/dst"}
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("A", "0", "")}
+	client := f.client()
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetVariables("g/src"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := client.WithContext(context.Background())
+	if err := other.UpdateVariable("g/dst", envVar("A", "1", "")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetVariable("g/dst", "A", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteVariable("g/dst", envVar("A", "", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := lookups(f); len(got) != 2 || got["projects/g%2Fsrc"] != 1 || got["projects/g%2Fdst"] != 1 {
+		t.Errorf("lookups = %v, want one per project", got)
+	}
+	for _, r := range f.received() {
+		if strings.Contains(r.Path, "/variables") && !strings.HasPrefix(r.Path, "projects/11/") && !strings.HasPrefix(r.Path, "projects/22/") {
+			t.Errorf("%s is not addressed by ID", r)
+		}
+	}
+}
+
+// When the lookup fails the encoded path is used, without looking the
+// project up again.
+func TestProjectIDFallsBackToPath(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = []EnvVar{envVar("A", "1", "")}
+	client := f.client()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetVariables("g/app"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := lookups(f); got["projects/g%2Fapp"] != 1 {
+		t.Errorf("lookups = %v, want one", got)
+	}
+	if reads := f.received(); reads[len(reads)-1].Path != "projects/g%2Fapp/variables" {
+		t.Errorf("last request = %s, want the path", reads[len(reads)-1])
+	}
+}
+
+// Projects listed from a target group are addressed by ID without a lookup.
+func TestGroupProjectsNeedNoLookup(t *testing.T) {
+	f := groupFake(t)
+
+	_, stderr, code := runMain(t, "", f.args("--source", "grp/src", "--target-group", "grp", "--yes")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	got := lookups(f)
+	if got["projects/grp%2Fapp"] != 0 || got["projects/grp%2Fsub%2Fapi"] != 0 {
+		t.Errorf("lookups = %v, want none for the group's projects", got)
+	}
+	if writes := f.writes(); len(writes) != 2 || writes[0] != "POST projects/2/variables" || writes[1] != "POST projects/3/variables" {
+		t.Errorf("writes = %v", writes)
+	}
+}
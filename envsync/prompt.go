@@ -0,0 +1,29 @@
+package envsync
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// confirm asks a yes/no question. Anything but an explicit yes, including
+// end of input, is a no.
+func confirm(reader *bufio.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := readLine(reader)
+	if err != nil {
+		fmt.Fprintln(out)
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,135 @@
+package envsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+)
+
+// variableRef names a variable without its value.
+type variableRef struct {
+	Key              string `json:"key"`
+	EnvironmentScope string `json:"environment_scope"`
+}
+
+func refOf(v EnvVar) variableRef {
+	return variableRef{Key: v.Key, EnvironmentScope: normalizeScope(v.EnvironmentScope)}
+}
+
+// planPrune returns the target variables whose (key, scope) is not present
+// in the source, sorted by key and scope.
+func planPrune(source, target []EnvVar) []EnvVar {
+	inSource := map[variableKey]bool{}
+	for _, v := range source {
+		inSource[keyOf(v)] = true
+	}
+
+	var prune []EnvVar
+	for _, v := range target {
+		if !inSource[keyOf(v)] {
+			prune = append(prune, v)
+		}
+	}
+	sort.Slice(prune, func(i, j int) bool {
+		a, b := keyOf(prune[i]), keyOf(prune[j])
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Scope < b.Scope
+	})
+	return prune
+}
+
+// writePruneList prints the variables that prune would delete.
+func writePruneList(w io.Writer, prune []EnvVar, color bool) {
+	if len(prune) == 0 {
+		fmt.Fprintln(w, "Prune: nothing to delete")
+		return
+	}
+	fmt.Fprintf(w, "Prune: %d variable(s) would be deleted from the target:\n", len(prune))
+	for _, v := range prune {
+		fmt.Fprintln(w, colorize("  - "+keyOf(v).String(), colorRed, color))
+	}
+}
+
+// pruneVariables deletes the given variables from the target project within
+// opts.VariableTimeout each. With opts.StopOnFailure the first failure ends
+// the prune and is returned; otherwise only authentication failures are.
+func pruneVariables(client *GitLabClient, targetProject string, prune []EnvVar, opts transferOptions, report reportFunc) error {
+	timeout := opts.VariableTimeout
+	for _, v := range prune {
+		log.Printf("Deleting variable: %s", keyOf(v))
+		ctx, cancel := variableContext(timeout)
+		err := client.WithContext(ctx).DeleteVariable(targetProject, v)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w after %s: %v", errVariableTimeout, timeout, err)
+		}
+		cancel()
+		if err != nil {
+			if isAuthError(err) {
+				return err
+			}
+			log.Printf("Error deleting variable %s: %v", keyOf(v), err)
+			report(v, outcomeFailed, err)
+			if opts.StopOnFailure {
+				return err
+			}
+			continue
+		}
+		report(v, outcomeDeleted, nil)
+	}
+	return nil
+}
+
+// skipProtected drops the variables whose key matches protect from a prune
+// list.
+func skipProtected(prune []EnvVar, protect *regexp.Regexp) []EnvVar {
+	var result []EnvVar
+	for _, v := range prune {
+		if protect.MatchString(v.Key) {
+			log.Printf("Not deleting %s: protected by --protect-keys", keyOf(v))
+			continue
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+// skipHidden drops hidden variables from the prune list of a transactional
+// run: their values were never readable, so a rollback could not recreate
+// them.
+func skipHidden(prune []EnvVar) []EnvVar {
+	var result []EnvVar
+	for _, v := range prune {
+		if v.Hidden {
+			log.Printf("Not deleting %s: hidden, a --transactional rollback could not restore it", keyOf(v))
+			continue
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+// pruneInBatches deletes prune in batches of size, asking confirm before
+// each one. A declined batch stops the prune; the variables of earlier
+// batches stay deleted.
+func pruneInBatches(prune []EnvVar, size int, deleteBatch func([]EnvVar) error, confirm func(n, total int, batch []EnvVar) bool) error {
+	total := (len(prune) + size - 1) / size
+	for n := 1; len(prune) > 0; n++ {
+		batch := prune[:min(size, len(prune))]
+		if !confirm(n, total, batch) {
+			log.Printf("Prune stopped before batch %d/%d, %d variable(s) not deleted", n, total, len(prune))
+			return nil
+		}
+		log.Printf("Prune batch %d/%d: deleting %d variable(s)", n, total, len(batch))
+		if err := deleteBatch(batch); err != nil {
+			return err
+		}
+		prune = prune[len(batch):]
+	}
+	return nil
+}
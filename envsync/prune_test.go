@@ -0,0 +1,186 @@
+package envsync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanPruneMatchesKeyAndScope(t *testing.T) {
+	source := []EnvVar{envVar("A", "1", ""), envVar("B", "1", "production")}
+	target := []EnvVar{
+		envVar("C", "1", ""),
+		envVar("B", "1", "staging"),
+		envVar("B", "1", "production"),
+		envVar("A", "1", "*"),
+	}
+	got := planPrune(source, target)
+	want := []EnvVar{envVar("B", "1", "staging"), envVar("C", "1", "")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("planPrune = %v, want %v", got, want)
+	}
+}
+
+func TestWritePruneList(t *testing.T) {
+	var buf bytes.Buffer
+	writePruneList(&buf, []EnvVar{envVar("B", "1", "staging"), envVar("C", "1", "")}, false)
+	want := "Prune: 2 variable(s) would be deleted from the target:\n  - B@staging\n  - C@*\n"
+	if buf.String() != want {
+		t.Errorf("prune list:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	buf.Reset()
+	writePruneList(&buf, nil, false)
+	if buf.String() != "Prune: nothing to delete\n" {
+		t.Errorf("empty prune list = %q", buf.String())
+	}
+}
+
+func TestPruneDryRunPreview(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("A", "1", ""), envVar("OLD", "1", "staging")}
+	plan := filepath.Join(t.TempDir(), "plan.json")
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--prune", "--dry-run", "--output", plan)...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Prune: 1 variable(s) would be deleted from the target:\n  - OLD@staging\n") {
+		t.Errorf("stderr lacks the prune list:\n%s", stderr)
+	}
+	data, err := os.ReadFile(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output dryRunOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+	if want := []variableRef{{Key: "OLD", EnvironmentScope: "staging"}}; !reflect.DeepEqual(output.Prune, want) {
+		t.Errorf("plan prune = %v, want %v", output.Prune, want)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("dry run wrote %v", writes)
+	}
+}
+
+func TestPruneRequiresConfirmation(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("OLD", "1", "")}
+
+	_, stderr, code := runMain(t, "n\n", f.args("--source", "g/src", "--target", "g/dst", "--prune")...)
+	if code == 0 {
+		t.Fatalf("declined prune exited 0; stderr:\n%s", stderr)
+	}
+	if !strings.Contains(stderr, "  - OLD@*") || !strings.Contains(stderr, "Delete 1 variable(s) from g/dst?") {
+		t.Errorf("stderr lacks the prune list or prompt:\n%s", stderr)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("declined prune wrote %v", writes)
+	}
+
+	if _, stderr, code := runMain(t, "y\n", f.args("--source", "g/src", "--target", "g/dst", "--prune")...); code != 0 {
+		t.Fatalf("confirmed prune: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if got := f.vars("g/dst"); len(got) != 1 || got[0].Key != "A" {
+		t.Errorf("target = %v, want only A", got)
+	}
+}
+
+// A declined batch stops the prune; the answers come from an injected reader.
+func TestPruneInBatches(t *testing.T) {
+	var prune []EnvVar
+	for i := 0; i < 5; i++ {
+		prune = append(prune, envVar(fmt.Sprintf("OLD_%d", i), "1", ""))
+	}
+	for _, test := range []struct {
+		answers string
+		deleted int
+		asked   int
+	}{
+		{"y\ny\ny\n", 5, 3},
+		{"y\nn\n", 2, 2},
+		{"", 0, 1},
+	} {
+		reader := bufio.NewReader(strings.NewReader(test.answers))
+		var out bytes.Buffer
+		deleted, asked := 0, 0
+		err := pruneInBatches(prune, 2, func(batch []EnvVar) error {
+			deleted += len(batch)
+			return nil
+		}, func(n, total int, batch []EnvVar) bool {
+			asked++
+			return confirm(reader, &out, fmt.Sprintf("Delete batch %d/%d (%d variable(s))?", n, total, len(batch)))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != test.deleted || asked != test.asked {
+			t.Errorf("answers %q: %d deleted after %d questions, want %d after %d", test.answers, deleted, asked, test.deleted, test.asked)
+		}
+		if !strings.HasPrefix(out.String(), "Delete batch 1/3 (2 variable(s))? [y/N] ") {
+			t.Errorf("prompt = %q", out.String())
+		}
+	}
+
+	failure := errors.New("boom")
+	batches := 0
+	err := pruneInBatches(prune, 2, func([]EnvVar) error {
+		batches++
+		return failure
+	}, func(int, int, []EnvVar) bool { return true })
+	if err != failure || batches != 1 {
+		t.Errorf("err = %v after %d batches, want the first batch's error", err, batches)
+	}
+}
+
+func TestPruneBatchesCommand(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		stdin   string
+		flags   []string
+		code    int
+		deleted int
+	}{
+		// Without a terminal the answers are not read and --yes is required.
+		{"non-interactive", "y\n", nil, exitFailure, 0},
+		{"--yes", "", []string{"--yes"}, 0, 5},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakeGitLab(t)
+			f.projects["g/src"] = nil
+			for i := 0; i < 5; i++ {
+				f.projects["g/dst"] = append(f.projects["g/dst"], envVar(fmt.Sprintf("OLD_%d", i), "1", ""))
+			}
+			args := f.args(append([]string{"--source", "g/src", "--target", "g/dst", "--prune", "--prune-batch-size", "2"}, test.flags...)...)
+			_, stderr, code := runMain(t, test.stdin, args...)
+			if code != test.code {
+				t.Fatalf("exit code %d, want %d; stderr:\n%s", code, test.code, stderr)
+			}
+			if deletes := len(f.received(http.MethodDelete)); deletes != test.deleted {
+				t.Errorf("%d deletes, want %d; stderr:\n%s", deletes, test.deleted, stderr)
+			}
+			if strings.Contains(stderr, "[y/N]") {
+				t.Errorf("stderr has a prompt:\n%s", stderr)
+			}
+			if test.code != 0 {
+				if !strings.Contains(stderr, "prune of g/dst in batches needs confirmation") {
+					t.Errorf("stderr does not ask for --yes:\n%s", stderr)
+				}
+				return
+			}
+			if !strings.Contains(stderr, "Prune batch 1/3: deleting 2 variable(s)") {
+				t.Errorf("stderr has no batch log:\n%s", stderr)
+			}
+		})
+	}
+}
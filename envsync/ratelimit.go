@@ -0,0 +1,44 @@
+package envsync
+
+import (
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitedTransport holds every request until limiter allows it, so all
+// workers of a run, across targets and variables, share one request rate.
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
+
+// newRateLimiter returns the limiter for --rate-limit, in requests per
+// second, or nil for no limit. Requests are spaced evenly, without bursts.
+func newRateLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), 1)
+}
+
+// WithRateLimit makes every request wait for limiter. Clients given the same
+// limiter share its rate, as the target and source clients of a run do. It
+// wraps the transport the options before it configure and comes before
+// WithRetries, so each retry waits its turn as well.
+func WithRateLimit(limiter *rate.Limiter) ClientOption {
+	return func(c *GitLabClient) {
+		next := c.httpClient.Transport
+		if next == nil {
+			next = c.transport()
+		}
+		c.httpClient.Transport = &rateLimitedTransport{next: next, limiter: limiter}
+	}
+}
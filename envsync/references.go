@@ -0,0 +1,94 @@
+package envsync
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+// variableReference matches $NAME and ${NAME}. A doubled $$ is GitLab's
+// escape for a literal dollar sign and is stripped before matching.
+var variableReference = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// predefinedPrefixes are prefixes of variables GitLab defines in every job.
+var predefinedPrefixes = []string{"CI_", "GITLAB_"}
+
+// referencedKeys returns the variable names an expanded value refers to.
+func referencedKeys(value string) []string {
+	var keys []string
+	for _, m := range variableReference.FindAllStringSubmatch(strings.ReplaceAll(value, "$$", ""), -1) {
+		key := m[1]
+		if key == "" {
+			key = m[2]
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func isPredefined(key string) bool {
+	for _, prefix := range predefinedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReferences warns about expanded (non-raw) source variables whose $
+// references would not resolve in the target after the sync, i.e. match
+// neither a synced nor an existing target variable in an overlapping scope.
+// It returns the affected variables. Group variables are not consulted.
+func checkReferences(variables []EnvVar, targetVars []EnvVar) []EnvVar {
+	available := map[string][]string{}
+	for _, v := range append(append([]EnvVar(nil), targetVars...), variables...) {
+		available[v.Key] = append(available[v.Key], v.EnvironmentScope)
+	}
+	resolves := func(key, scope string) bool {
+		if isPredefined(key) {
+			return true
+		}
+		for _, s := range available[key] {
+			if scopesOverlap(s, scope) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var unresolved []EnvVar
+	for _, v := range variables {
+		if v.Raw {
+			continue
+		}
+		var missing []string
+		for _, key := range referencedKeys(v.Value) {
+			if !resolves(key, v.EnvironmentScope) {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			log.Printf("Warning: %s references %s, which will not exist in the target", keyOf(v), strings.Join(missing, ", "))
+			unresolved = append(unresolved, v)
+		}
+	}
+	return unresolved
+}
+
+// markRaw disables expansion for the given variables.
+func markRaw(variables []EnvVar, raw []EnvVar) []EnvVar {
+	set := map[variableKey]bool{}
+	for _, v := range raw {
+		set[keyOf(v)] = true
+	}
+	result := make([]EnvVar, len(variables))
+	for i, v := range variables {
+		if set[keyOf(v)] {
+			log.Printf("Marking %s as raw so its value is not expanded", keyOf(v))
+			v.Raw = true
+			v.unspecified &^= attrRaw
+		}
+		result[i] = v
+	}
+	return result
+}
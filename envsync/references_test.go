@@ -0,0 +1,46 @@
+package envsync
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReferencedKeys(t *testing.T) {
+	got := referencedKeys("postgres://$DB_USER:${DB_PASS}@$$HOST/$CI_PROJECT_NAME-1")
+	if want := []string{"DB_USER", "DB_PASS", "CI_PROJECT_NAME"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("referencedKeys = %v, want %v", got, want)
+	}
+	if got := referencedKeys("costs $$5 or $1"); len(got) != 0 {
+		t.Errorf("referencedKeys = %v, want none", got)
+	}
+}
+
+func TestCheckReferences(t *testing.T) {
+	logs := captureLog(t)
+	raw := envVar("TEMPLATE", "$UNDEFINED", "")
+	raw.Raw = true
+	variables := []EnvVar{
+		envVar("URL", "https://$HOST/$CI_PROJECT_PATH", ""),
+		envVar("DSN", "$DB_USER@$DB_HOST", "production"),
+		envVar("DB_USER", "app", "production"),
+		envVar("STAGING_DSN", "$DB_HOST", "staging"),
+		raw,
+	}
+	target := []EnvVar{envVar("HOST", "example.com", ""), envVar("DB_HOST", "db.prod", "production")}
+
+	unresolved := checkReferences(variables, target)
+	if len(unresolved) != 1 || unresolved[0].Key != "STAGING_DSN" {
+		t.Errorf("unresolved = %v, want STAGING_DSN only", unresolved)
+	}
+	if !strings.Contains(logs.String(), "STAGING_DSN@staging references DB_HOST, which will not exist in the target") {
+		t.Errorf("log = %q", logs)
+	}
+
+	marked := markRaw(variables, unresolved)
+	for _, v := range marked {
+		if v.Raw != (v.Key == "STAGING_DSN" || v.Key == "TEMPLATE") {
+			t.Errorf("%s: raw = %v", keyOf(v), v.Raw)
+		}
+	}
+}
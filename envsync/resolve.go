@@ -0,0 +1,83 @@
+package envsync
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Conflict resolution strategies for --resolve.
+const (
+	resolveSource      = "source"
+	resolveTarget      = "target"
+	resolveInteractive = "interactive"
+
+	// resolveNewer is rejected with an explanation: GitLab keeps no
+	// modification time, so there is nothing to compare.
+	resolveNewer = "newer"
+)
+
+// resolver picks the value to apply when source and target values differ.
+type resolver func(source, current EnvVar) (EnvVar, error)
+
+// newResolver returns the resolver for a --resolve strategy. in and out are
+// only used by the interactive strategy.
+func newResolver(strategy string, in io.Reader, out io.Writer) (resolver, error) {
+	switch strategy {
+	case "", resolveSource:
+		return nil, nil
+	case resolveTarget:
+		return func(source, current EnvVar) (EnvVar, error) {
+			source.Value = current.Value
+			return source, nil
+		}, nil
+	case resolveInteractive:
+		reader := bufio.NewReader(in)
+		return func(source, current EnvVar) (EnvVar, error) {
+			return promptConflict(reader, out, source, current)
+		}, nil
+	case resolveNewer:
+		return nil, fmt.Errorf("--resolve %s is not supported because GitLab does not record when a variable changed (use source, target or interactive)", resolveNewer)
+	default:
+		return nil, fmt.Errorf("unknown --resolve strategy %q (use source, target or interactive)", strategy)
+	}
+}
+
+func promptConflict(reader *bufio.Reader, out io.Writer, source, current EnvVar) (EnvVar, error) {
+	fmt.Fprintf(out, "\nConflict on %s\n", keyOf(source))
+	fmt.Fprintf(out, "  source: %s\n", displayValue(source))
+	fmt.Fprintf(out, "  target: %s\n", displayValue(current))
+
+	for {
+		fmt.Fprint(out, "Keep [s]ource, keep [t]arget or [e]dit? ")
+		answer, err := readLine(reader)
+		if err != nil {
+			return source, err
+		}
+
+		switch strings.ToLower(answer) {
+		case "s", "source":
+			return source, nil
+		case "t", "target":
+			source.Value = current.Value
+			return source, nil
+		case "e", "edit":
+			fmt.Fprint(out, "New value: ")
+			value, err := readLine(reader)
+			if err != nil {
+				return source, err
+			}
+			source.Value = value
+			return source, nil
+		}
+	}
+}
+
+// displayValue shows a value for human review. Masked values stay hidden.
+func displayValue(v EnvVar) string {
+	if v.Masked {
+		return fmt.Sprintf("[masked, %d chars]", len(v.Value))
+	}
+	return fmt.Sprintf("%q", v.Value)
+}
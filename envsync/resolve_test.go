@@ -0,0 +1,99 @@
+package envsync
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNonInteractiveResolvers(t *testing.T) {
+	source, current := envVar("A", "from-source", ""), envVar("A", "from-target", "")
+	current.Protected = true
+
+	keepSource, err := newResolver(resolveSource, nil, nil)
+	if err != nil || keepSource != nil {
+		t.Errorf("source strategy = %v, %v, want no resolver", keepSource, err)
+	}
+	keepTarget, err := newResolver(resolveTarget, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := keepTarget(source, current)
+	if err != nil || got.Value != "from-target" || got.Protected {
+		t.Errorf("target strategy = %+v, %v, want the target value and the source attributes", got, err)
+	}
+	if _, err := newResolver("newest", nil, nil); err == nil || !strings.Contains(err.Error(), "unknown --resolve strategy") {
+		t.Errorf("unknown strategy: err = %v", err)
+	}
+}
+
+// newer gets its own explanation rather than the unknown strategy error.
+func TestNewerResolverUnsupported(t *testing.T) {
+	_, err := newResolver(resolveNewer, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "GitLab does not record when a variable changed") {
+		t.Errorf("err = %v, want newer explained as unsupported", err)
+	}
+
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("A", "2", "")}
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--resolve", "newer")...)
+	if code == 0 || !strings.Contains(stderr, "--resolve newer is not supported because GitLab does not record when a variable changed") {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v, want none", writes)
+	}
+}
+
+func TestInteractiveResolver(t *testing.T) {
+	source, current := envVar("A", "from-source", ""), envVar("A", "from-target", "")
+	var out bytes.Buffer
+	// "x" is not an answer, so the prompt repeats.
+	resolve, err := newResolver(resolveInteractive, strings.NewReader("x\ns\nt\ne\nedited\n"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"from-source", "from-target", "edited"} {
+		got, err := resolve(source, current)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Value != want {
+			t.Errorf("value = %q, want %q", got.Value, want)
+		}
+	}
+	if n := strings.Count(out.String(), "Keep [s]ource"); n != 4 {
+		t.Errorf("prompted %d times, want 4:\n%s", n, out.String())
+	}
+	if _, err := resolve(source, current); err == nil {
+		t.Error("resolving at the end of input succeeded, want an error")
+	}
+}
+
+func TestDisplayValueHidesMasked(t *testing.T) {
+	masked := envVar("A", "secret", "")
+	masked.Masked = true
+	if got := displayValue(masked); strings.Contains(got, "secret") || got != "[masked, 6 chars]" {
+		t.Errorf("displayValue = %q", got)
+	}
+	if got := displayValue(envVar("A", "plain", "")); got != `"plain"` {
+		t.Errorf("displayValue = %q", got)
+	}
+}
+
+// A resolver that keeps the target value turns the update into an
+// unchanged variable.
+func TestResolveTargetLeavesTargetAlone(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{envVar("A", "target", "")}
+	resolve, _ := newResolver(resolveTarget, nil, nil)
+
+	decisions := transfer(t, f, "g/dst", []EnvVar{envVar("A", "source", "")}, transferOptions{Upsert: true, Resolve: resolve})
+	if decisions[0].Action != actionUnchanged {
+		t.Errorf("decision = %v, want unchanged", decisions[0])
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v, want none", writes)
+	}
+}
@@ -0,0 +1,56 @@
+package envsync
+
+import (
+	"log"
+	"strings"
+)
+
+const (
+	reviewScopePrefix = "review/"
+	reviewWildcard    = reviewScopePrefix + "*"
+)
+
+// consolidateReviewScopes collapses per-branch review app scopes such as
+// review/feature-x into a single review/* variant per key. When branches
+// disagree on a value the first one wins and the rest are reported.
+func consolidateReviewScopes(variables []EnvVar) []EnvVar {
+	result := make([]EnvVar, 0, len(variables))
+	kept := map[string]int{}
+	for _, v := range variables {
+		scope := v.EnvironmentScope
+		if !strings.HasPrefix(scope, reviewScopePrefix) {
+			result = append(result, v)
+			continue
+		}
+
+		original := scope
+		v.EnvironmentScope = reviewWildcard
+		if i, ok := kept[v.Key]; ok {
+			if result[i].Value != v.Value {
+				log.Printf("Warning: %s@%s differs from the consolidated %s, keeping the first value", v.Key, original, keyOf(result[i]))
+			}
+			continue
+		}
+		kept[v.Key] = len(result)
+		result = append(result, v)
+	}
+	return result
+}
+
+// expandReviewScopes copies every review/* variable into one variant per
+// named review environment.
+func expandReviewScopes(variables []EnvVar, environments []string) []EnvVar {
+	result := make([]EnvVar, 0, len(variables))
+	for _, v := range variables {
+		if v.EnvironmentScope != reviewWildcard {
+			result = append(result, v)
+			continue
+		}
+		for _, env := range environments {
+			expanded := v
+			expanded.EnvironmentScope = reviewScopePrefix + env
+			result = append(result, expanded)
+		}
+	}
+	return result
+}
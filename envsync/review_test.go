@@ -0,0 +1,40 @@
+package envsync
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConsolidateReviewScopes(t *testing.T) {
+	logs := captureLog(t)
+	variables := []EnvVar{
+		envVar("URL", "https://a.review", "review/feature-a"),
+		envVar("URL", "https://prod", "production"),
+		envVar("URL", "https://b.review", "review/feature-b"),
+		envVar("DEBUG", "1", "review/feature-b"),
+		envVar("DEBUG", "1", "review/*"),
+	}
+
+	got := consolidateReviewScopes(variables)
+	want := []EnvVar{
+		envVar("URL", "https://a.review", "review/*"),
+		envVar("URL", "https://prod", "production"),
+		envVar("DEBUG", "1", "review/*"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("consolidateReviewScopes = %v, want %v", got, want)
+	}
+	if warnings := strings.Count(logs.String(), "Warning:"); warnings != 1 || !strings.Contains(logs.String(), "URL@review/feature-b differs") {
+		t.Errorf("log = %q, want one warning for URL@review/feature-b", logs)
+	}
+}
+
+func TestExpandReviewScopes(t *testing.T) {
+	variables := []EnvVar{envVar("URL", "u", "review/*"), envVar("URL", "p", "production")}
+	got := expandReviewScopes(variables, []string{"a", "b"})
+	want := []EnvVar{envVar("URL", "u", "review/a"), envVar("URL", "u", "review/b"), envVar("URL", "p", "production")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandReviewScopes = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,24 @@
+package envsync
+
+import (
+	"regexp"
+	"sort"
+)
+
+// highRiskChanges lists the writes and deletions in scopes matching scopes,
+// sorted, as KEY@scope.
+func highRiskChanges(decisions []decision, prune []EnvVar, scopes *regexp.Regexp) []string {
+	var changes []string
+	for _, d := range decisions {
+		if isWrite(d.Action) && scopes.MatchString(normalizeScope(d.Variable.EnvironmentScope)) {
+			changes = append(changes, keyOf(d.Variable).String())
+		}
+	}
+	for _, v := range prune {
+		if scopes.MatchString(normalizeScope(v.EnvironmentScope)) {
+			changes = append(changes, keyOf(v).String()+" (delete)")
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
@@ -0,0 +1,63 @@
+package envsync
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHighRiskChanges(t *testing.T) {
+	decisions := []decision{
+		{envVar("B", "1", "production"), actionUpdate, ""},
+		{envVar("A", "1", "production"), actionCreate, ""},
+		{envVar("C", "1", "production"), actionUnchanged, ""},
+		{envVar("D", "1", "production"), actionSkip, ""},
+		{envVar("E", "1", "staging"), actionCreate, ""},
+		{envVar("F", "1", "prod-eu"), actionReplace, ""},
+	}
+	prune := []EnvVar{envVar("OLD", "1", "production"), envVar("OLD", "1", "staging")}
+	got := highRiskChanges(decisions, prune, regexp.MustCompile(`^(?:production|prod-.*)$`))
+	want := []string{"A@production", "B@production", "F@prod-eu", "OLD@production (delete)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("highRiskChanges = %v, want %v", got, want)
+	}
+}
+
+// The extra confirmation is asked even with --yes, but only when the run
+// touches a --high-risk-scopes scope.
+func TestHighRiskConfirmation(t *testing.T) {
+	const question = "Apply 1 change(s) in high-risk scopes of g/dst?"
+	for _, test := range []struct {
+		name   string
+		source EnvVar
+		stdin  string
+		flags  []string
+		asked  bool
+		synced bool
+	}{
+		{"other scope", envVar("A", "1", "staging"), "", nil, false, true},
+		{"declined", envVar("A", "1", "production"), "n\n", nil, true, false},
+		{"no answer", envVar("A", "1", "production"), "", nil, true, false},
+		{"confirmed", envVar("A", "1", "production"), "y\n", nil, true, true},
+		{"--confirm-production", envVar("A", "1", "production"), "", []string{"--confirm-production"}, false, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakeGitLab(t)
+			f.projects["g/src"] = []EnvVar{test.source}
+			f.projects["g/dst"] = nil
+
+			args := f.args(append([]string{"--source", "g/src", "--target", "g/dst", "--yes", "--high-risk-scopes", "production"}, test.flags...)...)
+			_, stderr, code := runMain(t, test.stdin, args...)
+			if asked := strings.Contains(stderr, question); asked != test.asked {
+				t.Errorf("asked = %t, want %t; stderr:\n%s", asked, test.asked, stderr)
+			}
+			if synced := len(f.vars("g/dst")) == 1; synced != test.synced || (code == 0) != test.synced {
+				t.Errorf("synced = %t, exit code %d; stderr:\n%s", synced, code, stderr)
+			}
+			if !test.synced && len(f.writes()) != 0 {
+				t.Errorf("writes = %v", f.writes())
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package envsync
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// writeRollbackScript writes a shell script of curl calls that undoes the
+// recorded writes to targetProject, last first, the way a transactional
+// rollback would. Restored values appear in the script, so it is written
+// with the mode of secret files.
+func writeRollbackScript(filename string, client *GitLabClient, targetProject string, steps []undoStep, mode os.FileMode) error {
+	var buf bytes.Buffer
+	if err := rollbackScript(&buf, client, targetProject, steps); err != nil {
+		return err
+	}
+	return writeOutputFile(filename, buf.Bytes(), mode)
+}
+
+func rollbackScript(w io.Writer, client *GitLabClient, targetProject string, steps []undoStep) error {
+	fmt.Fprintf(w, "#!/bin/sh\n")
+	fmt.Fprintf(w, "# Undoes the %d change(s) env-sync made to %s at %s.\n", len(steps), targetProject, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "# Review it before running it with GITLAB_TOKEN set.\n")
+	fmt.Fprintf(w, "set -eu\n")
+	fmt.Fprintf(w, ": \"${GITLAB_TOKEN:?set GITLAB_TOKEN to a token that can write the variables of %s}\"\n", targetProject)
+	fmt.Fprintf(w, "api=%s\n", shellQuote(fmt.Sprintf("%s/api/v4/projects/%s/variables", client.baseURL, pathSegment(targetProject))))
+	fmt.Fprintf(w, "gitlab() {\n\tcurl --fail --silent --show-error --output /dev/null -H \"PRIVATE-TOKEN: $GITLAB_TOKEN\" -H 'Content-Type: application/json' \"$@\"\n}\n")
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		s := steps[i]
+		variable := "$api/" + pathSegment(s.variable.Key) + "?filter%5Benvironment_scope%5D=" + url.QueryEscape(normalizeScope(s.variable.EnvironmentScope))
+		fmt.Fprintln(w)
+		switch {
+		case s.previous == nil:
+			fmt.Fprintf(w, "# %s was created\n", keyOf(s.variable))
+			fmt.Fprintf(w, "gitlab -X DELETE \"%s\"\n", variable)
+		case s.previous.Hidden:
+			fmt.Fprintf(w, "# %s was hidden before the run, its value cannot be restored\n", keyOf(s.variable))
+		default:
+			data, err := client.fields.marshal(*s.previous)
+			if err != nil {
+				return err
+			}
+			if s.deleted {
+				fmt.Fprintf(w, "# %s was deleted\n", keyOf(s.variable))
+				fmt.Fprintf(w, "gitlab -X POST \"$api\" --data %s\n", shellQuote(string(data)))
+			} else {
+				fmt.Fprintf(w, "# %s was updated\n", keyOf(s.variable))
+				fmt.Fprintf(w, "gitlab -X PUT \"%s\" --data %s\n", variable, shellQuote(string(data)))
+			}
+		}
+	}
+	return nil
+}
+
+// shellQuote single-quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
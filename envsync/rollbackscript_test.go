@@ -0,0 +1,123 @@
+package envsync
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The script undoes the steps last first: a create becomes a delete, an
+// update a PUT of the previous variable and a delete a POST of it.
+func TestRollbackScript(t *testing.T) {
+	f := newFakeGitLab(t)
+	previous := envVar("UPDATED", "it's old", "production")
+	previous.Protected = true
+	deleted := envVar("DELETED", "gone", "")
+	hidden := envVar("HIDDEN", "", "")
+	hidden.Hidden = true
+	steps := []undoStep{
+		{variable: envVar("CREATED", "new", "")},
+		{variable: envVar("UPDATED", "new", "production"), previous: &previous},
+		{variable: deleted, previous: &deleted, deleted: true},
+		{variable: hidden, previous: &hidden},
+	}
+
+	var buf bytes.Buffer
+	if err := rollbackScript(&buf, f.client(), "g/my.app", steps); err != nil {
+		t.Fatal(err)
+	}
+	script := buf.String()
+	_, body, _ := strings.Cut(script, "gitlab() {")
+	want := []string{
+		"# HIDDEN@* was hidden before the run, its value cannot be restored",
+		"# DELETED@* was deleted",
+		`gitlab -X POST "$api" --data '{"variable_type":"env_var","key":"DELETED","value":"gone"`,
+		"# UPDATED@production was updated",
+		`gitlab -X PUT "$api/UPDATED?filter%5Benvironment_scope%5D=production" --data '{"variable_type":"env_var","key":"UPDATED","value":"it'\''s old","protected":true`,
+		"# CREATED@* was created",
+		`gitlab -X DELETE "$api/CREATED?filter%5Benvironment_scope%5D=%2A"`,
+	}
+	last := -1
+	for _, line := range want {
+		i := strings.Index(body, line)
+		if i < 0 {
+			t.Fatalf("script lacks %q:\n%s", line, script)
+		}
+		if i < last {
+			t.Errorf("%q is out of order:\n%s", line, script)
+		}
+		last = i
+	}
+	if !strings.Contains(script, "api='"+f.server.URL+"/api/v4/projects/g%2Fmy.app/variables'") {
+		t.Errorf("script does not address the project:\n%s", script)
+	}
+}
+
+// Running the script written after a live run puts the target back the way
+// it was.
+func TestRollbackScriptRestoresTarget(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl is not installed")
+	}
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("NEW", "1", ""), envVar("CHANGED", "new", "production")}
+	f.projects["g/dst"] = []EnvVar{envVar("CHANGED", "old 'quoted' $HOME", "production"), envVar("STALE", "x", "")}
+	before := targetValues(f.vars("g/dst"))
+	script := filepath.Join(t.TempDir(), "undo.sh")
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--prune", "--yes", "--rollback-script", script)...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Wrote rollback script for 3 change(s)") {
+		t.Errorf("stderr:\n%s", stderr)
+	}
+	info, err := os.Stat(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("script mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	cmd := exec.Command("sh", script)
+	cmd.Env = append(os.Environ(), "GITLAB_TOKEN=test-token")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("rollback script failed: %v\n%s", err, out)
+	}
+	if got := targetValues(f.vars("g/dst")); !reflect.DeepEqual(got, before) {
+		t.Errorf("target after rollback = %v, want %v", got, before)
+	}
+}
+
+// A --replace that deleted a variable but could not create it again is in
+// the script as a delete to revert.
+func TestRollbackScriptRecreatesFailedReplace(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("KEY", "new", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("KEY", "old", "")}
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost {
+			return http.StatusBadRequest, `{"message":"400 Bad request"}`
+		}
+		return 0, ""
+	}
+	script := filepath.Join(t.TempDir(), "undo.sh")
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--replace", "--rollback-script", script)...)
+	if code != exitFailure {
+		t.Errorf("exit code %d, want %d; stderr:\n%s", code, exitFailure, stderr)
+	}
+	data, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "# KEY@* was deleted\ngitlab -X POST") || !strings.Contains(string(data), `"value":"old"`) {
+		t.Errorf("script does not recreate KEY@*:\n%s", data)
+	}
+}
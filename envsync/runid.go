@@ -0,0 +1,26 @@
+package envsync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// runIDHeader carries the run ID on every API request, so an administrator
+// can find all calls of one invocation in GitLab's logs.
+const runIDHeader = "X-Env-Sync-Run-Id"
+
+// newRunID returns a random ID for this invocation.
+func newRunID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// WithRunID sends id in the X-Env-Sync-Run-Id header of every request.
+func WithRunID(id string) ClientOption {
+	return func(c *GitLabClient) {
+		c.runID = id
+	}
+}
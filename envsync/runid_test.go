@@ -0,0 +1,84 @@
+package envsync
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewRunID(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id, err := newRunID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(id) {
+			t.Fatalf("run ID %q is not 16 hex digits", id)
+		}
+		if seen[id] {
+			t.Fatalf("run ID %q repeated", id)
+		}
+		seen[id] = true
+	}
+}
+
+// Every request of a run carries the same run ID, which also prefixes the
+// run's log lines.
+func TestRunIDOnEveryRequest(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.version = "17.0.0"
+	f.projects["g/src"] = []EnvVar{envVar("NEW", "1", ""), envVar("CHANGED", "new", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("CHANGED", "old", ""), envVar("STALE", "x", "")}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--prune", "--yes", "--transactional")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	requests := f.received()
+	if len(requests) < 6 {
+		t.Fatalf("only %d requests: %v", len(requests), requests)
+	}
+	id := requests[0].Header.Get(runIDHeader)
+	if id == "" {
+		t.Fatalf("%s has no %s header", requests[0], runIDHeader)
+	}
+	for _, r := range requests {
+		if got := r.Header.Get(runIDHeader); got != id {
+			t.Errorf("%s: run ID %q, want %q", r, got, id)
+		}
+	}
+	// Lines without a timestamp are the prune listing, not log output.
+	logLine := regexp.MustCompile(`^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d `)
+	for _, line := range strings.Split(stderr, "\n") {
+		if logLine.MatchString(line) && !strings.Contains(line, " ["+id+"] ") {
+			t.Errorf("log line without the run ID: %q", line)
+		}
+	}
+}
+
+// --run-id sets the ID; JSON logs carry it in a field.
+func TestRunIDFlag(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = []EnvVar{}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--run-id", "deploy-42", "--log-format", "json")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	for _, r := range f.received() {
+		if got := r.Header.Get(runIDHeader); got != "deploy-42" {
+			t.Errorf("%s: run ID %q, want deploy-42", r, got)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		var entry struct {
+			RunID string `json:"run_id"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.RunID != "deploy-42" {
+			t.Errorf("log entry %q: run_id %q, %v", line, entry.RunID, err)
+		}
+	}
+}
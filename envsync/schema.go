@@ -0,0 +1,39 @@
+package envsync
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// WithStrictSchema makes the client reject API responses with variable
+// fields it does not know, instead of silently ignoring them.
+func WithStrictSchema() ClientOption {
+	return func(c *GitLabClient) {
+		c.strict = true
+	}
+}
+
+// decodeVariableList decodes a JSON array of variables, rejecting unknown
+// fields when strict is set.
+func decodeVariableList(dec *json.Decoder, out *[]EnvVar, strict bool) error {
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	// Decoding into *out would reset it, dropping the earlier pages.
+	var page []EnvVar
+	if err := dec.Decode(&page); err != nil {
+		return err
+	}
+	*out = append(*out, page...)
+	return nil
+}
+
+// unmarshalJSON is json.Unmarshal, rejecting unknown fields when strict is
+// set.
+func unmarshalJSON(data []byte, v interface{}, strict bool) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(v)
+}
@@ -0,0 +1,55 @@
+package envsync
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newFieldVariables answers the variable list with a field the client does
+// not know, as a newer GitLab might.
+func newFieldVariables(f *fakeGitLab) {
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodGet && r.Path == "projects/g%2Fsrc/variables" {
+			return http.StatusOK, `[{"key": "A", "value": "1", "environment_scope": "*", "rotation_policy": "30d"}]`
+		}
+		return 0, ""
+	}
+}
+
+func TestStrictSchemaRejectsUnknownFields(t *testing.T) {
+	f := newFakeGitLab(t)
+	newFieldVariables(f)
+
+	variables, err := f.client().GetVariables("g/src")
+	if err != nil || len(variables) != 1 {
+		t.Fatalf("lenient client: %v, %v", variables, err)
+	}
+	_, err = f.client(WithStrictSchema()).GetVariables("g/src")
+	if err == nil || !strings.Contains(err.Error(), `unknown field "rotation_policy"`) {
+		t.Errorf("strict client: err = %v", err)
+	}
+}
+
+func TestStrictSchemaPlanFile(t *testing.T) {
+	plan := writeFile(t, "plan.json", `{"variables": [], "approved_by": "someone"}`)
+	if _, err := readDryRunOutput(plan, nil, false); err != nil {
+		t.Errorf("lenient read: %v", err)
+	}
+	if _, err := readDryRunOutput(plan, nil, true); err == nil || !strings.Contains(err.Error(), "approved_by") {
+		t.Errorf("strict read: err = %v", err)
+	}
+}
+
+func TestStrictSchemaFlag(t *testing.T) {
+	f := newFakeGitLab(t)
+	newFieldVariables(f)
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--list", "--strict-schema")...)
+	if code == 0 || !strings.Contains(stderr, "rotation_policy") {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--list")...); code != 0 {
+		t.Errorf("without --strict-schema: exit code %d; stderr:\n%s", code, stderr)
+	}
+}
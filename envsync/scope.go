@@ -0,0 +1,43 @@
+package envsync
+
+// defaultScope is the wildcard scope GitLab assigns to variables created
+// without an explicit environment_scope.
+const defaultScope = "*"
+
+// normalizeScope maps the empty scope to the "*" wildcard, which is how
+// GitLab stores it. No other normalization is applied: environment names are
+// case-sensitive, so "Production" and "production" are distinct scopes and
+// must never be folded together.
+func normalizeScope(scope string) string {
+	if scope == "" {
+		return defaultScope
+	}
+	return scope
+}
+
+// variableKey identifies a variable within a project. GitLab allows the same
+// key to exist once per environment scope.
+type variableKey struct {
+	Key   string
+	Scope string
+}
+
+func keyOf(v EnvVar) variableKey {
+	return variableKey{Key: v.Key, Scope: normalizeScope(v.EnvironmentScope)}
+}
+
+func (k variableKey) String() string {
+	return k.Key + "@" + k.Scope
+}
+
+// keepScopes drops the variables outside the given scopes. Scopes match
+// exactly, except that "" and "*" are the same.
+func keepScopes(variables []EnvVar, scopes []string, explain bool) []EnvVar {
+	keep := map[string]bool{}
+	for _, s := range scopes {
+		keep[normalizeScope(s)] = true
+	}
+	return filterVariables(variables, func(v EnvVar) bool {
+		return keep[normalizeScope(v.EnvironmentScope)]
+	}, "scope not in --source-scopes", explain)
+}
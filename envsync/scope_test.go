@@ -0,0 +1,181 @@
+package envsync
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeScopeKeepsCase(t *testing.T) {
+	for scope, want := range map[string]string{
+		"":             "*",
+		"*":            "*",
+		"Production":   "Production",
+		"production":   "production",
+		"review/Feat1": "review/Feat1",
+	} {
+		if got := normalizeScope(scope); got != want {
+			t.Errorf("normalizeScope(%q) = %q, want %q", scope, got, want)
+		}
+	}
+}
+
+func TestKeyOfDistinguishesScopeCase(t *testing.T) {
+	upper := keyOf(envVar("API_URL", "a", "Production"))
+	lower := keyOf(envVar("API_URL", "b", "production"))
+	if upper == lower {
+		t.Fatalf("keyOf treats %s and %s as the same variable", upper, lower)
+	}
+	if keyOf(envVar("API_URL", "c", "")) != keyOf(envVar("API_URL", "d", "*")) {
+		t.Error("the empty scope and * should be the same variable")
+	}
+}
+
+func TestKeepScopesIsCaseSensitive(t *testing.T) {
+	variables := []EnvVar{
+		envVar("A", "1", "Production"),
+		envVar("A", "2", "production"),
+		envVar("B", "3", ""),
+	}
+	kept := keepScopes(variables, []string{"production", "*"}, false)
+	if len(kept) != 2 || kept[0].Value != "2" || kept[1].Value != "3" {
+		t.Errorf("keepScopes kept %v, want A@production and B@*", kept)
+	}
+}
+
+// Regression test: mixed-case scopes of one key are planned and created as
+// separate variables, with their scope sent unchanged.
+func TestTransferPreservesScopeCase(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{envVar("API_URL", "old", "production")}
+	client := f.client()
+
+	existing := map[variableKey]EnvVar{}
+	targetVars, err := client.GetVariables("g/dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range targetVars {
+		existing[keyOf(v)] = v
+	}
+	source := []EnvVar{
+		envVar("API_URL", "upper", "Production"),
+		envVar("API_URL", "old", "production"),
+	}
+	opts := transferOptions{Upsert: true, OnMaskFailure: maskFailureFail}
+	decisions, err := planTransfer(source, existing, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decisions[0].Action != actionCreate || decisions[1].Action != actionUnchanged {
+		t.Fatalf("decisions = %v, want create for Production and unchanged for production", decisions)
+	}
+	if err := transferVariables(client, "g/dst", decisions, opts, func(EnvVar, outcome, error) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, v := range f.vars("g/dst") {
+		got[v.EnvironmentScope] = v.Value
+	}
+	if len(got) != 2 || got["Production"] != "upper" || got["production"] != "old" {
+		t.Errorf("target scopes = %v, want Production and production kept apart", got)
+	}
+	posts := f.received(http.MethodPost)
+	if len(posts) != 1 || !strings.Contains(posts[0].Body, `"environment_scope":"Production"`) {
+		t.Errorf("create request = %v, want the scope sent as Production", posts)
+	}
+}
+
+// The documented promotion: read staging and * from the source, sync
+// staging as production and leave the source's production variables out.
+func TestPromoteStagingToProduction(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = []EnvVar{
+		envVar("API_URL", "https://staging", "staging"),
+		envVar("API_URL", "https://source-prod", "production"),
+		envVar("LOG_LEVEL", "debug", ""),
+		envVar("ONLY_STAGING", "1", "staging"),
+		envVar("ONLY_PRODUCTION", "1", "production"),
+	}
+	f.projects["g/app-prod"] = []EnvVar{envVar("API_URL", "https://old", "production")}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/app", "--target", "g/app-prod",
+		"--source-scopes", "staging,*", "--scope-rename", "^staging$=production", "--upsert")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+
+	got := map[variableKey]string{}
+	for _, v := range f.vars("g/app-prod") {
+		got[keyOf(v)] = v.Value
+	}
+	want := map[variableKey]string{
+		keyOf(envVar("API_URL", "", "production")):      "https://staging",
+		keyOf(envVar("LOG_LEVEL", "", "")):              "debug",
+		keyOf(envVar("ONLY_STAGING", "", "production")): "1",
+	}
+	if len(got) != len(want) {
+		t.Errorf("target = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("target %s = %q, want %q", k, got[k], v)
+		}
+	}
+	if !strings.Contains(stderr, "Kept 3 source variables in scopes staging,*") {
+		t.Errorf("stderr does not report the scope filter:\n%s", stderr)
+	}
+}
+
+// A scoped source variable next to the target's * variant is created as a
+// new variant; the * one is not touched.
+func TestUpsertCreatesScopedVariant(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{envVar("KEY", "default", "")}
+
+	decisions := transfer(t, f, "g/dst", []EnvVar{envVar("KEY", "prod", "production")}, transferOptions{Upsert: true, OnMaskFailure: maskFailureFail})
+	if decisions[0].Action != actionCreate {
+		t.Errorf("decision = %v, want create", decisions[0])
+	}
+	got := f.vars("g/dst")
+	if len(got) != 2 || got[0].Value != "default" || got[1].EnvironmentScope != "production" || got[1].Value != "prod" {
+		t.Errorf("target = %v", got)
+	}
+	if writes := f.writes(); len(writes) != 1 || writes[0] != "POST projects/g%2Fdst/variables" {
+		t.Errorf("writes = %v", writes)
+	}
+}
+
+// An update whose exact scope is gone by the time it is sent falls back to
+// creating that scoped variant.
+func TestUpdateOfMissingScopeCreates(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{envVar("KEY", "default", "")}
+	opts := transferOptions{Upsert: true, OnMaskFailure: maskFailureFail}
+
+	// The plan still believes KEY@production exists.
+	decisions, err := planTransfer([]EnvVar{envVar("KEY", "prod", "production")}, existingVars(envVar("KEY", "old", "production")), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outcomes []outcome
+	if err := transferVariables(f.client(), "g/dst", decisions, opts, func(_ EnvVar, result outcome, _ error) { outcomes = append(outcomes, result) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(outcomes) != 1 || outcomes[0] != outcomeCreated {
+		t.Errorf("outcomes = %v, want created", outcomes)
+	}
+	want := []string{"PUT projects/g%2Fdst/variables/KEY", "POST projects/g%2Fdst/variables"}
+	if writes := f.writes(); strings.Join(writes, ",") != strings.Join(want, ",") {
+		t.Errorf("writes = %v, want %v", writes, want)
+	}
+	if put := f.received(http.MethodPut)[0]; put.Query.Get("filter[environment_scope]") != "production" {
+		t.Errorf("update %v is not filtered by scope", put.Query)
+	}
+	got := f.vars("g/dst")
+	if len(got) != 2 || got[0].Value != "default" || got[1].Value != "prod" {
+		t.Errorf("target = %v, want KEY@* untouched and KEY@production created", got)
+	}
+}
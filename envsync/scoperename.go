@@ -0,0 +1,97 @@
+package envsync
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// scopeRename rewrites environment scopes matching pattern. replacement may
+// refer to capture groups as $1 or ${name}.
+type scopeRename struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// scopeRenameFlag collects repeated --scope-rename PATTERN=REPLACEMENT flags.
+type scopeRenameFlag struct {
+	renames []scopeRename
+}
+
+func (f *scopeRenameFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	parts := make([]string, len(f.renames))
+	for i, r := range f.renames {
+		parts[i] = r.pattern.String() + "=" + r.replacement
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *scopeRenameFlag) Set(value string) error {
+	pattern, replacement, ok := strings.Cut(value, "=")
+	if !ok || pattern == "" {
+		return fmt.Errorf("invalid scope rename %q, expected PATTERN=REPLACEMENT", value)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid scope rename pattern %q: %v", pattern, err)
+	}
+	f.renames = append(f.renames, scopeRename{pattern: re, replacement: replacement})
+	return nil
+}
+
+// Strategies for --scope-collision, when renames put two variants of a key
+// into the same scope.
+const (
+	collisionFirst = "first"
+	collisionLast  = "last"
+	collisionError = "error"
+)
+
+// renameScopes applies the renames in order to every variable's scope. When
+// two variants of a key end up in the same scope, collision decides which
+// one is kept; with collisionError every collision is returned as an error.
+func renameScopes(variables []EnvVar, renames []scopeRename, collision string, explain bool) ([]EnvVar, error) {
+	result := make([]EnvVar, 0, len(variables))
+	originals := make([]string, 0, len(variables))
+	kept := map[variableKey]int{}
+	var collisions []string
+	for _, v := range variables {
+		original := normalizeScope(v.EnvironmentScope)
+		scope := original
+		for _, r := range renames {
+			if r.pattern.MatchString(scope) {
+				scope = r.pattern.ReplaceAllString(scope, r.replacement)
+			}
+		}
+		v.EnvironmentScope = normalizeScope(scope)
+		if explain && v.EnvironmentScope != original {
+			log.Printf("explain: %s@%s: scope renamed to %s", v.Key, original, v.EnvironmentScope)
+		}
+
+		i, ok := kept[keyOf(v)]
+		if !ok {
+			kept[keyOf(v)] = len(result)
+			result = append(result, v)
+			originals = append(originals, original)
+			continue
+		}
+		first := v.Key + "@" + originals[i]
+		switch collision {
+		case collisionError:
+			collisions = append(collisions, fmt.Sprintf("%s and %s@%s both become %s", first, v.Key, original, keyOf(v)))
+		case collisionLast:
+			log.Printf("Warning: %s@%s is renamed onto %s like %s, keeping the last", v.Key, original, keyOf(v), first)
+			result[i], originals[i] = v, original
+		default:
+			log.Printf("Warning: %s@%s is renamed onto %s like %s, keeping the first", v.Key, original, keyOf(v), first)
+		}
+	}
+	if len(collisions) > 0 {
+		return nil, fmt.Errorf("scope renames collide: %s", strings.Join(collisions, "; "))
+	}
+	return result, nil
+}
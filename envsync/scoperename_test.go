@@ -0,0 +1,121 @@
+package envsync
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func renames(t *testing.T, values ...string) []scopeRename {
+	t.Helper()
+	var f scopeRenameFlag
+	for _, value := range values {
+		if err := f.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f.renames
+}
+
+func TestRenameScopes(t *testing.T) {
+	variables := []EnvVar{
+		envVar("URL", "s", "staging"),
+		envVar("URL", "r", "review/feature-1"),
+		envVar("URL", "p", "production"),
+		envVar("DEBUG", "1", ""),
+	}
+	// Renames apply in order, each to the result of the one before.
+	got, err := renameScopes(variables, renames(t, `^review/(.+)$=preview/$1`, `^staging$=qa`, `^qa$=qa-eu`), collisionFirst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EnvVar{
+		envVar("URL", "s", "qa-eu"),
+		envVar("URL", "r", "preview/feature-1"),
+		envVar("URL", "p", "production"),
+		envVar("DEBUG", "1", "*"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("renameScopes = %v, want %v", got, want)
+	}
+}
+
+func TestRenameScopesNamedGroups(t *testing.T) {
+	got, err := renameScopes([]EnvVar{envVar("A", "1", "eu-prod")}, renames(t, `^(?P<region>\w+)-prod$=production/${region}`), collisionFirst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].EnvironmentScope != "production/eu" {
+		t.Errorf("scope = %q, want production/eu", got[0].EnvironmentScope)
+	}
+}
+
+func TestScopeRenameFlagErrors(t *testing.T) {
+	var f scopeRenameFlag
+	for _, value := range []string{"no-equals", "=x", "(=x"} {
+		if err := f.Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded", value)
+		}
+	}
+}
+
+// collidingVariants all become URL@production under ^(staging|qa)$=production.
+func collidingVariants() []EnvVar {
+	return []EnvVar{
+		envVar("URL", "staging", "staging"),
+		envVar("OTHER", "1", "staging"),
+		envVar("URL", "qa", "qa"),
+		envVar("URL", "prod", "production"),
+	}
+}
+
+func TestRenameScopesCollision(t *testing.T) {
+	rename := renames(t, `^(staging|qa)$=production`)
+	for _, test := range []struct {
+		collision string
+		want      string
+		log       string
+	}{
+		{collisionFirst, "staging", "URL@production is renamed onto URL@production like URL@staging, keeping the first"},
+		{collisionLast, "prod", "URL@production is renamed onto URL@production like URL@qa, keeping the last"},
+	} {
+		logs := captureLog(t)
+		got, err := renameScopes(collidingVariants(), rename, test.collision, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []EnvVar{envVar("URL", test.want, "production"), envVar("OTHER", "1", "production")}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: renameScopes = %v, want %v", test.collision, got, want)
+		}
+		if !strings.Contains(logs.String(), test.log) {
+			t.Errorf("%s: log lacks %q:\n%s", test.collision, test.log, logs)
+		}
+	}
+
+	_, err := renameScopes(collidingVariants(), rename, collisionError, false)
+	want := "scope renames collide: URL@staging and URL@qa both become URL@production; URL@staging and URL@production both become URL@production"
+	if err == nil || err.Error() != want {
+		t.Errorf("error: err = %v, want %q", err, want)
+	}
+}
+
+// With --scope-collision error nothing is written.
+func TestScopeCollisionErrorStopsRun(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = collidingVariants()
+	f.projects["g/dst"] = []EnvVar{}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--scope-rename", "^(staging|qa)$=production", "--scope-collision", "error")...)
+	if code == 0 || !strings.Contains(stderr, "URL@staging and URL@qa both become URL@production") {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v, want none", writes)
+	}
+
+	_, stderr, code = runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--scope-rename", "^qa$=production", "--scope-collision", "newest")...)
+	if code == 0 || !strings.Contains(stderr, `unknown --scope-collision "newest"`) {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+}
@@ -0,0 +1,83 @@
+package envsync
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// simulatedFailures is a RoundTripper that answers a fixed share of write
+// requests with a synthetic 503 instead of sending them. Failures are spread
+// evenly, so n writes at rate r fail exactly floor(n*r) times.
+type simulatedFailures struct {
+	next http.RoundTripper
+	rate float64
+
+	mu     sync.Mutex
+	writes int
+}
+
+func (s *simulatedFailures) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet || !s.fail() {
+		return s.next.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	body := `{"message":"503 Service Unavailable (simulated by --simulate-failures)"}`
+	return &http.Response{
+		Status:        "503 Service Unavailable",
+		StatusCode:    http.StatusServiceUnavailable,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (s *simulatedFailures) fail() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := math.Floor(float64(s.writes) * s.rate)
+	s.writes++
+	return math.Floor(float64(s.writes)*s.rate) > before
+}
+
+// WithSimulatedFailures makes the given share (0 to 1) of write requests
+// fail without reaching the server. It wraps the transport the options before
+// it configure, retries included, so it comes last and its failures are
+// never retried.
+func WithSimulatedFailures(rate float64) ClientOption {
+	return func(c *GitLabClient) {
+		next := c.httpClient.Transport
+		if next == nil {
+			next = c.transport()
+		}
+		c.httpClient.Transport = &simulatedFailures{next: next, rate: rate}
+	}
+}
+
+// checkSimulationTarget only allows --simulate-failures against a server on
+// the local machine, so it can never disturb a real instance.
+func checkSimulationTarget(rate float64, gitlabURL string) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("--simulate-failures must be between 0 and 1, got %g", rate)
+	}
+	u, err := url.Parse(gitlabURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("--simulate-failures only works against a local test server, not %s", host)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package envsync
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckSimulationTarget(t *testing.T) {
+	for _, u := range []string{"http://localhost:8080", "http://127.0.0.1:1234", "http://[::1]:80"} {
+		if err := checkSimulationTarget(0.5, u); err != nil {
+			t.Errorf("%s: %v", u, err)
+		}
+	}
+	for u, want := range map[string]string{
+		"https://gitlab.com":       "only works against a local test server, not gitlab.com",
+		"http://10.0.0.1":          "not 10.0.0.1",
+		"http://localhost.evil.io": "not localhost.evil.io",
+	} {
+		if err := checkSimulationTarget(0.5, u); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", u, err, want)
+		}
+	}
+	if err := checkSimulationTarget(1.5, "http://localhost"); err == nil {
+		t.Error("a rate above 1 was accepted")
+	}
+}
+
+// Simulated failures hit exactly the requested share of writes, never
+// reach the server and are not retried.
+func TestSimulatedFailureRate(t *testing.T) {
+	for _, rate := range []float64{0, 0.25, 0.5, 1} {
+		f := newFakeGitLab(t)
+		f.projects["g/app"] = nil
+		backoff, err := NewBackoff(backoffConstant, time.Millisecond, time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := f.client(WithRetries(3, backoff), WithSimulatedFailures(rate))
+
+		failed := 0
+		for i := 0; i < 8; i++ {
+			if err := client.CreateVariable("g/app", envVar(fmt.Sprintf("V%d", i), "1", ""), false); err != nil {
+				failed++
+			}
+		}
+		if want := int(8 * rate); failed != want {
+			t.Errorf("rate %g: %d failed, want %d", rate, failed, want)
+		}
+		if posts := len(f.received(http.MethodPost)); posts != 8-failed {
+			t.Errorf("rate %g: server got %d writes, want %d", rate, posts, 8-failed)
+		}
+		if _, err := client.GetVariables("g/app"); err != nil {
+			t.Errorf("rate %g: a read failed: %v", rate, err)
+		}
+	}
+}
+
+func TestSimulateFailuresCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	for i := 0; i < 4; i++ {
+		f.projects["g/src"] = append(f.projects["g/src"], envVar(fmt.Sprintf("V%d", i), "1", ""))
+	}
+	f.projects["g/dst"] = nil
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--simulate-failures", "0.5", "--compact-summary")...)
+	if code != exitFailure {
+		t.Errorf("exit code %d, want %d", code, exitFailure)
+	}
+	if !strings.Contains(stderr, "env-sync: created=2 updated=0 skipped=0 failed=2 pruned=0") {
+		t.Errorf("stderr:\n%s", stderr)
+	}
+	if got := f.vars("g/dst"); len(got) != 2 {
+		t.Errorf("target = %v, want two variables", got)
+	}
+}
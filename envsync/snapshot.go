@@ -0,0 +1,34 @@
+package envsync
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// Value modes accepted by --snapshot-values.
+const (
+	snapshotHash   = "hash"
+	snapshotRedact = "redact"
+)
+
+// writeSnapshot writes a .env-style snapshot meant to be committed to git:
+// one line per variable, sorted by key and scope, with the value redacted (or
+// replaced by its SHA-256) and the attributes in a trailing comment. It has
+// no timestamp, so the same variables always give the same bytes.
+func writeSnapshot(filename, project string, variables []EnvVar, values string, mode os.FileMode) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# env-sync snapshot of %s\n", project)
+	for _, v := range sortedByKey(variables) {
+		value := redactedPlaceholder
+		switch {
+		case v.Hidden:
+			value = "[hidden]"
+		case values == snapshotHash:
+			value = "sha256:" + valueChecksum(v.Value)
+		}
+		fmt.Fprintf(&buf, "%s=%s # scope=%s type=%s protected=%t masked=%t raw=%t\n",
+			v.Key, value, normalizeScope(v.EnvironmentScope), v.VariableType, v.Protected, v.Masked, v.Raw)
+	}
+	return writeOutputFile(filename, buf.Bytes(), mode)
+}
@@ -0,0 +1,95 @@
+package envsync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func snapshotDataset() []EnvVar {
+	masked := envVar("API_TOKEN", "s3cr3t", "production")
+	masked.Masked, masked.Protected = true, true
+	hidden := envVar("SIGNING_KEY", "", "")
+	hidden.Hidden = true
+	file := envVar("CONFIG", "a: 1\n", "")
+	file.VariableType = "file"
+	return []EnvVar{
+		envVar("API_URL", "https://staging", "staging"),
+		masked,
+		envVar("API_URL", "https://example.com", ""),
+		hidden,
+		file,
+	}
+}
+
+// The snapshot of the same variables is byte-identical whatever order the
+// API lists them in.
+func TestSnapshotIsStable(t *testing.T) {
+	dir := t.TempDir()
+	variables := snapshotDataset()
+	reversed := make([]EnvVar, len(variables))
+	for i, v := range variables {
+		reversed[len(variables)-1-i] = v
+	}
+
+	var outputs []string
+	for i, vars := range [][]EnvVar{variables, reversed, variables} {
+		filename := filepath.Join(dir, fmt.Sprintf("snapshot-%d.env", i))
+		if err := writeSnapshot(filename, "g/app", vars, snapshotHash, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, string(data))
+	}
+	if outputs[0] != outputs[1] || outputs[0] != outputs[2] {
+		t.Errorf("snapshots differ:\n%s\n%s", outputs[0], outputs[1])
+	}
+	checkGolden(t, "snapshot.env", []byte(outputs[0]))
+}
+
+func TestSnapshotRedact(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "snapshot.env")
+	if err := writeSnapshot(filename, "g/app", snapshotDataset(), snapshotRedact, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"s3cr3t", "https://", "sha256:", valueChecksum("s3cr3t")} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("snapshot contains %q:\n%s", secret, data)
+		}
+	}
+	if !strings.Contains(string(data), "API_TOKEN=[redacted] # scope=production type=env_var protected=true masked=true raw=false\n") {
+		t.Errorf("snapshot:\n%s", data)
+	}
+}
+
+func TestSnapshotCommand(t *testing.T) {
+	if got := testConfig(t).SnapshotValues; got != snapshotRedact {
+		t.Errorf("--snapshot-values defaults to %q, want %q", got, snapshotRedact)
+	}
+
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = snapshotDataset()
+	snapshot := filepath.Join(t.TempDir(), "app.env")
+
+	if _, stderr, code := runMain(t, "", f.args("--target", "g/app", "--snapshot", snapshot)...); code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	data, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Values are redacted unless --snapshot-values hash asks for hashes.
+	checkGolden(t, "snapshot-redact.env", data)
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v", writes)
+	}
+}
@@ -0,0 +1,260 @@
+package envsync
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// exportFormatSOPS is a YAML document for SOPS to encrypt:
+//
+//	variables:
+//	  - key: "DB_PASSWORD"
+//	    value: "..."
+//	    ...
+const exportFormatSOPS = "sops-yaml"
+
+// writeSOPSYAML writes variables as the plaintext of a sops-yaml export.
+func writeSOPSYAML(w io.Writer, variables []EnvVar) error {
+	fmt.Fprintln(w, "variables:")
+	writeVariablesYAML(w, "  ", variables)
+	return nil
+}
+
+// withoutHidden drops hidden variables, whose values cannot be exported.
+func withoutHidden(variables []EnvVar) []EnvVar {
+	var result []EnvVar
+	for _, v := range variables {
+		if v.Hidden {
+			log.Printf("Warning: not exporting %s, hidden values are not readable", keyOf(v))
+			continue
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+// writeSOPSExport encrypts a sops-yaml export with the sops binary, which
+// picks its keys from the creation rule of .sops.yaml matching filename.
+// The plaintext is only passed to sops and never written to disk.
+func writeSOPSExport(filename string, variables []EnvVar, mode os.FileMode) error {
+	var plain bytes.Buffer
+	if err := writeSOPSYAML(&plain, variables); err != nil {
+		return err
+	}
+	encrypted, err := runSOPS(&plain, "--encrypt", "--input-type", "yaml", "--output-type", "yaml", "--filename-override", filename, "/dev/stdin")
+	if err != nil {
+		return err
+	}
+	return writeOutputFile(filename, encrypted, mode)
+}
+
+// readSOPSYAML reads a sops-yaml file, decrypting it with the sops binary
+// first if decrypt is set.
+func readSOPSYAML(filename string, decrypt bool) ([]EnvVar, error) {
+	var data []byte
+	var err error
+	if decrypt {
+		data, err = runSOPS(nil, "--decrypt", "--input-type", "yaml", "--output-type", "yaml", filename)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseSOPSYAML(data, filename)
+}
+
+func runSOPS(stdin io.Reader, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("sops"); err != nil {
+		return nil, fmt.Errorf("--sops needs the sops binary in PATH: %v", err)
+	}
+	var out bytes.Buffer
+	cmd := exec.Command("sops", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("sops %s: %v", args[0], err)
+	}
+	return out.Bytes(), nil
+}
+
+// parseSOPSYAML reads the variables: list of a sops-yaml document, as
+// written by writeSOPSYAML or re-emitted by sops --decrypt. It is not a
+// general YAML parser: it understands a list of flat mappings whose values
+// are plain, quoted or literal block (|) scalars. Other top-level keys,
+// such as the sops: metadata, are ignored. Attributes a variable leaves out
+// are marked unspecified, as for a .env import.
+func parseSOPSYAML(data []byte, name string) ([]EnvVar, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	var variables []EnvVar
+	var current *EnvVar
+	inList, itemIndent := false, -1
+	finish := func() {
+		if current != nil {
+			variables = append(variables, *current)
+			current = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent == 0 && !strings.HasPrefix(trimmed, "-") {
+			finish()
+			inList = trimmed == "variables:"
+			continue
+		}
+		if !inList {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			finish()
+			current = &EnvVar{EnvironmentScope: defaultScope, unspecified: attrAll}
+			itemIndent = indent + 2
+			trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))
+			if trimmed == "" {
+				continue
+			}
+		} else if current == nil || indent != itemIndent {
+			return nil, fmt.Errorf("%s:%d: expected a list item or field of a variable", name, lineNo)
+		}
+
+		field, raw, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected field: value", name, lineNo)
+		}
+		raw = strings.TrimSpace(raw)
+		var value string
+		var err error
+		if strings.HasPrefix(raw, "|") {
+			value, i, err = yamlBlock(lines, i+1, itemIndent, raw)
+		} else {
+			value, err = yamlScalar(raw)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", name, lineNo, field, err)
+		}
+		if err := setSOPSField(current, field, value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, lineNo, err)
+		}
+	}
+	finish()
+
+	for _, v := range variables {
+		if v.Key == "" {
+			return nil, fmt.Errorf("%s: variable without key", name)
+		}
+		if strings.HasPrefix(v.Value, "ENC[") {
+			return nil, fmt.Errorf("%s: %s is still SOPS-encrypted; pass --sops or decrypt it with sops --decrypt", name, keyOf(v))
+		}
+	}
+	return variables, nil
+}
+
+func setSOPSField(v *EnvVar, field, value string) error {
+	boolField := func(target *bool, attr attribute) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: expected true or false, got %q", field, value)
+		}
+		*target = b
+		v.unspecified &^= attr
+		return nil
+	}
+	switch field {
+	case "key":
+		v.Key = value
+	case "value":
+		v.Value = value
+	case "environment_scope":
+		v.EnvironmentScope = normalizeScope(value)
+	case "description":
+		v.Description = value
+	case "variable_type":
+		if value != "" {
+			v.VariableType = value
+			v.unspecified &^= attrVariableType
+		}
+	case "protected":
+		return boolField(&v.Protected, attrProtected)
+	case "masked":
+		return boolField(&v.Masked, attrMasked)
+	case "raw":
+		return boolField(&v.Raw, attrRaw)
+	}
+	return nil
+}
+
+// yamlScalar decodes a plain, single- or double-quoted YAML scalar.
+func yamlScalar(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("unterminated single-quoted value")
+		}
+		return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), nil
+	case raw == "~" || raw == "null":
+		return "", nil
+	}
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = strings.TrimSpace(raw[:i])
+	}
+	return raw, nil
+}
+
+// yamlBlock decodes the literal block scalar starting at lines[start], whose
+// header is |, |- or |+. It returns the value and the index of its last
+// line.
+func yamlBlock(lines []string, start, parentIndent int, header string) (string, int, error) {
+	chomp := strings.TrimPrefix(header, "|")
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return "", 0, fmt.Errorf("unsupported block scalar header %q", header)
+	}
+	var block []string
+	indent := -1
+	end := start - 1
+	for i := start; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			block = append(block, "")
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " "))
+		if indent < 0 {
+			indent = n
+		}
+		if n < indent || n <= parentIndent {
+			break
+		}
+		block = append(block, line[indent:])
+		end = i
+	}
+	// Blank lines after the last content line belong to what follows.
+	block = block[:end-start+1]
+	value := strings.Join(block, "\n")
+	switch chomp {
+	case "":
+		value += "\n"
+	case "+":
+		for i := end + 1; i < len(lines) && strings.TrimSpace(lines[i]) == ""; i++ {
+			value += "\n"
+		}
+		value += "\n"
+	}
+	return value, end, nil
+}
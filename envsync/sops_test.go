@@ -0,0 +1,218 @@
+package envsync
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sopsDataset() []EnvVar {
+	password := envVar("DB_PASSWORD", `p@ss"word\`, "production")
+	password.Protected, password.Masked = true, true
+	cert := envVar("TLS_CERT", "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n", "")
+	cert.VariableType = "file"
+	template := envVar("TEMPLATE", "$HOME: #1 'x'", "")
+	template.Raw = true
+	template.Description = "Not expanded"
+	return []EnvVar{envVar("APP_ENV", "production", ""), password, cert, template}
+}
+
+// The plaintext layout that sops encrypts.
+func TestSOPSExport(t *testing.T) {
+	f := newFakeGitLab(t)
+	hidden := envVar("HIDDEN", "", "")
+	hidden.Hidden = true
+	f.projects["g/src"] = append(sopsDataset(), hidden)
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/src", "--export", "-", "--export-format", "sops-yaml", "--show-values")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	checkGolden(t, "sops.yaml", []byte(stdout))
+	if !strings.Contains(stderr, "not exporting HIDDEN@*") {
+		t.Errorf("stderr does not mention the hidden variable:\n%s", stderr)
+	}
+}
+
+func TestSOPSExportNeedsShowValues(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = sopsDataset()
+
+	stdout, stderr, code := runMain(t, "", f.args("--source", "g/src", "--export", "-", "--export-format", "sops-yaml")...)
+	if code == 0 || !strings.Contains(stderr, "pass --show-values") {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if strings.Contains(stdout, "p@ss") {
+		t.Errorf("values were written:\n%s", stdout)
+	}
+}
+
+func TestSOPSRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeSOPSYAML(&buf, sopsDataset()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseSOPSYAML(buf.Bytes(), "export.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sopsDataset()
+	if len(got) != len(want) {
+		t.Fatalf("read %d variables, want %d", len(got), len(want))
+	}
+	for i := range want {
+		got[i].unspecified = 0
+		want[i].EnvironmentScope = normalizeScope(want[i].EnvironmentScope)
+		if got[i] != want[i] {
+			t.Errorf("variable %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// What sops --decrypt re-emits: its own quoting, block scalars, comments
+// and the sops: metadata, which is ignored.
+func TestParseSOPSDecrypted(t *testing.T) {
+	data := `variables:
+    # the certificate
+    - key: TLS_CERT
+      value: |
+        line 1
+
+        line 3
+      variable_type: file
+    - key: 'IT''S'
+      value: plain text # comment
+      masked: true
+    -
+      key: KEPT
+      value: |-
+        no newline
+      environment_scope: production
+sops:
+    age:
+        - recipient: age1xyz
+    version: 3.9.0
+`
+	got, err := parseSOPSYAML([]byte(data), "decrypted.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("variables = %+v", got)
+	}
+	if got[0].Value != "line 1\n\nline 3\n" || got[0].VariableType != "file" {
+		t.Errorf("TLS_CERT = %+v", got[0])
+	}
+	if got[1].Key != "IT'S" || got[1].Value != "plain text" || !got[1].Masked {
+		t.Errorf("IT'S = %+v", got[1])
+	}
+	if got[1].unspecified&attrMasked != 0 || got[1].unspecified&attrProtected == 0 {
+		t.Errorf("IT'S should have masked set and protected unspecified: %b", got[1].unspecified)
+	}
+	if got[2].Value != "no newline" || got[2].EnvironmentScope != "production" {
+		t.Errorf("KEPT = %+v", got[2])
+	}
+}
+
+func TestParseSOPSErrors(t *testing.T) {
+	for _, test := range []struct {
+		data, want string
+	}{
+		{"variables:\n  - key: A\n    value: ENC[AES256_GCM,data:abc]\n", "A@* is still SOPS-encrypted"},
+		{"variables:\n  - value: x\n", "variable without key"},
+		{"variables:\n  - key: A\n    masked: maybe\n", "x.yaml:3: masked: expected true or false"},
+		{"variables:\n  - key: A\n      value: x\n", "x.yaml:3: expected a list item"},
+		{"variables:\n  - key A\n", "x.yaml:2: expected field: value"},
+		{"variables:\n  - key: \"A\n", "x.yaml:2: key:"},
+	} {
+		if _, err := parseSOPSYAML([]byte(test.data), "x.yaml"); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("parseSOPSYAML(%q) = %v, want %q", test.data, err, test.want)
+		}
+	}
+}
+
+// fakeSOPS puts a sops script in PATH that "encrypts" by prefixing a marker
+// line and "decrypts" by stripping it, recording its arguments.
+func fakeSOPS(t *testing.T) (argsFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile = filepath.Join(dir, "args")
+	script := `#!/bin/sh
+echo "$@" >> ` + argsFile + `
+case "$1" in
+--encrypt) echo "# ENCRYPTED"; cat ;;
+--decrypt) grep -v '^# ENCRYPTED$' "$6" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "sops"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+// With --sops only sops's output reaches the file, and importing it with
+// --sops decrypts it back to the same variables.
+func TestSOPSEncryptAndImport(t *testing.T) {
+	argsFile := fakeSOPS(t)
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = sopsDataset()
+	f.projects["g/dst"] = []EnvVar{}
+	filename := filepath.Join(t.TempDir(), "vars.enc.yaml")
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--export", filename, "--export-format", "sops-yaml", "--show-values", "--sops")...)
+	if code != 0 {
+		t.Fatalf("export: exit code %d; stderr:\n%s", code, stderr)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# ENCRYPTED\nvariables:\n") {
+		t.Errorf("export was not passed through sops:\n%s", data)
+	}
+
+	_, stderr, code = runMain(t, "", f.args("--import", filename, "--sops", "--target", "g/dst")...)
+	if code != 0 {
+		t.Fatalf("import: exit code %d; stderr:\n%s", code, stderr)
+	}
+	got := map[variableKey]EnvVar{}
+	for _, v := range f.vars("g/dst") {
+		got[keyOf(v)] = v
+	}
+	for _, want := range sopsDataset() {
+		v := got[keyOf(want)]
+		if v.Value != want.Value || v.VariableType != want.VariableType || v.Masked != want.Masked || v.Protected != want.Protected || v.Raw != want.Raw {
+			t.Errorf("%s = %+v, want %+v", keyOf(want), v, want)
+		}
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArgs := "--encrypt --input-type yaml --output-type yaml --filename-override " + filename + " /dev/stdin\n" +
+		"--decrypt --input-type yaml --output-type yaml " + filename + "\n"
+	if string(args) != wantArgs {
+		t.Errorf("sops was run with:\n%s\nwant:\n%s", args, wantArgs)
+	}
+}
+
+func TestSOPSFlagChecks(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = sopsDataset()
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--export", "out.env", "--sops"}, "--sops needs --export-format sops-yaml"},
+		{[]string{"--export", "-", "--export-format", "sops-yaml", "--show-values", "--sops"}, "cannot export to stdout"},
+	} {
+		_, stderr, code := runMain(t, "", f.args(append([]string{"--source", "g/src"}, test.args...)...)...)
+		if code == 0 || !strings.Contains(stderr, test.want) {
+			t.Errorf("%v: exit code %d; stderr:\n%s", test.args, code, stderr)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stateEntry is what env-sync last wrote to one target variable. Values are
+// only kept as checksums.
+type stateEntry struct {
+	ValueSHA256  string `json:"value_sha256"`
+	VariableType string `json:"variable_type"`
+	Protected    bool   `json:"protected"`
+	Masked       bool   `json:"masked"`
+	Raw          bool   `json:"raw"`
+	SyncedAt     string `json:"synced_at"`
+}
+
+func newStateEntry(v EnvVar, syncedAt string) stateEntry {
+	return stateEntry{
+		ValueSHA256:  valueChecksum(v.Value),
+		VariableType: normalizeVariableType(v.VariableType),
+		Protected:    v.Protected,
+		Masked:       v.Masked,
+		Raw:          v.Raw,
+		SyncedAt:     syncedAt,
+	}
+}
+
+// syncState is the --state-file: per target, the variables env-sync manages
+// there, keyed by KEY@scope.
+type syncState struct {
+	Targets map[string]map[string]stateEntry `json:"targets"`
+
+	// Sources holds, per source, a snapshot of its variables with the time
+	// each last changed, for --changed-since-sync.
+	Sources map[string]map[string]sourceEntry `json:"sources,omitempty"`
+}
+
+// readStateFile reads a state file. A missing file is an empty state.
+func readStateFile(filename string) (*syncState, error) {
+	state := &syncState{Targets: map[string]map[string]stateEntry{}}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", filename, err)
+	}
+	if state.Targets == nil {
+		state.Targets = map[string]map[string]stateEntry{}
+	}
+	return state, nil
+}
+
+func writeStateFile(filename string, state *syncState, mode os.FileMode) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, append(data, '\n'), mode)
+}
+
+// record updates the state after a variable was handled by a live run.
+// Unchanged variables keep the time they were last written.
+func (s *syncState) record(targetProject string, v EnvVar, result outcome) {
+	key := keyOf(v).String()
+	entries := s.Targets[targetProject]
+	if entries == nil {
+		entries = map[string]stateEntry{}
+		s.Targets[targetProject] = entries
+	}
+	switch result {
+	case outcomeCreated, outcomeUpdated:
+		entries[key] = newStateEntry(v, time.Now().Format(time.RFC3339Nano))
+	case outcomeUnchanged:
+		syncedAt := time.Now().Format(time.RFC3339Nano)
+		if previous, ok := entries[key]; ok && previous.SyncedAt != "" {
+			syncedAt = previous.SyncedAt
+		}
+		entries[key] = newStateEntry(v, syncedAt)
+	case outcomeDeleted:
+		delete(entries, key)
+	}
+}
+
+// detectDrift compares a target's variables with what env-sync last wrote
+// there and describes every out-of-band change.
+func detectDrift(entries map[string]stateEntry, targetVars []EnvVar) []string {
+	current := map[string]EnvVar{}
+	for _, v := range targetVars {
+		current[keyOf(v).String()] = v
+	}
+
+	var drift []string
+	for key, entry := range entries {
+		v, ok := current[key]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("%s: deleted outside env-sync (last synced %s)", key, entry.SyncedAt))
+			continue
+		}
+		actual := newStateEntry(v, entry.SyncedAt)
+		var changed []string
+		if actual.ValueSHA256 != entry.ValueSHA256 {
+			changed = append(changed, fieldValue)
+		}
+		if actual.VariableType != normalizeVariableType(entry.VariableType) {
+			changed = append(changed, fieldVariableType)
+		}
+		if actual.Protected != entry.Protected {
+			changed = append(changed, fieldProtected)
+		}
+		if actual.Masked != entry.Masked {
+			changed = append(changed, fieldMasked)
+		}
+		if actual.Raw != entry.Raw {
+			changed = append(changed, fieldRaw)
+		}
+		if len(changed) > 0 {
+			drift = append(drift, fmt.Sprintf("%s: %s changed outside env-sync (last synced %s)", key, strings.Join(changed, ", "), entry.SyncedAt))
+		}
+	}
+	sort.Strings(drift)
+	return drift
+}
+
+// runDetectDrift checks every target against the state file and returns the
+// process exit code.
+func runDetectDrift(client *GitLabClient, cfg *config, state *syncState, targets []string) int {
+	total := 0
+	for _, target := range targets {
+		entries := state.Targets[target]
+		if len(entries) == 0 {
+			log.Printf("No synced variables recorded for %s in %s", target, cfg.StateFile)
+			continue
+		}
+		log.Printf("Fetching variables from target project: %s", target)
+		targetVars, err := client.GetVariables(target)
+		if isAuthError(err) {
+			exitAuth(err)
+		}
+		if err != nil {
+			log.Fatalf("Error getting variables from target project: %v", err)
+		}
+		drift := detectDrift(entries, targetVars)
+		for _, d := range drift {
+			if len(targets) > 1 {
+				fmt.Printf("%s: %s\n", target, d)
+			} else {
+				fmt.Println(d)
+			}
+		}
+		total += len(drift)
+	}
+
+	if total == 0 {
+		log.Printf("No drift: managed variables match what env-sync last synced")
+		return 0
+	}
+	log.Printf("Found %d drifted variables", total)
+	if cfg.FailOnDrift {
+		return exitMismatch
+	}
+	return 0
+}
+
+// sourceEntry is a source variable as last seen by a run with a state file,
+// and when it was first seen that way.
+type sourceEntry struct {
+	ValueSHA256  string `json:"value_sha256"`
+	VariableType string `json:"variable_type"`
+	Protected    bool   `json:"protected"`
+	Masked       bool   `json:"masked"`
+	Raw          bool   `json:"raw"`
+	ChangedAt    string `json:"changed_at"`
+}
+
+func newSourceEntry(v EnvVar, changedAt string) sourceEntry {
+	return sourceEntry{
+		ValueSHA256:  valueChecksum(v.Value),
+		VariableType: v.VariableType,
+		Protected:    v.Protected,
+		Masked:       v.Masked,
+		Raw:          v.Raw,
+		ChangedAt:    changedAt,
+	}
+}
+
+// observeSource updates the snapshot of source. Variables that are new or
+// differ from the snapshot are stamped with the current time; the others
+// keep the time they last changed. Variables no longer in the source are
+// dropped.
+func (s *syncState) observeSource(source string, variables []EnvVar) {
+	if s.Sources == nil {
+		s.Sources = map[string]map[string]sourceEntry{}
+	}
+	previous := s.Sources[source]
+	now := time.Now().Format(time.RFC3339Nano)
+	entries := make(map[string]sourceEntry, len(variables))
+	for _, v := range variables {
+		key := keyOf(v).String()
+		entry := newSourceEntry(v, now)
+		if old, ok := previous[key]; ok && old.ChangedAt != "" && newSourceEntry(v, old.ChangedAt) == old {
+			entry = old
+		}
+		entries[key] = entry
+	}
+	s.Sources[source] = entries
+}
+
+// changedSinceSync keeps the variables whose source snapshot changed after
+// they were last written to targetProject, and those never written there.
+func (s *syncState) changedSinceSync(source, targetProject string, variables []EnvVar, explain bool) []EnvVar {
+	sources := s.Sources[source]
+	targets := s.Targets[targetProject]
+	return filterVariables(variables, func(v EnvVar) bool {
+		key := keyOf(v).String()
+		synced, ok := targets[key]
+		if !ok {
+			return true
+		}
+		seen, ok := sources[key]
+		if !ok {
+			return true
+		}
+		return newerThan(seen.ChangedAt, synced.SyncedAt)
+	}, "unchanged since last sync", explain)
+}
+
+// newerThan compares two RFC 3339 timestamps. They are written with
+// sub-second precision, since a source is observed and synced within the
+// same second. Unparsable ones count as newer so the variable is synced
+// rather than silently skipped.
+func newerThan(changedAt, syncedAt string) bool {
+	changed, err := time.Parse(time.RFC3339, changedAt)
+	if err != nil {
+		return true
+	}
+	synced, err := time.Parse(time.RFC3339, syncedAt)
+	if err != nil {
+		return true
+	}
+	return changed.After(synced)
+}
@@ -0,0 +1,170 @@
+package envsync
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectDrift(t *testing.T) {
+	masked := envVar("MASKED", "1", "")
+	masked.Masked = true
+	entries := map[string]stateEntry{
+		"CLEAN@*":   newStateEntry(envVar("CLEAN", "1", ""), "t0"),
+		"VALUE@*":   newStateEntry(envVar("VALUE", "1", ""), "t0"),
+		"MASKED@*":  newStateEntry(masked, "t0"),
+		"DELETED@*": newStateEntry(envVar("DELETED", "1", ""), "t0"),
+	}
+	targetVars := []EnvVar{
+		envVar("CLEAN", "1", ""),
+		envVar("VALUE", "edited", ""),
+		envVar("MASKED", "1", ""),
+		envVar("UNMANAGED", "1", ""),
+	}
+	got := detectDrift(entries, targetVars)
+	want := []string{
+		"DELETED@*: deleted outside env-sync (last synced t0)",
+		"MASKED@*: masked changed outside env-sync (last synced t0)",
+		"VALUE@*: value changed outside env-sync (last synced t0)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("drift:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if drift := detectDrift(entries, []EnvVar{envVar("CLEAN", "1", "*"), envVar("VALUE", "1", ""), masked, envVar("DELETED", "1", "")}); len(drift) != 0 {
+		t.Errorf("clean target has drift %v", drift)
+	}
+}
+
+// A live run records what it wrote; --detect-drift then reports manual
+// edits to those variables only.
+func TestDetectDriftCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("MANUAL", "1", "")}
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--state-file", stateFile)...); code != 0 {
+		t.Fatalf("sync: exit code %d; stderr:\n%s", code, stderr)
+	}
+	detect := f.args("--target", "g/dst", "--state-file", stateFile, "--detect-drift", "--fail-on-drift")
+	stdout, stderr, code := runMain(t, "", detect...)
+	if code != 0 || stdout != "" || !strings.Contains(stderr, "No drift") {
+		t.Fatalf("clean target: exit code %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+
+	f.mu.Lock()
+	for i, v := range f.projects["g/dst"] {
+		if v.Key == "B" || v.Key == "MANUAL" {
+			f.projects["g/dst"][i].Value = "edited"
+		}
+	}
+	f.mu.Unlock()
+
+	stdout, stderr, code = runMain(t, "", detect...)
+	if code != exitMismatch {
+		t.Errorf("exit code %d, want %d; stderr:\n%s", code, exitMismatch, stderr)
+	}
+	if !strings.HasPrefix(stdout, "B@*: value changed outside env-sync") || strings.Count(stdout, "\n") != 1 {
+		t.Errorf("stdout = %q, want only B@* reported", stdout)
+	}
+	if _, _, code := runMain(t, "", detect[:len(detect)-1]...); code != 0 {
+		t.Errorf("without --fail-on-drift: exit code %d, want 0", code)
+	}
+}
+
+func TestNewerThan(t *testing.T) {
+	for _, test := range []struct {
+		changed, synced string
+		want            bool
+	}{
+		{"2026-01-02T10:00:00.5Z", "2026-01-02T10:00:00.2Z", true},
+		{"2026-01-02T10:00:00.2Z", "2026-01-02T10:00:00.5Z", false},
+		{"2026-01-02T10:00:00Z", "2026-01-02T10:00:00Z", false},
+		{"2026-01-02T12:00:00+02:00", "2026-01-02T10:30:00Z", false},
+		{"garbage", "2026-01-02T10:00:00Z", true},
+		{"2026-01-02T10:00:00Z", "", true},
+	} {
+		if got := newerThan(test.changed, test.synced); got != test.want {
+			t.Errorf("newerThan(%q, %q) = %t, want %t", test.changed, test.synced, got, test.want)
+		}
+	}
+}
+
+func TestChangedSinceSync(t *testing.T) {
+	const (
+		early  = "2026-01-01T00:00:00Z"
+		synced = "2026-01-02T00:00:00Z"
+		late   = "2026-01-03T00:00:00Z"
+	)
+	state := &syncState{
+		Targets: map[string]map[string]stateEntry{"g/dst": {
+			"OLD@*":     {SyncedAt: synced},
+			"CHANGED@*": {SyncedAt: synced},
+			"UNSEEN@*":  {SyncedAt: synced},
+			"EXACTLY@*": {SyncedAt: synced},
+		}},
+		Sources: map[string]map[string]sourceEntry{"g/src": {
+			"OLD@*":     {ChangedAt: early},
+			"CHANGED@*": {ChangedAt: late},
+			"NEVER@*":   {ChangedAt: early},
+			"EXACTLY@*": {ChangedAt: synced},
+		}},
+	}
+	variables := []EnvVar{envVar("OLD", "", ""), envVar("CHANGED", "", ""), envVar("NEVER", "", ""), envVar("UNSEEN", "", ""), envVar("EXACTLY", "", "")}
+
+	var got []string
+	for _, v := range state.changedSinceSync("g/src", "g/dst", variables, false) {
+		got = append(got, v.Key)
+	}
+	if want := "CHANGED,NEVER,UNSEEN"; strings.Join(got, ",") != want {
+		t.Errorf("kept %v, want %s", got, want)
+	}
+}
+
+func TestObserveSourceKeepsChangeTime(t *testing.T) {
+	state := &syncState{}
+	state.observeSource("g/src", []EnvVar{envVar("A", "1", ""), envVar("B", "1", ""), envVar("GONE", "1", "")})
+	first := state.Sources["g/src"]
+
+	time.Sleep(time.Millisecond)
+	state.observeSource("g/src", []EnvVar{envVar("A", "1", ""), envVar("B", "2", "")})
+	second := state.Sources["g/src"]
+	if second["A@*"] != first["A@*"] {
+		t.Errorf("unchanged A was restamped: %v -> %v", first["A@*"], second["A@*"])
+	}
+	if !newerThan(second["B@*"].ChangedAt, first["B@*"].ChangedAt) {
+		t.Errorf("changed B kept %s", second["B@*"].ChangedAt)
+	}
+	if _, ok := second["GONE@*"]; ok {
+		t.Error("a variable no longer in the source was kept")
+	}
+}
+
+// After a full sync, --changed-since-sync only writes what changed in the
+// source since.
+func TestChangedSinceSyncCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), envVar("B", "1", "")}
+	f.projects["g/dst"] = nil
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	args := f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--state-file", stateFile, "--changed-since-sync")
+
+	if _, stderr, code := runMain(t, "", args...); code != 0 {
+		t.Fatalf("first run: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if got := f.vars("g/dst"); len(got) != 2 {
+		t.Fatalf("target = %v, want both variables synced", got)
+	}
+
+	f.mu.Lock()
+	f.projects["g/src"][1].Value = "2"
+	f.requests = nil
+	f.mu.Unlock()
+	if _, stderr, code := runMain(t, "", args...); code != 0 {
+		t.Fatalf("second run: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if writes := f.writes(); len(writes) != 1 || writes[0] != "PUT projects/g%2Fdst/variables/B" {
+		t.Errorf("writes = %v, want only B", writes)
+	}
+}
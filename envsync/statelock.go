@@ -0,0 +1,28 @@
+package envsync
+
+import (
+	"fmt"
+	"log"
+)
+
+// stateLock is the target state a plan was computed against. --apply refuses
+// to run when the target has changed since, unless --force is given.
+type stateLock struct {
+	TargetProject string
+	Hash          string
+}
+
+// checkStateLock compares the target's current variables with the state
+// recorded in the plan and returns an error on drift unless force is set.
+func checkStateLock(lock *stateLock, targetVars []EnvVar, force bool) error {
+	current := fingerprint(targetVars)
+	if current == lock.Hash {
+		log.Printf("Target %s is unchanged since the plan was written", lock.TargetProject)
+		return nil
+	}
+	if force {
+		log.Printf("Warning: target %s changed since the plan was written, applying anyway (--force)", lock.TargetProject)
+		return nil
+	}
+	return fmt.Errorf("target %s changed since the plan was written; re-run the plan or use --force to apply anyway", lock.TargetProject)
+}
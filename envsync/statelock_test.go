@@ -0,0 +1,65 @@
+package envsync
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// planWithLock writes an --upsert plan, which records the target's state.
+func planWithLock(t *testing.T, f *fakeGitLab) string {
+	t.Helper()
+	plan := filepath.Join(t.TempDir(), "plan.json")
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--dry-run", "--output", plan)...); code != 0 {
+		t.Fatalf("plan: exit code %d; stderr:\n%s", code, stderr)
+	}
+	output, err := readDryRunOutput(plan, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.TargetStateHash == "" {
+		t.Fatal("the plan did not record the target state")
+	}
+	return plan
+}
+
+func TestApplyUnchangedTarget(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "new", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("A", "old", ""), envVar("B", "1", "")}
+	plan := planWithLock(t, f)
+
+	_, stderr, code := runMain(t, "", f.args("--apply", plan, "--upsert", "--yes")...)
+	if code != 0 {
+		t.Fatalf("apply: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Target g/dst is unchanged since the plan was written") {
+		t.Errorf("stderr lacks the state check:\n%s", stderr)
+	}
+	if got := f.vars("g/dst")[0]; got.Value != "new" {
+		t.Errorf("A = %q, want new", got.Value)
+	}
+}
+
+func TestApplyDriftedTarget(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "new", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("A", "old", ""), envVar("B", "1", "")}
+	plan := planWithLock(t, f)
+	f.projects["g/dst"][1].Value = "edited by hand"
+
+	_, stderr, code := runMain(t, "", f.args("--apply", plan, "--upsert", "--yes")...)
+	if code == 0 || !strings.Contains(stderr, "target g/dst changed since the plan was written") {
+		t.Fatalf("drifted apply: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("drifted apply wrote %v", writes)
+	}
+
+	if _, stderr, code := runMain(t, "", f.args("--apply", plan, "--upsert", "--yes", "--force")...); code != 0 {
+		t.Fatalf("forced apply: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if got := f.vars("g/dst"); got[0].Value != "new" || got[1].Value != "edited by hand" {
+		t.Errorf("target = %v", got)
+	}
+}
@@ -0,0 +1,120 @@
+package envsync
+
+import (
+	"fmt"
+	"time"
+)
+
+// runSummary is the machine-readable outcome of a run.
+type runSummary struct {
+	Timestamp      string   `json:"timestamp"`
+	SourceProject  string   `json:"source_project"`
+	TargetProject  string   `json:"target_project"`
+	DryRun         bool     `json:"dry_run"`
+	Total          int      `json:"total"`
+	Transferred    int      `json:"transferred"`
+	Created        int      `json:"created"`
+	Updated        int      `json:"updated"`
+	Unchanged      int      `json:"unchanged"`
+	Skipped        int      `json:"skipped"`
+	Pruned         int      `json:"pruned"`
+	RolledBack     int      `json:"rolled_back"`
+	RollbackFailed int      `json:"rollback_failed"`
+	Failed         int      `json:"failed"`
+	FailedKeys     []string `json:"failed_keys"`
+	TimedOut       int      `json:"timed_out"`
+	TimedOutKeys   []string `json:"timed_out_keys"`
+	Duration       float64  `json:"duration_seconds"`
+
+	// Provenance maps KEY@scope to the source each variable came from when
+	// --overlay was used.
+	Provenance map[string]string `json:"provenance,omitempty"`
+
+	// Targets holds the per-target summaries of a multi-target run.
+	Targets []*runSummary `json:"targets,omitempty"`
+
+	started time.Time
+}
+
+func newRunSummary(sourceProject, targetProject string, dryRun bool) *runSummary {
+	now := time.Now()
+	return &runSummary{
+		Timestamp:     now.Format(time.RFC3339),
+		SourceProject: sourceProject,
+		TargetProject: targetProject,
+		DryRun:        dryRun,
+		FailedKeys:    []string{},
+		TimedOutKeys:  []string{},
+		started:       now,
+	}
+}
+
+// finish records the run's duration.
+func (s *runSummary) finish() {
+	s.Duration = time.Since(s.started).Seconds()
+}
+
+func (s *runSummary) record(v EnvVar, result outcome, err error) {
+	switch result {
+	case outcomeCreated:
+		s.Transferred++
+		s.Created++
+	case outcomeUpdated:
+		s.Transferred++
+		s.Updated++
+	case outcomeUnchanged:
+		s.Unchanged++
+	case outcomeSkipped:
+		s.Skipped++
+	case outcomeDeleted:
+		s.Pruned++
+	case outcomeFailed:
+		s.Failed++
+		s.FailedKeys = append(s.FailedKeys, keyOf(v).String())
+		if isTimeout(err) {
+			s.TimedOut++
+			s.TimedOutKeys = append(s.TimedOutKeys, keyOf(v).String())
+		}
+	}
+}
+
+// compact renders the summary as a single greppable line, e.g.
+//
+//	env-sync: created=5 updated=3 skipped=2 failed=0 pruned=1 duration=4.2s target=group/project
+func (s *runSummary) compact() string {
+	return fmt.Sprintf("env-sync: created=%d updated=%d skipped=%d failed=%d pruned=%d duration=%.1fs target=%s",
+		s.Created, s.Updated, s.Skipped, s.Failed, s.Pruned, s.Duration, s.TargetProject)
+}
+
+// breakdown lists the outcome counts, e.g. "5 created, 3 updated, 2
+// unchanged, 0 skipped, 0 failed". Pruned variables are only mentioned if
+// there were any.
+func (s *runSummary) breakdown() string {
+	text := fmt.Sprintf("%d created, %d updated, %d unchanged, %d skipped, %d failed", s.Created, s.Updated, s.Unchanged, s.Skipped, s.Failed)
+	if s.Pruned > 0 {
+		text += fmt.Sprintf(", %d pruned", s.Pruned)
+	}
+	return text
+}
+
+// add folds a per-target summary into a multi-target one.
+func (s *runSummary) add(target *runSummary) {
+	s.Total += target.Total
+	s.Transferred += target.Transferred
+	s.Created += target.Created
+	s.Updated += target.Updated
+	s.Unchanged += target.Unchanged
+	s.Skipped += target.Skipped
+	s.Pruned += target.Pruned
+	s.RolledBack += target.RolledBack
+	s.RollbackFailed += target.RollbackFailed
+	s.Failed += target.Failed
+	s.TimedOut += target.TimedOut
+	for _, key := range target.FailedKeys {
+		s.FailedKeys = append(s.FailedKeys, target.TargetProject+":"+key)
+	}
+	for _, key := range target.TimedOutKeys {
+		s.TimedOutKeys = append(s.TimedOutKeys, target.TargetProject+":"+key)
+	}
+	s.Targets = append(s.Targets, target)
+}
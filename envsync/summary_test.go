@@ -0,0 +1,111 @@
+package envsync
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCompactSummary(t *testing.T) {
+	s := newRunSummary("g/src", "group/project", false)
+	for _, r := range []outcome{outcomeCreated, outcomeCreated, outcomeUpdated, outcomeUnchanged, outcomeSkipped, outcomeDeleted} {
+		s.record(envVar("A", "", ""), r, nil)
+	}
+	s.record(envVar("BAD", "", ""), outcomeFailed, errVariableTimeout)
+	s.Duration = 4.25
+
+	want := "env-sync: created=2 updated=1 skipped=1 failed=1 pruned=1 duration=4.2s target=group/project"
+	if got := s.compact(); got != want {
+		t.Errorf("compact() = %q, want %q", got, want)
+	}
+	if s.TimedOut != 1 || s.Transferred != 3 || s.FailedKeys[0] != "BAD@*" {
+		t.Errorf("summary = %+v", s)
+	}
+}
+
+// The compact line is the last thing written, whatever the outcomes.
+func TestCompactSummaryCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	hidden := envVar("HIDDEN", "", "")
+	hidden.Hidden = true
+	f.projects["g/src"] = []EnvVar{envVar("NEW", "1", ""), envVar("CHANGED", "new", ""), envVar("BAD", "1", ""), hidden}
+	f.projects["g/dst"] = []EnvVar{envVar("CHANGED", "old", ""), envVar("STALE", "1", "")}
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost && strings.Contains(r.Body, `"key":"BAD"`) {
+			return http.StatusForbidden, `{"message":"403 Forbidden"}`
+		}
+		return 0, ""
+	}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--prune", "--yes", "--compact-summary")...)
+	if code != exitFailure {
+		t.Errorf("exit code %d, want %d", code, exitFailure)
+	}
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	last := lines[len(lines)-1]
+	pattern := regexp.MustCompile(`^env-sync: created=1 updated=1 skipped=1 failed=1 pruned=1 duration=\d+\.\ds target=g/dst$`)
+	if !pattern.MatchString(last) {
+		t.Errorf("last line = %q, want the compact summary; stderr:\n%s", last, stderr)
+	}
+}
+
+func TestBreakdown(t *testing.T) {
+	s := newRunSummary("g/src", "g/dst", false)
+	for _, r := range []outcome{outcomeCreated, outcomeCreated, outcomeCreated, outcomeUpdated, outcomeUpdated, outcomeUnchanged, outcomeSkipped, outcomeFailed} {
+		s.record(envVar("A", "", ""), r, nil)
+	}
+	if got, want := s.breakdown(), "3 created, 2 updated, 1 unchanged, 1 skipped, 1 failed"; got != want {
+		t.Errorf("breakdown() = %q, want %q", got, want)
+	}
+	s.record(envVar("OLD", "", ""), outcomeDeleted, nil)
+	if got, want := s.breakdown(), "3 created, 2 updated, 1 unchanged, 1 skipped, 1 failed, 1 pruned"; got != want {
+		t.Errorf("breakdown() with a prune = %q, want %q", got, want)
+	}
+}
+
+// The final log line and the JSON summary report the same count for every
+// outcome.
+func TestOutcomeCounts(t *testing.T) {
+	var body []byte
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer webhook.Close()
+
+	f := newFakeGitLab(t)
+	hidden := envVar("HIDDEN", "", "")
+	hidden.Hidden = true
+	f.projects["g/src"] = []EnvVar{
+		envVar("NEW1", "1", ""), envVar("NEW2", "2", ""),
+		envVar("CHANGED", "new", ""),
+		envVar("SAME1", "x", ""), envVar("SAME2", "y", ""), envVar("SAME3", "z", ""),
+		envVar("BAD", "1", ""), hidden,
+	}
+	f.projects["g/dst"] = []EnvVar{envVar("CHANGED", "old", ""), envVar("SAME1", "x", ""), envVar("SAME2", "y", ""), envVar("SAME3", "z", "")}
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost && strings.Contains(r.Body, `"key":"BAD"`) {
+			return http.StatusForbidden, `{"message":"403 Forbidden"}`
+		}
+		return 0, ""
+	}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--summary-webhook", webhook.URL)...)
+	if code != exitFailure {
+		t.Errorf("exit code %d, want %d", code, exitFailure)
+	}
+	want := "Successfully transferred 3/8 variables: 2 created, 1 updated, 3 unchanged, 1 skipped, 1 failed\n"
+	if !strings.Contains(stderr, want) {
+		t.Errorf("stderr has no %q:\n%s", want, stderr)
+	}
+	var sent runSummary
+	if err := json.Unmarshal(body, &sent); err != nil {
+		t.Fatalf("webhook body %q: %v", body, err)
+	}
+	if sent.Created != 2 || sent.Updated != 1 || sent.Unchanged != 3 || sent.Skipped != 1 || sent.Failed != 1 || sent.Transferred != 3 {
+		t.Errorf("JSON summary = %+v", sent)
+	}
+}
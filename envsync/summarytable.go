@@ -0,0 +1,72 @@
+package envsync
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// maxReasonLength bounds the failure reasons in the summary table; the
+// failures file has them in full.
+const maxReasonLength = 72
+
+// writeSummaryTable renders the run's counts and its failed variables with
+// the category and reason of each failure, for --summary-table.
+func writeSummaryTable(w io.Writer, summary *runSummary, failures []failureRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESULT\tVARIABLES")
+	for _, row := range []struct {
+		name string
+		n    int
+	}{
+		{"created", summary.Created},
+		{"updated", summary.Updated},
+		{"unchanged", summary.Unchanged},
+		{"skipped", summary.Skipped},
+		{"pruned", summary.Pruned},
+		{"failed", summary.Failed},
+	} {
+		fmt.Fprintf(tw, "%s\t%d\n", row.name, row.n)
+	}
+	if summary.RolledBack > 0 {
+		fmt.Fprintf(tw, "rolled back\t%d\n", summary.RolledBack)
+	}
+	if summary.RollbackFailed > 0 {
+		fmt.Fprintf(tw, "not rolled back\t%d\n", summary.RollbackFailed)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+
+	multi := len(summary.Targets) > 0
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if multi {
+		fmt.Fprintln(tw, "TARGET\tFAILED\tCATEGORY\tREASON")
+	} else {
+		fmt.Fprintln(tw, "FAILED\tCATEGORY\tREASON")
+	}
+	for _, f := range failures {
+		variable := f.Key + "@" + normalizeScope(f.EnvironmentScope)
+		if multi {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Target, variable, f.Category, failureReason(f.Error))
+		} else {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", variable, f.Category, failureReason(f.Error))
+		}
+	}
+	return tw.Flush()
+}
+
+// failureReason shortens an error message to its first line, at most
+// maxReasonLength characters long.
+func failureReason(message string) string {
+	message, _, _ = strings.Cut(message, "\n")
+	if runes := []rune(message); len(runes) > maxReasonLength {
+		return string(runes[:maxReasonLength-3]) + "..."
+	}
+	return message
+}
@@ -0,0 +1,63 @@
+package envsync
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSummaryTable(t *testing.T) {
+	summary := &runSummary{TargetProject: "g/dst", Created: 12, Unchanged: 3, Skipped: 1, Failed: 2}
+	failures := []failureRecord{
+		{Key: "B", EnvironmentScope: "production", Category: failureValidation, Error: "failed to create variable B: validation failed (status code 400): {\"message\":{\"value\":[\"is invalid\"]}}\nsecond line"},
+		{Key: "TOKEN", Category: failureRetryable, Error: "timed out"},
+	}
+	var buf bytes.Buffer
+	if err := writeSummaryTable(&buf, summary, failures); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "summary-table.txt", buf.Bytes())
+}
+
+// Multi-target runs name the target of each failure, and the rollback
+// counts only appear when there were any.
+func TestSummaryTableMultiTarget(t *testing.T) {
+	summary := newRunSummary("g/src", "g", false)
+	summary.add(&runSummary{TargetProject: "g/app", Created: 2, RolledBack: 2})
+	summary.add(&runSummary{TargetProject: "g/worker", Failed: 1, RollbackFailed: 1})
+	failures := []failureRecord{{Target: "g/worker", Key: "A", Category: failureRetryable, Error: "503 Service Unavailable"}}
+	var buf bytes.Buffer
+	if err := writeSummaryTable(&buf, summary, failures); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "summary-table-multi.txt", buf.Bytes())
+}
+
+func TestFailureReason(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	for message, want := range map[string]string{
+		"short":                 "short",
+		"first line\nsecond":    "first line",
+		long:                    long[:69] + "...",
+		strings.Repeat("é", 72): strings.Repeat("é", 72),
+	} {
+		if got := failureReason(message); got != want {
+			t.Errorf("failureReason(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+// Piped stderr gets no table, so the flag can stay in a shell alias.
+func TestSummaryTableSuppressedWhenPiped(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = nil
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--summary-table")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if strings.Contains(stderr, "RESULT") {
+		t.Errorf("table written to a pipe:\n%s", stderr)
+	}
+}
@@ -0,0 +1,671 @@
+package envsync
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// loadSourceVariables reads the source variables from a plan file, an import
+// file or the source project, in that order of precedence.
+func loadSourceVariables(client *GitLabClient, cfg *config) []EnvVar {
+	if cfg.ApplyFile != "" {
+		log.Printf("Reading plan file: %s", cfg.ApplyFile)
+		plan, err := readDryRunOutput(cfg.ApplyFile, cfg.DecryptKey.key, cfg.StrictSchema)
+		if err != nil {
+			log.Fatalf("Error reading plan file: %v", err)
+		}
+		cfg.SourceProject = plan.SourceProject
+		if cfg.SourceProject == "" {
+			cfg.SourceProject = cfg.ApplyFile
+		}
+		if cfg.TargetProject == "" && cfg.TargetGroup == "" {
+			if plan.TargetProject == "" {
+				log.Fatalf("Plan file %s names no target project, pass --target", cfg.ApplyFile)
+			}
+			cfg.TargetProject = plan.TargetProject
+		}
+		if plan.TargetStateHash != "" {
+			cfg.planLock = &stateLock{TargetProject: plan.TargetProject, Hash: plan.TargetStateHash}
+		}
+
+		secretsPath := cfg.SecretsFile
+		if secretsPath == "" {
+			secretsPath = secretsFileFor(cfg.ApplyFile)
+		}
+		// A missing default secrets file is fine for plans without tokens.
+		secrets, err := readSecretsFile(secretsPath, cfg.DecryptKey.key)
+		if err != nil && (cfg.SecretsFile != "" || !os.IsNotExist(err)) {
+			log.Fatalf("Error reading secrets file: %v", err)
+		}
+		variables, err := resolveTokens(plan.Variables, secrets)
+		if err != nil {
+			log.Fatalf("Error resolving plan tokens from %s: %v", secretsPath, err)
+		}
+		return variables
+	}
+
+	if cfg.ImportFile != "" {
+		cfg.SourceProject = cfg.ImportFile
+		log.Printf("Reading variables from import file: %s", cfg.ImportFile)
+		var variables []EnvVar
+		var err error
+		if strings.EqualFold(filepath.Ext(cfg.ImportFile), ".csv") {
+			var columns csvColumns
+			if columns, err = parseCSVColumns(cfg.CSVColumns); err != nil {
+				log.Fatalf("Error: invalid --csv-columns: %v", err)
+			}
+			variables, err = readCSV(cfg.ImportFile, columns)
+		} else if ext := strings.ToLower(filepath.Ext(cfg.ImportFile)); ext == ".yaml" || ext == ".yml" {
+			variables, err = readSOPSYAML(cfg.ImportFile, cfg.SOPS)
+		} else if strings.EqualFold(filepath.Ext(cfg.ImportFile), ".json") {
+			var plan *dryRunOutput
+			if plan, err = readDryRunOutput(cfg.ImportFile, cfg.DecryptKey.key, cfg.StrictSchema); err == nil {
+				variables = plan.Variables
+			}
+		} else {
+			variables, err = readDotEnv(cfg.ImportFile)
+		}
+		if err != nil {
+			log.Fatalf("Error reading import file: %v", err)
+		}
+		if cfg.ImportScope != "" {
+			variables = withScope(variables, cfg.ImportScope)
+		}
+		return variables
+	}
+
+	log.Printf("Fetching variables from source project: %s", cfg.SourceProject)
+	variables, err := client.GetVariables(cfg.SourceProject)
+	if isAuthError(err) {
+		exitAuth(err)
+	}
+	if err != nil {
+		log.Fatalf("Error getting variables from source project: %v", err)
+	}
+	return variables
+}
+
+// resolveTargets returns the target project paths of the run. With
+// --target-group these are all projects of the group except the source.
+func resolveTargets(client *GitLabClient, cfg *config) []string {
+	if cfg.TargetGroup == "" {
+		return []string{cfg.TargetProject}
+	}
+
+	log.Printf("Listing projects of target group: %s", cfg.TargetGroup)
+	projects, err := client.GetGroupProjects(cfg.TargetGroup)
+	if isAuthError(err) {
+		exitAuth(err)
+	}
+	if err != nil {
+		log.Fatalf("Error listing projects of target group: %v", err)
+	}
+
+	exclude, err := compileNameList(cfg.TargetExclude)
+	if err != nil {
+		log.Fatalf("Error: invalid --target-exclude: %v", err)
+	}
+
+	var targets []string
+	archived, excluded := 0, 0
+	for _, p := range projects {
+		path := p.PathWithNamespace
+		client.rememberProject(path, p.ID)
+		switch {
+		case path == cfg.SourceProject:
+			continue
+		case p.Archived:
+			archived++
+			if cfg.Explain {
+				log.Printf("explain: target %s: skipped (archived)", path)
+			}
+		case exclude != nil && exclude.MatchString(path):
+			excluded++
+			if cfg.Explain {
+				log.Printf("explain: target %s: skipped (--target-exclude)", path)
+			}
+		default:
+			targets = append(targets, path)
+			if cfg.Explain {
+				log.Printf("explain: target %s: included", path)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		log.Fatalf("Target group %s has no projects to sync to", cfg.TargetGroup)
+	}
+
+	log.Printf("Resolved %d target projects from group %s (%d archived, %d excluded)", len(targets), cfg.TargetGroup, archived, excluded)
+	return targets
+}
+
+// confirmTargets lists the projects resolved from --target-group before
+// anything is written and asks for confirmation, so a wrong group or
+// --target-exclude does not fan out unnoticed. Without a terminal it needs
+// --yes.
+func confirmTargets(reader *bufio.Reader, targets []string, cfg *config) {
+	log.Printf("This run writes to %d target project(s):", len(targets))
+	for _, target := range targets {
+		log.Printf("  %s", target)
+	}
+	if cfg.AssumeYes {
+		return
+	}
+	if !isTerminal(os.Stdin) {
+		log.Fatalf("Writing to %d target project(s) needs confirmation; pass --yes when running non-interactively", len(targets))
+	}
+	if !confirm(reader, os.Stderr, fmt.Sprintf("Write to these %d target project(s)?", len(targets))) {
+		log.Fatalf("Targets not confirmed, aborting before any changes (use --yes to skip confirmation)")
+	}
+}
+
+// targetRun carries what is shared by the per-target syncs of one run.
+type targetRun struct {
+	client *GitLabClient
+	cfg    *config
+	opts   transferOptions
+	stdin  *bufio.Reader
+	stream *jsonlWriter
+	multi  bool
+
+	// retry, if set, limits each target to its previously failed variables.
+	retry    retrySet
+	failures []failureRecord
+
+	// checkpoint, if set, records progress and skips completed variables.
+	checkpoint *checkpoint
+	journal    *journal
+
+	// state, if set, records what is written for --state-file.
+	state *syncState
+
+	// highRisk, if set, matches the scopes whose changes need
+	// --confirm-production or an extra confirmation, even with --yes.
+	highRisk *regexp.Regexp
+
+	// masking is what the target accepts as masked values, checked before
+	// a --transactional run writes.
+	masking maskingRules
+
+	// mu serializes what targets synced at the same time share: results,
+	// failures, the state file, checkpoint, journal, streamed output and
+	// prompts.
+	mu sync.Mutex
+}
+
+// syncAll syncs the source variables to every target, up to workers of them
+// at the same time, and returns their summaries in the order of targets. A
+// target that fails does not stop the others; the errors of all failed
+// targets are returned together once every target is done.
+func (r *targetRun) syncAll(sourceVars []EnvVar, targets []string, workers int) ([]*runSummary, error) {
+	summaries := make([]*runSummary, len(targets))
+	errs := make([]error, len(targets))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(targets)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				var err error
+				summaries[i], err = r.sync(sourceVars, targets[i])
+				if err != nil {
+					errs[i] = fmt.Errorf("%s: %w", targets[i], err)
+				}
+			}
+		}()
+	}
+	for i := range targets {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return summaries, errors.Join(errs...)
+}
+
+// sync plans and applies the source variables to a single target project.
+// It runs alongside the syncs of other targets, so it returns its errors
+// rather than ending the process; the summary holds what was done before.
+func (r *targetRun) sync(sourceVars []EnvVar, targetProject string) (*runSummary, error) {
+	cfg := r.cfg
+	// Pruning compares the target with the whole source, not just the
+	// variables left to sync.
+	allSourceVars := sourceVars
+	if r.retry != nil {
+		sourceVars = r.retry.filter(targetProject, sourceVars)
+		log.Printf("Retrying %d previously failed variables for %s", len(sourceVars), targetProject)
+	}
+	if r.checkpoint != nil && cfg.Resume {
+		r.mu.Lock()
+		pending := r.checkpoint.pending(targetProject, sourceVars)
+		r.mu.Unlock()
+		log.Printf("Resuming %s: %d of %d variables already synced", targetProject, len(sourceVars)-len(pending), len(sourceVars))
+		sourceVars = pending
+	}
+	if cfg.ContinueFrom != "" {
+		before := len(sourceVars)
+		sourceVars = filterVariables(sourceVars, func(v EnvVar) bool {
+			return v.Key >= cfg.ContinueFrom
+		}, "sorts before --continue-from", cfg.Explain)
+		log.Printf("Continuing from %s: skipping %d of %d variables", cfg.ContinueFrom, before-len(sourceVars), before)
+	}
+	if cfg.ChangedSinceSync {
+		r.mu.Lock()
+		changed := r.state.changedSinceSync(cfg.SourceProject, targetProject, sourceVars, cfg.Explain)
+		r.mu.Unlock()
+		log.Printf("Syncing %d of %d variables changed since the last sync to %s", len(changed), len(sourceVars), targetProject)
+		sourceVars = changed
+	}
+
+	summary := newRunSummary(cfg.SourceProject, targetProject, cfg.DryRun)
+	defer summary.finish()
+	summary.Total = len(sourceVars)
+	summary.Provenance = provenance(sourceVars)
+
+	// The plan's lock only applies to the target it was computed for.
+	lock := cfg.planLock
+	if lock != nil && lock.TargetProject != targetProject {
+		lock = nil
+	}
+
+	var targetVars []EnvVar
+	existing := map[variableKey]EnvVar{}
+	// needTarget says whether the target's variables are read, both for the
+	// run itself and for its API call estimate.
+	needTarget := cfg.Upsert || cfg.Prune || cfg.CheckReferences || cfg.SafeMode || cfg.VerifyBeforeWrite || cfg.Transactional || cfg.RollbackScript != "" || cfg.Replace || lock != nil
+	if needTarget {
+		log.Printf("Fetching existing variables from target project: %s", targetProject)
+		var err error
+		targetVars, err = r.client.GetVariables(targetProject)
+		if err != nil {
+			return summary, fmt.Errorf("getting variables from target project: %w", err)
+		}
+		for _, v := range targetVars {
+			existing[keyOf(v)] = v
+		}
+	}
+
+	if lock != nil && !cfg.DryRun {
+		if err := checkStateLock(lock, targetVars, cfg.Force); err != nil {
+			return summary, err
+		}
+	}
+
+	// checkReads counts the requests of the checks for the estimate.
+	var checkReads int
+	if cfg.CheckInheritance {
+		if err := warnInheritedConflicts(r.client, targetProject, sourceVars); err != nil {
+			return summary, fmt.Errorf("checking inherited variables: %w", err)
+		}
+		checkReads += len(ancestorGroups(targetProject))
+	}
+	if cfg.CheckFork {
+		reads, err := warnForkDuplicates(r.client, targetProject, sourceVars)
+		if err != nil {
+			return summary, fmt.Errorf("checking fork parent: %w", err)
+		}
+		checkReads += reads
+	}
+	if cfg.CheckReferences {
+		if unresolved := checkReferences(sourceVars, targetVars); len(unresolved) > 0 && cfg.RawUnresolved {
+			sourceVars = markRaw(sourceVars, unresolved)
+		}
+	}
+
+	decisions, err := planTransfer(sourceVars, existing, r.opts)
+	if err != nil {
+		return summary, fmt.Errorf("planning transfer: %w", err)
+	}
+	if cfg.Explain {
+		for _, d := range decisions {
+			log.Printf("explain: %s", d)
+		}
+	}
+
+	if cfg.SafeMode {
+		for _, d := range decisions {
+			if blockedBySafeMode(d) {
+				log.Printf("Safe mode: not changing %s (%s)", keyOf(d.Variable), d.Reason)
+			}
+		}
+	}
+
+	var pruneVars []EnvVar
+	if cfg.Prune {
+		pruneVars = planPrune(allSourceVars, targetVars)
+		if r.opts.Protect != nil {
+			pruneVars = skipProtected(pruneVars, r.opts.Protect)
+		}
+		if cfg.Transactional {
+			pruneVars = skipHidden(pruneVars)
+		}
+		if cfg.SafeMode && !cfg.AllowDelete && len(pruneVars) > 0 {
+			for _, v := range pruneVars {
+				log.Printf("Safe mode: not deleting %s (pass --allow-delete)", keyOf(v))
+			}
+			pruneVars = nil
+		}
+		r.mu.Lock()
+		writePruneList(os.Stderr, pruneVars, colorEnabled(os.Stderr, cfg.NoColor))
+		r.mu.Unlock()
+	}
+
+	if cfg.QuietDryRun {
+		counts := countPlan(decisions, pruneVars)
+		target := ""
+		if r.multi {
+			target = targetProject
+		}
+		r.mu.Lock()
+		writeCounts(os.Stdout, target, counts)
+		r.mu.Unlock()
+		summary.Created, summary.Updated, summary.Pruned = counts.Create, counts.Update, counts.Delete
+		summary.Unchanged, summary.Skipped = counts.Unchanged, counts.Skip
+		return summary, nil
+	}
+
+	if cfg.DryRun {
+		outputFile := cfg.OutputFile
+		if r.multi {
+			outputFile = outputFileForTarget(cfg.OutputFile, targetProject)
+		}
+		// Record the target state only when it was actually read.
+		var targetHash string
+		if cfg.Upsert || cfg.Prune {
+			targetHash = fingerprint(targetVars)
+		}
+		// A --check-fork run before the target is read resolves its ID.
+		reads := runReads{Checks: checkReads, LookupID: needTarget || !cfg.CheckFork}
+		if needTarget {
+			reads.Target = pages(len(targetVars))
+		}
+		estimate := estimateAPICalls(countPlan(decisions, pruneVars), reads, cfg.VerifyBeforeWrite)
+		if cfg.SplitPlan != "" {
+			for _, part := range splitPlan(decisions, pruneVars) {
+				file := cfg.SplitPlan + part.name + planExtension(cfg.DryRunFormat)
+				if r.multi {
+					file = outputFileForTarget(file, targetProject)
+				}
+				partEstimate := estimateAPICalls(countPlan(part.decisions, part.prune), reads, cfg.VerifyBeforeWrite)
+				if err := r.writePlan(file, variablesOf(part.decisions), targetProject, part.prune, targetHash, partEstimate); err != nil {
+					return summary, err
+				}
+			}
+		} else if err := r.writePlan(outputFile, sourceVars, targetProject, pruneVars, targetHash, estimate); err != nil {
+			return summary, err
+		}
+		log.Printf("Dry run completed. Found %d variables to transfer", len(sourceVars))
+		log.Printf("Estimated API calls: %s", estimate)
+		return summary, nil
+	}
+
+	if cfg.Transactional {
+		if problems := validateWrites(decisions, r.masking); len(problems) > 0 {
+			for _, p := range problems {
+				log.Printf("Invalid: %s", p)
+			}
+			return summary, &exitError{exitInvalid, fmt.Errorf("transactional run: %d problem(s) found, not changing %s", len(problems), targetProject)}
+		}
+	}
+
+	if err := r.confirmChanges(decisions, pruneVars, targetProject); err != nil {
+		return summary, err
+	}
+
+	log.Printf("Starting transfer of %d variables from %s to %s", len(sourceVars), cfg.SourceProject, targetProject)
+
+	var stream reportFunc
+	if r.stream != nil {
+		stream = r.stream.forTarget(targetProject)
+	}
+	var synced []EnvVar
+	// commit records an outcome that stands in the state file and
+	// checkpoint.
+	commit := func(v EnvVar, result outcome) {
+		if r.state != nil {
+			r.state.record(targetProject, v, result)
+		}
+		switch result {
+		case outcomeCreated, outcomeUpdated, outcomeUnchanged:
+			synced = append(synced, v)
+			if r.checkpoint != nil {
+				if err := r.checkpoint.complete(targetProject, v); err != nil {
+					log.Printf("Warning: failed to write checkpoint file %s: %v", cfg.Checkpoint, err)
+				}
+			}
+		}
+	}
+	// undo records how to revert the run's writes, for the rollback of
+	// --transactional or for --rollback-script.
+	var tx, undo *transaction
+	if cfg.Transactional {
+		tx = newTransaction(existing)
+		undo = tx
+	} else if cfg.RollbackScript != "" {
+		undo = newTransaction(existing)
+	}
+	report := func(v EnvVar, result outcome, err error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		summary.record(v, result, err)
+		if stream != nil {
+			stream(v, result, err)
+		}
+		if r.journal != nil {
+			if err := r.journal.record(targetProject, v, result); err != nil {
+				log.Printf("Warning: failed to write journal %s: %v", cfg.Journal, err)
+			}
+		}
+		if undo != nil {
+			undo.record(v, result, err)
+		}
+		if tx == nil {
+			commit(v, result)
+		}
+		if result == outcomeFailed {
+			r.failures = append(r.failures, newFailureRecord(targetProject, v, err))
+		}
+	}
+
+	opts := r.opts
+	if cfg.VerifyBeforeWrite {
+		opts.Expected = existing
+	}
+	if err := transferVariables(r.client, targetProject, decisions, opts, report); isAuthError(err) {
+		return summary, err
+	}
+	switch {
+	case tx != nil && summary.Failed > 0:
+		if len(pruneVars) > 0 {
+			log.Printf("Transactional run: not pruning %d variable(s) from %s after a failure", len(pruneVars), targetProject)
+		}
+	case cfg.PruneBatchSize > 0:
+		err := pruneInBatches(pruneVars, cfg.PruneBatchSize, func(prune []EnvVar) error {
+			return pruneVariables(r.client, targetProject, prune, opts, report)
+		}, r.confirmBatch(targetProject))
+		if isAuthError(err) {
+			return summary, err
+		}
+	default:
+		if err := pruneVariables(r.client, targetProject, pruneVars, opts, report); isAuthError(err) {
+			return summary, err
+		}
+	}
+	if tx != nil && summary.Failed > 0 {
+		log.Printf("Transactional run: %d variable(s) failed, rolling back %d change(s) to %s", summary.Failed, len(tx.undo), targetProject)
+		failed := tx.rollback(r.client, targetProject)
+		summary.RolledBack = len(tx.undo) - failed
+		summary.RollbackFailed = failed
+		if failed > 0 {
+			log.Printf("Rollback incomplete: %d change(s) to %s could not be reverted", failed, targetProject)
+		}
+	} else if tx != nil {
+		r.mu.Lock()
+		for _, res := range tx.results {
+			commit(res.variable, res.result)
+		}
+		r.mu.Unlock()
+	}
+	log.Printf("Transfer completed. Successfully transferred %d/%d variables: %s", summary.Transferred, len(sourceVars), summary.breakdown())
+	if summary.TimedOut > 0 {
+		log.Printf("%d variables timed out: %s", summary.TimedOut, strings.Join(summary.TimedOutKeys, ", "))
+	}
+
+	if cfg.RollbackScript != "" {
+		scriptFile := cfg.RollbackScript
+		if r.multi {
+			scriptFile = outputFileForTarget(cfg.RollbackScript, targetProject)
+		}
+		steps := undo.undo
+		if tx != nil && summary.Failed > 0 {
+			// The transaction was rolled back already.
+			steps = nil
+		}
+		if err := writeRollbackScript(scriptFile, r.client, targetProject, steps, cfg.FileMode.modeFor(true)); err != nil {
+			log.Printf("Warning: failed to write rollback script %s: %v", scriptFile, err)
+		} else {
+			log.Printf("Wrote rollback script for %d change(s) to %s", len(steps), scriptFile)
+		}
+	}
+
+	if cfg.ChecksumOutput != "" {
+		checksumFile := cfg.ChecksumOutput
+		if r.multi {
+			checksumFile = outputFileForTarget(cfg.ChecksumOutput, targetProject)
+		}
+		if err := writeChecksumFile(checksumFile, synced, cfg.FileMode.modeFor(false)); err != nil {
+			log.Printf("Warning: failed to write checksum file %s: %v", checksumFile, err)
+		} else {
+			log.Printf("Wrote checksums of %d variables to %s", len(synced), checksumFile)
+		}
+	}
+	return summary, nil
+}
+
+// confirmChanges asks for the confirmations a target's changes need before
+// the first write: changes in high-risk scopes, and a prune without --yes.
+// A prune in batches is confirmed batch by batch, so without a terminal to
+// ask on it needs --yes.
+func (r *targetRun) confirmChanges(decisions []decision, pruneVars []EnvVar, targetProject string) error {
+	cfg := r.cfg
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.highRisk != nil && !cfg.ConfirmProduction {
+		if changes := highRiskChanges(decisions, pruneVars, r.highRisk); len(changes) > 0 {
+			for _, c := range changes {
+				log.Printf("High-risk change: %s", c)
+			}
+			if !confirm(r.stdin, os.Stderr, fmt.Sprintf("Apply %d change(s) in high-risk scopes of %s?", len(changes), targetProject)) {
+				return fmt.Errorf("high-risk changes to %s not confirmed, aborting before any changes (use --confirm-production to skip this confirmation)", targetProject)
+			}
+		}
+	}
+	if len(pruneVars) > 0 && !cfg.AssumeYes {
+		switch {
+		case cfg.PruneBatchSize == 0:
+			if !confirm(r.stdin, os.Stderr, fmt.Sprintf("Delete %d variable(s) from %s?", len(pruneVars), targetProject)) {
+				return fmt.Errorf("prune of %s not confirmed, aborting before any changes (use --yes to skip confirmation)", targetProject)
+			}
+		case !isTerminal(os.Stdin):
+			return fmt.Errorf("prune of %s in batches needs confirmation, aborting before any changes (use --yes when running non-interactively)", targetProject)
+		}
+	}
+	return nil
+}
+
+// runVerifyChecksum checks the target against a checksum file and returns the
+// process exit code.
+func runVerifyChecksum(client *GitLabClient, cfg *config) int {
+	expected, err := readChecksumFile(cfg.VerifyChecksum)
+	if err != nil {
+		log.Fatalf("Error reading checksum file: %v", err)
+	}
+
+	log.Printf("Fetching variables from target project: %s", cfg.TargetProject)
+	targetVars, err := client.GetVariables(cfg.TargetProject)
+	if isAuthError(err) {
+		exitAuth(err)
+	}
+	if err != nil {
+		log.Fatalf("Error getting variables from target project: %v", err)
+	}
+
+	problems := verifyChecksums(expected, targetVars)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		log.Printf("Checksum verification failed: %d of %d variables differ", len(problems), len(expected))
+		return exitMismatch
+	}
+	log.Printf("Checksum verification passed for %d variables", len(expected))
+	return 0
+}
+
+// confirmBatch returns the per-batch confirmation for --prune-batch-size.
+// With --yes every batch is accepted; the batches are still logged as they
+// are deleted. confirmChanges has already refused to run without --yes or a
+// terminal.
+func (r *targetRun) confirmBatch(targetProject string) func(n, total int, batch []EnvVar) bool {
+	return func(n, total int, batch []EnvVar) bool {
+		if r.cfg.AssumeYes {
+			return true
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		writePruneList(os.Stderr, batch, colorEnabled(os.Stderr, r.cfg.NoColor))
+		return confirm(r.stdin, os.Stderr, fmt.Sprintf("Delete batch %d/%d (%d variable(s)) from %s?", n, total, len(batch), targetProject))
+	}
+}
+
+func (r *targetRun) writePlan(outputFile string, sourceVars []EnvVar, targetProject string, pruneVars []EnvVar, targetHash string, estimate *apiCallEstimate) error {
+	cfg := r.cfg
+	log.Printf("Performing dry run, writing output to %s", outputFile)
+	planVars := sourceVars
+	if cfg.Tokenize && len(sourceVars) > 0 {
+		var secrets map[string]string
+		var err error
+		planVars, secrets, err = tokenizeValues(sourceVars)
+		if err != nil {
+			return fmt.Errorf("tokenizing values: %w", err)
+		}
+		secretsPath := cfg.SecretsFile
+		if secretsPath == "" || r.multi || cfg.SplitPlan != "" {
+			secretsPath = secretsFileFor(outputFile)
+		}
+		if err := writeSecretsFile(secretsPath, secrets, cfg.EncryptRecipient.key, cfg.FileMode.modeFor(true)); err != nil {
+			return fmt.Errorf("writing secrets file: %w", err)
+		}
+		log.Printf("Wrote value secrets to %s; keep this file out of version control", secretsPath)
+	}
+	// A tokenized plan holds no values and may be shared more widely.
+	mode := cfg.FileMode.modeFor(!cfg.Tokenize)
+	if err := writeDryRunOutput(outputFile, cfg.DryRunFormat, cfg.ShowValues, cfg.SourceProject, targetProject, planVars, pruneVars, targetHash, estimate, cfg.EncryptRecipient.key, mode); err != nil {
+		return fmt.Errorf("writing dry run output: %w", err)
+	}
+	return nil
+}
+
+// outputFileForTarget derives a per-target plan file name, e.g.
+// env-sync-dry-run.group-app.json for target group/app.
+func outputFileForTarget(outputFile, targetProject string) string {
+	ext := filepath.Ext(outputFile)
+	base := strings.TrimSuffix(outputFile, ext)
+	return base + "." + strings.ReplaceAll(targetProject, "/", "-") + ext
+}
+
+// logTargetSummaries prints one line per target of a multi-target run.
+func logTargetSummaries(summary *runSummary) {
+	log.Printf("Synced %d target projects:", len(summary.Targets))
+	for _, t := range summary.Targets {
+		log.Printf("  %s: %s", t.TargetProject, t.breakdown())
+	}
+	log.Printf("Total: %s", summary.breakdown())
+}
@@ -0,0 +1,274 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyFake serves a source of n variables and a group of targets,
+// answering each create after a pause so that concurrent writes overlap.
+// It records the most creates in flight per target and in total.
+type concurrencyFake struct {
+	*fakeGitLab
+	mu        sync.Mutex
+	inFlight  map[string]int
+	maxTarget map[string]int
+	total     int
+	maxTotal  int
+}
+
+func newConcurrencyFake(t *testing.T, targets, n int) *concurrencyFake {
+	c := &concurrencyFake{fakeGitLab: newFakeGitLab(t), inFlight: map[string]int{}, maxTarget: map[string]int{}}
+	for i := 0; i < n; i++ {
+		c.projects["grp/src"] = append(c.projects["grp/src"], envVar(fmt.Sprintf("VAR_%02d", i), "v", ""))
+	}
+	c.info["grp/src"] = Project{ID: 1, PathWithNamespace: "grp/src"}
+	for i := 0; i < targets; i++ {
+		p := Project{ID: 100 + i, PathWithNamespace: fmt.Sprintf("grp/target%d", i)}
+		c.groupProjects["grp"] = append(c.groupProjects["grp"], p)
+		c.info[p.PathWithNamespace] = p
+		c.projects[p.PathWithNamespace] = []EnvVar{}
+	}
+	c.intercept = func(r fakeRequest) (int, string) {
+		if r.Method != http.MethodPost {
+			return 0, ""
+		}
+		c.mu.Lock()
+		c.inFlight[r.Path]++
+		c.total++
+		c.maxTarget[r.Path] = max(c.maxTarget[r.Path], c.inFlight[r.Path])
+		c.maxTotal = max(c.maxTotal, c.total)
+		c.mu.Unlock()
+
+		time.Sleep(30 * time.Millisecond)
+
+		c.mu.Lock()
+		c.inFlight[r.Path]--
+		c.total--
+		c.mu.Unlock()
+		return 0, ""
+	}
+	return c
+}
+
+// --parallel-projects bounds the targets synced at once and
+// --threads-per-target the writes to each of them.
+func TestTargetAndVariableConcurrency(t *testing.T) {
+	for _, test := range []struct {
+		parallel, threads int
+	}{
+		{1, 1},
+		{1, 3},
+		{3, 1},
+		{2, 3},
+	} {
+		t.Run(fmt.Sprintf("%dx%d", test.parallel, test.threads), func(t *testing.T) {
+			c := newConcurrencyFake(t, 3, 6)
+
+			_, stderr, code := runMain(t, "", c.args("--source", "grp/src", "--target-group", "grp", "--yes",
+				"--parallel-projects", fmt.Sprint(test.parallel), "--threads-per-target", fmt.Sprint(test.threads))...)
+			if code != 0 {
+				t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.maxTotal != test.parallel*test.threads {
+				t.Errorf("%d creates in flight at most, want %d", c.maxTotal, test.parallel*test.threads)
+			}
+			if len(c.maxTarget) != 3 {
+				t.Fatalf("creates reached %d targets, want 3", len(c.maxTarget))
+			}
+			for target, n := range c.maxTarget {
+				if n != test.threads {
+					t.Errorf("%s: %d creates in flight at most, want %d", target, n, test.threads)
+				}
+			}
+			for i := 0; i < 3; i++ {
+				if got := len(c.vars(fmt.Sprintf("grp/target%d", i))); got != 6 {
+					t.Errorf("target%d has %d variables, want 6", i, got)
+				}
+			}
+		})
+	}
+}
+
+// --rate-limit is shared by all targets and threads: however many workers
+// there are, requests are spaced by the rate.
+func TestRateLimitSharedAcrossWorkers(t *testing.T) {
+	f := groupFake(t)
+	for i := 0; i < 5; i++ {
+		f.projects["grp/src"] = append(f.projects["grp/src"], envVar(fmt.Sprintf("VAR_%d", i), "v", ""))
+	}
+	var mu sync.Mutex
+	var times []time.Time
+	f.intercept = func(fakeRequest) (int, string) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		return 0, ""
+	}
+
+	const perSecond = 50
+	_, stderr, code := runMain(t, "", f.args("--source", "grp/src", "--target-group", "grp", "--yes",
+		"--parallel-projects", "2", "--threads-per-target", "4", "--rate-limit", fmt.Sprint(perSecond))...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if len(f.writes()) != 12 {
+		t.Fatalf("writes = %v, want 6 per target", f.writes())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	span := times[len(times)-1].Sub(times[0])
+	if want := time.Duration(len(times)-1) * time.Second / perSecond * 9 / 10; span < want {
+		t.Errorf("%d requests took %s, want at least %s at %d per second", len(times), span, want, perSecond)
+	}
+}
+
+func TestConcurrencyFlagChecks(t *testing.T) {
+	f := groupFake(t)
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--threads-per-target", "0"}, "--threads-per-target must be at least 1"},
+		{[]string{"--parallel-projects", "0"}, "--parallel-projects must be at least 1"},
+		{[]string{"--rate-limit", "-1"}, "--rate-limit"},
+	} {
+		_, stderr, code := runMain(t, "", f.args(append([]string{"--source", "grp/src", "--target-group", "grp", "--yes"}, test.args...)...)...)
+		if code == 0 || !strings.Contains(stderr, test.want) {
+			t.Errorf("%v: exit code %d; stderr:\n%s", test.args, code, stderr)
+		}
+	}
+}
+
+// A target that fails to sync does not end the run while other targets are
+// writing: they finish, a failing transactional target is rolled back, and
+// the state and failures files are written before the run exits non-zero.
+func TestTargetErrorLetsOthersFinish(t *testing.T) {
+	c := newConcurrencyFake(t, 3, 6)
+	pause := c.intercept
+	c.intercept = func(r fakeRequest) (int, string) {
+		switch {
+		case r.Method == http.MethodGet && r.Path == "projects/101/variables":
+			return http.StatusInternalServerError, `{"message":"500 Internal Server Error"}`
+		case r.Method == http.MethodPost && r.Path == "projects/102/variables" && strings.Contains(r.Body, `"key":"VAR_03"`):
+			return http.StatusForbidden, `{"message":"403 Forbidden"}`
+		}
+		return pause(r)
+	}
+	dir := t.TempDir()
+	stateFile, failuresFile := filepath.Join(dir, "state.json"), filepath.Join(dir, "failures.json")
+
+	_, stderr, code := runMain(t, "", c.args("--source", "grp/src", "--target-group", "grp", "--yes", "--transactional",
+		"--parallel-projects", "3", "--state-file", stateFile, "--failures-file", failuresFile)...)
+	if code != exitFailure {
+		t.Errorf("exit code %d, want %d", code, exitFailure)
+	}
+	if !strings.Contains(stderr, "Error: grp/target1: getting variables from target project") {
+		t.Errorf("stderr does not name the failed target:\n%s", stderr)
+	}
+	if got := len(c.vars("grp/target0")); got != 6 {
+		t.Errorf("target0 has %d variables, want 6", got)
+	}
+	if got := c.vars("grp/target2"); len(got) != 0 {
+		t.Errorf("target2 = %v, want its writes rolled back", got)
+	}
+	state, err := readStateFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Targets["grp/target0"]) != 6 {
+		t.Errorf("state file = %+v, want the variables of target0", state.Targets)
+	}
+	records, err := readFailuresFile(failuresFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Target != "grp/target2" || records[0].Key != "VAR_03" {
+		t.Errorf("failures = %+v, want VAR_03 of target2", records)
+	}
+}
+
+// The exit code of an error a target returns is kept: a transactional
+// target with invalid writes exits with exitInvalid after the others.
+func TestTargetErrorExitCode(t *testing.T) {
+	c := newConcurrencyFake(t, 2, 2)
+	short := envVar("SHORT", "abc", "")
+	short.Masked = true
+	c.projects["grp/src"] = append(c.projects["grp/src"], short)
+
+	_, stderr, code := runMain(t, "", c.args("--source", "grp/src", "--target-group", "grp", "--yes", "--transactional", "--gitlab-version", "17.0", "--parallel-projects", "2")...)
+	if code != exitInvalid {
+		t.Errorf("exit code %d, want %d; stderr:\n%s", code, exitInvalid, stderr)
+	}
+	if n := strings.Count(stderr, "Error: grp/target"); n != 2 {
+		t.Errorf("%d target errors logged, want 2:\n%s", n, stderr)
+	}
+	if writes := c.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v, want none", writes)
+	}
+}
+
+// A token rejected by a target's --check-fork read is returned like any
+// other target error: the other targets finish and the run exits with the
+// auth exit code.
+func TestCheckAuthErrorLetsOthersFinish(t *testing.T) {
+	c := newConcurrencyFake(t, 2, 2)
+	pause := c.intercept
+	c.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodGet && r.Path == "projects/grp%2Ftarget1" {
+			return http.StatusUnauthorized, `{"message":"401 Unauthorized"}`
+		}
+		return pause(r)
+	}
+
+	_, stderr, code := runMain(t, "", c.args("--source", "grp/src", "--target-group", "grp", "--yes", "--check-fork", "--parallel-projects", "2")...)
+	if code != exitAuthFailure {
+		t.Errorf("exit code %d, want %d; stderr:\n%s", code, exitAuthFailure, stderr)
+	}
+	if !strings.Contains(stderr, "Authentication failed - token may be revoked or expired: grp/target1: checking fork parent") {
+		t.Errorf("stderr does not name the failed target:\n%s", stderr)
+	}
+	if got := len(c.vars("grp/target0")); got != 2 {
+		t.Errorf("target0 has %d variables, want 2", got)
+	}
+}
+
+// Each target's summary times that target: its duration covers its own
+// writes and is not left at zero.
+func TestTargetSummaryDuration(t *testing.T) {
+	var body []byte
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer webhook.Close()
+	c := newConcurrencyFake(t, 2, 2)
+
+	_, stderr, code := runMain(t, "", c.args("--source", "grp/src", "--target-group", "grp", "--yes",
+		"--threads-per-target", "1", "--summary-webhook", webhook.URL)...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	var sent runSummary
+	if err := json.Unmarshal(body, &sent); err != nil {
+		t.Fatalf("webhook body %q: %v", body, err)
+	}
+	if len(sent.Targets) != 2 {
+		t.Fatalf("summary has %d targets, want 2", len(sent.Targets))
+	}
+	for _, target := range sent.Targets {
+		// Two creates paused for 30ms each, one after the other.
+		if target.Duration < 0.06 || target.Duration > sent.Duration {
+			t.Errorf("%s: duration %gs, want at least 0.06s and at most the run's %gs", target.TargetProject, target.Duration, sent.Duration)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package envsync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// tokenPrefix marks a value that was replaced by an opaque reference.
+const tokenPrefix = "env-sync-token:"
+
+// tokenizeValues replaces every value with a random reference token and
+// returns the token-to-value map needed to restore them.
+func tokenizeValues(variables []EnvVar) ([]EnvVar, map[string]string, error) {
+	tokenized := make([]EnvVar, len(variables))
+	secrets := make(map[string]string, len(variables))
+	for i, v := range variables {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		token := tokenPrefix + hex.EncodeToString(buf)
+		secrets[token] = v.Value
+		v.Value = token
+		tokenized[i] = v
+	}
+	return tokenized, secrets, nil
+}
+
+// resolveTokens swaps reference tokens back for their real values. Values
+// that are not tokens are left untouched.
+func resolveTokens(variables []EnvVar, secrets map[string]string) ([]EnvVar, error) {
+	resolved := make([]EnvVar, len(variables))
+	for i, v := range variables {
+		if strings.HasPrefix(v.Value, tokenPrefix) {
+			value, ok := secrets[v.Value]
+			if !ok {
+				return nil, fmt.Errorf("no secret found for token of variable %s", keyOf(v))
+			}
+			v.Value = value
+		}
+		resolved[i] = v
+	}
+	return resolved, nil
+}
+
+func writeSecretsFile(filename string, secrets map[string]string, recipient *age.X25519Recipient, mode os.FileMode) error {
+	data, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = sealFor(recipient, data); err != nil {
+		return err
+	}
+	return writeOutputFile(filename, data, mode)
+}
+
+// secretsFileFor derives the default secrets file name from a plan file name.
+func secretsFileFor(planFile string) string {
+	return strings.TrimSuffix(planFile, ".json") + ".secrets.json"
+}
+
+func readSecretsFile(filename string, identity *age.X25519Identity) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = openSealed(identity, data); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %v", filename, err)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,89 @@
+package envsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeRoundTrip(t *testing.T) {
+	variables := []EnvVar{envVar("A", "secret-a", ""), envVar("B", "secret-b", "production")}
+	tokenized, secrets, err := tokenizeValues(variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range tokenized {
+		if !strings.HasPrefix(v.Value, tokenPrefix) || v.Value == tokenized[1-i].Value {
+			t.Errorf("%s: value %q is not a unique token", v.Key, v.Value)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "plan.secrets.json")
+	if err := writeSecretsFile(filename, secrets, nil, secretFileMode); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("secrets file mode = %v, want 0600", info.Mode().Perm())
+	}
+	read, err := readSecretsFile(filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := resolveTokens(tokenized, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range resolved {
+		if v != variables[i] {
+			t.Errorf("resolved %+v, want %+v", v, variables[i])
+		}
+	}
+}
+
+func TestResolveTokensMissingSecret(t *testing.T) {
+	_, err := resolveTokens([]EnvVar{envVar("A", tokenPrefix+"00", "")}, map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), "A@*") {
+		t.Errorf("err = %v, want the variable named", err)
+	}
+	plain, err := resolveTokens([]EnvVar{envVar("A", "plain", "")}, map[string]string{})
+	if err != nil || plain[0].Value != "plain" {
+		t.Errorf("plain value = %v, %v, want it untouched", plain, err)
+	}
+}
+
+func TestSecretsFileFor(t *testing.T) {
+	if got := secretsFileFor("out/plan.json"); got != "out/plan.secrets.json" {
+		t.Errorf("secretsFileFor = %q", got)
+	}
+}
+
+// A tokenized plan holds no secrets but applies with the real values.
+func TestTokenizedPlanApplies(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("API_KEY", "s3cr3t-value", "")}
+	f.projects["g/dst"] = nil
+	plan := filepath.Join(t.TempDir(), "plan.json")
+
+	if _, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--dry-run", "--tokenize-values", "--output", plan)...); code != 0 {
+		t.Fatalf("dry run: exit code %d; stderr:\n%s", code, stderr)
+	}
+	data, err := os.ReadFile(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cr3t-value") || !strings.Contains(string(data), tokenPrefix) {
+		t.Errorf("plan holds the secret or no token:\n%s", data)
+	}
+
+	if _, stderr, code := runMain(t, "", f.args("--apply", plan, "--yes")...); code != 0 {
+		t.Fatalf("apply: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if got := f.vars("g/dst"); len(got) != 1 || got[0].Value != "s3cr3t-value" {
+		t.Errorf("target = %v, want the real value", got)
+	}
+}
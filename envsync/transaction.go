@@ -0,0 +1,100 @@
+package envsync
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// undoStep reverts one write of a transactional run. previous is the
+// target variable before the write, nil if it did not exist.
+type undoStep struct {
+	variable EnvVar
+	previous *EnvVar
+	deleted  bool
+}
+
+// transaction records the writes of a --transactional run so they can be
+// reverted from the backup taken when the target was read. Outcomes are
+// held back in results until the run is known to stand, so the state file
+// and checkpoints never record writes that were rolled back.
+type transaction struct {
+	backup  map[variableKey]EnvVar
+	undo    []undoStep
+	results []transactionResult
+}
+
+type transactionResult struct {
+	variable EnvVar
+	result   outcome
+}
+
+func newTransaction(backup map[variableKey]EnvVar) *transaction {
+	return &transaction{backup: backup}
+}
+
+// validateWrites checks the variables a plan would write against GitLab's
+// rules, so a transactional run fails before its first write rather than
+// rolling back.
+func validateWrites(decisions []decision, masking maskingRules) []string {
+	var writes []EnvVar
+	for _, d := range decisions {
+		if isWrite(d.Action) {
+			writes = append(writes, d.Variable)
+		}
+	}
+	return validateVariables(writes, masking)
+}
+
+// record notes an outcome and, for writes, how the rollback would revert it.
+// A failed --replace that got as far as the delete is reverted like a
+// delete.
+func (t *transaction) record(v EnvVar, result outcome, err error) {
+	t.results = append(t.results, transactionResult{v, result})
+	lost := result == outcomeFailed && errors.Is(err, errNotRecreated)
+	switch {
+	case result == outcomeCreated, result == outcomeUpdated, result == outcomeDeleted, lost:
+	default:
+		return
+	}
+	step := undoStep{variable: v, deleted: result == outcomeDeleted || lost}
+	if previous, ok := t.backup[keyOf(v)]; ok {
+		step.previous = &previous
+	}
+	t.undo = append(t.undo, step)
+}
+
+// rollback reverts the recorded writes, last first: created variables are
+// deleted, updated ones get their backed-up value and attributes back and
+// deleted ones are created again. It keeps going past failures and returns
+// the number of writes it could not revert.
+func (t *transaction) rollback(client *GitLabClient, targetProject string) int {
+	failed := 0
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		step := t.undo[i]
+		if err := step.revert(client, targetProject); err != nil {
+			log.Printf("Error rolling back %s: %v", keyOf(step.variable), err)
+			failed++
+		}
+	}
+	return failed
+}
+
+func (s undoStep) revert(client *GitLabClient, targetProject string) error {
+	switch {
+	case s.previous == nil:
+		log.Printf("Rollback: deleting %s", keyOf(s.variable))
+		return client.DeleteVariable(targetProject, s.variable)
+	case s.previous.Hidden:
+		// The value of a hidden variable was never readable, so it
+		// cannot be restored.
+		return fmt.Errorf("hidden in the backup, its value cannot be restored")
+	case s.deleted:
+		log.Printf("Rollback: recreating %s", keyOf(s.variable))
+		return client.CreateVariable(targetProject, *s.previous, false)
+	default:
+		log.Printf("Rollback: restoring %s", keyOf(s.variable))
+		_, err := createIfMissing(client, targetProject, *s.previous, client.UpdateVariable(targetProject, *s.previous))
+		return err
+	}
+}
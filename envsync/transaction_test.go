@@ -0,0 +1,154 @@
+package envsync
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// targetValues maps each variable's KEY@scope to its value.
+func targetValues(variables []EnvVar) map[variableKey]string {
+	values := map[variableKey]string{}
+	for _, v := range variables {
+		values[keyOf(v)] = v.Value
+	}
+	return values
+}
+
+func TestTransactionRollback(t *testing.T) {
+	f := newFakeGitLab(t)
+	original := []EnvVar{envVar("UPDATED", "old", ""), envVar("DELETED", "old", ""), envVar("SAME", "1", "")}
+	f.projects["g/dst"] = append([]EnvVar(nil), original...)
+	client := f.client()
+	tx := newTransaction(existingVars(original...))
+
+	// The writes of a run that then fails.
+	for _, step := range []struct {
+		v      EnvVar
+		result outcome
+		write  func(EnvVar) error
+	}{
+		{envVar("CREATED", "new", ""), outcomeCreated, func(v EnvVar) error { return client.CreateVariable("g/dst", v, false) }},
+		{envVar("UPDATED", "new", ""), outcomeUpdated, func(v EnvVar) error { return client.UpdateVariable("g/dst", v) }},
+		{envVar("DELETED", "old", ""), outcomeDeleted, func(v EnvVar) error { return client.DeleteVariable("g/dst", v) }},
+		{envVar("SAME", "1", ""), outcomeUnchanged, nil},
+	} {
+		if step.write != nil {
+			if err := step.write(step.v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tx.record(step.v, step.result, nil)
+	}
+	if len(tx.undo) != 3 {
+		t.Fatalf("undo = %v, want the three writes", tx.undo)
+	}
+
+	if failed := tx.rollback(client, "g/dst"); failed != 0 {
+		t.Fatalf("%d steps failed", failed)
+	}
+	if got := targetValues(f.vars("g/dst")); !reflect.DeepEqual(got, targetValues(original)) {
+		t.Errorf("target after rollback = %v, want %v", f.vars("g/dst"), original)
+	}
+}
+
+func TestRollbackOfHiddenVariableFails(t *testing.T) {
+	hidden := envVar("SECRET", "", "")
+	hidden.Hidden = true
+	tx := newTransaction(existingVars(hidden))
+	tx.record(envVar("SECRET", "new", ""), outcomeUpdated, nil)
+
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{envVar("SECRET", "new", "")}
+	if failed := tx.rollback(f.client(), "g/dst"); failed != 1 {
+		t.Errorf("%d steps failed, want 1", failed)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v", writes)
+	}
+}
+
+// A failure in the middle of a --transactional run reverts the writes made
+// before it.
+func TestTransactionalRunRollsBack(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("CREATED", "1", ""), envVar("UPDATED", "new", ""), envVar("FAILS", "1", ""), envVar("NOT_REACHED", "1", "")}
+	original := []EnvVar{envVar("UPDATED", "old", ""), envVar("STALE", "1", "")}
+	f.projects["g/dst"] = append([]EnvVar(nil), original...)
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost && strings.Contains(r.Body, `"key":"FAILS"`) {
+			return http.StatusForbidden, `{"message":"403 Forbidden"}`
+		}
+		return 0, ""
+	}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--prune", "--yes", "--transactional", "--compact-summary")...)
+	if code != exitFailure {
+		t.Errorf("exit code %d, want %d", code, exitFailure)
+	}
+	for _, want := range []string{
+		"Transactional run: 1 variable(s) failed, rolling back 2 change(s) to g/dst",
+		"Rollback: restoring UPDATED@*",
+		"Rollback: deleting CREATED@*",
+		"Transactional run: not pruning 1 variable(s) from g/dst after a failure",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr does not contain %q:\n%s", want, stderr)
+		}
+	}
+	if got := targetValues(f.vars("g/dst")); !reflect.DeepEqual(got, targetValues(original)) {
+		t.Errorf("target = %v, want it restored to %v", f.vars("g/dst"), original)
+	}
+	for _, r := range f.received(http.MethodPost) {
+		if strings.Contains(r.Body, "NOT_REACHED") {
+			t.Error("the run went on after the failure")
+		}
+	}
+}
+
+// Problems GitLab would reject stop a --transactional run before any write.
+func TestTransactionalRunValidatesFirst(t *testing.T) {
+	f := newFakeGitLab(t)
+	short := envVar("SHORT", "abc", "")
+	short.Masked = true
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", ""), short}
+	f.projects["g/dst"] = nil
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--transactional")...)
+	if code != exitInvalid {
+		t.Errorf("exit code %d, want %d", code, exitInvalid)
+	}
+	if !strings.Contains(stderr, "Invalid: SHORT@*: masked value must be") {
+		t.Errorf("stderr:\n%s", stderr)
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v", writes)
+	}
+}
+
+// A --replace whose create fails after its delete went through leaves the
+// variable missing, so the rollback creates it again from the backup.
+func TestTransactionalReplaceRestoresDeleted(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("KEY", "new", ""), envVar("OTHER", "new", "")}
+	original := []EnvVar{envVar("KEY", "old", ""), envVar("OTHER", "old", "")}
+	f.projects["g/dst"] = append([]EnvVar(nil), original...)
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost && strings.Contains(r.Body, `"key":"KEY"`) && strings.Contains(r.Body, `"value":"new"`) {
+			return http.StatusBadRequest, `{"message":"400 Bad request"}`
+		}
+		return 0, ""
+	}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--replace", "--transactional")...)
+	if code != exitFailure {
+		t.Errorf("exit code %d, want %d", code, exitFailure)
+	}
+	if !strings.Contains(stderr, "Rollback: recreating KEY@*") {
+		t.Errorf("stderr:\n%s", stderr)
+	}
+	if got := targetValues(f.vars("g/dst")); !reflect.DeepEqual(got, targetValues(original)) {
+		t.Errorf("target = %v, want it restored to %v", f.vars("g/dst"), original)
+	}
+}
@@ -0,0 +1,395 @@
+package envsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outcome is what happened to a single source variable during a run.
+type outcome string
+
+const (
+	outcomeCreated   outcome = "created"
+	outcomeUpdated   outcome = "updated"
+	outcomeUnchanged outcome = "unchanged"
+	outcomeSkipped   outcome = "skipped"
+	outcomeDeleted   outcome = "deleted"
+	outcomeFailed    outcome = "failed"
+)
+
+// reportFunc is called once per source variable with its outcome. err is set
+// only when result is outcomeFailed.
+type reportFunc func(v EnvVar, result outcome, err error)
+
+// action is what the tool decided to do with a source variable.
+type action string
+
+const (
+	actionCreate           action = "create"
+	actionUpdate           action = "update"
+	actionUpdateAttributes action = "update-attributes"
+	actionReplace          action = "replace"
+	actionUnchanged        action = "unchanged"
+	actionSkip             action = "skip"
+)
+
+// decision is the planned action for one source variable and why it was
+// chosen.
+type decision struct {
+	Variable EnvVar
+	Action   action
+	Reason   string
+}
+
+func (d decision) String() string {
+	return fmt.Sprintf("%s: %s (%s)", keyOf(d.Variable), d.Action, d.Reason)
+}
+
+type transferOptions struct {
+	Upsert          bool
+	MergeAttributes bool
+
+	// UpdateOnly skips variables that are not already in the target.
+	UpdateOnly bool
+
+	// Replace deletes and recreates every variable that exists in the
+	// target instead of comparing and updating it.
+	Replace bool
+
+	// Safe refuses to change existing variables unless AllowOverwrite is
+	// set as well.
+	Safe           bool
+	AllowOverwrite bool
+
+	// Expected, if set, is the target state the plan assumed. Every write
+	// first re-reads its variable and fails if it no longer matches.
+	Expected map[variableKey]EnvVar
+
+	// CompareFields, if set, limits the fields that are compared with and
+	// written over an existing variable.
+	CompareFields []string
+
+	// Resolve, if set, is consulted when an upsert would change a value.
+	Resolve resolver
+
+	// OnMaskFailure is one of the maskFailure* strategies.
+	OnMaskFailure string
+
+	// Protect, if set, matches keys that are never written to the target.
+	Protect *regexp.Regexp
+
+	// VariableTimeout bounds the API calls for a single variable; zero
+	// means no limit.
+	VariableTimeout time.Duration
+
+	// Threads is the number of variables written at the same time; zero
+	// or one writes them one after another.
+	Threads int
+
+	// StopOnFailure starts no further writes or deletes after the first
+	// failure, as a transactional run that will be rolled back needs.
+	StopOnFailure bool
+}
+
+// safeModeNote marks the reason of decisions --safe-mode blocked.
+const safeModeNote = "safe mode needs"
+
+// blockedBySafeMode reports whether d was skipped by --safe-mode.
+func blockedBySafeMode(d decision) bool {
+	return d.Action == actionSkip && strings.Contains(d.Reason, safeModeNote)
+}
+
+// Strategies for --on-mask-failure.
+const (
+	maskFailureFail   = "fail"
+	maskFailureSkip   = "skip"
+	maskFailureUnmask = "unmask"
+)
+
+// planTransfer decides what to do with each source variable. existing holds
+// the target's current variables and is only consulted in upsert and safe
+// mode.
+func planTransfer(variables []EnvVar, existing map[variableKey]EnvVar, opts transferOptions) ([]decision, error) {
+	decisions := make([]decision, 0, len(variables))
+	for _, v := range variables {
+		if opts.Protect != nil && opts.Protect.MatchString(v.Key) {
+			decisions = append(decisions, decision{v, actionSkip, "protected by --protect-keys"})
+			continue
+		}
+		if v.Hidden {
+			decisions = append(decisions, decision{v, actionSkip, "hidden, value is not readable"})
+			continue
+		}
+
+		current, exists := existing[keyOf(v)]
+		if opts.Replace {
+			switch {
+			case !exists && opts.UpdateOnly:
+				decisions = append(decisions, decision{v, actionSkip, "not in target, --update-only"})
+			case !exists:
+				decisions = append(decisions, decision{v, actionCreate, "not in target"})
+			case opts.Safe && !opts.AllowOverwrite:
+				decisions = append(decisions, decision{v, actionSkip, "exists in target, " + safeModeNote + " --allow-overwrite"})
+			default:
+				decisions = append(decisions, decision{v, actionReplace, "exists in target, --replace"})
+			}
+			continue
+		}
+		if !opts.Upsert && opts.Safe && exists {
+			decisions = append(decisions, decision{v, actionSkip, "exists in target, " + safeModeNote + " --upsert --allow-overwrite"})
+			continue
+		}
+		if !opts.Upsert {
+			decisions = append(decisions, decision{v, actionCreate, "target not checked without --upsert"})
+			continue
+		}
+
+		if !exists && opts.UpdateOnly {
+			decisions = append(decisions, decision{v, actionSkip, "not in target, --update-only"})
+			continue
+		}
+		if !exists {
+			decisions = append(decisions, decision{v, actionCreate, "not in target"})
+			continue
+		}
+
+		if opts.MergeAttributes {
+			v = mergeUnspecified(v, current)
+		}
+		if opts.CompareFields != nil {
+			v = keepUncompared(v, current, opts.CompareFields)
+		}
+		changed := changedFields(v, current)
+		if opts.Resolve != nil && containsField(changed, fieldValue) {
+			resolved, err := opts.Resolve(v, current)
+			if err != nil {
+				return nil, fmt.Errorf("resolving %s: %v", keyOf(v), err)
+			}
+			v = resolved
+			changed = changedFields(v, current)
+		}
+		switch {
+		case len(changed) == 0:
+			decisions = append(decisions, decision{v, actionUnchanged, "identical"})
+		case opts.Safe && !opts.AllowOverwrite:
+			decisions = append(decisions, decision{v, actionSkip, strings.Join(changed, ", ") + " would change, " + safeModeNote + " --allow-overwrite"})
+		case containsField(changed, fieldValue):
+			decisions = append(decisions, decision{v, actionUpdate, strings.Join(changed, ", ") + " changed"})
+		default:
+			decisions = append(decisions, decision{v, actionUpdateAttributes, strings.Join(changed, ", ") + " changed, value identical"})
+		}
+	}
+	return decisions, nil
+}
+
+// transferVariables applies planned decisions to the target project. Failures
+// are reported per variable and the run continues unless opts.StopOnFailure
+// is set; they are returned together as a *MultiError. Authentication
+// failures stop the transfer and are returned on their own.
+func transferVariables(client *GitLabClient, targetProject string, decisions []decision, opts transferOptions, report reportFunc) error {
+	if opts.Threads > 1 {
+		return transferConcurrently(client, targetProject, decisions, opts, report)
+	}
+	failures := &MultiError{}
+	for _, d := range decisions {
+		v, result, err := transferOne(client, targetProject, d, opts)
+		if err != nil {
+			if isAuthError(err) {
+				return err
+			}
+			failures.add(v, err)
+			report(v, outcomeFailed, err)
+			if opts.StopOnFailure {
+				break
+			}
+			continue
+		}
+		report(v, result, nil)
+	}
+	return failures.errOrNil()
+}
+
+// transferConcurrently is transferVariables with opts.Threads workers. Calls
+// to report are serialized, and failures are returned in plan order. After
+// an authentication failure, or any failure with opts.StopOnFailure, no
+// further variables are started.
+func transferConcurrently(client *GitLabClient, targetProject string, decisions []decision, opts transferOptions, report reportFunc) error {
+	var (
+		mu      sync.Mutex
+		authErr error
+		stopped bool
+		failed  = make([]error, len(decisions))
+		written = make([]EnvVar, len(decisions))
+		next    = make(chan int)
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < opts.Threads; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				v, result, err := transferOne(client, targetProject, decisions[i], opts)
+				mu.Lock()
+				switch {
+				case err != nil && isAuthError(err):
+					if authErr == nil {
+						authErr = err
+					}
+				case err != nil:
+					failed[i], written[i] = err, v
+					report(v, outcomeFailed, err)
+					stopped = stopped || opts.StopOnFailure
+				default:
+					report(v, result, nil)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range decisions {
+		mu.Lock()
+		stop := authErr != nil || stopped
+		mu.Unlock()
+		if stop {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if authErr != nil {
+		return authErr
+	}
+	failures := &MultiError{}
+	for i, err := range failed {
+		if err != nil {
+			failures.add(written[i], err)
+		}
+	}
+	return failures.errOrNil()
+}
+
+// transferOne applies one decision within opts.VariableTimeout and logs a
+// failure.
+func transferOne(client *GitLabClient, targetProject string, d decision, opts transferOptions) (EnvVar, outcome, error) {
+	ctx, cancel := variableContext(opts.VariableTimeout)
+	defer cancel()
+	v, result, err := applyVariable(client.WithContext(ctx), targetProject, d, opts)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %s: %v", errVariableTimeout, opts.VariableTimeout, err)
+	}
+	if err != nil && !isAuthError(err) {
+		log.Printf("Error transferring variable %s: %v", keyOf(v), err)
+	}
+	return v, result, err
+}
+
+// applyVariable applies one decision, falling back per --on-mask-failure when
+// a masked value is rejected. It returns the variable as finally written.
+func applyVariable(client *GitLabClient, targetProject string, d decision, opts transferOptions) (EnvVar, outcome, error) {
+	if opts.Expected != nil && isWrite(d.Action) {
+		if err := checkUnchanged(client, targetProject, d.Variable, opts.Expected); err != nil {
+			return d.Variable, outcomeFailed, err
+		}
+	}
+	result, err := applyDecision(client, targetProject, d)
+	if err != nil && d.Variable.Masked && isMaskError(err) {
+		switch opts.OnMaskFailure {
+		case maskFailureFail:
+			log.Printf("Value of %s does not meet GitLab's masking requirements (see --on-mask-failure)", keyOf(d.Variable))
+		case maskFailureSkip:
+			log.Printf("Warning: value of %s cannot be masked, skipping it", keyOf(d.Variable))
+			return d.Variable, outcomeSkipped, nil
+		case maskFailureUnmask:
+			log.Printf("Warning: value of %s cannot be masked, retrying as unmasked", keyOf(d.Variable))
+			d.Variable.Masked = false
+			result, err = applyDecision(client, targetProject, d)
+		}
+	}
+	return d.Variable, result, err
+}
+
+func isWrite(a action) bool {
+	return a == actionCreate || a == actionUpdate || a == actionUpdateAttributes || a == actionReplace
+}
+
+// checkUnchanged re-reads v's target variable and returns an
+// errChangedSincePlan error if it differs from expected, the state the plan
+// was computed against.
+func checkUnchanged(client *GitLabClient, targetProject string, v EnvVar, expected map[variableKey]EnvVar) error {
+	assumed, existed := expected[keyOf(v)]
+	current, err := client.GetVariable(targetProject, v.Key, v.EnvironmentScope)
+	switch {
+	case isNotFound(err):
+		if existed {
+			return fmt.Errorf("%w: deleted", errChangedSincePlan)
+		}
+		return nil
+	case err != nil:
+		return err
+	case !existed:
+		return fmt.Errorf("%w: created", errChangedSincePlan)
+	}
+	if changed := changedFields(*current, assumed); len(changed) > 0 {
+		return fmt.Errorf("%w: %s changed", errChangedSincePlan, strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// variableContext returns the context for one variable's API calls.
+func variableContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// applyDecision performs the API call for a single decision.
+func applyDecision(client *GitLabClient, targetProject string, d decision) (outcome, error) {
+	v := d.Variable
+	switch d.Action {
+	case actionSkip:
+		log.Printf("Skipping variable %s: %s", keyOf(v), d.Reason)
+		return outcomeSkipped, nil
+	case actionUnchanged:
+		log.Printf("Variable %s is unchanged, skipping", keyOf(v))
+		return outcomeUnchanged, nil
+	case actionCreate:
+		log.Printf("Transferring variable: %s", keyOf(v))
+		return outcomeCreated, client.CreateVariable(targetProject, v, false)
+	case actionUpdate:
+		log.Printf("Updating variable: %s", keyOf(v))
+		return createIfMissing(client, targetProject, v, client.UpdateVariable(targetProject, v))
+	case actionUpdateAttributes:
+		log.Printf("Updating attributes of variable: %s (%s)", keyOf(v), d.Reason)
+		return createIfMissing(client, targetProject, v, client.UpdateVariableAttributes(targetProject, v))
+	case actionReplace:
+		log.Printf("Replacing variable: %s", keyOf(v))
+		if err := client.DeleteVariable(targetProject, v); err != nil && !isNotFound(err) {
+			return outcomeFailed, err
+		}
+		if err := client.CreateVariable(targetProject, v, false); err != nil {
+			return outcomeFailed, fmt.Errorf("%w: %w", errNotRecreated, err)
+		}
+		return outcomeUpdated, nil
+	}
+	return outcomeFailed, fmt.Errorf("unknown action %q", d.Action)
+}
+
+// createIfMissing handles the result of an update. Updates are addressed by
+// key and scope, so a 404 means that exact scope does not exist (any more),
+// even if other scopes of the key do; the variable is created instead.
+func createIfMissing(client *GitLabClient, targetProject string, v EnvVar, err error) (outcome, error) {
+	if !isNotFound(err) {
+		return outcomeUpdated, err
+	}
+	log.Printf("Variable %s not found in target, creating it", keyOf(v))
+	return outcomeCreated, client.CreateVariable(targetProject, v, false)
+}
@@ -0,0 +1,491 @@
+package envsync
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// existingVars indexes variables by key and scope, as a sync does with the
+// target's variables.
+func existingVars(variables ...EnvVar) map[variableKey]EnvVar {
+	existing := map[variableKey]EnvVar{}
+	for _, v := range variables {
+		existing[keyOf(v)] = v
+	}
+	return existing
+}
+
+// transfer plans and applies source over the target's current variables.
+func transfer(t *testing.T, f *fakeGitLab, target string, source []EnvVar, opts transferOptions) []decision {
+	t.Helper()
+	client := f.client()
+	current, err := client.GetVariables(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decisions, err := planTransfer(source, existingVars(current...), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transferVariables(client, target, decisions, opts, func(EnvVar, outcome, error) {}); err != nil {
+		t.Fatal(err)
+	}
+	return decisions
+}
+
+// Only attributes differ, so the update never sends the value.
+func TestUpsertSendsAttributesOnly(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{envVar("A", "same", ""), envVar("B", "old", "")}
+	protected := envVar("A", "same", "")
+	protected.Protected = true
+	source := []EnvVar{protected, envVar("B", "new", "")}
+
+	decisions := transfer(t, f, "g/dst", source, transferOptions{Upsert: true})
+	if decisions[0].Action != actionUpdateAttributes || decisions[1].Action != actionUpdate {
+		t.Fatalf("decisions = %v, want update-attributes for A and update for B", decisions)
+	}
+
+	puts := f.received(http.MethodPut)
+	if len(puts) != 2 {
+		t.Fatalf("PUT requests = %v, want two", puts)
+	}
+	for _, put := range puts {
+		var body map[string]interface{}
+		if err := json.Unmarshal([]byte(put.Body), &body); err != nil {
+			t.Fatal(err)
+		}
+		_, hasValue := body["value"]
+		switch put.Path {
+		case "projects/g%2Fdst/variables/A":
+			if hasValue || body["protected"] != true {
+				t.Errorf("A: body %s, want protected without the value", put.Body)
+			}
+		case "projects/g%2Fdst/variables/B":
+			if !hasValue {
+				t.Errorf("B: body %s, want the value", put.Body)
+			}
+		}
+	}
+	if got := f.vars("g/dst"); got[0].Value != "same" || !got[0].Protected || got[1].Value != "new" {
+		t.Errorf("target = %v", got)
+	}
+}
+
+func TestPlanTransferReasons(t *testing.T) {
+	existing := existingVars(envVar("SAME", "1", ""), envVar("VALUE", "old", ""), envVar("ATTR", "1", ""))
+	attr := envVar("ATTR", "1", "")
+	attr.Masked = true
+	source := []EnvVar{envVar("NEW", "1", ""), envVar("SAME", "1", ""), envVar("VALUE", "new", ""), attr}
+
+	decisions, err := planTransfer(source, existing, transferOptions{Upsert: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"NEW@*: create (not in target)",
+		"SAME@*: unchanged (identical)",
+		"VALUE@*: update (value changed)",
+		"ATTR@*: update-attributes (masked changed, value identical)",
+	}
+	for i, d := range decisions {
+		if d.String() != want[i] {
+			t.Errorf("decision %d = %q, want %q", i, d, want[i])
+		}
+	}
+}
+
+// --explain logs one line per source variable, including those filtered out.
+func TestExplainLogsEveryVariable(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("KEEP", "1", ""), envVar("DROP", "2", "")}
+	f.projects["g/dst"] = nil
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--explain", "--dry-run", "--where", `key == "KEEP"`)...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	for _, line := range []string{
+		"explain: DROP@*: filtered out (--where)",
+		"explain: KEEP@*: create (target not checked without --upsert)",
+	} {
+		if !strings.Contains(stderr, line) {
+			t.Errorf("stderr lacks %q:\n%s", line, stderr)
+		}
+	}
+}
+
+// rejectMasked makes the fake answer masked creates as GitLab does for a
+// value that does not meet the masking requirements.
+func rejectMasked(f *fakeGitLab) {
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost && strings.Contains(r.Body, `"masked":true`) {
+			return http.StatusBadRequest, `{"message":{"value":["is invalid"]}}`
+		}
+		return 0, ""
+	}
+}
+
+func TestOnMaskFailure(t *testing.T) {
+	for _, test := range []struct {
+		strategy string
+		result   outcome
+		created  bool
+	}{
+		{maskFailureFail, outcomeFailed, false},
+		{maskFailureSkip, outcomeSkipped, false},
+		{maskFailureUnmask, outcomeCreated, true},
+	} {
+		t.Run(test.strategy, func(t *testing.T) {
+			f := newFakeGitLab(t)
+			f.projects["g/dst"] = nil
+			rejectMasked(f)
+			secret := envVar("SECRET", "short", "")
+			secret.Masked = true
+			opts := transferOptions{OnMaskFailure: test.strategy}
+
+			var results []outcome
+			err := transferVariables(f.client(), "g/dst", []decision{{secret, actionCreate, ""}}, opts, func(v EnvVar, result outcome, err error) {
+				results = append(results, result)
+			})
+			if (err != nil) != (test.result == outcomeFailed) {
+				t.Errorf("err = %v", err)
+			}
+			if len(results) != 1 || results[0] != test.result {
+				t.Errorf("results = %v, want %v", results, test.result)
+			}
+			got := f.vars("g/dst")
+			if test.created != (len(got) == 1) || test.created && got[0].Masked {
+				t.Errorf("target = %v, want created unmasked: %v", got, test.created)
+			}
+		})
+	}
+}
+
+// hang makes the fake hold requests for one variable until the test ends.
+func hang(t *testing.T, f *fakeGitLab, key string) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodPost && strings.Contains(r.Body, `"key":"`+key+`"`) {
+			<-release
+		}
+		return 0, ""
+	}
+}
+
+// A hung variable times out on its own; the rest of the run goes on.
+func TestVariableTimeout(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = nil
+	hang(t, f, "SLOW")
+	decisions := []decision{
+		{envVar("A", "1", ""), actionCreate, ""},
+		{envVar("SLOW", "2", ""), actionCreate, ""},
+		{envVar("B", "3", ""), actionCreate, ""},
+	}
+	summary := newRunSummary("g/src", "g/dst", false)
+
+	start := time.Now()
+	err := transferVariables(f.client(), "g/dst", decisions, transferOptions{VariableTimeout: 50 * time.Millisecond}, summary.record)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("transfer took %s", elapsed)
+	}
+	var failures *MultiError
+	if !errors.As(err, &failures) || failures.Get("SLOW@*") == nil || !isTimeout(failures.Get("SLOW@*")) {
+		t.Fatalf("err = %v, want SLOW@* timed out", err)
+	}
+	if summary.Created != 2 || summary.Failed != 1 || summary.TimedOut != 1 || summary.TimedOutKeys[0] != "SLOW@*" {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestPlanTransferUpdateOnly(t *testing.T) {
+	existing := existingVars(envVar("SHARED", "old", ""), envVar("SAME", "1", ""))
+	source := []EnvVar{envVar("SHARED", "new", ""), envVar("SAME", "1", ""), envVar("NEW", "1", ""), envVar("SHARED", "new", "production")}
+	for _, replace := range []bool{false, true} {
+		decisions, err := planTransfer(source, existing, transferOptions{Upsert: true, UpdateOnly: true, Replace: replace, OnMaskFailure: maskFailureFail})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []action{actionUpdate, actionUnchanged, actionSkip, actionSkip}
+		if replace {
+			// --replace recreates every existing variable.
+			want[0], want[1] = actionReplace, actionReplace
+		}
+		for i, d := range decisions {
+			if d.Action != want[i] {
+				t.Errorf("replace %t: %s: %s, want %s", replace, keyOf(d.Variable), d.Action, want[i])
+			}
+		}
+		if decisions[2].Reason != "not in target, --update-only" {
+			t.Errorf("reason = %q", decisions[2].Reason)
+		}
+	}
+}
+
+// --update-only never creates and leaves target-only variables alone.
+func TestUpdateOnlyCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("SHARED", "new", ""), envVar("SOURCE_ONLY", "1", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("SHARED", "old", ""), envVar("TARGET_ONLY", "1", "")}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--update-only")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if writes := f.writes(); len(writes) != 1 || writes[0] != "PUT projects/g%2Fdst/variables/SHARED" {
+		t.Errorf("writes = %v, want only the update of SHARED", writes)
+	}
+	got := f.vars("g/dst")
+	if len(got) != 2 || got[0].Value != "new" || got[1].Key != "TARGET_ONLY" {
+		t.Errorf("target = %v", got)
+	}
+}
+
+func TestPlanTransferSafeMode(t *testing.T) {
+	existing := existingVars(envVar("CHANGED", "old", ""), envVar("SAME", "1", ""))
+	source := []EnvVar{envVar("CHANGED", "new", ""), envVar("SAME", "1", ""), envVar("NEW", "1", "")}
+	for _, test := range []struct {
+		name string
+		opts transferOptions
+		want []action
+	}{
+		{"upsert", transferOptions{Upsert: true, Safe: true}, []action{actionSkip, actionUnchanged, actionCreate}},
+		{"create only", transferOptions{Safe: true}, []action{actionSkip, actionSkip, actionCreate}},
+		{"replace", transferOptions{Replace: true, Safe: true}, []action{actionSkip, actionSkip, actionCreate}},
+		{"allow overwrite", transferOptions{Upsert: true, Safe: true, AllowOverwrite: true}, []action{actionUpdate, actionUnchanged, actionCreate}},
+	} {
+		test.opts.OnMaskFailure = maskFailureFail
+		decisions, err := planTransfer(source, existing, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, d := range decisions {
+			if d.Action != test.want[i] {
+				t.Errorf("%s: %s: %s, want %s", test.name, keyOf(d.Variable), d.Action, test.want[i])
+			}
+			if d.Action == actionSkip && !blockedBySafeMode(d) {
+				t.Errorf("%s: %s skipped for %q", test.name, keyOf(d.Variable), d.Reason)
+			}
+		}
+	}
+}
+
+// In --safe-mode only creates go through until the destructive actions are
+// allowed, and the run says what it did not do.
+func TestSafeModeCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("NEW", "1", ""), envVar("CHANGED", "new", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("CHANGED", "old", ""), envVar("STALE", "1", "")}
+	args := f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--prune", "--yes", "--safe-mode")
+
+	_, stderr, code := runMain(t, "", args...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if writes := f.writes(); len(writes) != 1 || writes[0] != "POST projects/g%2Fdst/variables" {
+		t.Errorf("writes = %v, want only the create", writes)
+	}
+	for _, want := range []string{
+		"Safe mode: not changing CHANGED@* (value would change, safe mode needs --allow-overwrite)",
+		"Safe mode: not deleting STALE@* (pass --allow-delete)",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr does not contain %q:\n%s", want, stderr)
+		}
+	}
+
+	if _, stderr, code := runMain(t, "", append(args, "--allow-overwrite", "--allow-delete")...); code != 0 {
+		t.Fatalf("allowed: exit code %d; stderr:\n%s", code, stderr)
+	}
+	got := f.vars("g/dst")
+	if len(got) != 2 || got[0].Key != "CHANGED" || got[0].Value != "new" || got[1].Key != "NEW" {
+		t.Errorf("target = %v, want CHANGED updated and STALE deleted", got)
+	}
+}
+
+// With Expected set, writes whose target changed after planning fail and the
+// others go through.
+func TestVerifyBeforeWrite(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{envVar("STEADY", "old", ""), envVar("EDITED", "old", ""), envVar("DELETED", "old", "")}
+	existing := existingVars(f.vars("g/dst")...)
+	source := []EnvVar{envVar("STEADY", "new", ""), envVar("EDITED", "new", ""), envVar("DELETED", "new", ""), envVar("RACED", "new", "")}
+	opts := transferOptions{Upsert: true, OnMaskFailure: maskFailureFail}
+	decisions, err := planTransfer(source, existing, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent edits between planning and writing.
+	f.mu.Lock()
+	f.projects["g/dst"] = []EnvVar{envVar("STEADY", "old", ""), envVar("EDITED", "manual", ""), envVar("RACED", "manual", "")}
+	f.mu.Unlock()
+
+	opts.Expected = existing
+	errs := map[string]error{}
+	err = transferVariables(f.client(), "g/dst", decisions, opts, func(v EnvVar, result outcome, err error) {
+		errs[v.Key] = err
+	})
+	if err == nil {
+		t.Fatal("no error for the changed variables")
+	}
+	if errs["STEADY"] != nil {
+		t.Errorf("STEADY: %v", errs["STEADY"])
+	}
+	for key, want := range map[string]string{"EDITED": "value changed", "DELETED": "deleted", "RACED": "created"} {
+		if !errors.Is(errs[key], errChangedSincePlan) || !strings.Contains(errs[key].Error(), want) {
+			t.Errorf("%s: err = %v, want %q", key, errs[key], want)
+		}
+	}
+	got := map[string]string{}
+	for _, v := range f.vars("g/dst") {
+		got[v.Key] = v.Value
+	}
+	if want := map[string]string{"STEADY": "new", "EDITED": "manual", "RACED": "manual"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("target = %v, want %v", got, want)
+	}
+}
+
+func TestVerifyBeforeWriteCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "new", "")}
+	f.projects["g/dst"] = []EnvVar{envVar("A", "old", "")}
+	// Someone edits A after the target was listed, just before the re-read.
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Method == http.MethodGet && r.Path == "projects/g%2Fdst/variables/A" {
+			f.mu.Lock()
+			f.projects["g/dst"][0].Value = "manual"
+			f.mu.Unlock()
+		}
+		return 0, ""
+	}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--upsert", "--verify-before-write")...)
+	if code != exitFailure {
+		t.Errorf("exit code %d, want %d", code, exitFailure)
+	}
+	if !strings.Contains(stderr, "target changed since planning: value changed") {
+		t.Errorf("stderr:\n%s", stderr)
+	}
+	if got := f.vars("g/dst"); got[0].Value != "manual" {
+		t.Errorf("the manual edit was overwritten: %v", got)
+	}
+}
+
+// --replace deletes the exact (key, scope) variant and creates it fresh, so
+// attributes the source does not set are not carried over; other scopes of
+// the key are left alone.
+func TestReplaceDeletesThenCreates(t *testing.T) {
+	f := newFakeGitLab(t)
+	old := envVar("KEY", "old", "")
+	old.Protected, old.Description = true, "stale"
+	f.projects["g/src"] = []EnvVar{envVar("KEY", "new", ""), envVar("NEW", "1", "")}
+	f.projects["g/dst"] = []EnvVar{old, envVar("KEY", "prod", "production")}
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--replace")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	want := []string{"DELETE projects/g%2Fdst/variables/KEY", "POST projects/g%2Fdst/variables", "POST projects/g%2Fdst/variables"}
+	if writes := f.writes(); strings.Join(writes, ",") != strings.Join(want, ",") {
+		t.Errorf("writes = %v, want %v", writes, want)
+	}
+	if del := f.received(http.MethodDelete)[0]; del.Query.Get("filter[environment_scope]") != "*" {
+		t.Errorf("delete %v is not filtered by scope", del.Query)
+	}
+	got := map[variableKey]EnvVar{}
+	for _, v := range f.vars("g/dst") {
+		got[keyOf(v)] = v
+	}
+	if v := got[keyOf(envVar("KEY", "", ""))]; v.Value != "new" || v.Protected || v.Description != "" {
+		t.Errorf("KEY@* = %+v, want recreated from the source", v)
+	}
+	if v := got[keyOf(envVar("KEY", "", "production"))]; v.Value != "prod" {
+		t.Errorf("KEY@production = %+v, want untouched", v)
+	}
+	if len(got) != 3 {
+		t.Errorf("target = %v", got)
+	}
+}
+
+// A variant deleted by someone else between the read and the replace is
+// simply created.
+func TestReplaceOfVanishedVariable(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{}
+	decisions := []decision{{envVar("KEY", "new", ""), actionReplace, ""}}
+	var outcomes []outcome
+	if err := transferVariables(f.client(), "g/dst", decisions, transferOptions{Replace: true}, func(_ EnvVar, o outcome, _ error) { outcomes = append(outcomes, o) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 1 || outcomes[0] != outcomeUpdated {
+		t.Errorf("outcomes = %v", outcomes)
+	}
+	if got := f.vars("g/dst"); len(got) != 1 || got[0].Value != "new" {
+		t.Errorf("target = %v", got)
+	}
+}
+
+// Updates always carry a variable_type, env_var when the source has none,
+// since some instances reject updates without one.
+func TestUpdateSendsVariableType(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{envVar("A", "old", ""), envVar("B", "same", ""), envVar("C", "old", "")}
+	client := f.client()
+
+	untyped := EnvVar{Key: "A", Value: "new", EnvironmentScope: "*"}
+	if err := client.UpdateVariable("g/dst", untyped); err != nil {
+		t.Fatal(err)
+	}
+	untyped = EnvVar{Key: "B", Value: "same", EnvironmentScope: "*", Protected: true}
+	if err := client.UpdateVariableAttributes("g/dst", untyped); err != nil {
+		t.Fatal(err)
+	}
+	file := envVar("C", "new", "")
+	file.VariableType = "file"
+	if err := client.UpdateVariable("g/dst", file); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"projects/g%2Fdst/variables/A": "env_var",
+		"projects/g%2Fdst/variables/B": "env_var",
+		"projects/g%2Fdst/variables/C": "file",
+	}
+	puts := f.received(http.MethodPut)
+	if len(puts) != len(want) {
+		t.Fatalf("PUT requests = %v, want %d", puts, len(want))
+	}
+	for _, put := range puts {
+		var body map[string]interface{}
+		if err := json.Unmarshal([]byte(put.Body), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body["variable_type"] != want[put.Path] {
+			t.Errorf("%s: body %s, want variable_type %q", put, put.Body, want[put.Path])
+		}
+	}
+}
+
+// A source variable without a type equals a target env_var variable, so an
+// upsert leaves it alone.
+func TestUntypedSourceIsUnchanged(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/dst"] = []EnvVar{envVar("A", "1", "")}
+	source := []EnvVar{{Key: "A", Value: "1", EnvironmentScope: "*"}}
+
+	decisions := transfer(t, f, "g/dst", source, transferOptions{Upsert: true})
+	if decisions[0].Action != actionUnchanged {
+		t.Errorf("decision = %+v, want unchanged", decisions[0])
+	}
+	if writes := f.writes(); len(writes) != 0 {
+		t.Errorf("writes = %v, want none", writes)
+	}
+}
@@ -0,0 +1,87 @@
+package envsync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// ClientOption customizes a GitLabClient.
+type ClientOption func(*GitLabClient)
+
+// WithDialContext routes all connections through dial, e.g. an SSH tunnel to
+// a bastion host.
+func WithDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) ClientOption {
+	return func(c *GitLabClient) {
+		c.transport().DialContext = dial
+	}
+}
+
+// WithProxy sends requests through a proxy. socks5:// URLs are supported.
+func WithProxy(proxyURL *url.URL) ClientOption {
+	return func(c *GitLabClient) {
+		c.transport().Proxy = http.ProxyURL(proxyURL)
+	}
+}
+
+// ConnectionPool sizes the client's connection pool. Zero fields keep the
+// defaults of http.DefaultTransport.
+type ConnectionPool struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+}
+
+// WithConnectionPool sizes the connection pool. Go keeps only two idle
+// connections per host by default, so requests issued in parallel to one
+// GitLab instance keep opening new connections.
+func WithConnectionPool(pool ConnectionPool) ClientOption {
+	return func(c *GitLabClient) {
+		t := c.transport()
+		if pool.MaxIdleConns > 0 {
+			t.MaxIdleConns = pool.MaxIdleConns
+		}
+		if pool.MaxIdleConnsPerHost > 0 {
+			t.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+		}
+		if pool.MaxConnsPerHost > 0 {
+			t.MaxConnsPerHost = pool.MaxConnsPerHost
+		}
+	}
+}
+
+// transport returns the client's own *http.Transport, cloning the default
+// one on first use so options never change http.DefaultTransport. Options
+// that wrap the transport keep it at the bottom, so it can still be
+// configured after them.
+func (c *GitLabClient) transport() *http.Transport {
+	if c.base != nil {
+		return c.base
+	}
+	if t, ok := c.httpClient.Transport.(*http.Transport); ok {
+		c.base = t
+		return t
+	}
+	c.base = http.DefaultTransport.(*http.Transport).Clone()
+	if c.httpClient.Transport == nil {
+		c.httpClient.Transport = c.base
+	}
+	return c.base
+}
+
+// socks5ProxyURL parses a --socks5 value, either host:port or a full
+// socks5:// URL.
+func socks5ProxyURL(value string) (*url.URL, error) {
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.Host != "" {
+		if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+			return nil, fmt.Errorf("invalid --socks5 %q: unsupported scheme %s", value, u.Scheme)
+		}
+		return u, nil
+	}
+	if _, _, err := net.SplitHostPort(value); err != nil {
+		return nil, fmt.Errorf("invalid --socks5 %q: expected host:port", value)
+	}
+	return &url.URL{Scheme: "socks5", Host: value}, nil
+}
@@ -0,0 +1,134 @@
+package envsync
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingDialer dials like net.Dialer and counts the connections.
+func countingDialer(count *int32) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(count, 1)
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+func TestWithDialContext(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = []EnvVar{envVar("A", "1", "")}
+	var dials int32
+	client := f.client(WithDialContext(countingDialer(&dials)))
+
+	if _, err := client.GetVariables("g/app"); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&dials) == 0 {
+		t.Error("the injected dialer was not used")
+	}
+	if http.DefaultTransport.(*http.Transport).DialContext == nil {
+		t.Fatal("http.DefaultTransport lost its dialer")
+	}
+}
+
+// Options configuring the transport still apply after one that wraps it.
+func TestWithDialContextAfterRetries(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/app"] = nil
+	var calls int32
+	f.intercept = func(r fakeRequest) (int, string) {
+		if r.Path == "projects/g%2Fapp/variables" && atomic.AddInt32(&calls, 1) == 1 {
+			return http.StatusServiceUnavailable, `{"message":"busy"}`
+		}
+		return 0, ""
+	}
+	backoff, err := NewBackoff(backoffConstant, time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dials int32
+	client := f.client(WithRetries(1, backoff), WithDialContext(countingDialer(&dials)))
+
+	if _, err := client.GetVariables("g/app"); err != nil {
+		t.Fatalf("the retry was lost: %v", err)
+	}
+	if atomic.LoadInt32(&dials) == 0 {
+		t.Error("the injected dialer was not used")
+	}
+}
+
+func TestSocks5ProxyURL(t *testing.T) {
+	for value, want := range map[string]string{
+		"localhost:1080":          "socks5://localhost:1080",
+		"socks5h://bastion:1080":  "socks5h://bastion:1080",
+		"socks5://user:pw@h:1080": "socks5://user:pw@h:1080",
+	} {
+		u, err := socks5ProxyURL(value)
+		if err != nil || u.String() != want {
+			t.Errorf("socks5ProxyURL(%q) = %v, %v, want %s", value, u, err, want)
+		}
+	}
+	for _, value := range []string{"http://proxy:8080", "bastion"} {
+		if _, err := socks5ProxyURL(value); err == nil {
+			t.Errorf("socks5ProxyURL(%q) succeeded", value)
+		}
+	}
+}
+
+func TestWithConnectionPool(t *testing.T) {
+	client := NewGitLabClient("https://gitlab.example.com", "token",
+		WithConnectionPool(ConnectionPool{MaxIdleConnsPerHost: 32, MaxConnsPerHost: 64}))
+	got := client.transport()
+	if got.MaxIdleConnsPerHost != 32 || got.MaxConnsPerHost != 64 {
+		t.Errorf("pool = %d idle per host, %d per host", got.MaxIdleConnsPerHost, got.MaxConnsPerHost)
+	}
+	if want := http.DefaultTransport.(*http.Transport).MaxIdleConns; got.MaxIdleConns != want {
+		t.Errorf("MaxIdleConns = %d, want the default %d", got.MaxIdleConns, want)
+	}
+	if http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost == 32 {
+		t.Error("http.DefaultTransport was changed")
+	}
+}
+
+// BenchmarkConnectionPool reads variables from 32 goroutines per CPU. With
+// the default of two idle connections per host most requests open a new
+// connection; a pool sized for the workers reuses them. Compare the dials/op
+// and ns/op of the two cases.
+func BenchmarkConnectionPool(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Microsecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	for _, bench := range []struct {
+		name string
+		pool ConnectionPool
+	}{
+		{"default", ConnectionPool{}},
+		{"pool-32", ConnectionPool{MaxIdleConns: 100, MaxIdleConnsPerHost: 32}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var dials int32
+			client := NewGitLabClient(server.URL, "token", WithConnectionPool(bench.pool), WithDialContext(countingDialer(&dials)))
+			defer client.httpClient.CloseIdleConnections()
+			b.SetParallelism(32)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := client.GetVariables("g/app"); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(atomic.LoadInt32(&dials))/float64(b.N), "dials/op")
+		})
+	}
+}
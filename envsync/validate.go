@@ -0,0 +1,109 @@
+package envsync
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// variableKeyPattern is GitLab's rule for variable keys.
+var variableKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,255}$`)
+
+// maskingRules is what a GitLab release accepts as a masked value.
+type maskingRules struct {
+	// since is the first release with these rules.
+	since   GitLabVersion
+	pattern *regexp.Regexp
+	// allowed describes pattern for error messages.
+	allowed string
+}
+
+// maskingRuleSets lists the masking rules by release, newest first: the
+// original Base64 alphabet with @ and :, then the wider URL-safe set, and
+// since 17.0 any printable ASCII character but a space.
+var maskingRuleSets = []maskingRules{
+	{GitLabVersion{17, 0}, regexp.MustCompile(`^[!-~]{8,}$`), "printable ASCII characters other than space"},
+	{GitLabVersion{13, 0}, regexp.MustCompile(`^[A-Za-z0-9@_\-:+./=~]{8,}$`), "A-Z, a-z, 0-9 and @_-:+./=~"},
+	{GitLabVersion{0, 0}, regexp.MustCompile(`^[A-Za-z0-9+/=@:]{8,}$`), "A-Z, a-z, 0-9 and +/=@:"},
+}
+
+// defaultMaskingRules apply when the instance's version is unknown.
+var defaultMaskingRules = maskingRuleSets[1]
+
+// maskingRulesFor returns the masking rules of a release.
+func maskingRulesFor(version GitLabVersion) maskingRules {
+	for _, rules := range maskingRuleSets {
+		if version.atLeast(rules.since.Major, rules.since.Minor) {
+			return rules
+		}
+	}
+	return defaultMaskingRules
+}
+
+// validateMaskedValue checks value against the masking rules.
+func validateMaskedValue(value string, rules maskingRules) error {
+	if !rules.pattern.MatchString(value) {
+		return fmt.Errorf("masked value must be a single line of at least 8 characters from %s", rules.allowed)
+	}
+	return nil
+}
+
+// validateVariables checks variables against the rules GitLab enforces when
+// they are written and returns one message per problem. Tokenized values are
+// not checked against the masking rules since their real value is unknown.
+func validateVariables(variables []EnvVar, masking maskingRules) []string {
+	var problems []string
+	seen := map[variableKey]bool{}
+	for _, v := range variables {
+		k := keyOf(v)
+		if !variableKeyPattern.MatchString(v.Key) {
+			problems = append(problems, fmt.Sprintf("%s: key must be 1-255 letters, digits or underscores", k))
+		}
+		if seen[k] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate key and scope", k))
+		}
+		seen[k] = true
+
+		switch v.VariableType {
+		case "", "env_var", "file":
+		default:
+			problems = append(problems, fmt.Sprintf("%s: unknown variable_type %q (use env_var or file)", k, v.VariableType))
+		}
+
+		if v.Masked && !strings.HasPrefix(v.Value, tokenPrefix) {
+			if err := validateMaskedValue(v.Value, masking); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", k, err))
+			}
+		}
+	}
+	return problems
+}
+
+// runValidatePlan validates a plan file without contacting GitLab and returns
+// the process exit code.
+func runValidatePlan(filename string, identity *age.X25519Identity, strict bool, masking maskingRules) int {
+	plan, err := readDryRunOutput(filename, identity, strict)
+	if err != nil {
+		log.Fatalf("Error reading plan file: %v", err)
+	}
+
+	problems := validateVariables(plan.Variables, masking)
+	for _, ref := range plan.Prune {
+		if !variableKeyPattern.MatchString(ref.Key) {
+			problems = append(problems, fmt.Sprintf("prune %s@%s: invalid key", ref.Key, normalizeScope(ref.EnvironmentScope)))
+		}
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		log.Printf("Plan %s is invalid: %d problems in %d variables", filename, len(problems), len(plan.Variables))
+		return exitInvalid
+	}
+	log.Printf("Plan %s is valid: %d variables, %d to prune", filename, len(plan.Variables), len(plan.Prune))
+	return 0
+}
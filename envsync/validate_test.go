@@ -0,0 +1,66 @@
+package envsync
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateVariables(t *testing.T) {
+	masked := envVar("TOKEN", "short", "")
+	masked.Masked = true
+	tokenized := envVar("SECRET", tokenPrefix+"00ff", "")
+	tokenized.Masked = true
+	weird := envVar("CERT", "x", "")
+	weird.VariableType = "binary"
+	variables := []EnvVar{
+		envVar("OK", "1", ""),
+		envVar("BAD-KEY", "1", ""),
+		envVar("OK", "2", "*"),
+		masked,
+		tokenized,
+		weird,
+	}
+
+	got := validateVariables(variables, defaultMaskingRules)
+	want := []string{
+		"BAD-KEY@*: key must be 1-255 letters, digits or underscores",
+		"OK@*: duplicate key and scope",
+		"TOKEN@*: masked value must be a single line of at least 8 characters from A-Z, a-z, 0-9 and @_-:+./=~",
+		`CERT@*: unknown variable_type "binary" (use env_var or file)`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("problems =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// --validate-plan needs no GitLab URL or token and makes no API calls.
+func TestValidatePlanOffline(t *testing.T) {
+	valid := writeFile(t, "valid.json", `{
+		"source_project": "g/src",
+		"target_project": "g/dst",
+		"variables": [{"key": "A", "value": "1", "variable_type": "env_var", "environment_scope": "*"}],
+		"prune": [{"key": "OLD", "environment_scope": "staging"}]
+	}`)
+	if _, stderr, code := runMain(t, "", "--validate-plan", valid); code != 0 || !strings.Contains(stderr, "is valid: 1 variables, 1 to prune") {
+		t.Errorf("valid plan: exit code %d; stderr:\n%s", code, stderr)
+	}
+
+	invalid := writeFile(t, "invalid.json", `{
+		"variables": [
+			{"key": "A", "value": "1"},
+			{"key": "A", "value": "2", "environment_scope": "*"},
+			{"key": "PW", "value": "short", "masked": true}
+		],
+		"prune": [{"key": "bad key", "environment_scope": "*"}]
+	}`)
+	stdout, stderr, code := runMain(t, "", "--validate-plan", invalid)
+	if code != exitInvalid {
+		t.Fatalf("invalid plan: exit code %d, want %d; stderr:\n%s", code, exitInvalid, stderr)
+	}
+	for _, want := range []string{"A@*: duplicate key and scope", "PW@*: masked value", "prune bad key@*: invalid key"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("report lacks %q:\n%s", want, stdout)
+		}
+	}
+}
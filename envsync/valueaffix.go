@@ -0,0 +1,27 @@
+package envsync
+
+import (
+	"log"
+	"regexp"
+)
+
+// affixValues adds prefix and suffix to the values of the variables whose key
+// matches keys, or of all variables if keys is nil. Hidden variables are left
+// alone, since their values cannot be read. With show set, each new value is
+// logged with the original part redacted.
+func affixValues(variables []EnvVar, prefix, suffix string, keys *regexp.Regexp, show bool) []EnvVar {
+	result := make([]EnvVar, len(variables))
+	count := 0
+	for i, v := range variables {
+		if (keys == nil || keys.MatchString(v.Key)) && !v.Hidden {
+			count++
+			v.Value = prefix + v.Value + suffix
+			if show {
+				log.Printf("Value of %s: %s%s%s", keyOf(v), prefix, redactedPlaceholder, suffix)
+			}
+		}
+		result[i] = v
+	}
+	log.Printf("Added --value-prefix/--value-suffix to %d values", count)
+	return result
+}
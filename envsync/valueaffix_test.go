@@ -0,0 +1,70 @@
+package envsync
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAffixValues(t *testing.T) {
+	hidden := envVar("HIDDEN", "", "")
+	hidden.Hidden = true
+	variables := []EnvVar{envVar("DB_NAME", "app", "production"), envVar("HOST", "db", ""), hidden}
+
+	for _, test := range []struct {
+		name           string
+		prefix, suffix string
+		keys           *regexp.Regexp
+		want           []string
+	}{
+		{"suffix", "", "-prod", nil, []string{"app-prod", "db-prod", ""}},
+		{"prefix", "prod-", "", nil, []string{"prod-app", "prod-db", ""}},
+		{"both", "[", "]", nil, []string{"[app]", "[db]", ""}},
+		{"suffix with keys", "", "-prod", regexp.MustCompile(`^(?:DB_.*)$`), []string{"app-prod", "db", ""}},
+		{"prefix with keys", "prod-", "", regexp.MustCompile(`^(?:HOST|HIDDEN)$`), []string{"app", "prod-db", ""}},
+	} {
+		got := affixValues(variables, test.prefix, test.suffix, test.keys, false)
+		for i, v := range got {
+			if v.Value != test.want[i] {
+				t.Errorf("%s: %s = %q, want %q", test.name, v.Key, v.Value, test.want[i])
+			}
+		}
+	}
+	if variables[0].Value != "app" {
+		t.Error("affixValues changed its input")
+	}
+}
+
+// The log shows the added text but never the original value.
+func TestAffixValuesLogsRedacted(t *testing.T) {
+	logs := captureLog(t)
+	affixValues([]EnvVar{envVar("DB_NAME", "s3cr3t", "production")}, "", "-prod", nil, true)
+
+	if !strings.Contains(logs.String(), "Value of DB_NAME@production: [redacted]-prod") {
+		t.Errorf("log = %q, want the redacted new value", logs)
+	}
+	if strings.Contains(logs.String(), "s3cr3t") {
+		t.Errorf("log = %q leaks the value", logs)
+	}
+}
+
+func TestValueAffixCommand(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("DB_NAME", "app", ""), envVar("HOST", "db", "")}
+	f.projects["g/dst"] = nil
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--value-suffix", "-prod", "--value-affix-keys", "DB_.*")...)
+	if code != 0 {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	got := map[string]string{}
+	for _, v := range f.vars("g/dst") {
+		got[v.Key] = v.Value
+	}
+	if got["DB_NAME"] != "app-prod" || got["HOST"] != "db" {
+		t.Errorf("target values = %v, want only DB_NAME suffixed", got)
+	}
+	if strings.Contains(stderr, "Value of") {
+		t.Errorf("values logged without --dry-run or --explain:\n%s", stderr)
+	}
+}
@@ -0,0 +1,92 @@
+package envsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GitLabVersion is the major and minor release of a GitLab instance.
+type GitLabVersion struct {
+	Major, Minor int
+}
+
+func (v GitLabVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+func (v GitLabVersion) atLeast(major, minor int) bool {
+	return v.Major > major || v.Major == major && v.Minor >= minor
+}
+
+// parseGitLabVersion reads the major and minor release from a version such
+// as "16.11.2-ee" or "17.0".
+func parseGitLabVersion(s string) (GitLabVersion, error) {
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) < 2 {
+		return GitLabVersion{}, fmt.Errorf("invalid GitLab version %q, expected MAJOR.MINOR", s)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return GitLabVersion{}, fmt.Errorf("invalid GitLab version %q, expected MAJOR.MINOR", s)
+	}
+	minor, err := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return GitLabVersion{}, fmt.Errorf("invalid GitLab version %q, expected MAJOR.MINOR", s)
+	}
+	return GitLabVersion{major, minor}, nil
+}
+
+// GetVersion reads the instance's version. Any authenticated token may read
+// it.
+func (c *GitLabClient) GetVersion() (GitLabVersion, error) {
+	req, err := c.makeRequest("GET", "version", nil)
+	if err != nil {
+		return GitLabVersion{}, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return GitLabVersion{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return GitLabVersion{}, newAPIError("failed to get GitLab version", resp)
+	}
+
+	var info struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return GitLabVersion{}, err
+	}
+	return parseGitLabVersion(info.Version)
+}
+
+// resolveMaskingRules returns the masking rules of the release given by
+// --gitlab-version or, without one, of the instance client talks to. A nil
+// client or an unreadable version gives the default rules.
+func resolveMaskingRules(client *GitLabClient, assumed string) maskingRules {
+	if assumed != "" {
+		version, err := parseGitLabVersion(assumed)
+		if err != nil {
+			log.Fatalf("Error: invalid --gitlab-version: %v", err)
+		}
+		return maskingRulesFor(version)
+	}
+	if client == nil {
+		return defaultMaskingRules
+	}
+	version, err := client.GetVersion()
+	if isAuthError(err) {
+		exitAuth(err)
+	}
+	if err != nil {
+		log.Printf("Warning: cannot read the GitLab version, validating masked values against the rules of %s and later: %v", defaultMaskingRules.since, err)
+		return defaultMaskingRules
+	}
+	log.Printf("GitLab %s: masked values may use %s", version, maskingRulesFor(version).allowed)
+	return maskingRulesFor(version)
+}
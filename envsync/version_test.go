@@ -0,0 +1,124 @@
+package envsync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGitLabVersion(t *testing.T) {
+	for s, want := range map[string]GitLabVersion{
+		"16.11.2-ee":     {16, 11},
+		"17.0":           {17, 0},
+		"13.12.15":       {13, 12},
+		"15.4-pre":       {15, 4},
+		"18.1.0-rc42-ee": {18, 1},
+	} {
+		got, err := parseGitLabVersion(s)
+		if err != nil || got != want {
+			t.Errorf("parseGitLabVersion(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "17", "x.1", "17.x"} {
+		if _, err := parseGitLabVersion(s); err == nil {
+			t.Errorf("parseGitLabVersion(%q) succeeded", s)
+		}
+	}
+}
+
+func TestMaskingRulesFor(t *testing.T) {
+	for version, want := range map[GitLabVersion]GitLabVersion{
+		{12, 10}: {0, 0},
+		{13, 0}:  {13, 0},
+		{16, 11}: {13, 0},
+		{17, 0}:  {17, 0},
+		{18, 2}:  {17, 0},
+	} {
+		if got := maskingRulesFor(version).since; got != want {
+			t.Errorf("maskingRulesFor(%s) are those since %s, want %s", version, got, want)
+		}
+	}
+}
+
+// The same value can be masked on one release and rejected on another.
+func TestValidateMaskedValueByVersion(t *testing.T) {
+	for _, test := range []struct {
+		value              string
+		old, middle, newer bool
+	}{
+		{"c2VjcmV0LXZhbHVl", true, true, true},
+		{"short", false, false, false},
+		{"abc-def_ghi~", false, true, true},
+		{"pa$$w0rd!", false, false, true},
+		{"has a space", false, false, false},
+		{"line1\nline2xx", false, false, false},
+	} {
+		for _, rules := range []struct {
+			version GitLabVersion
+			want    bool
+		}{{GitLabVersion{12, 9}, test.old}, {GitLabVersion{16, 0}, test.middle}, {GitLabVersion{17, 3}, test.newer}} {
+			err := validateMaskedValue(test.value, maskingRulesFor(rules.version))
+			if (err == nil) != rules.want {
+				t.Errorf("GitLab %s: validateMaskedValue(%q) = %v, want valid %t", rules.version, test.value, err, rules.want)
+			}
+		}
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.version = "16.11.2-ee"
+	if got, err := f.client().GetVersion(); err != nil || got != (GitLabVersion{16, 11}) {
+		t.Errorf("GetVersion = %v, %v", got, err)
+	}
+}
+
+// The pre-flight check of a transactional run uses the rules of the
+// target's release, or of --gitlab-version, and the default rules when the
+// version is unreadable.
+func TestMaskedValueCheckFollowsVersion(t *testing.T) {
+	secret := envVar("PASSWORD", "pa$$w0rd!", "")
+	secret.Masked = true
+
+	for _, test := range []struct {
+		version string
+		args    []string
+		ok      bool
+		log     string
+	}{
+		{"17.2.0", nil, true, "GitLab 17.2: masked values may use printable ASCII characters other than space"},
+		{"16.11.0", nil, false, "GitLab 16.11: masked values may use A-Z, a-z, 0-9 and @_-:+./=~"},
+		{"16.11.0", []string{"--gitlab-version", "17.0"}, true, ""},
+		{"", nil, false, "cannot read the GitLab version, validating masked values against the rules of 13.0 and later"},
+	} {
+		f := newFakeGitLab(t)
+		f.version = test.version
+		f.projects["g/src"] = []EnvVar{secret}
+		f.projects["g/dst"] = []EnvVar{}
+
+		args := append([]string{"--source", "g/src", "--target", "g/dst", "--transactional"}, test.args...)
+		_, stderr, code := runMain(t, "", f.args(args...)...)
+		want := exitInvalid
+		if test.ok {
+			want = 0
+		}
+		if code != want {
+			t.Errorf("version %q %v: exit code %d; stderr:\n%s", test.version, test.args, code, stderr)
+		}
+		if !strings.Contains(stderr, test.log) {
+			t.Errorf("version %q %v: stderr lacks %q:\n%s", test.version, test.args, test.log, stderr)
+		}
+		if got := len(f.vars("g/dst")); test.ok != (got == 1) {
+			t.Errorf("version %q %v: target has %d variables", test.version, test.args, got)
+		}
+	}
+}
+
+func TestInvalidGitLabVersionFlag(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{}
+	f.projects["g/dst"] = []EnvVar{}
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst", "--transactional", "--gitlab-version", "seventeen")...)
+	if code == 0 || !strings.Contains(stderr, "invalid --gitlab-version") {
+		t.Errorf("exit code %d; stderr:\n%s", code, stderr)
+	}
+}
@@ -0,0 +1,74 @@
+package envsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// headerFlag collects repeated "Name: value" flags into an http.Header.
+type headerFlag struct {
+	header http.Header
+}
+
+func (f *headerFlag) String() string {
+	if f == nil || f.header == nil {
+		return ""
+	}
+	var parts []string
+	for name := range f.header {
+		parts = append(parts, name)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *headerFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("invalid header %q, expected \"Name: value\"", value)
+	}
+	if f.header == nil {
+		f.header = http.Header{}
+	}
+	f.header.Add(name, strings.TrimSpace(val))
+	return nil
+}
+
+// postSummary sends the run summary as JSON to a webhook endpoint.
+func postSummary(webhookURL string, headers http.Header, summary *runSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", webhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range headers {
+		req.Header.Del(name)
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+
+	client := &http.Client{Timeout: time.Second * 10}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("webhook returned status code %d, response: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package envsync
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHeaderFlag(t *testing.T) {
+	var f headerFlag
+	for _, value := range []string{"Authorization: Bearer abc", "X-Team:ops", "X-Team: infra"} {
+		if err := f.Set(value); err != nil {
+			t.Fatalf("Set(%q): %v", value, err)
+		}
+	}
+	if got := f.header.Get("Authorization"); got != "Bearer abc" {
+		t.Errorf("Authorization = %q, want Bearer abc", got)
+	}
+	if got := f.header.Values("X-Team"); len(got) != 2 || got[0] != "ops" || got[1] != "infra" {
+		t.Errorf("X-Team = %v, want [ops infra]", got)
+	}
+	for _, value := range []string{"no-colon", ": empty"} {
+		if err := f.Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestPostSummary(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	summary := newRunSummary("g/src", "g/dst", false)
+	summary.Created = 2
+	summary.Failed = 1
+	summary.FailedKeys = []string{"TOKEN@*"}
+	headers := http.Header{"X-Team": {"ops"}, "Content-Type": {"application/vnd.ops+json"}}
+	if err := postSummary(server.URL, headers, summary); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Method != http.MethodPost {
+		t.Errorf("method = %s, want POST", got.Method)
+	}
+	if got.Header.Get("X-Team") != "ops" || got.Header.Get("Content-Type") != "application/vnd.ops+json" {
+		t.Errorf("headers = %v, want the configured headers to win", got.Header)
+	}
+	var sent runSummary
+	if err := json.Unmarshal(body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Created != 2 || sent.Failed != 1 || len(sent.FailedKeys) != 1 || sent.FailedKeys[0] != "TOKEN@*" {
+		t.Errorf("posted summary = %+v", sent)
+	}
+}
+
+func TestPostSummaryReportsStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad token", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := postSummary(server.URL, nil, newRunSummary("g/src", "g/dst", false))
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "bad token") {
+		t.Errorf("err = %v, want the status and response", err)
+	}
+}
+
+// A failing webhook is only a warning; the run itself succeeded.
+func TestSummaryWebhookFailureKeepsExitCode(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.projects["g/src"] = []EnvVar{envVar("A", "1", "")}
+	f.projects["g/dst"] = nil
+	var posted int
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer hook.Close()
+
+	_, stderr, code := runMain(t, "", f.args("--source", "g/src", "--target", "g/dst",
+		"--summary-webhook", hook.URL)...)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0; stderr:\n%s", code, stderr)
+	}
+	if posted != 1 {
+		t.Errorf("webhook called %d times, want once", posted)
+	}
+	if !strings.Contains(stderr, "failed to post summary to webhook") {
+		t.Errorf("stderr does not warn about the webhook:\n%s", stderr)
+	}
+	if len(f.vars("g/dst")) != 1 {
+		t.Errorf("target = %v, want A created", f.vars("g/dst"))
+	}
+}
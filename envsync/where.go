@@ -0,0 +1,291 @@
+package envsync
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// A --where expression selects variables by their fields, e.g.
+//
+//	masked == true && scope != "*"
+//	key =~ "^DB_" || (type == "file" && !protected)
+//
+// Fields: key, value, scope, type (strings) and protected, masked (booleans).
+// Operators: == and != on matching types, =~ and !~ against a regular
+// expression literal, !, && and ||, with parentheses for grouping. String
+// literals are double-quoted with Go escapes.
+
+// whereExpr reports whether a variable matches a --where expression.
+type whereExpr func(v EnvVar) bool
+
+var whereStringFields = map[string]func(EnvVar) string{
+	"key":   func(v EnvVar) string { return v.Key },
+	"value": func(v EnvVar) string { return v.Value },
+	"scope": func(v EnvVar) string { return normalizeScope(v.EnvironmentScope) },
+	"type":  func(v EnvVar) string { return normalizeVariableType(v.VariableType) },
+}
+
+var whereBoolFields = map[string]func(EnvVar) bool{
+	"protected": func(v EnvVar) bool { return v.Protected },
+	"masked":    func(v EnvVar) bool { return v.Masked },
+}
+
+// parseWhere compiles a --where expression.
+func parseWhere(src string) (whereExpr, error) {
+	tokens, err := tokenizeWhere(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &whereParser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	if n.boolean == nil {
+		return nil, fmt.Errorf("expression must be a condition, not a string")
+	}
+	return n.boolean, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type whereToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var whereOperators = []string{"&&", "||", "==", "!=", "=~", "!~", "!"}
+
+func tokenizeWhere(src string) ([]whereToken, error) {
+	var tokens []whereToken
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, whereToken{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, whereToken{tokRParen, ")", i})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			var value string
+			if _, err := fmt.Sscanf(src[i:end+1], "%q", &value); err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %v", i, err)
+			}
+			tokens = append(tokens, whereToken{tokString, value, i})
+			i = end + 1
+		case unicode.IsLetter(c) || c == '_':
+			end := i
+			for end < len(src) && (unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end])) || src[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, whereToken{tokIdent, src[i:end], i})
+			i = end
+		default:
+			matched := false
+			for _, op := range whereOperators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, whereToken{tokOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, whereToken{tokEOF, "end of expression", len(src)}), nil
+}
+
+// whereNode is a typed subexpression: exactly one of str and boolean is set.
+type whereNode struct {
+	str     func(EnvVar) string
+	boolean func(EnvVar) bool
+
+	// literal is set for string literals, which may be used as patterns.
+	literal *string
+}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) peek() whereToken {
+	return p.tokens[p.pos]
+}
+
+func (p *whereParser) next() whereToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *whereParser) parseOr() (whereNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		op := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		l, r, err := boolOperands(op, left, right)
+		if err != nil {
+			return left, err
+		}
+		left = whereNode{boolean: func(v EnvVar) bool { return l(v) || r(v) }}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseAnd() (whereNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return left, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		op := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return right, err
+		}
+		l, r, err := boolOperands(op, left, right)
+		if err != nil {
+			return left, err
+		}
+		left = whereNode{boolean: func(v EnvVar) bool { return l(v) && r(v) }}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseUnary() (whereNode, error) {
+	if p.peek().kind == tokOp && p.peek().text == "!" {
+		op := p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return operand, err
+		}
+		if operand.boolean == nil {
+			return operand, fmt.Errorf("%q at position %d needs a condition", op.text, op.pos)
+		}
+		inner := operand.boolean
+		return whereNode{boolean: func(v EnvVar) bool { return !inner(v) }}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *whereParser) parseComparison() (whereNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return left, err
+	}
+
+	op := p.peek()
+	if op.kind != tokOp || (op.text != "==" && op.text != "!=" && op.text != "=~" && op.text != "!~") {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return right, err
+	}
+
+	switch op.text {
+	case "=~", "!~":
+		if left.str == nil || right.literal == nil {
+			return left, fmt.Errorf("%q at position %d needs a string field and a pattern literal", op.text, op.pos)
+		}
+		re, err := regexp.Compile(*right.literal)
+		if err != nil {
+			return left, fmt.Errorf("invalid pattern at position %d: %v", op.pos, err)
+		}
+		field, negate := left.str, op.text == "!~"
+		return whereNode{boolean: func(v EnvVar) bool { return re.MatchString(field(v)) != negate }}, nil
+	}
+
+	negate := op.text == "!="
+	switch {
+	case left.str != nil && right.str != nil:
+		l, r := left.str, right.str
+		return whereNode{boolean: func(v EnvVar) bool { return (l(v) == r(v)) != negate }}, nil
+	case left.boolean != nil && right.boolean != nil:
+		l, r := left.boolean, right.boolean
+		return whereNode{boolean: func(v EnvVar) bool { return (l(v) == r(v)) != negate }}, nil
+	default:
+		return left, fmt.Errorf("%q at position %d compares a string with a boolean", op.text, op.pos)
+	}
+}
+
+func (p *whereParser) parseOperand() (whereNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return n, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return n, fmt.Errorf("expected \")\" at position %d", closing.pos)
+		}
+		return n, nil
+	case tokString:
+		value := t.text
+		return whereNode{str: func(EnvVar) string { return value }, literal: &value}, nil
+	case tokIdent:
+		if t.text == "true" || t.text == "false" {
+			value := t.text == "true"
+			return whereNode{boolean: func(EnvVar) bool { return value }}, nil
+		}
+		if field, ok := whereStringFields[t.text]; ok {
+			return whereNode{str: field}, nil
+		}
+		if field, ok := whereBoolFields[t.text]; ok {
+			return whereNode{boolean: field}, nil
+		}
+		return whereNode{}, fmt.Errorf("unknown field %q at position %d", t.text, t.pos)
+	default:
+		return whereNode{}, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+}
+
+func boolOperands(op whereToken, left, right whereNode) (func(EnvVar) bool, func(EnvVar) bool, error) {
+	if left.boolean == nil || right.boolean == nil {
+		return nil, nil, fmt.Errorf("%q at position %d needs conditions on both sides", op.text, op.pos)
+	}
+	return left.boolean, right.boolean, nil
+}
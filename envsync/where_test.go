@@ -0,0 +1,60 @@
+package envsync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseWhere(t *testing.T) {
+	masked := envVar("DB_PASSWORD", "pw", "production")
+	masked.Masked = true
+	file := envVar("CERT", "-----BEGIN", "")
+	file.VariableType = "file"
+	file.Protected = true
+	plain := envVar("DEBUG", "1", "")
+	plain.VariableType = "" // unset types count as env_var
+
+	for _, test := range []struct {
+		expr string
+		want []bool // masked, file, plain
+	}{
+		{`masked == true && scope != "*"`, []bool{true, false, false}},
+		{`key =~ "^DB_" || (type == "file" && !protected)`, []bool{true, false, false}},
+		{`type == "env_var"`, []bool{true, false, true}},
+		{`!(protected || masked)`, []bool{false, false, true}},
+		{`value !~ "BEGIN"`, []bool{true, false, true}},
+		{`protected == masked`, []bool{false, false, true}},
+		{`scope == "*" && key != "CERT"`, []bool{false, false, true}},
+	} {
+		where, err := parseWhere(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		for i, v := range []EnvVar{masked, file, plain} {
+			if got := where(v); got != test.want[i] {
+				t.Errorf("%s on %s = %v, want %v", test.expr, v.Key, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestParseWhereErrors(t *testing.T) {
+	for expr, want := range map[string]string{
+		`key`:                  "must be a condition",
+		`colour == "red"`:      `unknown field "colour"`,
+		`key == true`:          "compares a string with a boolean",
+		`key =~ scope`:         "needs a string field and a pattern literal",
+		`key =~ "("`:           "invalid pattern",
+		`masked &&`:            "unexpected",
+		`(masked`:              `expected ")"`,
+		`masked && key`:        "needs conditions on both sides",
+		`!key`:                 "needs a condition",
+		`masked == true extra`: `unexpected "extra"`,
+	} {
+		_, err := parseWhere(expr)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseWhere(%s) = %v, want an error containing %q", expr, err, want)
+		}
+	}
+}
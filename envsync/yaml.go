@@ -0,0 +1,29 @@
+package envsync
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// yamlString renders s as a YAML double-quoted scalar. The escapes produced
+// by strconv.Quote are a subset of those YAML accepts.
+func yamlString(s string) string {
+	return strconv.Quote(s)
+}
+
+// writeVariablesYAML writes variables as a YAML sequence indented by indent.
+func writeVariablesYAML(w io.Writer, indent string, variables []EnvVar) {
+	for _, v := range variables {
+		fmt.Fprintf(w, "%s- key: %s\n", indent, yamlString(v.Key))
+		fmt.Fprintf(w, "%s  value: %s\n", indent, yamlString(v.Value))
+		fmt.Fprintf(w, "%s  variable_type: %s\n", indent, yamlString(v.VariableType))
+		fmt.Fprintf(w, "%s  environment_scope: %s\n", indent, yamlString(normalizeScope(v.EnvironmentScope)))
+		fmt.Fprintf(w, "%s  protected: %t\n", indent, v.Protected)
+		fmt.Fprintf(w, "%s  masked: %t\n", indent, v.Masked)
+		fmt.Fprintf(w, "%s  raw: %t\n", indent, v.Raw)
+		if v.Description != "" {
+			fmt.Fprintf(w, "%s  description: %s\n", indent, yamlString(v.Description))
+		}
+	}
+}
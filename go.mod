@@ -1,3 +1,14 @@
 module github.com/regularpoe/gitlab-env-sync
 
-go 1.23.2
+go 1.26.0
+
+require (
+	filippo.io/age v1.3.2
+	golang.org/x/time v0.16.0
+)
+
+require (
+	filippo.io/hpke v0.4.0 // indirect
+	golang.org/x/crypto v0.55.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+)